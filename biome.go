@@ -18,15 +18,21 @@
 package biome
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
+	"time"
 
 	"zombiezen.com/go/log"
 )
@@ -34,12 +40,55 @@ import (
 // ErrUnsupported indicates that a request operation cannot be performed,
 // because it is unsupported.
 //
+// Biome implementations can return an error wrapping ErrUnsupported from
+// any of the optional methods listed in the Biome documentation to make the
+// corresponding package-level function (like OpenFile) use its fallback,
+// which runs programs in the biome.
+//
 // TODO(light): Replace with errors.ErrUnsupported when
 // https://golang.org/issue/41198 is resolved.
 var ErrUnsupported = errors.New("unsupported operation")
 
+// IsUnsupported reports whether err is or wraps ErrUnsupported.
+func IsUnsupported(err error) bool {
+	return errors.Is(err, ErrUnsupported)
+}
+
+// ErrNotFound indicates that a file does not exist in the biome.
+// Functions in this package that operate on files return errors that wrap
+// ErrNotFound when the file is missing, so callers can check with
+// errors.Is(err, biome.ErrNotFound). It is the same value as fs.ErrNotExist.
+var ErrNotFound = fs.ErrNotExist
+
+// ErrPermission and ErrExists are like ErrNotFound, but indicate that
+// a file operation was not permitted or that a file already exists.
+// They are the same values as fs.ErrPermission and fs.ErrExist.
+var (
+	ErrPermission = fs.ErrPermission
+	ErrExists     = fs.ErrExist
+)
+
 // A Biome is an environment that programs can be run in.
 // Implementations must be safe to use from multiple goroutines.
+//
+// The methods below are the only ones a Biome is required to have.
+// A Biome may also implement any of the following methods
+// to provide a more efficient implementation of the package-level function
+// with the same name. Callers should use the package-level function
+// (for example, OpenFile) instead of type-asserting for the method directly,
+// since the function falls back to running programs in the biome
+// if the method is not present.
+//
+//	OpenFile(ctx context.Context, path string) (io.ReadCloser, error)
+//	WriteFile(ctx context.Context, path string, src io.Reader) error
+//	MkdirAll(ctx context.Context, path string) error
+//	MkdirAllPerm(ctx context.Context, path string, perm fs.FileMode) error
+//	EvalSymlinks(ctx context.Context, path string) (string, error)
+//	Stat(ctx context.Context, path string) (fs.FileInfo, error)
+//	ReadDir(ctx context.Context, path string) ([]fs.DirEntry, error)
+//	HasCommand(ctx context.Context, name string) (bool, error)
+//
+// Biomes that hold resources should also implement BiomeCloser.
 type Biome interface {
 	// Describe returns information about the execution environment.
 	// The caller must not modify the returned Descriptor.
@@ -57,29 +106,127 @@ type Biome interface {
 
 // A Descriptor describes various facets of a biome.
 type Descriptor struct {
-	OS   string
+	// OS is the biome's operating system as a GOOS value:
+	// Linux, MacOS ("darwin"), or Windows. Biomes that detect the operating
+	// system another way (like `uname -s`) should convert the name
+	// with NormalizeOS.
+	OS string
+	// Arch is the biome's CPU architecture, preferably as a GOARCH value.
+	// Use NativeArch to read it.
 	Arch string
 }
 
 // Equal reports whether two descriptors are equivalent.
+// Operating systems and architectures are compared after normalizing with
+// NormalizeOS and NormalizeArch.
 func (desc *Descriptor) Equal(desc2 *Descriptor) bool {
-	return *desc == *desc2
+	return desc.NativeOS() == desc2.NativeOS() && desc.NativeArch() == desc2.NativeArch()
+}
+
+// Compatible reports whether programs built for desc2 can run
+// on a system described by desc. This is true if the descriptors are Equal
+// or if desc's operating system can run desc2's architecture under emulation:
+// 64-bit Intel Linux and Windows run 32-bit Intel programs,
+// ARM64 macOS runs 64-bit Intel programs with Rosetta 2,
+// and ARM64 Windows runs both 32-bit and 64-bit Intel programs.
+// Compatible does not check whether the emulation layer is installed.
+func (desc *Descriptor) Compatible(desc2 *Descriptor) bool {
+	goos := desc.NativeOS()
+	if goos != desc2.NativeOS() {
+		return false
+	}
+	arch, arch2 := desc.NativeArch(), desc2.NativeArch()
+	if arch == arch2 {
+		return true
+	}
+	switch {
+	case arch == Intel64 && arch2 == Intel32:
+		return goos == Linux || goos == Windows
+	case arch == ARM64 && arch2 == Intel64:
+		return goos == MacOS || goos == Windows
+	case arch == ARM64 && arch2 == Intel32:
+		return goos == Windows
+	default:
+		return false
+	}
+}
+
+// NativeOS returns the descriptor's operating system as a GOOS value,
+// even if the biome reported it using another naming convention
+// (like "Darwin" from `uname -s`).
+func (desc *Descriptor) NativeOS() string {
+	return NormalizeOS(desc.OS)
+}
+
+// NativeArch returns the descriptor's architecture as a GOARCH value,
+// even if the biome reported it using another naming convention
+// (like "x86_64" from `uname -m`).
+func (desc *Descriptor) NativeArch() string {
+	return NormalizeArch(desc.Arch)
 }
 
-// Operating systems. Values are based off GOOS.
+// Operating systems. Values are based off GOOS,
+// so they can be compared directly with runtime.GOOS and Descriptor.NativeOS.
 const (
 	Linux   = "linux"
 	MacOS   = "darwin"
 	Windows = "windows"
+
+	// Darwin is an alias for MacOS that matches the GOOS spelling.
+	Darwin = MacOS
 )
 
-// CPU Architectures. Values are based off GOARCH.
+// NormalizeOS converts an operating system name to its GOOS value.
+// It recognizes the names printed by `uname -s` (including those of
+// Windows environments like MSYS2 and Cygwin) and common alternate
+// spellings, so "macOS", "Darwin", and "darwin" all normalize to MacOS.
+// Unrecognized names are returned in lowercase.
+func NormalizeOS(name string) string {
+	name = strings.ToLower(name)
+	switch {
+	case name == MacOS || name == "macos" || name == "mac" || name == "osx" || name == "macosx":
+		return MacOS
+	case name == Windows || name == "win32" || name == "win64" || name == "windows_nt" ||
+		strings.HasPrefix(name, "mingw") || strings.HasPrefix(name, "msys_nt") || strings.HasPrefix(name, "cygwin_nt"):
+		return Windows
+	default:
+		return name
+	}
+}
+
+// CPU Architectures. Values are based off GOARCH,
+// which is what NormalizeArch and Descriptor.NativeArch return
+// for the architectures they recognize.
 const (
 	Intel64 = "amd64"
 	Intel32 = "386"
 	ARM64   = "arm64"
+	ARM     = "arm" // 32-bit ARM
+
+	// AMD64 is an alias for Intel64 that matches the GOARCH spelling.
+	AMD64 = Intel64
 )
 
+// NormalizeArch converts a CPU architecture name to its GOARCH value.
+// It recognizes the names used by `uname -m`, Debian, and other common
+// conventions, so "x86_64" and "amd64" both normalize to Intel64 (AMD64).
+// Unrecognized names are returned in lowercase.
+func NormalizeArch(arch string) string {
+	arch = strings.ToLower(arch)
+	switch arch {
+	case "x86_64", "x86-64", "x64", "amd64":
+		return Intel64
+	case "i386", "i486", "i586", "i686", "x86", "386":
+		return Intel32
+	case "aarch64", "arm64", "armv8", "armv8b":
+		return ARM64
+	case "armv6l", "armv7l", "armhf", "armel", "arm":
+		return ARM
+	default:
+		return arch
+	}
+}
+
 // Dirs holds paths to special directories in a Context.
 type Dirs struct {
 	// Work is the absolute path of the biome's working directory.
@@ -91,8 +238,11 @@ type Dirs struct {
 	Home string
 
 	// Tools is the absolute path of a directory where helper tools can be
-	// installed. It is not shared with other biomes. It may have to be
-	// created.
+	// installed. It is the canonical place to install tool binaries.
+	// It is not shared with other biomes. It may have to be created.
+	// By convention, install scripts should extract tools into
+	// subdirectories of Tools and executables placed in its "bin"
+	// subdirectory are expected to be on PATH.
 	Tools string
 }
 
@@ -104,22 +254,37 @@ type Invocation struct {
 	// Dir is the directory to execute the program in. Paths are resolved relative to
 	// the biome's working directory. If empty, then it will be executed in the
 	// biome's working directory. It is separated by the biome's path separator.
+	// Dir must name an existing directory inside one of the biome's Dirs;
+	// a relative Dir must be inside the working directory.
 	Dir string
 
 	// Env specifies additional environment variables to send to the program.
 	// The biome may provide additional environment variables to the program, but
-	// will not override the provided environment variables.
+	// will not override the provided environment variables. Variables are
+	// given by name in Env.Vars rather than as "KEY=VALUE" strings. When run
+	// through an EnvBiome, Env is merged over the EnvBiome's environment.
 	Env Environment
 
 	// Stdin specifies the program's standard input.
-	// If Stdin is nil, the program reads from the null device.
+	// If Stdin is nil, the program reads from the null device. Biomes that
+	// run programs on the local machine may instead connect an Interactive
+	// program with a nil Stdin to the current process's standard input.
 	Stdin io.Reader
 
 	// Interactive indicates whether the program will be surfaced to the user
-	// interactively. This usually indicates that the program should be given
-	// a pseudo-TTY as input.
+	// interactively. Biomes that run programs on the local machine connect
+	// an Interactive program with a nil Stdin to the current process's
+	// standard input. Interactive does not allocate a pseudo-terminal: see PTY.
 	Interactive bool
 
+	// PTY indicates whether the program's standard output and error should be
+	// a pseudo-terminal, so that it behaves as it would when run in a terminal
+	// (for example, by producing colorized output). The terminal's output
+	// is copied to Stdout (or CombinedOutput) and Stderr is not used.
+	// PTY does not affect standard input. Biomes that cannot allocate
+	// a pseudo-terminal return an error wrapping ErrUnsupported.
+	PTY bool
+
 	// Stdout and Stderr specify the program's standard output and error.
 	// If either is nil, Run connects the corresponding file descriptor to the
 	// null device.
@@ -128,10 +293,170 @@ type Invocation struct {
 	// be compared with ==, at most one goroutine at a time will call Write.
 	Stdout io.Writer
 	Stderr io.Writer
+
+	// CombinedOutput specifies a single writer for both the program's standard
+	// output and error. If CombinedOutput is not nil, then Stdout and Stderr
+	// are ignored and the program's output is interleaved in CombinedOutput.
+	// At most one goroutine at a time will call Write.
+	CombinedOutput io.Writer
+}
+
+// Output returns the writers that should be used for the program's standard
+// output and error, taking CombinedOutput into account.
+func (invoke *Invocation) Output() (stdout, stderr io.Writer) {
+	if invoke.CombinedOutput != nil {
+		return invoke.CombinedOutput, invoke.CombinedOutput
+	}
+	return invoke.Stdout, invoke.Stderr
+}
+
+// CombinedOutput runs the given program in the biome's working directory and
+// returns its combined standard output and standard error.
+func CombinedOutput(ctx context.Context, bio Biome, argv ...string) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	err := bio.Run(ctx, &Invocation{
+		Argv:           argv,
+		CombinedOutput: buf,
+	})
+	return buf.Bytes(), err
+}
+
+type commandFinder interface {
+	HasCommand(ctx context.Context, name string) (bool, error)
+}
+
+// HasCommand reports whether the named program can be found in the biome.
+//
+// If the biome has a method `HasCommand(ctx context.Context, name string) (bool, error)`,
+// that will be used. Otherwise, HasCommand runs `command -v` on POSIX systems
+// and `where` on Windows each time it is called.
+// Wrap the biome in a CommandCache to avoid repeating lookups.
+func HasCommand(ctx context.Context, bio Biome, name string) (bool, error) {
+	if finder, ok := bio.(commandFinder); ok {
+		return finder.HasCommand(ctx, name)
+	}
+	return lookUpCommand(ctx, bio, name)
+}
+
+func lookUpCommand(ctx context.Context, bio Biome, name string) (bool, error) {
+	argv := []string{"sh", "-c", `command -v "$1"`, "sh", name}
+	if bio.Describe().NativeOS() == Windows {
+		argv = []string{"where", name}
+	}
+	out, err := CombinedOutput(ctx, bio, argv...)
+	if err != nil {
+		if ctx.Err() != nil {
+			return false, fmt.Errorf("look up command %s: %w", name, ctx.Err())
+		}
+		log.Debugf(ctx, "look up command %s: %v; output:\n%s", name, err, out)
+		return false, nil
+	}
+	return true, nil
+}
+
+// CommandCache wraps a biome to remember the programs that HasCommand
+// has found in it, so that repeated checks do not run a process each time.
+//
+// The cache lives as long as the CommandCache: it is not shared with other
+// CommandCaches, even ones that wrap the same biome. Failed lookups are not
+// cached, so programs installed later are found, but a program that is
+// removed from the biome is still reported as found. Callers should use
+// a CommandCache for a bounded operation (like running one install script)
+// rather than for the lifetime of the biome.
+//
+// A CommandCache must not be copied after first use.
+type CommandCache struct {
+	Biome
+
+	mu    sync.Mutex
+	found map[string]struct{}
+}
+
+// HasCommand reports whether the named program can be found
+// in cc.Biome, using a cached result if the program was found before.
+func (cc *CommandCache) HasCommand(ctx context.Context, name string) (bool, error) {
+	cc.mu.Lock()
+	_, found := cc.found[name]
+	cc.mu.Unlock()
+	if found {
+		return true, nil
+	}
+	found, err := HasCommand(ctx, cc.Biome, name)
+	if !found || err != nil {
+		return found, err
+	}
+	cc.mu.Lock()
+	if cc.found == nil {
+		cc.found = make(map[string]struct{})
+	}
+	cc.found[name] = struct{}{}
+	cc.mu.Unlock()
+	return true, nil
+}
+
+// Unwrap returns cc.Biome.
+func (cc *CommandCache) Unwrap() Biome {
+	return cc.Biome
+}
+
+// OpenFile calls cc.Biome.OpenFile or returns ErrUnsupported if not present.
+func (cc *CommandCache) OpenFile(ctx context.Context, path string) (io.ReadCloser, error) {
+	return forwardOpenFile(ctx, cc.Biome, path)
+}
+
+// WriteFile calls cc.Biome.WriteFile or returns ErrUnsupported if not present.
+func (cc *CommandCache) WriteFile(ctx context.Context, path string, src io.Reader) error {
+	return forwardWriteFile(ctx, cc.Biome, path, src)
+}
+
+// MkdirAll calls cc.Biome.MkdirAll or returns ErrUnsupported if not present.
+func (cc *CommandCache) MkdirAll(ctx context.Context, path string) error {
+	return forwardMkdirAll(ctx, cc.Biome, path)
+}
+
+// MkdirAllPerm calls cc.Biome.MkdirAllPerm or returns ErrUnsupported if not present.
+func (cc *CommandCache) MkdirAllPerm(ctx context.Context, path string, perm fs.FileMode) error {
+	return forwardMkdirAllPerm(ctx, cc.Biome, path, perm)
+}
+
+// EvalSymlinks calls cc.Biome.EvalSymlinks or returns ErrUnsupported if not present.
+func (cc *CommandCache) EvalSymlinks(ctx context.Context, path string) (string, error) {
+	return forwardEvalSymlinks(ctx, cc.Biome, path)
+}
+
+// Stat calls cc.Biome.Stat or returns ErrUnsupported if not present.
+func (cc *CommandCache) Stat(ctx context.Context, path string) (fs.FileInfo, error) {
+	return forwardStat(ctx, cc.Biome, path)
+}
+
+// ReadDir calls cc.Biome.ReadDir or returns ErrUnsupported if not present.
+func (cc *CommandCache) ReadDir(ctx context.Context, path string) ([]fs.DirEntry, error) {
+	return forwardReadDir(ctx, cc.Biome, path)
+}
+
+// Close calls cc.Biome.Close if such a method exists or returns nil if not present.
+func (cc *CommandCache) Close() error {
+	if c, ok := cc.Biome.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
 }
 
 // Local is a biome that executes processes in a directory on the
 // local machine.
+//
+// Local's methods do not modify the Local or any state shared between calls,
+// so it is safe to call Run, WriteFile, OpenFile, and the other methods
+// from multiple goroutines simultaneously. Callers must not modify an
+// Invocation (including its Env) while Run is using it.
+//
+// If an Invocation's Stdin is not an *os.File, Run copies it to the
+// subprocess in a separate goroutine. If the subprocess exits before reading
+// all of its input, Run reads and discards Stdin until it returns EOF or
+// an error before returning. This prevents writers feeding Stdin
+// (such as the other end of an io.Pipe) from blocking or failing with
+// a broken pipe error. As with exec.Cmd, Run does not return
+// until Stdin is exhausted.
 type Local struct {
 	// WorkDir is the absolute path to the biome's working directory.
 	WorkDir string
@@ -141,10 +466,54 @@ type Local struct {
 	// home directory. It's meant for storing configuration and intermediate files
 	// that any build tools need.
 	HomeDir string
+
+	// ToolsDir is the absolute path to a directory where helper tools can be
+	// installed. If empty, Dirs uses a directory inside HomeDir.
+	ToolsDir string
+
+	// If Isolate is true, then subprocesses do not inherit any variables from
+	// the host environment: not even LOGNAME, USER, NO_COLOR, or the host's
+	// PATH. Subprocesses only receive HOME, the standard locale and time zone
+	// variables, and the variables in the Invocation's Env.
+	Isolate bool
+
+	// ShutdownGracePeriod is how long Run waits for a subprocess to exit
+	// after sending it an interrupt signal when Run's Context is cancelled.
+	// If the subprocess is still running after the grace period,
+	// then it is killed. If ShutdownGracePeriod is zero,
+	// DefaultShutdownGracePeriod is used. If it is negative,
+	// then the subprocess is killed immediately.
+	ShutdownGracePeriod time.Duration
+
+	// CPUQuota limits subprocesses to the given number of CPUs' worth of time
+	// (like 1.5) if positive. MemoryBytes limits the memory that
+	// subprocesses may use if positive. The limits are enforced
+	// with a Linux cgroup v2 control group created inside the current
+	// process's control group, which must permit enabling the cpu and memory
+	// controllers for its children. Only the subprocess is started inside
+	// the new control group; the current process is never moved.
+	// If the limits cannot be enforced (including on other
+	// operating systems), then Run logs a warning and runs without them.
+	CPUQuota    float64
+	MemoryBytes int64
+
+	// Descriptor overrides the values returned by Describe if not nil.
+	// Its OS must be GOOS, but its architecture may be one that the host
+	// can run under emulation (see Descriptor.Compatible), so that install
+	// scripts pick programs for that architecture.
+	Descriptor *Descriptor
 }
 
-// Describe returns the values of GOOS/GOARCH.
+// DefaultShutdownGracePeriod is the default value of
+// Local.ShutdownGracePeriod.
+const DefaultShutdownGracePeriod = 5 * time.Second
+
+// Describe returns l.Descriptor or, if it is nil, the values of GOOS/GOARCH.
 func (l Local) Describe() *Descriptor {
+	if l.Descriptor != nil {
+		desc := *l.Descriptor
+		return &desc
+	}
 	return &Descriptor{
 		OS:   runtime.GOOS,
 		Arch: runtime.GOARCH,
@@ -159,10 +528,16 @@ func (l Local) Dirs() *Dirs {
 	if !filepath.IsAbs(l.HomeDir) {
 		panic("Local.HomeDir is not absolute")
 	}
+	tools := l.ToolsDir
+	if tools == "" {
+		tools = filepath.Join(l.HomeDir, ".cache", "zombiezen-biome", "tools")
+	} else if !filepath.IsAbs(tools) {
+		panic("Local.ToolsDir is not absolute")
+	}
 	return &Dirs{
 		Work:  l.WorkDir,
 		Home:  l.HomeDir,
-		Tools: filepath.Join(l.HomeDir, ".cache", "zombiezen-biome", "tools"),
+		Tools: tools,
 	}
 }
 
@@ -171,38 +546,245 @@ func (l Local) Run(ctx context.Context, invoke *Invocation) error {
 	if len(invoke.Argv) == 0 {
 		return fmt.Errorf("local run: argv empty")
 	}
+	if l.CPUQuota < 0 || l.MemoryBytes < 0 {
+		return fmt.Errorf("local run: negative resource limit")
+	}
 	log.Debugf(ctx, "Run: %s", strings.Join(invoke.Argv, " "))
 	log.Debugf(ctx, "Environment:\n%v", invoke.Env)
-	dir := invoke.Dir
-	if !filepath.IsAbs(invoke.Dir) {
-		dir = filepath.Join(l.WorkDir, invoke.Dir)
+	dir, err := l.resolveDir(invoke.Dir)
+	if err != nil {
+		return fmt.Errorf("local run: %w", err)
 	}
 	program, err := l.lookPath(invoke.Env, dir, invoke.Argv[0])
 	if err != nil {
 		return fmt.Errorf("local run: %w", err)
 	}
 	log.Debugf(ctx, "Program = %s", program)
-	c := exec.CommandContext(ctx, program, invoke.Argv[1:]...)
-	c.Env = []string{
-		"HOME=" + l.HomeDir,
-		"LOGNAME=" + os.Getenv("LOGNAME"),
-		"USER=" + os.Getenv("USER"),
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("local run: %w", err)
 	}
-	if v, ok := os.LookupEnv("NO_COLOR"); ok {
-		c.Env = append(c.Env, "NO_COLOR="+v)
+	c := exec.Command(program, invoke.Argv[1:]...)
+	c.Env = []string{"HOME=" + l.HomeDir}
+	if !l.Isolate {
+		c.Env = append(c.Env,
+			"LOGNAME="+os.Getenv("LOGNAME"),
+			"USER="+os.Getenv("USER"),
+		)
+		if v, ok := os.LookupEnv("NO_COLOR"); ok {
+			c.Env = append(c.Env, "NO_COLOR="+v)
+		}
 	}
 	c.Env = appendStandardEnv(c.Env, runtime.GOOS)
-	c.Env = invoke.Env.appendTo(c.Env, os.Getenv("PATH"), filepath.ListSeparator)
+	c.Env = invoke.Env.appendTo(c.Env, l.hostPATH(), filepath.ListSeparator)
 	c.Dir = dir
 	c.Stdin = invoke.Stdin
-	c.Stdout = invoke.Stdout
-	c.Stderr = invoke.Stderr
-	if err := c.Run(); err != nil {
+	if c.Stdin == nil && invoke.Interactive {
+		c.Stdin = os.Stdin
+	}
+	// Otherwise, a nil c.Stdin is connected to the null device, so
+	// non-interactive programs never hold onto our terminal.
+	c.Stdout, c.Stderr = invoke.Output()
+
+	var cg *cgroup
+	if limits := (resourceLimits{cpus: l.CPUQuota, memoryBytes: l.MemoryBytes}); limits != (resourceLimits{}) {
+		cg, err = createCgroup(limits)
+		if err != nil {
+			log.Warnf(ctx, "Running %s without resource limits: %v", invoke.Argv[0], err)
+			cg = nil
+		} else {
+			defer cg.remove(ctx)
+		}
+	}
+
+	// Files created for the subprocess that must be closed after it starts.
+	var childFiles []*os.File
+	closeChildFiles := func() {
+		for _, f := range childFiles {
+			f.Close()
+		}
+	}
+	var stdinPipe *os.File
+	if _, isFile := c.Stdin.(*os.File); c.Stdin != nil && !isFile {
+		// Rather than letting exec.Cmd copy Stdin, copy it ourselves
+		// so that we can keep draining it after the subprocess exits.
+		pr, pw, err := os.Pipe()
+		if err != nil {
+			return fmt.Errorf("local run: %w", err)
+		}
+		c.Stdin = pr
+		childFiles = append(childFiles, pr)
+		stdinPipe = pw
+	}
+	var ptyController *os.File
+	ptyOutput := c.Stdout
+	if invoke.PTY {
+		controller, tty, err := openPTY()
+		if err != nil {
+			closeChildFiles()
+			if stdinPipe != nil {
+				stdinPipe.Close()
+			}
+			return fmt.Errorf("local run: %w", err)
+		}
+		defer controller.Close()
+		ptyController = controller
+		c.Stdout, c.Stderr = tty, tty
+		childFiles = append(childFiles, tty)
+		setControllingTerminal(c)
+	}
+	if cg != nil {
+		// Start the subprocess inside the group
+		// so that none of its descendants escape the limits.
+		// This comes after setControllingTerminal,
+		// which replaces c.SysProcAttr.
+		if f, err := startInCgroup(c, cg); err != nil {
+			log.Warnf(ctx, "Running %s without resource limits: %v", invoke.Argv[0], err)
+		} else if f != nil {
+			childFiles = append(childFiles, f)
+		}
+	}
+	err = c.Start()
+	closeChildFiles()
+	if err != nil {
+		if stdinPipe != nil {
+			stdinPipe.Close()
+		}
+		return fmt.Errorf("local run: %w", err)
+	}
+	var stdinDone chan struct{}
+	if stdinPipe != nil {
+		stdinDone = make(chan struct{})
+		go func() {
+			defer close(stdinDone)
+			copyStdin(stdinPipe, invoke.Stdin)
+		}()
+	}
+	var ptyDone chan struct{}
+	if ptyController != nil {
+		if ptyOutput == nil {
+			ptyOutput = io.Discard
+		}
+		ptyDone = make(chan struct{})
+		go func() {
+			defer close(ptyDone)
+			_, err := io.Copy(ptyOutput, ptyController)
+			if err != nil && !isPTYClosed(err) {
+				log.Debugf(ctx, "Copying pseudo-terminal output: %v", err)
+			}
+		}()
+	}
+	waitDone := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			l.stop(ctx, c.Process, waitDone)
+		case <-waitDone:
+		}
+	}()
+	err = c.Wait()
+	close(waitDone)
+	if ptyDone != nil {
+		// The terminal reports that it is closed once every process
+		// that has it open exits.
+		<-ptyDone
+	}
+	if stdinDone != nil {
+		// Don't leave a goroutine reading from the caller's Stdin.
+		<-stdinDone
+	}
+	if err != nil {
 		return fmt.Errorf("local run: %w", err)
 	}
 	return nil
 }
 
+// resourceLimits is the set of limits to apply to a subprocess.
+// Zero values mean no limit.
+type resourceLimits struct {
+	cpus        float64
+	memoryBytes int64
+}
+
+// copyStdin copies src to a subprocess's standard input pipe and then closes
+// the pipe. If the subprocess stops reading before src is exhausted
+// (for example, by exiting), copyStdin reads and discards the rest of src
+// so that whatever is writing to src does not block or fail.
+func copyStdin(pipe *os.File, src io.Reader) {
+	_, err := io.Copy(pipe, src)
+	pipe.Close()
+	if err != nil {
+		io.Copy(io.Discard, src)
+	}
+}
+
+// stop interrupts the process and then kills it if it does not exit
+// (signaled by closing waitDone) within the shutdown grace period.
+func (l Local) stop(ctx context.Context, p *os.Process, waitDone <-chan struct{}) {
+	grace := l.ShutdownGracePeriod
+	if grace == 0 {
+		grace = DefaultShutdownGracePeriod
+	}
+	// Interrupt is not supported on all platforms (notably Windows).
+	if grace < 0 || p.Signal(os.Interrupt) != nil {
+		p.Kill()
+		return
+	}
+	t := time.NewTimer(grace)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		log.Debugf(ctx, "Process %d did not exit within %v of interrupt; killing", p.Pid, grace)
+		p.Kill()
+	case <-waitDone:
+	}
+}
+
+// resolveDir returns the absolute path of an Invocation.Dir value.
+// It returns an error if the directory is outside the biome's directories
+// or is not an existing directory.
+func (l Local) resolveDir(dir string) (string, error) {
+	if !filepath.IsAbs(dir) {
+		rel := filepath.Clean(dir)
+		if isParentRel(rel) {
+			return "", fmt.Errorf("directory %q is outside the working directory", dir)
+		}
+		dir = filepath.Join(l.WorkDir, rel)
+	} else {
+		dir = filepath.Clean(dir)
+		if !l.inRoots(dir) {
+			return "", fmt.Errorf("directory %s is outside the biome's directories", dir)
+		}
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("directory %s does not exist", dir)
+		}
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", dir)
+	}
+	return dir, nil
+}
+
+// inRoots reports whether the absolute path is inside
+// one of the biome's directories.
+func (l Local) inRoots(path string) bool {
+	dirs := l.Dirs()
+	for _, root := range []string{dirs.Work, dirs.Home, dirs.Tools} {
+		rel, err := filepath.Rel(root, path)
+		if err == nil && !isParentRel(rel) {
+			return true
+		}
+	}
+	return false
+}
+
+func isParentRel(rel string) bool {
+	return rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
 func appendStandardEnv(env []string, biomeOS string) []string {
 	env = append(env, "TZ=UTC0")
 	if biomeOS == MacOS {
@@ -213,6 +795,15 @@ func appendStandardEnv(env []string, biomeOS string) []string {
 	return env
 }
 
+// hostPATH returns the PATH that subprocesses start with
+// before any Environment changes are applied.
+func (l Local) hostPATH() string {
+	if l.Isolate {
+		return ""
+	}
+	return os.Getenv("PATH")
+}
+
 func (l Local) lookPath(env Environment, dir string, program string) (string, error) {
 	abs := func(path string) string {
 		if filepath.IsAbs(path) {
@@ -223,7 +814,7 @@ func (l Local) lookPath(env Environment, dir string, program string) (string, er
 	if strings.ContainsRune(program, filepath.Separator) {
 		return exec.LookPath(abs(program))
 	}
-	envPATH := env.computePATH(os.Getenv("PATH"), filepath.ListSeparator)
+	envPATH := env.computePATH(l.hostPATH(), filepath.ListSeparator)
 	envPATH = envPATH[len("PATH="):]
 	for _, p := range filepath.SplitList(envPATH) {
 		if found, err := exec.LookPath(abs(filepath.Join(p, program))); err == nil {
@@ -239,37 +830,120 @@ func (l Local) OpenFile(ctx context.Context, path string) (io.ReadCloser, error)
 }
 
 // WriteFile writes the data from src to the given path with the mode 0666.
+// If the file already exists, its permissions are preserved.
+// The data is first written to a temporary file in the same directory,
+// which is then renamed over the target, so the target is never left
+// partially written. If path is a symbolic link, the file it refers to
+// is replaced.
 func (l Local) WriteFile(ctx context.Context, path string, src io.Reader) error {
-	f, err := os.Create(AbsPath(l, path))
+	target := AbsPath(l, path)
+	if resolved, err := filepath.EvalSymlinks(target); err == nil {
+		target = resolved
+	}
+	f, err := createSiblingTemp(target)
 	if err != nil {
-		return err
+		return fmt.Errorf("write file %s: %w", path, err)
 	}
-	_, writeErr := io.Copy(f, src)
-	closeErr := f.Close()
-	if writeErr != nil {
-		return fmt.Errorf("write file %s: %w", path, writeErr)
+	tempPath := f.Name()
+	if err := writeTemp(f, target, src); err != nil {
+		if rmErr := os.Remove(tempPath); rmErr != nil {
+			log.Debugf(ctx, "Removing %s: %v", tempPath, rmErr)
+		}
+		return fmt.Errorf("write file %s: %w", path, err)
 	}
-	if closeErr != nil {
-		return fmt.Errorf("write file %s: %w", path, closeErr)
+	if err := os.Rename(tempPath, target); err != nil {
+		if rmErr := os.Remove(tempPath); rmErr != nil {
+			log.Debugf(ctx, "Removing %s: %v", tempPath, rmErr)
+		}
+		return fmt.Errorf("write file %s: %w", path, err)
 	}
 	return nil
 }
 
+// createSiblingTemp creates a new file in the same directory as path
+// with the mode 0666 (before umask).
+func createSiblingTemp(path string) (*os.File, error) {
+	dir, base := filepath.Split(path)
+	var buf [6]byte
+	for tries := 0; ; tries++ {
+		if _, err := rand.Read(buf[:]); err != nil {
+			return nil, err
+		}
+		name := filepath.Join(dir, "."+base+"."+hex.EncodeToString(buf[:])+".tmp")
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o666)
+		if errors.Is(err, fs.ErrExist) && tries < 100 {
+			continue
+		}
+		return f, err
+	}
+}
+
+// writeTemp copies src into f, matches the permissions of target
+// if it exists, and then flushes and closes f.
+func writeTemp(f *os.File, target string, src io.Reader) error {
+	_, err := io.Copy(f, src)
+	if err == nil {
+		if info, statErr := os.Stat(target); statErr == nil && info.Mode().IsRegular() {
+			err = f.Chmod(info.Mode().Perm())
+		}
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	closeErr := f.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
 // MkdirAll calls os.MkdirAll(path, 0777).
 func (l Local) MkdirAll(ctx context.Context, path string) error {
 	return os.MkdirAll(AbsPath(l, path), 0777)
 }
 
+// MkdirAllPerm calls os.MkdirAll(path, perm).
+func (l Local) MkdirAllPerm(ctx context.Context, path string, perm fs.FileMode) error {
+	return os.MkdirAll(AbsPath(l, path), perm)
+}
+
 // EvalSymlinks calls filepath.EvalSymlinks.
 func (l Local) EvalSymlinks(ctx context.Context, path string) (string, error) {
 	return filepath.EvalSymlinks(AbsPath(l, path))
 }
 
+// Stat calls os.Stat.
+func (l Local) Stat(ctx context.Context, path string) (fs.FileInfo, error) {
+	return os.Stat(AbsPath(l, path))
+}
+
+// ReadDir calls os.ReadDir.
+func (l Local) ReadDir(ctx context.Context, path string) ([]fs.DirEntry, error) {
+	return os.ReadDir(AbsPath(l, path))
+}
+
 // Close does nothing and returns nil.
 func (l Local) Close() error {
 	return nil
 }
 
+// AsLocal reports whether bio is a Local biome, possibly wrapped
+// in biomes like EnvBiome that have an Unwrap method returning the
+// underlying biome. Callers can use the result to access the biome's files
+// on the host directly.
+func AsLocal(bio Biome) (Local, bool) {
+	for {
+		switch b := bio.(type) {
+		case Local:
+			return b, true
+		case interface{ Unwrap() Biome }:
+			bio = b.Unwrap()
+		default:
+			return Local{}, false
+		}
+	}
+}
+
 // ExecPrefix intercepts calls to Run and prepends elements to the Argv slice.
 // This can be used to invoke tools with a wrapping command like `time` or `sudo`.
 type ExecPrefix struct {
@@ -306,11 +980,26 @@ func (ep ExecPrefix) MkdirAll(ctx context.Context, path string) error {
 	return forwardMkdirAll(ctx, ep.Biome, path)
 }
 
+// MkdirAllPerm calls ep.Context.MkdirAllPerm or returns ErrUnsupported if not present.
+func (ep ExecPrefix) MkdirAllPerm(ctx context.Context, path string, perm fs.FileMode) error {
+	return forwardMkdirAllPerm(ctx, ep.Biome, path, perm)
+}
+
 // EvalSymlinks calls ep.Context.EvalSymlinks or returns ErrUnsupported if not present.
 func (ep ExecPrefix) EvalSymlinks(ctx context.Context, path string) (string, error) {
 	return forwardEvalSymlinks(ctx, ep.Biome, path)
 }
 
+// Stat calls ep.Context.Stat or returns ErrUnsupported if not present.
+func (ep ExecPrefix) Stat(ctx context.Context, path string) (fs.FileInfo, error) {
+	return forwardStat(ctx, ep.Biome, path)
+}
+
+// ReadDir calls ep.Context.ReadDir or returns ErrUnsupported if not present.
+func (ep ExecPrefix) ReadDir(ctx context.Context, path string) ([]fs.DirEntry, error) {
+	return forwardReadDir(ctx, ep.Biome, path)
+}
+
 // Close calls ep.Biome.Close if such a method exists or returns nil if not present.
 func (ep ExecPrefix) Close() error {
 	if c, ok := ep.Biome.(io.Closer); ok {
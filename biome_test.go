@@ -18,13 +18,18 @@ package biome
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
@@ -37,7 +42,10 @@ var (
 		fileOpener
 		fileWriter
 		dirMaker
+		dirPermMaker
 		symlinkEvaler
+		statter
+		dirReader
 	} = Local{}
 
 	_ interface {
@@ -45,12 +53,46 @@ var (
 		fileOpener
 		fileWriter
 		dirMaker
+		dirPermMaker
 		symlinkEvaler
+		statter
+		dirReader
 	} = ExecPrefix{}
 
 	_ interface {
 		BiomeCloser
+		fileOpener
+		fileWriter
+		dirMaker
+		dirPermMaker
+		symlinkEvaler
+		statter
+		dirReader
 	} = (*Fake)(nil)
+
+	_ interface {
+		BiomeCloser
+		fileOpener
+		fileWriter
+		dirMaker
+		dirPermMaker
+		commandFinder
+		symlinkEvaler
+		statter
+		dirReader
+	} = nopCloser{}
+
+	_ interface {
+		BiomeCloser
+		fileOpener
+		fileWriter
+		dirMaker
+		dirPermMaker
+		commandFinder
+		symlinkEvaler
+		statter
+		dirReader
+	} = closer{}
 )
 
 func TestLocal(t *testing.T) {
@@ -75,6 +117,7 @@ func TestLocal(t *testing.T) {
 		t.Fatal(err)
 	}
 	homeDir := t.TempDir()
+	outsideDir := t.TempDir()
 
 	tests := []struct {
 		name    string
@@ -133,6 +176,78 @@ func TestLocal(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "DirEscape",
+			invoke: &Invocation{
+				Argv: []string{"true"},
+				Dir:  filepath.Join("..", "foo"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "DirEscapeThroughSubdir",
+			invoke: &Invocation{
+				Argv: []string{"true"},
+				Dir:  filepath.Join("foo", "..", ".."),
+			},
+			wantErr: true,
+		},
+		{
+			name: "DirSubdirParent",
+			invoke: &Invocation{
+				Argv: []string{"true"},
+				Dir:  filepath.Join("foo", ".."),
+			},
+			wantErr: false,
+		},
+		{
+			name: "DirMissing",
+			invoke: &Invocation{
+				Argv: []string{"true"},
+				Dir:  "bar",
+			},
+			wantErr: true,
+		},
+		{
+			name: "DirNotDirectory",
+			invoke: &Invocation{
+				Argv: []string{"true"},
+				Dir:  filepath.Join("foo", "xyzzy"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "AbsDirInWork",
+			invoke: &Invocation{
+				Argv: []string{"true"},
+				Dir:  filepath.Join(workDir, "foo"),
+			},
+			wantErr: false,
+		},
+		{
+			name: "AbsDirInHome",
+			invoke: &Invocation{
+				Argv: []string{"true"},
+				Dir:  homeDir,
+			},
+			wantErr: false,
+		},
+		{
+			name: "AbsDirOutside",
+			invoke: &Invocation{
+				Argv: []string{"true"},
+				Dir:  outsideDir,
+			},
+			wantErr: true,
+		},
+		{
+			name: "AbsDirEscape",
+			invoke: &Invocation{
+				Argv: []string{"true"},
+				Dir:  filepath.Join(workDir, "..", filepath.Base(outsideDir)),
+			},
+			wantErr: true,
+		},
 		{
 			name: "RelativePATH",
 			invoke: &Invocation{
@@ -165,6 +280,762 @@ func TestLocal(t *testing.T) {
 	}
 }
 
+func TestAsLocal(t *testing.T) {
+	local := Local{WorkDir: "/work"}
+	tests := []struct {
+		name   string
+		bio    Biome
+		wantOK bool
+	}{
+		{name: "Local", bio: local, wantOK: true},
+		{name: "EnvBiome", bio: EnvBiome{Biome: local}, wantOK: true},
+		{name: "NestedEnvBiome", bio: EnvBiome{Biome: EnvBiome{Biome: local}}, wantOK: true},
+		{name: "ExecPrefix", bio: ExecPrefix{Biome: local}, wantOK: false},
+		{name: "Fake", bio: EnvBiome{Biome: new(Fake)}, wantOK: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := AsLocal(test.bio)
+			if ok != test.wantOK {
+				t.Fatalf("AsLocal(...) = _, %t; want _, %t", ok, test.wantOK)
+			}
+			if ok && got.WorkDir != local.WorkDir {
+				t.Errorf("AsLocal(...).WorkDir = %q; want %q", got.WorkDir, local.WorkDir)
+			}
+		})
+	}
+}
+
+func TestNormalizeArch(t *testing.T) {
+	tests := []struct {
+		arch string
+		want string
+	}{
+		{arch: "amd64", want: AMD64},
+		{arch: "x86_64", want: Intel64},
+		{arch: "X86_64", want: Intel64},
+		{arch: "x64", want: Intel64},
+		{arch: "386", want: Intel32},
+		{arch: "i686", want: Intel32},
+		{arch: "arm64", want: ARM64},
+		{arch: "aarch64", want: ARM64},
+		{arch: "armv7l", want: ARM},
+		{arch: "arm", want: ARM},
+		{arch: "riscv64", want: "riscv64"},
+		{arch: "PPC64LE", want: "ppc64le"},
+		{arch: "", want: ""},
+	}
+	for _, test := range tests {
+		if got := NormalizeArch(test.arch); got != test.want {
+			t.Errorf("NormalizeArch(%q) = %q; want %q", test.arch, got, test.want)
+		}
+	}
+}
+
+func TestNormalizeOS(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "linux", want: Linux},
+		{name: "Linux", want: Linux},
+		{name: "darwin", want: MacOS},
+		{name: "Darwin", want: Darwin},
+		{name: "macOS", want: MacOS},
+		{name: "windows", want: Windows},
+		{name: "Windows_NT", want: Windows},
+		{name: "MINGW64_NT-10.0-19045", want: Windows},
+		{name: "MSYS_NT-10.0-19045", want: Windows},
+		{name: "CYGWIN_NT-10.0", want: Windows},
+		{name: "FreeBSD", want: "freebsd"},
+		{name: "", want: ""},
+	}
+	for _, test := range tests {
+		if got := NormalizeOS(test.name); got != test.want {
+			t.Errorf("NormalizeOS(%q) = %q; want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestDescriptorEqual(t *testing.T) {
+	tests := []struct {
+		desc1, desc2 Descriptor
+		want         bool
+	}{
+		{desc1: Descriptor{OS: Linux, Arch: Intel64}, desc2: Descriptor{OS: Linux, Arch: Intel64}, want: true},
+		{desc1: Descriptor{OS: Linux, Arch: Intel64}, desc2: Descriptor{OS: Linux, Arch: "x86_64"}, want: true},
+		{desc1: Descriptor{OS: Linux, Arch: Intel64}, desc2: Descriptor{OS: MacOS, Arch: Intel64}, want: false},
+		{desc1: Descriptor{OS: Linux, Arch: Intel64}, desc2: Descriptor{OS: Linux, Arch: "aarch64"}, want: false},
+		{desc1: Descriptor{OS: MacOS, Arch: ARM64}, desc2: Descriptor{OS: "Darwin", Arch: "arm64"}, want: true},
+	}
+	for _, test := range tests {
+		if got := test.desc1.Equal(&test.desc2); got != test.want {
+			t.Errorf("(%+v).Equal(%+v) = %t; want %t", test.desc1, test.desc2, got, test.want)
+		}
+	}
+}
+
+func TestDescriptorCompatible(t *testing.T) {
+	tests := []struct {
+		desc1, desc2 Descriptor
+		want         bool
+	}{
+		{desc1: Descriptor{OS: Linux, Arch: Intel64}, desc2: Descriptor{OS: Linux, Arch: "x86_64"}, want: true},
+		{desc1: Descriptor{OS: Linux, Arch: Intel64}, desc2: Descriptor{OS: MacOS, Arch: Intel64}, want: false},
+		{desc1: Descriptor{OS: Linux, Arch: Intel64}, desc2: Descriptor{OS: Linux, Arch: Intel32}, want: true},
+		{desc1: Descriptor{OS: Linux, Arch: Intel32}, desc2: Descriptor{OS: Linux, Arch: Intel64}, want: false},
+		{desc1: Descriptor{OS: Linux, Arch: ARM64}, desc2: Descriptor{OS: Linux, Arch: Intel64}, want: false},
+		{desc1: Descriptor{OS: Linux, Arch: Intel64}, desc2: Descriptor{OS: Linux, Arch: ARM64}, want: false},
+		{desc1: Descriptor{OS: MacOS, Arch: ARM64}, desc2: Descriptor{OS: MacOS, Arch: Intel64}, want: true},
+		{desc1: Descriptor{OS: MacOS, Arch: "aarch64"}, desc2: Descriptor{OS: MacOS, Arch: "x86_64"}, want: true},
+		{desc1: Descriptor{OS: MacOS, Arch: Intel64}, desc2: Descriptor{OS: MacOS, Arch: ARM64}, want: false},
+		{desc1: Descriptor{OS: MacOS, Arch: Intel64}, desc2: Descriptor{OS: MacOS, Arch: Intel32}, want: false},
+		{desc1: Descriptor{OS: Windows, Arch: ARM64}, desc2: Descriptor{OS: Windows, Arch: Intel64}, want: true},
+		{desc1: Descriptor{OS: Windows, Arch: ARM64}, desc2: Descriptor{OS: Windows, Arch: Intel32}, want: true},
+		{desc1: Descriptor{OS: Windows, Arch: Intel64}, desc2: Descriptor{OS: Windows, Arch: Intel32}, want: true},
+	}
+	for _, test := range tests {
+		if got := test.desc1.Compatible(&test.desc2); got != test.want {
+			t.Errorf("(%+v).Compatible(%+v) = %t; want %t", test.desc1, test.desc2, got, test.want)
+		}
+	}
+}
+
+func TestLocalDescribe(t *testing.T) {
+	want := &Descriptor{OS: runtime.GOOS, Arch: runtime.GOARCH}
+	host := (Local{}).Describe()
+	if !host.Equal(want) {
+		t.Errorf("Local{}.Describe() = %+v; want %+v", host, want)
+	}
+	if got, want := host.PathSep(), string(os.PathListSeparator); got != want {
+		t.Errorf("Local{}.Describe().PathSep() = %q; want %q", got, want)
+	}
+	if got, want := JoinPath(host, "foo", "bar"), filepath.Join("foo", "bar"); got != want {
+		t.Errorf("JoinPath(Local{}.Describe(), \"foo\", \"bar\") = %q; want %q", got, want)
+	}
+	override := &Descriptor{OS: runtime.GOOS, Arch: Intel32}
+	l := Local{Descriptor: override}
+	got := l.Describe()
+	if !got.Equal(override) {
+		t.Errorf("Local{Descriptor: %+v}.Describe() = %+v; want %+v", override, got, override)
+	}
+	got.Arch = ARM64
+	if override.Arch != Intel32 {
+		t.Error("Modifying the result of Describe changed Local.Descriptor")
+	}
+}
+
+func TestLocalDirs(t *testing.T) {
+	workDir := t.TempDir()
+	homeDir := t.TempDir()
+	toolsDir := t.TempDir()
+	tests := []struct {
+		name string
+		l    Local
+		want Dirs
+	}{
+		{
+			name: "DefaultTools",
+			l: Local{
+				WorkDir: workDir,
+				HomeDir: homeDir,
+			},
+			want: Dirs{
+				Work:  workDir,
+				Home:  homeDir,
+				Tools: filepath.Join(homeDir, ".cache", "zombiezen-biome", "tools"),
+			},
+		},
+		{
+			name: "ToolsDir",
+			l: Local{
+				WorkDir:  workDir,
+				HomeDir:  homeDir,
+				ToolsDir: toolsDir,
+			},
+			want: Dirs{
+				Work:  workDir,
+				Home:  homeDir,
+				Tools: toolsDir,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if diff := cmp.Diff(&test.want, test.l.Dirs()); diff != "" {
+				t.Errorf("Dirs() (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCombinedOutput(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("Cannot find sh:", err)
+	}
+	ctx := testlog.WithTB(context.Background(), t)
+	l := Local{
+		WorkDir: t.TempDir(),
+		HomeDir: t.TempDir(),
+	}
+	got, err := CombinedOutput(ctx, l, "sh", "-c", "echo foo; echo bar 1>&2; echo baz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = "foo\nbar\nbaz\n"
+	if string(got) != want {
+		t.Errorf("CombinedOutput(...) = %q; want %q", got, want)
+	}
+}
+
+func TestLocalNilStdin(t *testing.T) {
+	if _, err := exec.LookPath("cat"); err != nil {
+		t.Skip("Cannot find cat:", err)
+	}
+	ctx := testlog.WithTB(context.Background(), t)
+	l := Local{
+		WorkDir: t.TempDir(),
+		HomeDir: t.TempDir(),
+	}
+	got, err := CombinedOutput(ctx, l, "cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) > 0 {
+		t.Errorf("cat output = %q; want \"\"", got)
+	}
+}
+
+func TestLocalConcurrent(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("Cannot find sh:", err)
+	}
+	ctx := testlog.WithTB(context.Background(), t)
+	l := Local{
+		WorkDir: t.TempDir(),
+		HomeDir: t.TempDir(),
+	}
+	// All goroutines share the same Environment to check that Run
+	// does not modify it.
+	env := Environment{
+		Vars:        map[string]string{"GREETING": "Hello"},
+		PrependPath: []string{l.WorkDir},
+	}
+	const n = 50
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stdout := new(strings.Builder)
+			err := l.Run(ctx, &Invocation{
+				Argv:   []string{"sh", "-c", `echo "$GREETING $0"`, fmt.Sprint(i)},
+				Env:    env,
+				Stdout: stdout,
+				Stderr: stdout,
+			})
+			if err != nil {
+				t.Errorf("Run #%d: %v", i, err)
+				return
+			}
+			if got, want := stdout.String(), fmt.Sprintf("Hello %d\n", i); got != want {
+				t.Errorf("Run #%d output = %q; want %q", i, got, want)
+			}
+
+			name := fmt.Sprintf("file%02d.txt", i)
+			content := fmt.Sprintf("content %d", i)
+			if err := l.WriteFile(ctx, name, strings.NewReader(content)); err != nil {
+				t.Errorf("WriteFile #%d: %v", i, err)
+				return
+			}
+			f, err := l.OpenFile(ctx, name)
+			if err != nil {
+				t.Errorf("OpenFile #%d: %v", i, err)
+				return
+			}
+			got, err := ioutil.ReadAll(f)
+			f.Close()
+			if err != nil {
+				t.Errorf("OpenFile #%d: %v", i, err)
+				return
+			}
+			if string(got) != content {
+				t.Errorf("OpenFile #%d content = %q; want %q", i, got, content)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestLocalWriteFile(t *testing.T) {
+	ctx := testlog.WithTB(context.Background(), t)
+	newLocal := func(t *testing.T) Local {
+		dir := t.TempDir()
+		return Local{
+			HomeDir: filepath.Join(dir, "home"),
+			WorkDir: dir,
+		}
+	}
+	checkDir := func(t *testing.T, dir string, want ...string) {
+		t.Helper()
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, ent := range entries {
+			got = append(got, ent.Name())
+		}
+		if diff := cmp.Diff(want, got, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("directory entries (-want +got):\n%s", diff)
+		}
+	}
+
+	t.Run("Replace", func(t *testing.T) {
+		l := newLocal(t)
+		path := filepath.Join(l.WorkDir, "foo.txt")
+		if err := os.WriteFile(path, []byte("old content"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		const want = "new"
+		if err := l.WriteFile(ctx, "foo.txt", strings.NewReader(want)); err != nil {
+			t.Fatal("WriteFile:", err)
+		}
+		if got, err := os.ReadFile(path); err != nil {
+			t.Error(err)
+		} else if string(got) != want {
+			t.Errorf("content = %q; want %q", got, want)
+		}
+		if runtime.GOOS != Windows {
+			if info, err := os.Stat(path); err != nil {
+				t.Error(err)
+			} else if got := info.Mode().Perm(); got != 0o600 {
+				t.Errorf("mode = %v; want %v", got, os.FileMode(0o600))
+			}
+		}
+		checkDir(t, l.WorkDir, "foo.txt")
+	})
+
+	t.Run("ReadError", func(t *testing.T) {
+		l := newLocal(t)
+		path := filepath.Join(l.WorkDir, "foo.txt")
+		const want = "old content"
+		if err := os.WriteFile(path, []byte(want), 0o666); err != nil {
+			t.Fatal(err)
+		}
+		src := io.MultiReader(strings.NewReader("partial"), errReader{errors.New("bork")})
+		if err := l.WriteFile(ctx, "foo.txt", src); err == nil {
+			t.Error("WriteFile did not return an error")
+		}
+		if got, err := os.ReadFile(path); err != nil {
+			t.Error(err)
+		} else if string(got) != want {
+			t.Errorf("content = %q; want %q", got, want)
+		}
+		checkDir(t, l.WorkDir, "foo.txt")
+	})
+
+	t.Run("Symlink", func(t *testing.T) {
+		if runtime.GOOS == Windows {
+			t.Skip("Symlinks not reliably available on Windows")
+		}
+		l := newLocal(t)
+		if err := os.WriteFile(filepath.Join(l.WorkDir, "target.txt"), []byte("old content"), 0o666); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink("target.txt", filepath.Join(l.WorkDir, "link.txt")); err != nil {
+			t.Fatal(err)
+		}
+		const want = "new"
+		if err := l.WriteFile(ctx, "link.txt", strings.NewReader(want)); err != nil {
+			t.Fatal("WriteFile:", err)
+		}
+		if got, err := os.ReadFile(filepath.Join(l.WorkDir, "target.txt")); err != nil {
+			t.Error(err)
+		} else if string(got) != want {
+			t.Errorf("target content = %q; want %q", got, want)
+		}
+		if info, err := os.Lstat(filepath.Join(l.WorkDir, "link.txt")); err != nil {
+			t.Error(err)
+		} else if info.Mode()&os.ModeSymlink == 0 {
+			t.Error("link.txt is no longer a symlink")
+		}
+	})
+}
+
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
+
+func TestLocalPTY(t *testing.T) {
+	if runtime.GOOS != Linux {
+		t.Skip("Pseudo-terminals only supported on Linux")
+	}
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("Cannot find sh:", err)
+	}
+	ctx := testlog.WithTB(context.Background(), t)
+	l := Local{
+		WorkDir: t.TempDir(),
+		HomeDir: t.TempDir(),
+	}
+	const script = `if [ -t 0 ]; then echo stdin=tty; else echo stdin=notty; fi; ` +
+		`if [ -t 1 ]; then echo stdout=tty; else echo stdout=notty; fi; ` +
+		`if [ -t 2 ]; then echo stderr=tty >&2; else echo stderr=notty >&2; fi`
+	tests := []struct {
+		pty  bool
+		want string
+	}{
+		{pty: false, want: "stdin=notty\nstdout=notty\nstderr=notty\n"},
+		// Terminals translate newlines into carriage return + newline.
+		{pty: true, want: "stdin=notty\r\nstdout=tty\r\nstderr=tty\r\n"},
+	}
+	for _, test := range tests {
+		output := new(strings.Builder)
+		err := l.Run(ctx, &Invocation{
+			Argv:           []string{"sh", "-c", script},
+			PTY:            test.pty,
+			CombinedOutput: output,
+		})
+		if err != nil {
+			t.Errorf("Run(PTY: %t): %v", test.pty, err)
+			continue
+		}
+		if got := output.String(); got != test.want {
+			t.Errorf("Run(PTY: %t) output = %q; want %q", test.pty, got, test.want)
+		}
+	}
+}
+
+func TestLocalStdinDrain(t *testing.T) {
+	if _, err := exec.LookPath("true"); err != nil {
+		t.Skip("Cannot find true:", err)
+	}
+	ctx := testlog.WithTB(context.Background(), t)
+	l := Local{
+		WorkDir: t.TempDir(),
+		HomeDir: t.TempDir(),
+	}
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	writeDone := make(chan error, 1)
+	go func() {
+		// Write more than fits in a pipe buffer.
+		// Report before closing so that the result is available
+		// by the time Run reads EOF.
+		_, err := pw.Write(make([]byte, 1<<20))
+		writeDone <- err
+		pw.Close()
+	}()
+	err := l.Run(ctx, &Invocation{
+		Argv:  []string{"true"},
+		Stdin: pr,
+	})
+	if err != nil {
+		t.Fatal("Run:", err)
+	}
+	// Run must finish draining Stdin before it returns.
+	select {
+	case err := <-writeDone:
+		if err != nil {
+			t.Error("Write to stdin:", err)
+		}
+	default:
+		t.Fatal("Run returned before Stdin was drained")
+	}
+}
+
+func TestLocalIsolate(t *testing.T) {
+	envPath, err := exec.LookPath("env")
+	if err != nil {
+		t.Skip("Cannot find env:", err)
+	}
+	ctx := testlog.WithTB(context.Background(), t)
+	t.Setenv("BIOME_TEST_HOST_VAR", "leaked")
+	homeDir := t.TempDir()
+	l := Local{
+		WorkDir: t.TempDir(),
+		HomeDir: homeDir,
+		Isolate: true,
+	}
+	out := new(strings.Builder)
+	err = l.Run(ctx, &Invocation{
+		Argv: []string{envPath},
+		Env: Environment{
+			Vars:        map[string]string{"FOO": "bar"},
+			PrependPath: []string{"/biome/bin"},
+		},
+		Stdout: out,
+		Stderr: out,
+	})
+	if err != nil {
+		t.Fatalf("env: %v; output:\n%s", err, out)
+	}
+	got := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n") {
+		eq := strings.Index(line, "=")
+		if eq == -1 {
+			t.Fatalf("env printed %q", line)
+		}
+		got[line[:eq]] = line[eq+1:]
+	}
+	want := make(map[string]string)
+	for _, e := range appendStandardEnv(nil, runtime.GOOS) {
+		eq := strings.Index(e, "=")
+		want[e[:eq]] = e[eq+1:]
+	}
+	want["HOME"] = homeDir
+	want["FOO"] = "bar"
+	want["PATH"] = "/biome/bin"
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("environment (-want +got):\n%s", diff)
+	}
+
+	// Programs are not found on the host's PATH.
+	err = l.Run(ctx, &Invocation{Argv: []string{"env"}})
+	if err == nil {
+		t.Error("Running env by name succeeded; want error")
+	}
+}
+
+func TestLocalResourceLimits(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("Cannot find sh:", err)
+	}
+	ctx := testlog.WithTB(context.Background(), t)
+	l := Local{
+		WorkDir:     t.TempDir(),
+		HomeDir:     t.TempDir(),
+		CPUQuota:    0.5,
+		MemoryBytes: 256 << 20,
+	}
+	// Whether or not the host supports enforcing the limits,
+	// the program still runs.
+	out := new(strings.Builder)
+	err := l.Run(ctx, &Invocation{
+		Argv:   []string{"sh", "-c", "echo hello"},
+		Stdout: out,
+	})
+	if err != nil {
+		t.Fatal("Run:", err)
+	}
+	if got, want := out.String(), "hello\n"; got != want {
+		t.Errorf("output = %q; want %q", got, want)
+	}
+
+	l.MemoryBytes = -1
+	if err := l.Run(ctx, &Invocation{Argv: []string{"sh", "-c", "true"}}); err == nil {
+		t.Error("Run with negative MemoryBytes succeeded")
+	}
+}
+
+func TestLocalCancel(t *testing.T) {
+	if runtime.GOOS == Windows {
+		t.Skip("Interrupt not supported on Windows")
+	}
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("Cannot find sh:", err)
+	}
+
+	t.Run("Interrupt", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(testlog.WithTB(context.Background(), t))
+		defer cancel()
+		l := Local{
+			WorkDir:             t.TempDir(),
+			HomeDir:             t.TempDir(),
+			ShutdownGracePeriod: 30 * time.Second,
+		}
+		stdout := new(strings.Builder)
+		pr, pw := io.Pipe()
+		done := make(chan error, 1)
+		go func() {
+			done <- l.Run(ctx, &Invocation{
+				Argv:   []string{"sh", "-c", `trap 'echo interrupted; exit 3' INT; echo ready >&2; sleep 30 >/dev/null 2>&1 & wait`},
+				Stdout: stdout,
+				Stderr: pw,
+			})
+			pw.Close()
+		}()
+		// Wait for the trap to be installed before cancelling.
+		if _, err := io.ReadFull(pr, make([]byte, len("ready\n"))); err != nil {
+			t.Fatal(err)
+		}
+		go io.Copy(io.Discard, pr)
+		start := time.Now()
+		cancel()
+		err := <-done
+		if err == nil {
+			t.Error("Run did not return an error")
+		}
+		if elapsed := time.Since(start); elapsed >= 10*time.Second {
+			t.Errorf("Run took %v to return after cancel", elapsed)
+		}
+		if got, want := stdout.String(), "interrupted\n"; got != want {
+			t.Errorf("stdout = %q; want %q", got, want)
+		}
+	})
+
+	t.Run("Kill", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(testlog.WithTB(context.Background(), t))
+		defer cancel()
+		l := Local{
+			WorkDir:             t.TempDir(),
+			HomeDir:             t.TempDir(),
+			ShutdownGracePeriod: 100 * time.Millisecond,
+		}
+		pr, pw := io.Pipe()
+		done := make(chan error, 1)
+		go func() {
+			// Ignored signals are inherited across exec,
+			// so sleep will ignore the interrupt.
+			done <- l.Run(ctx, &Invocation{
+				Argv:   []string{"sh", "-c", `trap '' INT; echo ready >&2; exec sleep 30`},
+				Stderr: pw,
+			})
+			pw.Close()
+		}()
+		if _, err := io.ReadFull(pr, make([]byte, len("ready\n"))); err != nil {
+			t.Fatal(err)
+		}
+		go io.Copy(io.Discard, pr)
+		start := time.Now()
+		cancel()
+		err := <-done
+		if err == nil {
+			t.Error("Run did not return an error")
+		}
+		if elapsed := time.Since(start); elapsed >= 10*time.Second {
+			t.Errorf("Run took %v to return after cancel", elapsed)
+		}
+	})
+}
+
+func TestHasCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		os       string
+		command  string
+		want     bool
+		wantArgv []string
+	}{
+		{
+			name:     "POSIX/Found",
+			os:       Linux,
+			command:  "tar",
+			want:     true,
+			wantArgv: []string{"sh", "-c", `command -v "$1"`, "sh", "tar"},
+		},
+		{
+			name:     "POSIX/Missing",
+			os:       Linux,
+			command:  "unzip",
+			want:     false,
+			wantArgv: []string{"sh", "-c", `command -v "$1"`, "sh", "unzip"},
+		},
+		{
+			name:     "Windows/Found",
+			os:       Windows,
+			command:  "tar",
+			want:     true,
+			wantArgv: []string{"where", "tar"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := testlog.WithTB(context.Background(), t)
+			var calls [][]string
+			bio := &Fake{
+				Descriptor: Descriptor{OS: test.os, Arch: Intel64},
+				DirsResult: Dirs{Work: "/work", Home: "/home"},
+				RunFunc: func(ctx context.Context, invoke *Invocation) error {
+					calls = append(calls, invoke.Argv)
+					if invoke.Argv[len(invoke.Argv)-1] != "tar" {
+						return fmt.Errorf("not found")
+					}
+					return nil
+				},
+			}
+
+			// Without a cache, every call runs a lookup.
+			for i := 0; i < 2; i++ {
+				got, err := HasCommand(ctx, bio, test.command)
+				if got != test.want || err != nil {
+					t.Errorf("HasCommand(ctx, bio, %q) = %t, %v; want %t, <nil>", test.command, got, err, test.want)
+				}
+			}
+			if diff := cmp.Diff([][]string{test.wantArgv, test.wantArgv}, calls); diff != "" {
+				t.Errorf("invocations without cache (-want +got):\n%s", diff)
+			}
+
+			calls = nil
+			cache := &CommandCache{Biome: bio}
+			for i := 0; i < 2; i++ {
+				got, err := HasCommand(ctx, cache, test.command)
+				if got != test.want || err != nil {
+					t.Errorf("HasCommand(ctx, cache, %q) = %t, %v; want %t, <nil>", test.command, got, err, test.want)
+				}
+			}
+			wantCalls := [][]string{test.wantArgv}
+			if !test.want {
+				// Only found commands are cached.
+				wantCalls = append(wantCalls, test.wantArgv)
+			}
+			if diff := cmp.Diff(wantCalls, calls); diff != "" {
+				t.Errorf("invocations with cache (-want +got):\n%s", diff)
+			}
+
+			// Wrappers use the cache of the biome they wrap.
+			calls = nil
+			cache = &CommandCache{Biome: bio}
+			wrappers := []struct {
+				name string
+				bio  Biome
+			}{
+				{"NopCloser", NopCloser(cache)},
+				{"WithClose", WithClose(NopCloser(cache), func() error { return nil })},
+				{"EnvBiome", EnvBiome{Biome: cache, Env: Environment{Vars: map[string]string{"FOO": "bar"}}}},
+			}
+			for _, w := range wrappers {
+				got, err := HasCommand(ctx, w.bio, test.command)
+				if got != test.want || err != nil {
+					t.Errorf("HasCommand(ctx, %s(cache), %q) = %t, %v; want %t, <nil>", w.name, test.command, got, err, test.want)
+				}
+			}
+			wantCalls = [][]string{test.wantArgv}
+			if !test.want {
+				wantCalls = [][]string{test.wantArgv, test.wantArgv, test.wantArgv}
+			}
+			if diff := cmp.Diff(wantCalls, calls); diff != "" {
+				t.Errorf("invocations through wrappers (-want +got):\n%s", diff)
+			}
+
+			// An EnvBiome that changes PATH does not trust the wrapped biome.
+			calls = nil
+			pathBiome := EnvBiome{Biome: cache, Env: Environment{PrependPath: []string{"/opt/bin"}}}
+			if _, err := HasCommand(ctx, pathBiome, test.command); err != nil {
+				t.Error(err)
+			}
+			if diff := cmp.Diff([][]string{test.wantArgv}, calls); diff != "" {
+				t.Errorf("invocations with PATH-changing EnvBiome (-want +got):\n%s", diff)
+			}
+
+			// Caches are not shared between wrappers of the same biome.
+			calls = nil
+			if _, err := HasCommand(ctx, &CommandCache{Biome: bio}, test.command); err != nil {
+				t.Error(err)
+			}
+			if diff := cmp.Diff([][]string{test.wantArgv}, calls); diff != "" {
+				t.Errorf("invocations with new cache (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
 func TestStandardEnv(t *testing.T) {
 	stdenv := appendStandardEnv(nil, runtime.GOOS)
 
@@ -0,0 +1,43 @@
+// Copyright 2020 YourBase Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !go1.20
+// +build !go1.20
+
+package biome
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// cgroupExecScript is a shell script that moves the shell into
+// the control group whose cgroup.procs file is $1
+// and then replaces itself with the program in the remaining arguments.
+const cgroupExecScript = `echo $$ > "$1" && shift && exec "$@"`
+
+// startInCgroup configures c to run its program inside cg.
+// Go versions before 1.20 cannot start a process in a control group,
+// so c instead runs a shell that joins cg before executing the program,
+// which means the program sees its path as its argv[0].
+// No file needs to be closed once c has started.
+func startInCgroup(c *exec.Cmd, cg *cgroup) (*os.File, error) {
+	args := append([]string{"sh", "-c", cgroupExecScript, "sh", filepath.Join(cg.dir, "cgroup.procs"), c.Path}, c.Args[1:]...)
+	c.Path = "/bin/sh"
+	c.Args = args
+	return nil, nil
+}
@@ -0,0 +1,43 @@
+// Copyright 2020 YourBase Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build go1.20
+// +build go1.20
+
+package biome
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// startInCgroup configures c to start its process directly inside cg,
+// so that the limits apply from the process's first instruction.
+// The returned file must be closed once c has started.
+func startInCgroup(c *exec.Cmd, cg *cgroup) (*os.File, error) {
+	dir, err := os.Open(cg.dir)
+	if err != nil {
+		return nil, fmt.Errorf("start in cgroup: %w", err)
+	}
+	if c.SysProcAttr == nil {
+		c.SysProcAttr = new(syscall.SysProcAttr)
+	}
+	c.SysProcAttr.UseCgroupFD = true
+	c.SysProcAttr.CgroupFD = int(dir.Fd())
+	return dir, nil
+}
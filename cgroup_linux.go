@@ -0,0 +1,185 @@
+// Copyright 2020 YourBase Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package biome
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"zombiezen.com/go/log"
+)
+
+// cgroupRoot is the mount point of the cgroup v2 hierarchy.
+const cgroupRoot = "/sys/fs/cgroup"
+
+// cgroupCPUPeriod is the period (in microseconds) used for the cpu.max limit.
+const cgroupCPUPeriod = 100000
+
+// cgroup is a cgroup v2 control group that limits a subprocess's resources.
+type cgroup struct {
+	dir string
+}
+
+// createCgroup creates a control group with the given limits
+// inside the current process's control group.
+// The current process stays where it is: only subprocesses
+// are started inside the new group (see startInCgroup).
+func createCgroup(limits resourceLimits) (*cgroup, error) {
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		return nil, fmt.Errorf("create cgroup: cgroup v2 not mounted at %s", cgroupRoot)
+	}
+	procData, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return nil, fmt.Errorf("create cgroup: %w", err)
+	}
+	self, err := parseProcCgroup(procData)
+	if err != nil {
+		return nil, fmt.Errorf("create cgroup: %w", err)
+	}
+	parent := filepath.Join(cgroupRoot, filepath.FromSlash(self))
+	files := limits.cgroupFiles()
+	if err := enableControllers(parent, files); err != nil {
+		return nil, fmt.Errorf("create cgroup: %w", err)
+	}
+	var bits [8]byte
+	if _, err := rand.Read(bits[:]); err != nil {
+		return nil, fmt.Errorf("create cgroup: %w", err)
+	}
+	cg := &cgroup{dir: filepath.Join(parent, "biome-"+hex.EncodeToString(bits[:]))}
+	if err := os.Mkdir(cg.dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create cgroup: %w", err)
+	}
+	for _, f := range files {
+		if err := os.WriteFile(filepath.Join(cg.dir, f.name), []byte(f.value), 0o644); err != nil {
+			os.Remove(cg.dir)
+			return nil, fmt.Errorf("create cgroup: %w", err)
+		}
+	}
+	return cg, nil
+}
+
+// remove deletes the control group. It fails if any processes
+// (like orphaned background processes) are still in the group.
+func (cg *cgroup) remove(ctx context.Context) {
+	if err := os.Remove(cg.dir); err != nil {
+		log.Debugf(ctx, "Removing cgroup: %v", err)
+	}
+}
+
+// cgroupFile is a control group interface file and the value to write to it.
+type cgroupFile struct {
+	controller string
+	name       string
+	value      string
+}
+
+// cgroupFiles returns the control group interface files
+// that enforce the limits.
+func (limits resourceLimits) cgroupFiles() []cgroupFile {
+	var files []cgroupFile
+	if limits.cpus > 0 {
+		quota := int64(limits.cpus * cgroupCPUPeriod)
+		if quota < 1000 {
+			// The kernel's minimum quota is 1ms.
+			quota = 1000
+		}
+		files = append(files, cgroupFile{
+			controller: "cpu",
+			name:       "cpu.max",
+			value:      fmt.Sprintf("%d %d", quota, cgroupCPUPeriod),
+		})
+	}
+	if limits.memoryBytes > 0 {
+		files = append(files, cgroupFile{
+			controller: "memory",
+			name:       "memory.max",
+			value:      strconv.FormatInt(limits.memoryBytes, 10),
+		})
+	}
+	return files
+}
+
+// enableControllers enables the controllers needed by files
+// for the children of the control group at dir.
+func enableControllers(dir string, files []cgroupFile) error {
+	enabledData, err := os.ReadFile(filepath.Join(dir, "cgroup.subtree_control"))
+	if err != nil {
+		return err
+	}
+	enabled := strings.Fields(string(enabledData))
+	var missing []string
+	for _, f := range files {
+		if !containsString(enabled, f.controller) && !containsString(missing, f.controller) {
+			missing = append(missing, f.controller)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	availableData, err := os.ReadFile(filepath.Join(dir, "cgroup.controllers"))
+	if err != nil {
+		return err
+	}
+	available := strings.Fields(string(availableData))
+	for _, controller := range missing {
+		if !containsString(available, controller) {
+			return fmt.Errorf("%s controller is not delegated to %s", controller, dir)
+		}
+	}
+	for _, controller := range missing {
+		err := os.WriteFile(filepath.Join(dir, "cgroup.subtree_control"), []byte("+"+controller), 0o644)
+		if errors.Is(err, syscall.EBUSY) {
+			// The cgroup v2 "no internal processes" rule prevents
+			// enabling controllers for the children of a group with processes.
+			return fmt.Errorf("enable %s controller: %s contains processes; "+
+				"enable the controller for its children before running biome", controller, dir)
+		}
+		if err != nil {
+			return fmt.Errorf("enable %s controller: %w", controller, err)
+		}
+	}
+	return nil
+}
+
+// parseProcCgroup returns the cgroup v2 path from the contents of
+// /proc/PID/cgroup.
+func parseProcCgroup(data []byte) (string, error) {
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if path := bytes.TrimPrefix(line, []byte("0::")); len(path) < len(line) {
+			return string(path), nil
+		}
+	}
+	return "", errors.New("process is not in a cgroup v2 control group")
+}
+
+func containsString(list []string, s string) bool {
+	for _, elem := range list {
+		if elem == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,201 @@
+// Copyright 2020 YourBase Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package biome
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"zombiezen.com/go/log/testlog"
+)
+
+func TestLocalRunInCgroup(t *testing.T) {
+	ctx := testlog.WithTB(context.Background(), t)
+	limits := resourceLimits{memoryBytes: 1 << 30}
+	cg, err := createCgroup(limits)
+	if err != nil {
+		t.Skip("Control groups unavailable:", err)
+	}
+	cg.remove(ctx)
+	selfData, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	self, err := parseProcCgroup(selfData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := Local{
+		WorkDir:     t.TempDir(),
+		HomeDir:     t.TempDir(),
+		MemoryBytes: limits.memoryBytes,
+	}
+	stdout := new(strings.Builder)
+	err = l.Run(ctx, &Invocation{
+		Argv:   []string{"cat", "/proc/self/cgroup"},
+		Stdout: stdout,
+	})
+	if err != nil {
+		t.Fatal("Run:", err)
+	}
+	got, err := parseProcCgroup([]byte(stdout.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path.Dir(got) != self || !strings.HasPrefix(path.Base(got), "biome-") {
+		t.Errorf("subprocess control group = %q; want %s/biome-*", got, self)
+	}
+	// Only the subprocess is placed in the new control group.
+	if selfData2, err := os.ReadFile("/proc/self/cgroup"); err != nil {
+		t.Error(err)
+	} else if self2, err := parseProcCgroup(selfData2); err != nil || self2 != self {
+		t.Errorf("after Run, current process control group = %q, %v; want %q, <nil>", self2, err, self)
+	}
+}
+
+func TestEnableControllers(t *testing.T) {
+	files := []cgroupFile{{controller: "memory", name: "memory.max", value: "1024"}}
+	tests := []struct {
+		name        string
+		controllers string
+		enabled     string
+		want        string
+		err         bool
+	}{
+		{
+			name:        "Delegated",
+			controllers: "cpu memory\n",
+			want:        "+memory",
+		},
+		{
+			name:        "AlreadyEnabled",
+			controllers: "cpu memory\n",
+			enabled:     "memory\n",
+			want:        "memory\n",
+		},
+		{
+			name:        "NotDelegated",
+			controllers: "cpu\n",
+			err:         true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles := map[string]string{
+				"cgroup.controllers":     test.controllers,
+				"cgroup.subtree_control": test.enabled,
+			}
+			for name, content := range writeFiles {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := enableControllers(dir, files)
+			if test.err {
+				if err == nil {
+					t.Error("enableControllers did not return an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("enableControllers:", err)
+			}
+			// Writing to the real file appends, but a regular file is overwritten.
+			if got, err := os.ReadFile(filepath.Join(dir, "cgroup.subtree_control")); err != nil {
+				t.Error(err)
+			} else if string(got) != test.want {
+				t.Errorf("cgroup.subtree_control = %q; want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseProcCgroup(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+		err  bool
+	}{
+		{
+			name: "Unified",
+			data: "0::/user.slice/user-1000.slice/session-2.scope\n",
+			want: "/user.slice/user-1000.slice/session-2.scope",
+		},
+		{
+			name: "Hybrid",
+			data: "4:memory:/foo\n1:cpu:/\n0::/bar\n",
+			want: "/bar",
+		},
+		{
+			name: "V1Only",
+			data: "4:memory:/foo\n1:cpu:/\n",
+			err:  true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseProcCgroup([]byte(test.data))
+			if test.err {
+				if err == nil {
+					t.Errorf("parseProcCgroup(%q) = %q, <nil>; want error", test.data, got)
+				}
+				return
+			}
+			if err != nil || got != test.want {
+				t.Errorf("parseProcCgroup(%q) = %q, %v; want %q, <nil>", test.data, got, err, test.want)
+			}
+		})
+	}
+}
+
+func TestCgroupFiles(t *testing.T) {
+	tests := []struct {
+		limits resourceLimits
+		want   []cgroupFile
+	}{
+		{limits: resourceLimits{}, want: nil},
+		{
+			limits: resourceLimits{cpus: 1.5},
+			want:   []cgroupFile{{controller: "cpu", name: "cpu.max", value: "150000 100000"}},
+		},
+		{
+			limits: resourceLimits{cpus: 0.001},
+			want:   []cgroupFile{{controller: "cpu", name: "cpu.max", value: "1000 100000"}},
+		},
+		{
+			limits: resourceLimits{cpus: 2, memoryBytes: 1 << 30},
+			want: []cgroupFile{
+				{controller: "cpu", name: "cpu.max", value: "200000 100000"},
+				{controller: "memory", name: "memory.max", value: "1073741824"},
+			},
+		},
+	}
+	for _, test := range tests {
+		got := test.limits.cgroupFiles()
+		if diff := cmp.Diff(test.want, got, cmp.AllowUnexported(cgroupFile{})); diff != "" {
+			t.Errorf("%+v.cgroupFiles() (-want +got):\n%s", test.limits, diff)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+// Copyright 2020 YourBase Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux
+// +build !linux
+
+package biome
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+type cgroup struct{}
+
+func createCgroup(limits resourceLimits) (*cgroup, error) {
+	return nil, fmt.Errorf("create cgroup: %w on %s", ErrUnsupported, runtime.GOOS)
+}
+
+func (cg *cgroup) remove(ctx context.Context) {}
+
+func startInCgroup(c *exec.Cmd, cg *cgroup) (*os.File, error) {
+	return nil, fmt.Errorf("start in cgroup: %w on %s", ErrUnsupported, runtime.GOOS)
+}
@@ -19,6 +19,7 @@ package biome
 import (
 	"context"
 	"io"
+	"io/fs"
 
 	"zombiezen.com/go/log"
 )
@@ -59,10 +60,26 @@ func (n nopCloser) MkdirAll(ctx context.Context, path string) error {
 	return forwardMkdirAll(ctx, n.Biome, path)
 }
 
+func (n nopCloser) MkdirAllPerm(ctx context.Context, path string, perm fs.FileMode) error {
+	return forwardMkdirAllPerm(ctx, n.Biome, path, perm)
+}
+
+func (n nopCloser) HasCommand(ctx context.Context, name string) (bool, error) {
+	return HasCommand(ctx, n.Biome, name)
+}
+
 func (n nopCloser) EvalSymlinks(ctx context.Context, path string) (string, error) {
 	return forwardEvalSymlinks(ctx, n.Biome, path)
 }
 
+func (n nopCloser) Stat(ctx context.Context, path string) (fs.FileInfo, error) {
+	return forwardStat(ctx, n.Biome, path)
+}
+
+func (n nopCloser) ReadDir(ctx context.Context, path string) ([]fs.DirEntry, error) {
+	return forwardReadDir(ctx, n.Biome, path)
+}
+
 // WithClose returns a new biome that wraps another biome to call the given
 // function at the beginning of Close, before the underlying biome's Close
 // method is called. If the function returns an error, it will be returned from
@@ -106,6 +123,22 @@ func (c closer) MkdirAll(ctx context.Context, path string) error {
 	return forwardMkdirAll(ctx, c.BiomeCloser, path)
 }
 
+func (c closer) MkdirAllPerm(ctx context.Context, path string, perm fs.FileMode) error {
+	return forwardMkdirAllPerm(ctx, c.BiomeCloser, path, perm)
+}
+
+func (c closer) HasCommand(ctx context.Context, name string) (bool, error) {
+	return HasCommand(ctx, c.BiomeCloser, name)
+}
+
 func (c closer) EvalSymlinks(ctx context.Context, path string) (string, error) {
 	return forwardEvalSymlinks(ctx, c.BiomeCloser, path)
 }
+
+func (c closer) Stat(ctx context.Context, path string) (fs.FileInfo, error) {
+	return forwardStat(ctx, c.BiomeCloser, path)
+}
+
+func (c closer) ReadDir(ctx context.Context, path string) ([]fs.DirEntry, error) {
+	return forwardReadDir(ctx, c.BiomeCloser, path)
+}
@@ -19,15 +19,19 @@ package main
 import (
 	"archive/zip"
 	"bytes"
+	"compress/flate"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
+	slashpath "path"
 	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 
 	"go4.org/xdgdir"
@@ -40,13 +44,71 @@ import (
 
 const (
 	ignoreFileName       = ".biomeignore"
+	gitignoreFileName    = ".gitignore"
 	ignoreConfigFileName = "ignore"
 )
 
+// storeUncompressed is the bundleOptions.compressionLevel value that stores
+// files in the archive without compression.
+const storeUncompressed = -1
+
+// defaultMaxBundleFileSize is the default value of bundleOptions.maxFileSize.
+const defaultMaxBundleFileSize = 1 << 30 // 1 GiB
+
+// defaultBundleReadAhead is the default value of bundleOptions.readAhead.
+const defaultBundleReadAhead = 64 << 20 // 64 MiB
+
 type bundleOptions struct {
 	globalIgnore []gitglob.Pattern
 	prevStamps   map[string]string
 
+	// exclude is a list of patterns for paths to skip for this call only.
+	// Unlike ignored paths, excluded paths keep their previous stamps,
+	// so they are neither copied nor removed.
+	exclude []gitglob.Pattern
+
+	// If useGitignore is true, then paths matched by .gitignore files
+	// anywhere in src are ignored too. Like in Git, a .gitignore file
+	// in a subdirectory takes precedence over ones in its parents.
+	useGitignore bool
+
+	// gitExcludes is a list of patterns that Git applies to src
+	// from outside of it, as returned by readGitExcludes.
+	// They are only used if useGitignore is true and take precedence over
+	// globalIgnore, but not over .gitignore files in src.
+	gitExcludes []gitglob.Pattern
+
+	// include is a list of negated patterns for paths to copy for this call
+	// even if they would otherwise be ignored or excluded. include takes
+	// precedence over exclude, which takes precedence over the .biomeignore
+	// file, which takes precedence over .gitignore files (if useGitignore
+	// is set), which take precedence over globalIgnore. Like negated patterns
+	// in ignore files, include can re-include files inside ignored
+	// directories, but not inside excluded directories.
+	include []gitglob.Pattern
+
+	// maxFileSize is the size in bytes of the largest regular file to copy.
+	// Larger files are skipped with a warning and are not tracked in the
+	// returned stamps. If maxFileSize is zero, defaultMaxBundleFileSize
+	// is used. If maxFileSize is negative, then there is no limit.
+	maxFileSize int64
+
+	// compressionLevel is the compress/flate level to compress regular files
+	// with. If compressionLevel is zero, the default level is used. If it is
+	// storeUncompressed, then files are stored without compression.
+	compressionLevel int
+
+	// concurrency is the maximum number of files to read at once.
+	// The archive is always written in walk order. If concurrency is zero,
+	// then files are read one at a time.
+	concurrency int
+
+	// readAhead is the maximum number of bytes of file content to hold in
+	// memory while files are read concurrently. Files larger than readAhead
+	// are streamed into the archive when their turn comes. If readAhead is
+	// zero, defaultBundleReadAhead is used.
+	readAhead int64
+
 	// If linkRoot is not empty, then it is assumed to be the OS filesystem directory
 	// that src refers to. This is only used for reading symbolic links.
 	// TODO(someday): https://golang.org/issue/49580 proposes adding a ReadLink method.
@@ -57,20 +119,87 @@ type bundleOptions struct {
 // src since the last call to bundle. prevStamps should be the previous return
 // value of bundle, or an empty/nil map if this is the first call. toRemove is a
 // list of files or directories that should be removed before extracting the
-// resulting zip archive.
+// resulting zip archive. Regular files that are hard links to a file already in
+// the archive are stored as symlinks to the first path. Directories that must
+// be removed recursively because
+// they were replaced by a symlink end in a slash.
 func bundle(ctx context.Context, out io.Writer, src fs.FS, opts *bundleOptions) (newStamps map[string]string, toRemove []string, err error) {
 	if opts == nil {
 		opts = new(bundleOptions)
 	}
-	ignorePatterns := append([]gitglob.Pattern(nil), opts.globalIgnore...)
-	ignorePatterns, err = readLocalIgnore(ignorePatterns, src)
+	if opts.compressionLevel < storeUncompressed || opts.compressionLevel > flate.BestCompression {
+		return nil, nil, fmt.Errorf("bundle: invalid compression level %d", opts.compressionLevel)
+	}
+	maxFileSize := opts.maxFileSize
+	if maxFileSize == 0 {
+		maxFileSize = defaultMaxBundleFileSize
+	}
+	localIgnore, err := readLocalIgnore(nil, src)
 	if err != nil {
 		return nil, nil, err
 	}
+	var gitIgnore []gitglob.Pattern
+	if opts.useGitignore {
+		gitIgnore, err = readGitignore(gitIgnore, src, ".")
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	var ignorePatterns []gitglob.Pattern
+	buildIgnorePatterns := func() {
+		ignorePatterns = append([]gitglob.Pattern(nil), opts.globalIgnore...)
+		if opts.useGitignore {
+			ignorePatterns = append(ignorePatterns, opts.gitExcludes...)
+		}
+		ignorePatterns = append(ignorePatterns, gitIgnore...)
+		ignorePatterns = append(ignorePatterns, localIgnore...)
+		ignorePatterns = append(ignorePatterns, opts.include...)
+		ignorePatterns = gitglob.Simplify(ignorePatterns)
+	}
+	buildIgnorePatterns()
+
+	// ignoredDirs is the list of ignored directories that are still walked
+	// because a negated pattern may re-include a file inside them.
+	var ignoredDirs []string
+	isInIgnoredDir := func(path string) bool {
+		for _, dir := range ignoredDirs {
+			if strings.HasPrefix(path, dir+"/") {
+				return true
+			}
+		}
+		return false
+	}
+	skipIgnoredDir := func(path string) error {
+		for _, pat := range ignorePatterns {
+			if pat.IsNegated() && pat.MayMatchInside(path) {
+				ignoredDirs = append(ignoredDirs, path)
+				return nil
+			}
+		}
+		return fs.SkipDir
+	}
+
+	// hardlinks maps the file identity of each regular file with multiple links
+	// to the first path it was seen at.
+	hardlinks := make(map[fileKey]string)
 
 	newStamps = make(map[string]string)
 	zw := zip.NewWriter(out)
+	if opts.compressionLevel > 0 {
+		level := opts.compressionLevel
+		zw.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(w, level)
+		})
+	}
+	readAhead := opts.readAhead
+	if readAhead <= 0 {
+		readAhead = defaultBundleReadAhead
+	}
+	emit, finish := newBundleWriter(zw, src, opts.concurrency, readAhead)
 	err = fs.WalkDir(src, ".", func(path string, ent fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
 		if err != nil {
 			log.Warnf(ctx, "Could not list %s: %v", path, err)
 			return nil
@@ -78,13 +207,45 @@ func bundle(ctx context.Context, out io.Writer, src fs.FS, opts *bundleOptions)
 		if path == "." || path == ignoreFileName {
 			return nil
 		}
+		included := gitglob.LastMatch(opts.include, path, ent.Type()) != nil
+		if pat := gitglob.LastMatch(opts.exclude, path, ent.Type()); !included && pat != nil && !pat.IsNegated() {
+			log.Debugf(ctx, "Excluded %s due to rule %q", path, pat)
+			for prevPath, stamp := range opts.prevStamps {
+				if prevPath == path || strings.HasPrefix(prevPath, path+"/") {
+					newStamps[prevPath] = stamp
+				}
+			}
+			if ent.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
 		if pat := gitglob.LastMatch(ignorePatterns, path, ent.Type()); pat != nil && !pat.IsNegated() {
 			// Ignored.
 			log.Debugf(ctx, "Ignored %s due to rule %q", path, pat)
 			if ent.IsDir() {
-				return fs.SkipDir
+				return skipIgnoredDir(path)
 			}
 			return nil
+		} else if pat == nil && isInIgnoredDir(path) {
+			// Inside an ignored directory and not re-included by a negated pattern.
+			log.Debugf(ctx, "Ignored %s due to ignored parent directory", path)
+			if ent.IsDir() {
+				return skipIgnoredDir(path)
+			}
+			return nil
+		}
+		if opts.useGitignore && ent.IsDir() {
+			// Patterns in the directory's .gitignore only apply inside it,
+			// so they can be added as the walk enters the directory.
+			n := len(gitIgnore)
+			gitIgnore, err = readGitignore(gitIgnore, src, path)
+			if err != nil {
+				return err
+			}
+			if len(gitIgnore) > n {
+				buildIgnorePatterns()
+			}
 		}
 
 		// Check if the file needs to be changed.
@@ -92,8 +253,25 @@ func bundle(ctx context.Context, out io.Writer, src fs.FS, opts *bundleOptions)
 		if err != nil {
 			return err
 		}
+		if info.Mode().IsRegular() && maxFileSize >= 0 && info.Size() > maxFileSize {
+			log.Warnf(ctx, "Skipping %s: size %d exceeds limit of %d bytes", path, info.Size(), maxFileSize)
+			return nil
+		}
+		var firstLink string
+		if key, ok := hardlinkKey(info); ok {
+			if first, seen := hardlinks[key]; seen {
+				firstLink = first
+			} else {
+				hardlinks[key] = path
+			}
+		}
 		oldStamp := opts.prevStamps[path]
 		newStamp := readStamp(src, path, info)
+		if firstLink != "" {
+			// The file is pushed as a symlink to firstLink,
+			// so it must be pushed again if firstLink stops being pushed.
+			newStamp = hardlinkStamp(newStamp, firstLink)
+		}
 		newStamps[path] = newStamp
 		if oldStamp == newStamp && !info.IsDir() {
 			log.Debugf(ctx, "%s has not changed", path)
@@ -111,7 +289,7 @@ func bundle(ctx context.Context, out io.Writer, src fs.FS, opts *bundleOptions)
 				return err
 			}
 			hdr.Name = path + "/"
-			if _, err := zw.CreateHeader(hdr); err != nil {
+			if err := emit(&bundleEntry{hdr: hdr}); err != nil {
 				return err
 			}
 		case fs.ModeSymlink:
@@ -140,7 +318,7 @@ func bundle(ctx context.Context, out io.Writer, src fs.FS, opts *bundleOptions)
 
 			if oldStamp != "" {
 				// Symlinks must be removed to be replaced.
-				toRemove = append(toRemove, path)
+				toRemove = append(toRemove, removalPath(path, oldStamp))
 			}
 			hdr, err := zip.FileInfoHeader(info)
 			if err != nil {
@@ -148,41 +326,55 @@ func bundle(ctx context.Context, out io.Writer, src fs.FS, opts *bundleOptions)
 			}
 			hdr.Name = path
 			hdr.UncompressedSize64 = uint64(len(relLinkTarget))
-			w, err := zw.CreateHeader(hdr)
-			if err != nil {
+			if err := emit(&bundleEntry{hdr: hdr, content: []byte(relLinkTarget)}); err != nil {
 				return err
 			}
-			if _, err := io.WriteString(w, relLinkTarget); err != nil {
-				return fmt.Errorf("%s: %v", path, err)
-			}
 		case 0: // regular file
+			if firstLink != "" {
+				// Another path in the bundle has the same content.
+				// Link to it instead of copying the content again.
+				relLinkTarget, err := filepath.Rel(filepath.Dir(filepath.FromSlash(path)), filepath.FromSlash(firstLink))
+				if err != nil {
+					return fmt.Errorf("%s: %v", path, err)
+				}
+				relLinkTarget = filepath.ToSlash(relLinkTarget)
+				if oldStamp != "" {
+					toRemove = append(toRemove, removalPath(path, oldStamp))
+				}
+				hdr, err := zip.FileInfoHeader(info)
+				if err != nil {
+					return fmt.Errorf("%s: %v", path, err)
+				}
+				hdr.Name = path
+				hdr.SetMode(fs.ModeSymlink | 0o777)
+				hdr.UncompressedSize64 = uint64(len(relLinkTarget))
+				return emit(&bundleEntry{hdr: hdr, content: []byte(relLinkTarget)})
+			}
 			if oldStamp != "" && stampMode(oldStamp).Type() != 0 {
 				toRemove = append(toRemove, path)
 			}
 
-			f, err := src.Open(path)
-			if err != nil {
-				return err
-			}
-			defer f.Close()
 			hdr, err := zip.FileInfoHeader(info)
 			if err != nil {
 				return fmt.Errorf("%s: %v", path, err)
 			}
 			hdr.Name = path
 			hdr.Method = zip.Deflate
-			w, err := zw.CreateHeader(hdr)
-			if err != nil {
-				return fmt.Errorf("%s: %v", path, err)
+			if opts.compressionLevel == storeUncompressed {
+				hdr.Method = zip.Store
 			}
-			if _, err := io.Copy(w, f); err != nil {
-				return fmt.Errorf("%s: %v", path, err)
+			if err := emit(&bundleEntry{hdr: hdr, path: path}); err != nil {
+				return err
 			}
 		default:
 			return fmt.Errorf("%s: not a file, directory, or symlink", path)
 		}
 		return nil
 	})
+	if finishErr := finish(); finishErr != nil {
+		// The walk may have stopped because of errBundleWrite.
+		err = finishErr
+	}
 	if err != nil {
 		return nil, nil, err
 	}
@@ -197,26 +389,157 @@ func bundle(ctx context.Context, out io.Writer, src fs.FS, opts *bundleOptions)
 	return newStamps, toRemove, nil
 }
 
+// bundleEntry is a single file in a bundle's zip archive.
+type bundleEntry struct {
+	hdr     *zip.FileHeader
+	content []byte
+
+	// If path is not empty, then the entry's content is read
+	// from the regular file at path in the source filesystem.
+	path string
+
+	// size is the number of bytes reserved from the read-ahead budget
+	// while content is read in the background.
+	size int64
+
+	// If done is not nil, then content is being read in the background.
+	// err and content may only be accessed after done is closed.
+	done chan struct{}
+	err  error
+}
+
+// newBundleWriter returns functions to write entries to zw.
+// emit adds an entry to the archive, and finish waits for any pending
+// entries to be written. Entries are written in the order they are passed to
+// emit. If concurrency is greater than one, then up to concurrency files
+// totaling at most readAhead bytes are read in the background while earlier
+// entries are written. Files larger than readAhead are not read ahead.
+func newBundleWriter(zw *zip.Writer, src fs.FS, concurrency int, readAhead int64) (emit func(*bundleEntry) error, finish func() error) {
+	if concurrency <= 1 {
+		emit = func(e *bundleEntry) error {
+			return writeBundleEntry(zw, src, e)
+		}
+		finish = func() error { return nil }
+		return emit, finish
+	}
+
+	queue := make(chan *bundleEntry, concurrency)
+	sem := make(chan struct{}, concurrency)
+	var (
+		bytesMu   sync.Mutex
+		bytesCond = sync.NewCond(&bytesMu)
+		bytesHeld int64
+	)
+	failed := make(chan struct{})
+	writeDone := make(chan error, 1)
+	go func() {
+		var firstErr error
+		for e := range queue {
+			if e.done != nil {
+				<-e.done
+			}
+			if firstErr == nil {
+				firstErr = e.err
+				if firstErr == nil {
+					firstErr = writeBundleEntry(zw, src, e)
+				}
+				if firstErr != nil {
+					close(failed)
+				}
+			}
+			if e.done != nil {
+				e.content = nil
+				bytesMu.Lock()
+				bytesHeld -= e.size
+				bytesMu.Unlock()
+				bytesCond.Broadcast()
+				<-sem
+			}
+		}
+		writeDone <- firstErr
+	}()
+
+	emit = func(e *bundleEntry) error {
+		select {
+		case <-failed:
+			return errBundleWrite
+		default:
+		}
+		if e.path != "" && int64(e.hdr.UncompressedSize64) <= readAhead {
+			e.size = int64(e.hdr.UncompressedSize64)
+			bytesMu.Lock()
+			for bytesHeld+e.size > readAhead {
+				bytesCond.Wait()
+			}
+			bytesHeld += e.size
+			bytesMu.Unlock()
+			sem <- struct{}{}
+			e.done = make(chan struct{})
+			go func() {
+				defer close(e.done)
+				content, err := fs.ReadFile(src, e.path)
+				if err != nil {
+					e.err = fmt.Errorf("%s: %v", e.path, err)
+					return
+				}
+				e.content = content
+				e.path = ""
+			}()
+		}
+		queue <- e
+		return nil
+	}
+	finish = func() error {
+		close(queue)
+		return <-writeDone
+	}
+	return emit, finish
+}
+
+// errBundleWrite is returned by a concurrent bundle writer's emit function
+// after an entry fails to be written. The underlying error is returned by
+// finish.
+var errBundleWrite = errors.New("bundle write failed")
+
+// writeBundleEntry writes e to zw.
+func writeBundleEntry(zw *zip.Writer, src fs.FS, e *bundleEntry) error {
+	w, err := zw.CreateHeader(e.hdr)
+	if err != nil {
+		return fmt.Errorf("%s: %v", e.hdr.Name, err)
+	}
+	if e.path == "" {
+		if _, err := w.Write(e.content); err != nil {
+			return fmt.Errorf("%s: %v", e.hdr.Name, err)
+		}
+		return nil
+	}
+	f, err := src.Open(e.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.Copy(w, f); err != nil {
+		return fmt.Errorf("%s: %v", e.path, err)
+	}
+	return nil
+}
+
 func pushWorkDir(ctx context.Context, conn *sqlite.Conn, rec *biomeRecord, bio biome.Biome) (err error) {
 	defer func() {
 		if err != nil {
-			err = fmt.Errorf("push %s to %s: %v", rec.rootHostDir, rec.id, err)
+			err = fmt.Errorf("push %s to %s: %w", rec.rootHostDir, rec.id, err)
 		}
 	}()
 	ignorePatterns, err := readGlobalIgnore()
 	if err != nil {
 		return err
 	}
+	var gitExcludes []gitglob.Pattern
+	if rec.useGitignore {
+		gitExcludes = readGitExcludes(ctx, rec.rootHostDir)
+	}
 
-	defer sqlitex.Save(conn)(&err)
-
-	// Read previous stamps.
-	const prevStampsQuery = `select "path", "stamp" from "local_files" where "biome_id" = ?;`
-	prevStamps := make(map[string]string)
-	err = sqlitex.ExecTransient(conn, prevStampsQuery, func(stmt *sqlite.Stmt) error {
-		prevStamps[stmt.ColumnText(0)] = stmt.ColumnText(1)
-		return nil
-	}, rec.id)
+	prevStamps, err := readStamps(conn, rec.id)
 	if err != nil {
 		return err
 	}
@@ -236,7 +559,9 @@ func pushWorkDir(ctx context.Context, conn *sqlite.Conn, rec *biomeRecord, bio b
 		writeErrChan <- err
 	}()
 	defer func() {
-		err := bio.Run(ctx, &biome.Invocation{
+		// Clean up even if ctx is done, so that cancelling the push doesn't
+		// leave a partial bundle in the biome.
+		err := bio.Run(context.Background(), &biome.Invocation{
 			Argv:   []string{"rm", "-f", zipPath},
 			Stdout: os.Stderr,
 			Stderr: os.Stderr,
@@ -248,10 +573,23 @@ func pushWorkDir(ctx context.Context, conn *sqlite.Conn, rec *biomeRecord, bio b
 	newStamps, toRemove, err := bundle(ctx, pw, os.DirFS(rec.rootHostDir), &bundleOptions{
 		globalIgnore: ignorePatterns,
 		prevStamps:   prevStamps,
+		exclude:      rec.exclude,
+		include:      rec.include,
+		useGitignore: rec.useGitignore,
+		gitExcludes:  gitExcludes,
 		linkRoot:     rec.rootHostDir,
+		concurrency:  runtime.NumCPU(),
 	})
-	pw.Close()
+	if err != nil {
+		// Make WriteFile fail instead of writing a truncated bundle.
+		pw.CloseWithError(err)
+	} else {
+		pw.Close()
+	}
 	writeErr := <-writeErrChan
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
 	if err != nil {
 		return err
 	}
@@ -277,22 +615,60 @@ func pushWorkDir(ctx context.Context, conn *sqlite.Conn, rec *biomeRecord, bio b
 	}
 
 	// Unzip files.
-	err = bio.Run(ctx, &biome.Invocation{
-		Argv:   []string{"unzip", "-o", "-q", zipPath},
-		Stdout: os.Stderr,
-		Stderr: os.Stderr,
-	})
+	if local, ok := biome.AsLocal(bio); ok {
+		// The bundle is on the host filesystem,
+		// so extract it without depending on unzip.
+		zr, err := zip.OpenReader(zipPath)
+		if err != nil {
+			return err
+		}
+		err = extractBundle(&zr.Reader, local.WorkDir)
+		zr.Close()
+		if err != nil {
+			return err
+		}
+	} else {
+		err = bio.Run(ctx, &biome.Invocation{
+			Argv:   []string{"unzip", "-o", "-q", zipPath},
+			Stdout: os.Stderr,
+			Stderr: os.Stderr,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Record new stamps. This must only happen once the biome's files match
+	// them: if the push fails (or the process dies) before this point, the
+	// previous stamps are left as-is so that the next push copies the changed
+	// and removed files again.
+	return recordStamps(conn, rec.id, newStamps)
+}
+
+// readStamps returns the stamps recorded by the last push to the biome
+// with the given ID, keyed by slash-separated path.
+func readStamps(conn *sqlite.Conn, biomeID string) (map[string]string, error) {
+	const query = `select "path", "stamp" from "local_files" where "biome_id" = ?;`
+	stamps := make(map[string]string)
+	err := sqlitex.ExecTransient(conn, query, func(stmt *sqlite.Stmt) error {
+		stamps[stmt.ColumnText(0)] = stmt.ColumnText(1)
+		return nil
+	}, biomeID)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return stamps, nil
+}
 
-	// Record new stamps.
-	err = sqlitex.ExecTransient(conn, `delete from "local_files" where "biome_id" = ?;`, nil, rec.id)
+// recordStamps replaces the stamps stored for the biome with the given ID.
+func recordStamps(conn *sqlite.Conn, biomeID string, newStamps map[string]string) (err error) {
+	defer sqlitex.Save(conn)(&err)
+	err = sqlitex.ExecTransient(conn, `delete from "local_files" where "biome_id" = ?;`, nil, biomeID)
 	if err != nil {
 		return err
 	}
 	insertStampStmt := conn.Prep(`insert into "local_files" ("biome_id", "path", "stamp") values (?, ?, ?);`)
-	insertStampStmt.BindText(1, rec.id)
+	insertStampStmt.BindText(1, biomeID)
 	for path, stamp := range newStamps {
 		insertStampStmt.BindText(2, path)
 		insertStampStmt.BindText(3, stamp)
@@ -307,6 +683,90 @@ func pushWorkDir(ctx context.Context, conn *sqlite.Conn, rec *biomeRecord, bio b
 	return nil
 }
 
+// extractBundle extracts a zip archive created by bundle into the OS
+// filesystem directory dst, overwriting any existing files. Symbolic links are
+// recreated from their targets, which are stored as the entries' contents.
+func extractBundle(zr *zip.Reader, dst string) error {
+	for _, f := range zr.File {
+		name := strings.TrimSuffix(f.Name, "/")
+		if !fs.ValidPath(name) || name == "." {
+			return fmt.Errorf("extract bundle: invalid path %q", f.Name)
+		}
+		path := filepath.Join(dst, filepath.FromSlash(name))
+		if err := extractBundleFile(f, path); err != nil {
+			return fmt.Errorf("extract bundle: %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func extractBundleFile(f *zip.File, path string) error {
+	switch f.Mode().Type() {
+	case fs.ModeDir:
+		return os.MkdirAll(path, 0o777)
+	case fs.ModeSymlink:
+		if f.UncompressedSize64 > 4096 {
+			return fmt.Errorf("symlink target too long")
+		}
+		r, err := f.Open()
+		if err != nil {
+			return err
+		}
+		target := make([]byte, int(f.UncompressedSize64))
+		_, err = io.ReadFull(r, target)
+		r.Close()
+		if err != nil {
+			return fmt.Errorf("read symlink target: %v", err)
+		}
+		linkTarget := filepath.FromSlash(string(target))
+		if filepath.IsAbs(linkTarget) || !isSubFilepath(filepath.Join(filepath.Dir(f.Name), linkTarget)) {
+			return fmt.Errorf("symlink refers to %s which is outside the bundle", target)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return os.Symlink(linkTarget, path)
+	case 0: // regular file
+		if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
+			return err
+		}
+		// Don't write through an existing symlink.
+		if info, err := os.Lstat(path); err == nil && info.Mode().Type() == fs.ModeSymlink {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+		r, err := f.Open()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		perm := f.Mode().Perm()
+		w, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, r)
+		closeErr := w.Close()
+		if err != nil {
+			return err
+		}
+		if closeErr != nil {
+			return closeErr
+		}
+		// OpenFile does not change the mode of an existing file.
+		if err := os.Chmod(path, perm); err != nil {
+			return err
+		}
+		return os.Chtimes(path, f.Modified, f.Modified)
+	default:
+		return fmt.Errorf("not a file, directory, or symlink")
+	}
+}
+
 // readStamp computes a checksum of a file based on its metadata.
 // The checksum of a nonexistent or otherwise inaccessible file is "0".
 func readStamp(fsys fs.FS, path string, info fs.FileInfo) string {
@@ -321,9 +781,63 @@ func readStamp(fsys fs.FS, path string, info fs.FileInfo) string {
 	return pre + "+" + marshalStamp(targetInfo)
 }
 
+// fileKey identifies a file on the OS filesystem.
+type fileKey struct {
+	dev uint64
+	ino uint64
+}
+
+// hardlinkKey returns the identity of a regular file with more than one link.
+// It returns false if the file is not a regular file, has only one link,
+// or its identity cannot be determined.
+func hardlinkKey(info fs.FileInfo) (fileKey, bool) {
+	if !info.Mode().IsRegular() {
+		return fileKey{}, false
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || st.Nlink < 2 {
+		return fileKey{}, false
+	}
+	return fileKey{dev: uint64(st.Dev), ino: uint64(st.Ino)}, true
+}
+
 // dirStamp is the fake checksum value of a directory.
 const dirStamp = "dir"
 
+// hardlinkStampPrefix starts the stamp of a regular file
+// that was pushed as a symlink to another hard link of the same file.
+const hardlinkStampPrefix = "link:"
+
+// hardlinkStamp returns the stamp of a regular file with the given stamp
+// that is pushed as a symlink to the path first.
+func hardlinkStamp(fileStamp string, first string) string {
+	return hardlinkStampPrefix + fileStamp + ":" + first
+}
+
+// parseHardlinkStamp returns the regular file stamp and link target
+// of a stamp returned by hardlinkStamp.
+func parseHardlinkStamp(stamp string) (fileStamp string, first string, ok bool) {
+	if !strings.HasPrefix(stamp, hardlinkStampPrefix) {
+		return "", "", false
+	}
+	stamp = stamp[len(hardlinkStampPrefix):]
+	i := strings.IndexByte(stamp, ':')
+	if i < 0 {
+		return "", "", false
+	}
+	return stamp[:i], stamp[i+1:], true
+}
+
+// removalPath returns the toRemove entry for a path being replaced by a
+// symlink. If the path was previously a directory, the entry has a trailing
+// slash to indicate that it must be removed recursively.
+func removalPath(path string, oldStamp string) string {
+	if oldStamp == dirStamp {
+		return path + "/"
+	}
+	return path
+}
+
 func marshalStamp(info fs.FileInfo) string {
 	if info.IsDir() {
 		return dirStamp
@@ -349,6 +863,9 @@ func stampMode(stamp string) fs.FileMode {
 	if stamp == dirStamp {
 		return fs.ModeDir | 0o777
 	}
+	if _, _, ok := parseHardlinkStamp(stamp); ok {
+		return fs.ModeSymlink | 0o777
+	}
 	parts := strings.Split(stamp, "-")
 	if len(parts) < 4 {
 		return 0
@@ -368,6 +885,25 @@ func readGlobalIgnore() ([]gitglob.Pattern, error) {
 	return gitglob.ParseFiles(paths...)
 }
 
+// parsePatternFlags parses the values of a repeatable pattern flag
+// like --exclude. If negate is true, then each pattern is negated
+// as if it were prefixed with "!".
+func parsePatternFlags(flagName string, values []string, negate bool) ([]gitglob.Pattern, error) {
+	var patterns []gitglob.Pattern
+	for _, v := range values {
+		line := v
+		if negate {
+			line = "!" + v
+		}
+		pat := gitglob.ParseLine(line)
+		if !pat.IsValid() {
+			return nil, fmt.Errorf("--%s: invalid pattern %q", flagName, v)
+		}
+		patterns = append(patterns, pat)
+	}
+	return patterns, nil
+}
+
 func readLocalIgnore(dst []gitglob.Pattern, fsys fs.FS) ([]gitglob.Pattern, error) {
 	data, err := fs.ReadFile(fsys, ignoreFileName)
 	if errors.Is(err, fs.ErrNotExist) {
@@ -385,6 +921,25 @@ func readLocalIgnore(dst []gitglob.Pattern, fsys fs.FS) ([]gitglob.Pattern, erro
 	return dst, nil
 }
 
+// readGitignore appends the patterns in the .gitignore file
+// in the slash-separated directory dir of fsys to dst.
+func readGitignore(dst []gitglob.Pattern, fsys fs.FS, dir string) ([]gitglob.Pattern, error) {
+	data, err := fs.ReadFile(fsys, slashpath.Join(dir, gitignoreFileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return dst, nil
+	}
+	if err != nil {
+		return dst, err
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		pat := gitglob.ParseLineInDir(dir, string(line))
+		if pat.IsValid() {
+			dst = append(dst, pat)
+		}
+	}
+	return dst, nil
+}
+
 // isSubFilepath reports whether a relative path is a strict subpath: that is,
 // it does reference a file outside the working directory.
 func isSubFilepath(path string) bool {
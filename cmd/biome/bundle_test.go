@@ -20,18 +20,25 @@ import (
 	"archive/zip"
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"io/fs"
+	"math/rand"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 	"testing"
 	"testing/fstest"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	"go.uber.org/goleak"
+	"zombiezen.com/go/biome"
+	"zombiezen.com/go/biome/internal/gitglob"
 )
 
 func TestBuildArchive(t *testing.T) {
@@ -337,7 +344,7 @@ func TestBuildArchive(t *testing.T) {
 			},
 		},
 	}
-	if runtime.GOOS != "windows" {
+	if runtime.GOOS != biome.Windows {
 		permOf := func(path string) fs.FileMode {
 			info, err := os.Lstat(path)
 			if err != nil {
@@ -452,6 +459,80 @@ func TestBuildArchive(t *testing.T) {
 				},
 			},
 		})
+
+		dir4 := t.TempDir()
+		if err := os.Mkdir(filepath.Join(dir4, "foo"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(filepath.Join(dir4, "foo", "a.txt"), []byte("Hello\n"), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(filepath.Join(dir4, "bar.txt"), []byte("Hello\n"), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dir5 := t.TempDir()
+		err = os.WriteFile(filepath.Join(dir5, "bar.txt"), []byte("Hello\n"), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.Symlink("bar.txt", filepath.Join(dir5, "foo"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tests = append(tests, buildArchiveTest{
+			name:      "ReplaceDirectoryWithSymlink",
+			srcs:      []fs.FS{os.DirFS(dir4), os.DirFS(dir5)},
+			linkRoots: []string{dir4, dir5},
+			want: []testZipFile{
+				{
+					name:    "bar.txt",
+					mode:    permOf(filepath.Join(dir5, "bar.txt")),
+					content: "Hello\n",
+				},
+				{
+					name:    "foo",
+					mode:    permOf(filepath.Join(dir5, "foo")) | fs.ModeSymlink,
+					content: "bar.txt",
+				},
+			},
+			wantToRemove: []string{"foo/", "foo/a.txt"},
+		})
+
+		dir6 := t.TempDir()
+		err = os.WriteFile(filepath.Join(dir6, "a.txt"), []byte("Hello\n"), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Mkdir(filepath.Join(dir6, "b"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		err = os.Link(filepath.Join(dir6, "a.txt"), filepath.Join(dir6, "b", "c.txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tests = append(tests, buildArchiveTest{
+			name:      "Hardlink",
+			srcs:      []fs.FS{os.DirFS(dir6)},
+			linkRoots: []string{dir6},
+			want: []testZipFile{
+				{
+					name:    "a.txt",
+					mode:    permOf(filepath.Join(dir6, "a.txt")),
+					content: "Hello\n",
+				},
+				{
+					name: "b/",
+					mode: permOf(filepath.Join(dir6, "b")) | fs.ModeDir,
+				},
+				{
+					name:    "b/c.txt",
+					mode:    0o777 | fs.ModeSymlink,
+					content: "../a.txt",
+				},
+			},
+		})
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
@@ -525,6 +606,748 @@ func TestBuildArchive(t *testing.T) {
 	}
 }
 
+func TestBundleHardlinkStamps(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("Hello\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt")); err != nil {
+		t.Fatal(err)
+	}
+	opts := &bundleOptions{linkRoot: dir}
+	stamps, _, err := bundle(ctx, io.Discard, os.DirFS(dir), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, first, ok := parseHardlinkStamp(stamps["b.txt"]); !ok || first != "a.txt" {
+		t.Fatalf("stamps[%q] = %q; want hard link to a.txt", "b.txt", stamps["b.txt"])
+	}
+
+	// pushAgain bundles dir with the previous stamps and checks that b.txt
+	// is pushed as a regular file, replacing the symlink.
+	pushAgain := func(t *testing.T, prevStamps map[string]string, opts *bundleOptions) {
+		t.Helper()
+		opts.prevStamps = prevStamps
+		opts.linkRoot = dir
+		buf := new(bytes.Buffer)
+		newStamps, toRemove, err := bundle(ctx, buf, os.DirFS(dir), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, ok := parseHardlinkStamp(newStamps["b.txt"]); ok {
+			t.Errorf("stamps[%q] = %q; want regular file stamp", "b.txt", newStamps["b.txt"])
+		}
+		removed := false
+		for _, path := range toRemove {
+			removed = removed || path == "b.txt"
+		}
+		if !removed {
+			t.Errorf("toRemove = %q; want to include b.txt", toRemove)
+		}
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range zr.File {
+			if f.Name != "b.txt" {
+				continue
+			}
+			if !f.Mode().IsRegular() {
+				t.Errorf("b.txt mode = %v; want regular file", f.Mode())
+			}
+			return
+		}
+		t.Error("b.txt not in bundle")
+	}
+
+	t.Run("Ignored", func(t *testing.T) {
+		err := os.WriteFile(filepath.Join(dir, ignoreFileName), []byte("/a.txt\n"), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(filepath.Join(dir, ignoreFileName))
+		pushAgain(t, stamps, new(bundleOptions))
+	})
+	t.Run("Deleted", func(t *testing.T) {
+		if err := os.Remove(filepath.Join(dir, "a.txt")); err != nil {
+			t.Fatal(err)
+		}
+		pushAgain(t, stamps, new(bundleOptions))
+	})
+}
+
+func TestBundleExclude(t *testing.T) {
+	ctx := context.Background()
+	src1 := fstest.MapFS{
+		"foo.txt":       {Data: []byte("foo"), ModTime: time.Unix(1, 0)},
+		"build":         {Mode: fs.ModeDir | 0o755},
+		"build/out.bin": {Data: []byte("v1"), ModTime: time.Unix(1, 0)},
+	}
+	stamps, _, err := bundle(ctx, io.Discard, src1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src2 := fstest.MapFS{
+		"foo.txt":       {Data: []byte("foo2"), ModTime: time.Unix(2, 0)},
+		"build":         {Mode: fs.ModeDir | 0o755},
+		"build/out.bin": {Data: []byte("v22"), ModTime: time.Unix(2, 0)},
+	}
+	buf := new(bytes.Buffer)
+	newStamps, toRemove, err := bundle(ctx, buf, src2, &bundleOptions{
+		prevStamps: stamps,
+		exclude:    []gitglob.Pattern{gitglob.ParseLine("build/")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(toRemove) > 0 {
+		t.Errorf("toRemove = %q; want []", toRemove)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range zr.File {
+		got = append(got, f.Name)
+	}
+	if diff := cmp.Diff([]string{"foo.txt"}, got); diff != "" {
+		t.Errorf("zip archive files (-want +got):\n%s", diff)
+	}
+	for _, path := range []string{"build", "build/out.bin"} {
+		if newStamps[path] != stamps[path] {
+			t.Errorf("newStamps[%q] = %q; want %q (unchanged)", path, newStamps[path], stamps[path])
+		}
+	}
+}
+
+func TestBundleMaxFileSize(t *testing.T) {
+	ctx := context.Background()
+	src := fstest.MapFS{
+		"small.txt": {Data: []byte("hi\n"), Mode: 0o644},
+		"big.bin":   {Data: []byte("0123456789"), Mode: 0o644},
+	}
+	buf := new(bytes.Buffer)
+	newStamps, _, err := bundle(ctx, buf, src, &bundleOptions{maxFileSize: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := newStamps["big.bin"]; ok {
+		t.Error("newStamps contains big.bin")
+	}
+	if _, ok := newStamps["small.txt"]; !ok {
+		t.Error("newStamps does not contain small.txt")
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range zr.File {
+		got = append(got, f.Name)
+	}
+	if want := []string{"small.txt"}; !cmp.Equal(want, got) {
+		t.Errorf("archive files = %q; want %q", got, want)
+	}
+}
+
+func TestBundleCompressionLevel(t *testing.T) {
+	ctx := context.Background()
+	content := bytes.Repeat([]byte("Hello, World!\n"), 100)
+	src := fstest.MapFS{
+		"foo.txt": {Data: content, Mode: 0o644},
+	}
+	tests := []struct {
+		level      int
+		wantMethod uint16
+	}{
+		{level: 0, wantMethod: zip.Deflate},
+		{level: storeUncompressed, wantMethod: zip.Store},
+		{level: 1, wantMethod: zip.Deflate},
+		{level: 9, wantMethod: zip.Deflate},
+	}
+	for _, test := range tests {
+		buf := new(bytes.Buffer)
+		if _, _, err := bundle(ctx, buf, src, &bundleOptions{compressionLevel: test.level}); err != nil {
+			t.Errorf("bundle(compressionLevel: %d): %v", test.level, err)
+			continue
+		}
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(zr.File) != 1 {
+			t.Errorf("bundle(compressionLevel: %d) produced %d files; want 1", test.level, len(zr.File))
+			continue
+		}
+		f := zr.File[0]
+		if f.Method != test.wantMethod {
+			t.Errorf("bundle(compressionLevel: %d) method = %d; want %d", test.level, f.Method, test.wantMethod)
+		}
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Errorf("bundle(compressionLevel: %d) content differs", test.level)
+		}
+	}
+
+	if _, _, err := bundle(ctx, io.Discard, src, &bundleOptions{compressionLevel: 10}); err == nil {
+		t.Error("bundle(compressionLevel: 10) did not return an error")
+	}
+}
+
+func TestBundleConcurrency(t *testing.T) {
+	ctx := context.Background()
+	src := fstest.MapFS{
+		"a":     {Mode: 0o755 | fs.ModeDir},
+		"a/b":   {Mode: 0o755 | fs.ModeDir},
+		"a/b/c": {Data: []byte("c\n"), Mode: 0o644},
+	}
+	for i := 0; i < 20; i++ {
+		src[fmt.Sprintf("a/file%02d.txt", i)] = &fstest.MapFile{
+			Data: bytes.Repeat([]byte{byte('a' + i)}, 100*i),
+			Mode: 0o644,
+		}
+	}
+	readArchive := func(concurrency int, readAhead int64) []string {
+		buf := new(bytes.Buffer)
+		opts := &bundleOptions{
+			concurrency: concurrency,
+			readAhead:   readAhead,
+		}
+		if _, _, err := bundle(ctx, buf, src, opts); err != nil {
+			t.Fatalf("bundle(concurrency: %d, readAhead: %d): %v", concurrency, readAhead, err)
+		}
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var files []string
+		for _, f := range zr.File {
+			r, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, err := io.ReadAll(r)
+			r.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			files = append(files, fmt.Sprintf("%s %v %q", f.Name, f.Mode(), content))
+		}
+		return files
+	}
+	want := readArchive(1, 0)
+	for _, concurrency := range []int{2, 8} {
+		for _, readAhead := range []int64{0, 1000} {
+			if diff := cmp.Diff(want, readArchive(concurrency, readAhead)); diff != "" {
+				t.Errorf("bundle(concurrency: %d, readAhead: %d) archive (-concurrency=1 +got):\n%s", concurrency, readAhead, diff)
+			}
+		}
+	}
+}
+
+func TestBundleReadAhead(t *testing.T) {
+	ctx := context.Background()
+	src := fstest.MapFS{}
+	for i := 0; i < 10; i++ {
+		src[fmt.Sprintf("file%d.txt", i)] = &fstest.MapFile{
+			Data: bytes.Repeat([]byte{byte('a' + i)}, 100),
+			Mode: 0o644,
+		}
+	}
+	fsys := &gatedFS{
+		FS:      src,
+		gate:    "file0.txt",
+		release: make(chan struct{}),
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := bundle(ctx, io.Discard, fsys, &bundleOptions{
+			concurrency: 8,
+			readAhead:   250,
+		})
+		done <- err
+	}()
+
+	// Give the bundler time to read ahead as far as it will go.
+	time.Sleep(100 * time.Millisecond)
+	fsys.mu.Lock()
+	opened := fsys.opened
+	fsys.mu.Unlock()
+	close(fsys.release)
+	if err := <-done; err != nil {
+		t.Fatal("bundle:", err)
+	}
+	if opened > 2 {
+		t.Errorf("bundle opened %d files while the first was blocked; want <= 2 (readAhead / file size)", opened)
+	}
+}
+
+// gatedFS is an fs.FS that blocks opening the file named gate
+// until release is closed.
+type gatedFS struct {
+	fs.FS
+	gate    string
+	release chan struct{}
+
+	mu     sync.Mutex
+	opened int
+}
+
+func (fsys *gatedFS) Open(name string) (fs.File, error) {
+	if name != "." && !strings.HasSuffix(name, "/") {
+		if info, err := fs.Stat(fsys.FS, name); err == nil && info.Mode().IsRegular() {
+			fsys.mu.Lock()
+			fsys.opened++
+			fsys.mu.Unlock()
+			if name == fsys.gate {
+				<-fsys.release
+			}
+		}
+	}
+	return fsys.FS.Open(name)
+}
+
+func TestBundleInclude(t *testing.T) {
+	ctx := context.Background()
+	src := fstest.MapFS{
+		ignoreFileName:        {Data: []byte("*.lock\ngen/\n")},
+		"foo.txt":             {Data: []byte("foo")},
+		"deps.lock":           {Data: []byte("lock")},
+		"other.lock":          {Data: []byte("lock")},
+		"gen":                 {Mode: fs.ModeDir | 0o755},
+		"gen/keep.go":         {Data: []byte("package gen")},
+		"gen/skip.go":         {Data: []byte("package gen")},
+		"gen/sub":             {Mode: fs.ModeDir | 0o755},
+		"gen/sub/skip_too.go": {Data: []byte("package sub")},
+	}
+	include, err := parsePatternFlags("include", []string{"deps.lock", "gen/keep.go"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	_, _, err = bundle(ctx, buf, src, &bundleOptions{
+		include: include,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range zr.File {
+		got = append(got, f.Name)
+	}
+	want := []string{"deps.lock", "foo.txt", "gen/keep.go"}
+	diff := cmp.Diff(want, got, cmpopts.SortSlices(func(s1, s2 string) bool { return s1 < s2 }))
+	if diff != "" {
+		t.Errorf("zip archive files (-want +got):\n%s", diff)
+	}
+}
+
+func TestBundleNegationInsideIgnoredDir(t *testing.T) {
+	ctx := context.Background()
+	src := fstest.MapFS{
+		ignoreFileName:               {Data: []byte("build/\n!build/keep/important.txt\n")},
+		"foo.txt":                    {Data: []byte("foo")},
+		"build":                      {Mode: fs.ModeDir | 0o755},
+		"build/out.bin":              {Data: []byte("junk")},
+		"build/keep":                 {Mode: fs.ModeDir | 0o755},
+		"build/keep/important.txt":   {Data: []byte("important")},
+		"build/keep/unimportant.txt": {Data: []byte("junk")},
+	}
+	buf := new(bytes.Buffer)
+	if _, _, err := bundle(ctx, buf, src, nil); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range zr.File {
+		got = append(got, f.Name)
+	}
+	want := []string{"build/keep/important.txt", "foo.txt"}
+	diff := cmp.Diff(want, got, cmpopts.SortSlices(func(s1, s2 string) bool { return s1 < s2 }))
+	if diff != "" {
+		t.Errorf("zip archive files (-want +got):\n%s", diff)
+	}
+}
+
+func TestBundleGitignore(t *testing.T) {
+	ctx := context.Background()
+	src := fstest.MapFS{
+		gitignoreFileName:            {Data: []byte("node_modules/\n*.log\n")},
+		ignoreFileName:               {Data: []byte("!important.log\n")},
+		"foo.txt":                    {Data: []byte("foo")},
+		"app.log":                    {Data: []byte("junk")},
+		"important.log":              {Data: []byte("important")},
+		"node_modules/x/index.js":    {Data: []byte("junk")},
+		"web/" + gitignoreFileName:   {Data: []byte("/dist\n!debug.log\n")},
+		"web/debug.log":              {Data: []byte("debug")},
+		"web/dist/bundle.js":         {Data: []byte("junk")},
+		"web/node_modules/y/y.js":    {Data: []byte("junk")},
+		"web/src/a.log":              {Data: []byte("junk")},
+		"web/src/dist":               {Data: []byte("not anchored here")},
+		"other/" + gitignoreFileName: {Data: []byte("!*.log\n")},
+		"other/b.log":                {Data: []byte("b")},
+	}
+	tests := []struct {
+		name         string
+		useGitignore bool
+		gitExcludes  []string
+		want         []string
+	}{
+		{
+			name:         "Disabled",
+			useGitignore: false,
+			want: []string{
+				".gitignore",
+				"app.log",
+				"foo.txt",
+				"important.log",
+				"node_modules/x/index.js",
+				"other/.gitignore",
+				"other/b.log",
+				"web/.gitignore",
+				"web/debug.log",
+				"web/dist/bundle.js",
+				"web/node_modules/y/y.js",
+				"web/src/a.log",
+				"web/src/dist",
+			},
+		},
+		{
+			name:         "Enabled",
+			useGitignore: true,
+			want: []string{
+				".gitignore",
+				"foo.txt",
+				"important.log",
+				"other/.gitignore",
+				"other/b.log",
+				"web/.gitignore",
+				"web/debug.log",
+				"web/src/dist",
+			},
+		},
+		{
+			name:         "GitExcludes",
+			useGitignore: true,
+			gitExcludes:  []string{"foo.txt", "!app.log"},
+			want: []string{
+				".gitignore",
+				"important.log",
+				"other/.gitignore",
+				"other/b.log",
+				"web/.gitignore",
+				"web/debug.log",
+				"web/src/dist",
+			},
+		},
+		{
+			name:         "GitExcludesDisabled",
+			useGitignore: false,
+			gitExcludes:  []string{"*.log"},
+			want: []string{
+				".gitignore",
+				"app.log",
+				"foo.txt",
+				"important.log",
+				"node_modules/x/index.js",
+				"other/.gitignore",
+				"other/b.log",
+				"web/.gitignore",
+				"web/debug.log",
+				"web/dist/bundle.js",
+				"web/node_modules/y/y.js",
+				"web/src/a.log",
+				"web/src/dist",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var gitExcludes []gitglob.Pattern
+			for _, line := range test.gitExcludes {
+				gitExcludes = append(gitExcludes, gitglob.ParseLine(line))
+			}
+			buf := new(bytes.Buffer)
+			_, _, err := bundle(ctx, buf, src, &bundleOptions{
+				useGitignore: test.useGitignore,
+				gitExcludes:  gitExcludes,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, f := range zr.File {
+				if !strings.HasSuffix(f.Name, "/") {
+					got = append(got, f.Name)
+				}
+			}
+			diff := cmp.Diff(test.want, got, cmpopts.SortSlices(func(s1, s2 string) bool { return s1 < s2 }))
+			if diff != "" {
+				t.Errorf("zip archive files (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPushWorkDirCancel(t *testing.T) {
+	defer goleak.VerifyNone(t)
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	db, err := openDB(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Write enough incompressible data that the push can't finish
+	// before the cancellation is noticed.
+	rootHostDir := t.TempDir()
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		data := make([]byte, 256<<10)
+		rng.Read(data)
+		if err := os.WriteFile(filepath.Join(rootHostDir, fmt.Sprintf("file%03d", i)), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	homeDir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bio := cancelingWriteBiome{
+		Local: biome.Local{
+			WorkDir: t.TempDir(),
+			HomeDir: homeDir,
+		},
+		n:      1 << 20,
+		cancel: cancel,
+	}
+	rec := &biomeRecord{
+		id:          "testbiome",
+		rootHostDir: rootHostDir,
+	}
+
+	err = pushWorkDir(ctx, db, rec, bio)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("pushWorkDir(...) = %v; want %v", err, context.Canceled)
+	}
+	leftovers, err := os.ReadDir(homeDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ent := range leftovers {
+		t.Errorf("Found %s in biome home directory after cancelled push", ent.Name())
+	}
+}
+
+func TestPushWorkDirUnzipFailure(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	ctx := context.Background()
+	db, err := openDB(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rootHostDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(rootHostDir, "changed.txt"), []byte("old\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(rootHostDir, "removed.txt"), []byte("bye\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	bio := biome.Local{
+		WorkDir: t.TempDir(),
+		HomeDir: t.TempDir(),
+	}
+	rec := &biomeRecord{
+		id:          "testbiome",
+		rootHostDir: rootHostDir,
+	}
+	if err := pushWorkDir(ctx, db, rec, bio); err != nil {
+		t.Fatal("Initial push:", err)
+	}
+	initStamps, err := readStamps(db, rec.id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Change the directory, then push to a biome whose unzip fails
+	// after the removals have happened.
+	if err := os.WriteFile(filepath.Join(rootHostDir, "changed.txt"), []byte("new content\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(rootHostDir, "removed.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := pushWorkDir(ctx, db, rec, failingUnzipBiome{bio}); err == nil {
+		t.Error("pushWorkDir(...) with failing unzip did not return an error")
+	}
+	if stamps, err := readStamps(db, rec.id); err != nil {
+		t.Error(err)
+	} else if diff := cmp.Diff(initStamps, stamps); diff != "" {
+		t.Errorf("stamps after failed push (-want +got):\n%s", diff)
+	}
+
+	// A retry should push the changes that the failed push missed.
+	if err := pushWorkDir(ctx, db, rec, bio); err != nil {
+		t.Fatal("Retry push:", err)
+	}
+	got, err := os.ReadFile(filepath.Join(bio.WorkDir, "changed.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "new content\n"; string(got) != want {
+		t.Errorf("changed.txt after retry = %q; want %q", got, want)
+	}
+	if _, err := os.Lstat(filepath.Join(bio.WorkDir, "removed.txt")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("removed.txt after retry: Lstat error = %v; want %v", err, fs.ErrNotExist)
+	}
+}
+
+func TestParsePatternFlags(t *testing.T) {
+	if _, err := parsePatternFlags("exclude", []string{"build/", "*.log"}, false); err != nil {
+		t.Error("parsePatternFlags(valid patterns):", err)
+	}
+	pats, err := parsePatternFlags("include", []string{"go.sum"}, true)
+	if err != nil {
+		t.Error("parsePatternFlags(include):", err)
+	} else if !pats[0].IsNegated() {
+		t.Errorf("parsePatternFlags(include) = %q; want negated pattern", pats[0])
+	}
+	for _, bad := range []string{"", "# comment", "foo[a-"} {
+		if _, err := parsePatternFlags("exclude", []string{bad}, false); err == nil {
+			t.Errorf("parsePatternFlags(%q) did not return an error", bad)
+		}
+	}
+}
+
+func TestExtractBundle(t *testing.T) {
+	ctx := context.Background()
+	src := t.TempDir()
+	if err := os.Mkdir(filepath.Join(src, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	const content = "Hello, World!\n"
+	if err := os.WriteFile(filepath.Join(src, "foo.txt"), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "sub", "run.sh"), []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join("..", "foo.txt"), filepath.Join(src, "sub", "link")); err != nil {
+		t.Skip("Could not symlink:", err)
+	}
+
+	buf := new(bytes.Buffer)
+	if _, _, err := bundle(ctx, buf, os.DirFS(src), &bundleOptions{linkRoot: src}); err != nil {
+		t.Fatal("bundle:", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := t.TempDir()
+	// Extract twice to verify that existing files are overwritten.
+	for i := 0; i < 2; i++ {
+		if err := extractBundle(zr, dst); err != nil {
+			t.Fatalf("extractBundle #%d: %v", i+1, err)
+		}
+	}
+
+	gotTarget, err := os.Readlink(filepath.Join(dst, "sub", "link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join("..", "foo.txt"); gotTarget != want {
+		t.Errorf("sub/link target = %q; want %q", gotTarget, want)
+	}
+	got, err := os.ReadFile(filepath.Join(dst, "sub", "link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != content {
+		t.Errorf("sub/link content = %q; want %q", got, content)
+	}
+	if runtime.GOOS != biome.Windows {
+		info, err := os.Stat(filepath.Join(dst, "sub", "run.sh"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := info.Mode().Perm(), fs.FileMode(0o755); got != want {
+			t.Errorf("sub/run.sh mode = %v; want %v", got, want)
+		}
+	}
+}
+
+func TestExtractBundleRejectsEscapes(t *testing.T) {
+	tests := []struct {
+		name   string
+		header *zip.FileHeader
+		body   string
+	}{
+		{
+			name:   "ParentPath",
+			header: &zip.FileHeader{Name: "../evil.txt"},
+			body:   "evil",
+		},
+		{
+			name: "SymlinkOutside",
+			header: func() *zip.FileHeader {
+				hdr := &zip.FileHeader{Name: "sub/link"}
+				hdr.SetMode(fs.ModeSymlink | 0o777)
+				return hdr
+			}(),
+			body: "../../etc/passwd",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			zw := zip.NewWriter(buf)
+			w, err := zw.CreateHeader(test.header)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.WriteString(w, test.body); err != nil {
+				t.Fatal(err)
+			}
+			if err := zw.Close(); err != nil {
+				t.Fatal(err)
+			}
+			zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := extractBundle(zr, t.TempDir()); err == nil {
+				t.Error("extractBundle did not return an error")
+			}
+		})
+	}
+}
+
 func TestMarshalStamp(t *testing.T) {
 	tests := []struct {
 		info fs.FileInfo
@@ -580,3 +1403,40 @@ func (info *fakeInfo) Mode() fs.FileMode  { return info.mode }
 func (info *fakeInfo) ModTime() time.Time { return info.modTime }
 func (info *fakeInfo) IsDir() bool        { return info.mode.IsDir() }
 func (info *fakeInfo) Sys() interface{}   { return nil }
+
+// cancelingWriteBiome is a local biome that calls cancel
+// after n bytes have been written by WriteFile.
+type cancelingWriteBiome struct {
+	biome.Local
+	n      int64
+	cancel context.CancelFunc
+}
+
+func (bio cancelingWriteBiome) WriteFile(ctx context.Context, path string, src io.Reader) error {
+	return bio.Local.WriteFile(ctx, path, io.MultiReader(
+		io.LimitReader(src, bio.n),
+		readerFunc(func(p []byte) (int, error) {
+			bio.cancel()
+			return 0, io.EOF
+		}),
+		src,
+	))
+}
+
+type readerFunc func(p []byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) { return f(p) }
+
+// failingUnzipBiome is a local biome whose unzip command always fails.
+// Because it is not a biome.Local, pushWorkDir runs unzip
+// instead of extracting the bundle itself.
+type failingUnzipBiome struct {
+	biome.Local
+}
+
+func (bio failingUnzipBiome) Run(ctx context.Context, invoke *biome.Invocation) error {
+	if len(invoke.Argv) > 0 && invoke.Argv[0] == "unzip" {
+		return fmt.Errorf("unzip: simulated failure")
+	}
+	return bio.Local.Run(ctx, invoke)
+}
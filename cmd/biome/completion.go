@@ -0,0 +1,82 @@
+// Copyright 2021 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		 https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"zombiezen.com/go/sqlite"
+)
+
+func newCompletionCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:                   "completion bash|zsh|fish|powershell",
+		DisableFlagsInUseLine: true,
+		Short:                 "generate a shell completion script",
+		Long: "Generate a shell completion script for biome.\n\n" +
+			"For example, to load completions in the current bash session:\n\n" +
+			"\tsource <(biome completion bash)",
+		Args:          cobra.ExactValidArgs(1),
+		ValidArgs:     []string{"bash", "zsh", "fish", "powershell"},
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			root := cmd.Root()
+			out := cmd.OutOrStdout()
+			switch args[0] {
+			case "bash":
+				return root.GenBashCompletionV2(out, true)
+			case "zsh":
+				return root.GenZshCompletion(out)
+			case "fish":
+				return root.GenFishCompletion(out, true)
+			case "powershell":
+				return root.GenPowerShellCompletionWithDesc(out)
+			default:
+				return fmt.Errorf("completion: unknown shell %q", args[0])
+			}
+		},
+	}
+}
+
+// completeBiomeID completes the --biome flag with the IDs of existing biomes.
+func completeBiomeID(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	ctx := cmd.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	db, err := openDB(ctx)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+	defer db.Close()
+	var ids []string
+	err = listBiomes(db, true, nil, func(stmt *sqlite.Stmt) error {
+		id := stmt.ColumnText(0)
+		if strings.HasPrefix(id, toComplete) {
+			ids = append(ids, id+"\t"+stmt.ColumnText(2))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+	return ids, cobra.ShellCompDirectiveNoFileComp
+}
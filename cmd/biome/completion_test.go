@@ -0,0 +1,77 @@
+// Copyright 2021 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		 https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/spf13/cobra"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+func TestCompletionCommand(t *testing.T) {
+	for _, shell := range []string{"bash", "zsh", "fish", "powershell"} {
+		t.Run(shell, func(t *testing.T) {
+			root := newRootCommand()
+			out := new(strings.Builder)
+			root.SetOut(out)
+			root.SetArgs([]string{"completion", shell})
+			if err := root.Execute(); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(out.String(), "biome") {
+				t.Errorf("completion %s output does not mention biome:\n%s", shell, out)
+			}
+		})
+	}
+
+	root := newRootCommand()
+	root.SetOut(new(strings.Builder))
+	root.SetArgs([]string{"completion", "tcsh"})
+	if err := root.Execute(); err == nil {
+		t.Error("completion tcsh did not return an error")
+	}
+}
+
+func TestCompleteBiomeID(t *testing.T) {
+	db := openTestDB(t)
+	dirs := map[string]string{
+		"aaaa": t.TempDir(),
+		"aabb": t.TempDir(),
+		"bbbb": t.TempDir(),
+	}
+	for id, dir := range dirs {
+		err := sqlitex.Exec(db, `insert into "biomes" ("id", "root_host_dir") values (?, ?);`, nil, id, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, directive := completeBiomeID(newRunCommand(), nil, "aa")
+	want := []string{
+		"aaaa\t" + dirs["aaaa"],
+		"aabb\t" + dirs["aabb"],
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("completions (-want +got):\n%s", diff)
+	}
+	if directive != cobra.ShellCompDirectiveNoFileComp {
+		t.Errorf("directive = %v; want %v", directive, cobra.ShellCompDirectiveNoFileComp)
+	}
+}
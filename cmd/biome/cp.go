@@ -0,0 +1,223 @@
+// Copyright 2021 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		 https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	slashpath "path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"zombiezen.com/go/biome"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+// biomePathPrefix marks a cp argument as a path inside the biome.
+const biomePathPrefix = "biome:"
+
+type cpCommand struct {
+	biomeID string
+	src     string
+	dst     string
+}
+
+func newCpCommand() *cobra.Command {
+	c := new(cpCommand)
+	cmd := &cobra.Command{
+		Use:                   "cp [options] SRC DST",
+		DisableFlagsInUseLine: true,
+		Short:                 "copy a file between the biome and the host",
+		Long: "Copy a file between the biome and the host.\n\n" +
+			"Exactly one of SRC or DST must start with \"" + biomePathPrefix + "\" to name a path " +
+			"inside the biome. Biome paths are either absolute or start with work/, home/, or " +
+			"tools/ to name a path inside one of the biome's directories. If DST is a directory " +
+			"or ends with a slash, the file is copied into it.",
+		Args:          cobra.ExactArgs(2),
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c.src = args[0]
+			c.dst = args[1]
+			return c.run(cmd.Context())
+		},
+	}
+	addBiomeFlag(cmd, &c.biomeID, "biome to copy to or from")
+	return cmd
+}
+
+func (c *cpCommand) run(ctx context.Context) error {
+	srcInBiome := strings.HasPrefix(c.src, biomePathPrefix)
+	dstInBiome := strings.HasPrefix(c.dst, biomePathPrefix)
+	if srcInBiome == dstInBiome {
+		return fmt.Errorf("exactly one of SRC or DST must start with %q", biomePathPrefix)
+	}
+
+	var rec *biomeRecord
+	err := func() (err error) {
+		db, err := openDB(ctx)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		endFn, err := sqlitex.ImmediateTransaction(db)
+		if err != nil {
+			return err
+		}
+		defer endFn(&err)
+		rec, err = findBiome(db, c.biomeID)
+		if err != nil {
+			return err
+		}
+		return markBiomeUsed(db, rec.id, time.Now())
+	}()
+	if err != nil {
+		return err
+	}
+
+	// Don't use rec.setup: copying the working directory into the biome
+	// could clobber the file being copied out.
+	bio := rec.local()
+	if srcInBiome {
+		src, err := resolveBiomePath(bio, strings.TrimPrefix(c.src, biomePathPrefix))
+		if err != nil {
+			return err
+		}
+		return copyFromBiome(ctx, bio, src, c.dst)
+	}
+	rawDst := strings.TrimPrefix(c.dst, biomePathPrefix)
+	dst, err := resolveBiomePath(bio, rawDst)
+	if err != nil {
+		return err
+	}
+	return copyToBiome(ctx, bio, c.src, dst, strings.HasSuffix(rawDst, "/"))
+}
+
+// resolveBiomePath converts a cp biome path (without its "biome:" prefix)
+// into an absolute path in the biome. Relative paths must start with
+// "work", "home", or "tools" and may not refer outside that directory.
+func resolveBiomePath(bio biome.Biome, path string) (string, error) {
+	desc := bio.Describe()
+	if biome.IsAbsPath(desc, path) {
+		return biome.CleanPath(desc, path), nil
+	}
+	first, rest := path, ""
+	if i := strings.IndexByte(path, '/'); i != -1 {
+		first, rest = path[:i], path[i+1:]
+	}
+	var root string
+	switch dirs := bio.Dirs(); first {
+	case "work":
+		root = dirs.Work
+	case "home":
+		root = dirs.Home
+	case "tools":
+		root = dirs.Tools
+	default:
+		return "", fmt.Errorf("biome path %q must be absolute or start with work/, home/, or tools/", path)
+	}
+	rest = slashpath.Clean(rest)
+	if rest == ".." || strings.HasPrefix(rest, "../") {
+		return "", fmt.Errorf("biome path %q: outside %s directory", path, first)
+	}
+	return biome.JoinPath(desc, root, biome.FromSlash(desc, rest)), nil
+}
+
+// copyFromBiome copies a regular file from the biome to the host.
+// If hostPath is an existing directory or ends in a separator,
+// the file is copied into it with the same base name.
+func copyFromBiome(ctx context.Context, bio biome.Biome, biomePath, hostPath string) error {
+	info, err := biome.Stat(ctx, bio, biomePath)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("copy %s from biome: is a directory", biomePath)
+	}
+	if isDirPath(hostPath) {
+		hostPath = filepath.Join(hostPath, info.Name())
+	}
+	rc, err := biome.OpenFile(ctx, bio, biomePath)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if err := os.MkdirAll(filepath.Dir(hostPath), 0o777); err != nil {
+		return fmt.Errorf("copy %s from biome: %w", biomePath, err)
+	}
+	f, err := os.Create(hostPath)
+	if err != nil {
+		return fmt.Errorf("copy %s from biome: %w", biomePath, err)
+	}
+	_, err = io.Copy(f, rc)
+	closeErr := f.Close()
+	if err != nil {
+		return fmt.Errorf("copy %s from biome: %w", biomePath, err)
+	}
+	if closeErr != nil {
+		return fmt.Errorf("copy %s from biome: %w", biomePath, closeErr)
+	}
+	return nil
+}
+
+// copyToBiome copies a regular file from the host to the biome.
+// If biomePath is an existing directory in the biome or intoDir is true,
+// the file is copied into it with the same base name.
+func copyToBiome(ctx context.Context, bio biome.Biome, hostPath, biomePath string, intoDir bool) error {
+	f, err := os.Open(hostPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("copy %s to biome: is a directory", hostPath)
+	}
+	if !intoDir {
+		dstInfo, err := biome.Stat(ctx, bio, biomePath)
+		if err != nil && !errors.Is(err, biome.ErrNotFound) {
+			return err
+		}
+		intoDir = err == nil && dstInfo.IsDir()
+	}
+	desc := bio.Describe()
+	if intoDir {
+		biomePath = biome.JoinPath(desc, biomePath, info.Name())
+	}
+	if err := biome.MkdirAll(ctx, bio, biome.JoinPath(desc, biomePath, "..")); err != nil {
+		return err
+	}
+	return biome.WriteFile(ctx, bio, biomePath, f)
+}
+
+// isDirPath reports whether path names a directory on the host,
+// either because it ends in a separator or because it exists as one.
+func isDirPath(path string) bool {
+	if strings.HasSuffix(path, "/") || strings.HasSuffix(path, string(filepath.Separator)) {
+		return true
+	}
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
@@ -0,0 +1,152 @@
+// Copyright 2021 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		 https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"zombiezen.com/go/biome"
+)
+
+func TestResolveBiomePath(t *testing.T) {
+	bio := biome.Local{
+		HomeDir: filepath.Join("/biome", "home"),
+		WorkDir: filepath.Join("/biome", "work"),
+	}
+	tests := []struct {
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{path: "work/data.csv", want: filepath.Join(bio.WorkDir, "data.csv")},
+		{path: "work", want: bio.WorkDir},
+		{path: "work/", want: bio.WorkDir},
+		{path: "home/.config/foo.json", want: filepath.Join(bio.HomeDir, ".config", "foo.json")},
+		{path: "tools/bin/foo", want: filepath.Join(bio.Dirs().Tools, "bin", "foo")},
+		{path: "work/a/../b.txt", want: filepath.Join(bio.WorkDir, "b.txt")},
+		{path: "/etc/hosts", want: "/etc/hosts"},
+		{path: "data.csv", wantErr: true},
+		{path: "", wantErr: true},
+		{path: "work/../home/foo", wantErr: true},
+		{path: "work/..", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := resolveBiomePath(bio, test.path)
+		if err != nil {
+			if !test.wantErr {
+				t.Errorf("resolveBiomePath(bio, %q): %v", test.path, err)
+			}
+			continue
+		}
+		if test.wantErr {
+			t.Errorf("resolveBiomePath(bio, %q) = %q, <nil>; want error", test.path, got)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("resolveBiomePath(bio, %q) = %q, <nil>; want %q, <nil>", test.path, got, test.want)
+		}
+	}
+}
+
+func TestCopy(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	bio := biome.Local{
+		HomeDir: filepath.Join(dir, "biome", "home"),
+		WorkDir: filepath.Join(dir, "biome", "work"),
+	}
+	hostDir := filepath.Join(dir, "host")
+	if err := os.MkdirAll(hostDir, 0o777); err != nil {
+		t.Fatal(err)
+	}
+	const content = "Hello, World!\n"
+	hostFile := filepath.Join(hostDir, "foo.txt")
+	if err := os.WriteFile(hostFile, []byte(content), 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("ToBiome", func(t *testing.T) {
+		dst := filepath.Join(bio.HomeDir, "sub", "bar.txt")
+		if err := copyToBiome(ctx, bio, hostFile, dst, false); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := os.ReadFile(dst); err != nil {
+			t.Error(err)
+		} else if string(got) != content {
+			t.Errorf("content = %q; want %q", got, content)
+		}
+	})
+	t.Run("ToBiomeDir", func(t *testing.T) {
+		if err := copyToBiome(ctx, bio, hostFile, bio.HomeDir, false); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := os.ReadFile(filepath.Join(bio.HomeDir, "foo.txt")); err != nil {
+			t.Error(err)
+		} else if string(got) != content {
+			t.Errorf("content = %q; want %q", got, content)
+		}
+	})
+	t.Run("ToBiomeNewDir", func(t *testing.T) {
+		dst := filepath.Join(bio.WorkDir, "new")
+		if err := copyToBiome(ctx, bio, hostFile, dst, true); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := os.ReadFile(filepath.Join(dst, "foo.txt")); err != nil {
+			t.Error(err)
+		} else if string(got) != content {
+			t.Errorf("content = %q; want %q", got, content)
+		}
+	})
+	t.Run("FromBiome", func(t *testing.T) {
+		dst := filepath.Join(hostDir, "out", "baz.txt")
+		if err := copyFromBiome(ctx, bio, filepath.Join(bio.HomeDir, "sub", "bar.txt"), dst); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := os.ReadFile(dst); err != nil {
+			t.Error(err)
+		} else if string(got) != content {
+			t.Errorf("content = %q; want %q", got, content)
+		}
+	})
+	t.Run("FromBiomeIntoDir", func(t *testing.T) {
+		if err := copyFromBiome(ctx, bio, filepath.Join(bio.HomeDir, "sub", "bar.txt"), hostDir); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := os.ReadFile(filepath.Join(hostDir, "bar.txt")); err != nil {
+			t.Error(err)
+		} else if string(got) != content {
+			t.Errorf("content = %q; want %q", got, content)
+		}
+	})
+	t.Run("FromBiomeDirectory", func(t *testing.T) {
+		if err := copyFromBiome(ctx, bio, bio.HomeDir, filepath.Join(hostDir, "dir")); err == nil {
+			t.Error("copyFromBiome did not return an error")
+		}
+	})
+	t.Run("FromBiomeMissing", func(t *testing.T) {
+		err := copyFromBiome(ctx, bio, filepath.Join(bio.HomeDir, "missing.txt"), filepath.Join(hostDir, "missing.txt"))
+		if err == nil {
+			t.Error("copyFromBiome did not return an error")
+		}
+		if _, err := os.Stat(filepath.Join(hostDir, "missing.txt")); err == nil {
+			t.Error("missing.txt created on host")
+		}
+	})
+}
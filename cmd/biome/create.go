@@ -22,14 +22,25 @@ import (
 	"encoding/hex"
 	"fmt"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
+	"zombiezen.com/go/biome"
+	"zombiezen.com/go/sqlite"
 	"zombiezen.com/go/sqlite/sqlitex"
 )
 
 type createCommand struct {
-	rootDir string
+	rootDir  string
+	mount    bool
+	exclude  []string
+	include  []string
+	tags     []string
+	from     string
+	platform string
+
+	useGitignore bool
 }
 
 func newCreateCommand() *cobra.Command {
@@ -45,49 +56,148 @@ func newCreateCommand() *cobra.Command {
 		},
 	}
 	cmd.Flags().StringVar(&c.rootDir, "root", ".", "root of the directory to copy into the biome")
+	cmd.Flags().BoolVar(&c.mount, "mount", false, "use the root directory as the biome's working directory instead of copying it. "+
+		"Commands run in the biome can modify the directory directly, so this sacrifices isolation.")
+	cmd.Flags().StringArrayVar(&c.exclude, "exclude", nil, "skip files matching a .biomeignore-style `pattern` when copying (can be repeated)")
+	cmd.Flags().StringArrayVar(&c.include, "include", nil, "copy files matching a .biomeignore-style `pattern` even if ignored or excluded (can be repeated)")
+	cmd.Flags().BoolVar(&c.useGitignore, "use-gitignore", false, "also skip files matched by .gitignore files when copying")
+	cmd.Flags().StringArrayVar(&c.tags, "tag", nil, "attach a `KEY=VALUE` tag to the biome (can be repeated)")
+	cmd.Flags().StringVar(&c.from, "from", "", "copy the environment (but not files) of the biome with the given `ID`")
+	cmd.RegisterFlagCompletionFunc("from", completeBiomeID)
+	cmd.Flags().StringVar(&c.platform, "platform", "", "create the biome for the given `OS/ARCH` instead of the host's "+
+		"(for example, darwin/amd64 on an ARM64 Mac to use Intel programs)")
 	return cmd
 }
 
 func (c *createCommand) run(ctx context.Context) (err error) {
-	now := time.Now()
-	rootDir, err := filepath.Abs(c.rootDir)
+	db, err := openDB(ctx)
 	if err != nil {
 		return err
 	}
-	db, err := openDB(ctx)
+	defer db.Close()
+	id, err := c.create(ctx, db)
 	if err != nil {
 		return err
 	}
-	defer db.Close()
+	fmt.Println(id)
+	return nil
+}
 
+// create creates a new biome and returns its ID.
+func (c *createCommand) create(ctx context.Context, db *sqlite.Conn) (_ string, err error) {
+	now := time.Now()
+	rootDir, err := filepath.Abs(c.rootDir)
+	if err != nil {
+		return "", err
+	}
+	exclude, err := parsePatternFlags("exclude", c.exclude, false)
+	if err != nil {
+		return "", err
+	}
+	include, err := parsePatternFlags("include", c.include, true)
+	if err != nil {
+		return "", err
+	}
+	tags, err := parseTags(c.tags)
+	if err != nil {
+		return "", fmt.Errorf("--tag: %v", err)
+	}
+	var platform *biome.Descriptor
+	var platformArg interface{} // NULL in the database if no --platform
+	if c.platform != "" {
+		platform, err = parsePlatform(c.platform)
+		if err != nil {
+			return "", fmt.Errorf("--platform: %v", err)
+		}
+		if err := checkPlatform(platform); err != nil {
+			return "", fmt.Errorf("--platform: %v", err)
+		}
+		platformArg = formatPlatform(platform)
+	}
 	id, err := genHexDigits(16)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	endFn, err := sqlitex.ImmediateTransaction(db)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer endFn(&err)
-	err = sqlitex.Exec(db, `insert into "biomes" ("id", "created_at", "root_host_dir") values (?, ?, ?);`, nil,
-		id, now.UTC().Format(sqliteTimestampFormatMillis), rootDir)
+	err = sqlitex.Exec(db, `insert into "biomes" ("id", "created_at", "root_host_dir", "mounted", "platform", "use_gitignore") values (?, ?, ?, ?, ?, ?);`, nil,
+		id, now.UTC().Format(sqliteTimestampFormatMillis), rootDir, c.mount, platformArg, c.useGitignore)
 	if err != nil {
-		return err
+		return "", err
+	}
+	if err := setTags(db, id, tags); err != nil {
+		return "", err
+	}
+	var env biome.Environment
+	if c.from != "" {
+		src, err := findBiome(db, c.from)
+		if err != nil {
+			return "", fmt.Errorf("--from: %v", err)
+		}
+		newSupportRoot, err := computeSupportRoot(id)
+		if err != nil {
+			return "", err
+		}
+		env = rebaseEnvironment(src.env, src.supportRoot, newSupportRoot)
+		if err := writeBiomeEnvironment(db, id, env); err != nil {
+			return "", err
+		}
 	}
 	rec := &biomeRecord{
 		id:          id,
 		rootHostDir: rootDir,
+		mounted:     c.mount,
+		platform:    platform,
+		exclude:     exclude,
+		include:     include,
+		env:         env,
+
+		useGitignore: c.useGitignore,
 	}
 	rec.supportRoot, err = computeSupportRoot(id)
 	if err != nil {
-		return err
+		return "", err
 	}
 	if _, err := rec.setup(ctx, db); err != nil {
-		return err
+		return "", err
 	}
-	fmt.Println(id)
-	return nil
+	return id, nil
+}
+
+// rebaseEnvironment returns a copy of e with any references to the
+// oldSupportRoot directory replaced with newSupportRoot. This allows tools
+// installed into one biome's tools directory to be found at the same relative
+// location in another biome.
+func rebaseEnvironment(e biome.Environment, oldSupportRoot, newSupportRoot string) biome.Environment {
+	rebase := func(s string) string {
+		return strings.ReplaceAll(s, oldSupportRoot, newSupportRoot)
+	}
+	var e2 biome.Environment
+	if e.Vars != nil {
+		e2.Vars = make(map[string]string, len(e.Vars))
+		for k, v := range e.Vars {
+			e2.Vars[k] = rebase(v)
+		}
+	}
+	for k, expand := range e.ExpandVars {
+		if expand {
+			if e2.ExpandVars == nil {
+				e2.ExpandVars = make(map[string]bool)
+			}
+			e2.ExpandVars[k] = true
+		}
+	}
+	for _, dir := range e.PrependPath {
+		e2.PrependPath = append(e2.PrependPath, rebase(dir))
+	}
+	for _, dir := range e.AppendPath {
+		e2.AppendPath = append(e2.AppendPath, rebase(dir))
+	}
+	return e2
 }
 
 func genHexDigits(nbytes int) (string, error) {
@@ -0,0 +1,95 @@
+// Copyright 2021 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		 https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"zombiezen.com/go/biome"
+	"zombiezen.com/go/log/testlog"
+)
+
+func TestRebaseEnvironment(t *testing.T) {
+	const (
+		oldRoot = "/cache/biomes/ab/cdef"
+		newRoot = "/cache/biomes/12/3456"
+	)
+	e := biome.Environment{
+		Vars: map[string]string{
+			"GOROOT":  oldRoot + "/work/.tools/go",
+			"GOFLAGS": "-mod=mod",
+			"MULTI":   oldRoot + "/a:" + oldRoot + "/b",
+		},
+		PrependPath: []string{oldRoot + "/work/.tools/go/bin", "/usr/local/bin"},
+		AppendPath:  []string{oldRoot + "/home/bin"},
+	}
+	got := rebaseEnvironment(e, oldRoot, newRoot)
+	want := biome.Environment{
+		Vars: map[string]string{
+			"GOROOT":  newRoot + "/work/.tools/go",
+			"GOFLAGS": "-mod=mod",
+			"MULTI":   newRoot + "/a:" + newRoot + "/b",
+		},
+		PrependPath: []string{newRoot + "/work/.tools/go/bin", "/usr/local/bin"},
+		AppendPath:  []string{newRoot + "/home/bin"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("rebaseEnvironment(...) (-want +got):\n%s", diff)
+	}
+	if e.Vars["GOROOT"] != oldRoot+"/work/.tools/go" {
+		t.Error("rebaseEnvironment modified its argument")
+	}
+}
+
+func TestCreateMount(t *testing.T) {
+	ctx := testlog.WithTB(context.Background(), t)
+	db := openTestDB(t)
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "in.txt"), []byte("hello"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	id, err := (&createCommand{rootDir: root, mount: true}).create(ctx, db)
+	if err != nil {
+		t.Fatal("create:", err)
+	}
+	run := &runCommand{
+		biomeID: id,
+		argv:    []string{"sh", "-c", "cat in.txt > out.txt"},
+	}
+	if err := run.run(ctx); err != nil {
+		t.Fatal("run:", err)
+	}
+
+	// The command runs directly in the host directory.
+	if got, err := os.ReadFile(filepath.Join(root, "out.txt")); err != nil {
+		t.Error(err)
+	} else if string(got) != "hello" {
+		t.Errorf("out.txt = %q; want %q", got, "hello")
+	}
+	// No copy of the host directory is made.
+	rec, err := findBiome(db, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(rec.supportRoot, "work")); !os.IsNotExist(err) {
+		t.Errorf("os.Stat(<support root>/work) = _, %v; want not exist", err)
+	}
+}
@@ -0,0 +1,4 @@
+alter table "biomes" add column "mounted" integer
+  not null
+  default 0
+  check ("mounted" in (0, 1));
@@ -0,0 +1,18 @@
+create table "biome_installs" (
+  "biome_id" text
+    not null
+    references "biomes"
+      on update cascade
+      on delete cascade,
+  "script_path" text
+    not null
+    check ("script_path" <> ''),
+  "version" text
+    not null,
+  "installed_at" timestamp
+    not null
+    default current_timestamp
+    check ("installed_at" regexp '[0-9]{4}-[0-9]{2}-[0-9]{2} [0-2][0-9]:[0-5][0-9]:[0-5][0-9](\.[0-9]*)?'),
+
+  primary key ("biome_id", "script_path", "version")
+);
@@ -0,0 +1,2 @@
+alter table "biomes" add column "last_used_at" timestamp
+  check ("last_used_at" is null or "last_used_at" regexp '[0-9]{4}-[0-9]{2}-[0-9]{2} [0-2][0-9]:[0-5][0-9]:[0-5][0-9](\.[0-9]*)?');
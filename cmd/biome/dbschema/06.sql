@@ -0,0 +1,15 @@
+create table "biome_tags" (
+  "biome_id" text
+    not null
+    references "biomes"
+      on update cascade
+      on delete cascade,
+  "key" text
+    not null
+    check ("key" regexp '[^=]+'),
+  "value" text
+    not null
+    default '',
+
+  primary key ("biome_id", "key")
+);
@@ -0,0 +1,22 @@
+create table "biome_snapshots" (
+  "biome_id" text
+    not null
+    references "biomes"
+      on update cascade
+      on delete cascade,
+  "name" text
+    not null
+    check ("name" regexp '^[A-Za-z0-9._-]+$'),
+  "created_at" timestamp
+    not null
+    default current_timestamp
+    check ("created_at" regexp '[0-9]{4}-[0-9]{2}-[0-9]{2} [0-2][0-9]:[0-5][0-9]:[0-5][0-9](\.[0-9]*)?'),
+  "env" text
+    not null
+    default '{}',
+  "installs" text
+    not null
+    default '[]',
+
+  primary key ("biome_id", "name")
+);
@@ -0,0 +1,22 @@
+create table "biome_history" (
+  "id" integer
+    primary key,
+  "biome_id" text
+    not null
+    references "biomes"
+      on update cascade
+      on delete cascade,
+  "started_at" timestamp
+    not null
+    default current_timestamp
+    check ("started_at" regexp '[0-9]{4}-[0-9]{2}-[0-9]{2} [0-2][0-9]:[0-5][0-9]:[0-5][0-9](\.[0-9]*)?'),
+  "command" text
+    not null
+    check ("command" in ('run', 'install', 'uninstall')),
+  "argv" text
+    not null
+    default '[]',
+  "exit_code" integer
+);
+
+create index "biome_history_by_time" on "biome_history" ("biome_id", "started_at");
@@ -0,0 +1,4 @@
+alter table "env_vars" add column "expand" integer
+  not null
+  default 0
+  check ("expand" in (0, 1));
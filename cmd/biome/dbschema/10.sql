@@ -0,0 +1,19 @@
+create table "biome_tool_versions" (
+  "biome_id" text
+    not null
+    references "biomes"
+      on update cascade
+      on delete cascade,
+  "name" text
+    not null
+    check ("name" <> ''),
+  "version" text
+    not null
+    check ("version" <> ''),
+
+  primary key ("biome_id", "name")
+);
+
+alter table "biome_snapshots" add column "tool_versions" text
+  not null
+  default '{}';
@@ -0,0 +1,2 @@
+alter table "biomes" add column "platform" text
+  check ("platform" is null or "platform" regexp '^[a-z]+/[a-z0-9]+$');
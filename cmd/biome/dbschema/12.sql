@@ -0,0 +1 @@
+create index "biomes_by_root_host_dir" on "biomes" ("root_host_dir");
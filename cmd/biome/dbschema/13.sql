@@ -0,0 +1,4 @@
+alter table "biomes" add column "use_gitignore" integer
+  not null
+  default 0
+  check ("use_gitignore" in (0, 1));
@@ -17,26 +17,35 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"fmt"
 	"io"
 	"os"
 	"runtime"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"golang.org/x/sys/unix"
+	"zombiezen.com/go/biome"
 	"zombiezen.com/go/log"
+	"zombiezen.com/go/sqlite"
 	"zombiezen.com/go/sqlite/sqlitex"
 )
 
 type destroyCommand struct {
-	biomeID string
+	biomeID   string
+	all       bool
+	olderThan string
+	yes       bool
 }
 
 func newDestroyCommand() *cobra.Command {
 	c := new(destroyCommand)
 	cmd := &cobra.Command{
-		Use:                   "destroy [options] [--biome=ID]",
+		Use:                   "destroy [options] [--biome=ID | --all | --older-than=DURATION]",
 		DisableFlagsInUseLine: true,
 		Short:                 "destroy a biome",
 		Args:                  cobra.NoArgs,
@@ -46,10 +55,27 @@ func newDestroyCommand() *cobra.Command {
 			if len(args) > 0 {
 				c.biomeID = args[0]
 			}
-			return c.run(cmd.Context())
+			if !c.all && c.olderThan == "" {
+				return c.run(cmd.Context())
+			}
+			if cmd.Flags().Changed("biome") {
+				return fmt.Errorf("destroy: cannot use --all or --older-than with --biome")
+			}
+			var cutoff time.Time
+			if c.olderThan != "" {
+				age, err := parseAge(c.olderThan)
+				if err != nil {
+					return fmt.Errorf("destroy: --older-than: %v", err)
+				}
+				cutoff = time.Now().Add(-age)
+			}
+			return c.runMany(cmd.Context(), cutoff)
 		},
 	}
-	cmd.Flags().StringVarP(&c.biomeID, "biome", "b", "", "biome to run inside")
+	addBiomeFlag(cmd, &c.biomeID, "biome to destroy")
+	cmd.Flags().BoolVarP(&c.all, "all", "a", false, "destroy all biomes")
+	cmd.Flags().StringVar(&c.olderThan, "older-than", "", "destroy biomes not used for `duration` (e.g. 36h, 30d, 2w)")
+	cmd.Flags().BoolVarP(&c.yes, "yes", "y", false, "do not prompt for confirmation")
 	return cmd
 }
 
@@ -59,27 +85,150 @@ func (c *destroyCommand) run(ctx context.Context) (err error) {
 		return err
 	}
 	defer db.Close()
+	return destroyBiome(ctx, db, c.biomeID)
+}
+
+// runMany destroys all biomes last used (or created, if never used) before cutoff,
+// or every biome if cutoff is the zero time.
+func (c *destroyCommand) runMany(ctx context.Context, cutoff time.Time) (err error) {
+	db, err := openDB(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
 
-	endFn, err := sqlitex.ImmediateTransaction(db)
+	query := `select "id", "root_host_dir" from "biomes" `
+	var queryArgs []interface{}
+	if !cutoff.IsZero() {
+		query += `where coalesce("last_used_at", "created_at") < ? `
+		queryArgs = append(queryArgs, cutoff.UTC().Format(sqliteTimestampFormatMillis))
+	}
+	query += `order by "created_at", "id";`
+	var ids []string
+	err = sqlitex.Exec(db, query, func(stmt *sqlite.Stmt) error {
+		ids = append(ids, stmt.ColumnText(0))
+		if !c.yes {
+			_, err := fmt.Fprintf(os.Stderr, "%s\t%s\n", stmt.ColumnText(0), stmt.ColumnText(1))
+			return err
+		}
+		return nil
+	}, queryArgs...)
+	if err != nil {
+		return fmt.Errorf("destroy: %v", err)
+	}
+	if len(ids) == 0 {
+		log.Infof(ctx, "No biomes to destroy")
+		return nil
+	}
+	if !c.yes {
+		ok, err := confirm(os.Stdin, os.Stderr, fmt.Sprintf("Destroy %d biome(s)?", len(ids)))
+		if err != nil {
+			return fmt.Errorf("destroy: %v", err)
+		}
+		if !ok {
+			return fmt.Errorf("destroy: aborted")
+		}
+	}
+
+	failed := 0
+	for _, id := range ids {
+		if err := destroyBiome(ctx, db, id); err != nil {
+			log.Errorf(ctx, "%v", err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("destroy: %d of %d biomes could not be destroyed", failed, len(ids))
+	}
+	return nil
+}
+
+// destroyBiome deletes the biome record for the given ID reference
+// (see findBiome) and removes its support files.
+func destroyBiome(ctx context.Context, conn *sqlite.Conn, idArg string) (err error) {
+	endFn, err := sqlitex.ImmediateTransaction(conn)
 	if err != nil {
 		return fmt.Errorf("destroy: %v", err)
 	}
 	defer endFn(&err)
-	rec, err := findBiome(db, c.biomeID)
+	rec, err := findBiome(conn, idArg)
 	if err != nil {
-		return fmt.Errorf("destroy %q: %v", rec.id, err)
+		if idArg == "" {
+			return fmt.Errorf("destroy: %v", err)
+		}
+		return fmt.Errorf("destroy %q: %v", idArg, err)
 	}
-	err = sqlitex.Exec(db, `delete from "biomes" where "id" = ?;`, nil, rec.id)
+	err = sqlitex.Exec(conn, `delete from "biomes" where "id" = ?;`, nil, rec.id)
 	if err != nil {
 		return fmt.Errorf("destroy %q: %v", rec.id, err)
 	}
 
 	if err := removeAll(ctx, rec.supportRoot); err != nil {
-		return err
+		return fmt.Errorf("destroy %q: %v", rec.id, err)
 	}
 	return nil
 }
 
+// parseAge parses a duration string as accepted by time.ParseDuration,
+// extended with "d" (24 hours) and "w" (7 days) units.
+// Day and week components must come first, as in "1w2d12h".
+func parseAge(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, fmt.Errorf("empty duration")
+	}
+	orig := s
+	var d time.Duration
+	for {
+		i := 0
+		for i < len(s) && '0' <= s[i] && s[i] <= '9' {
+			i++
+		}
+		if i == 0 || i >= len(s) || (s[i] != 'd' && s[i] != 'w') {
+			break
+		}
+		n, err := strconv.Atoi(s[:i])
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", orig)
+		}
+		unit := 24 * time.Hour
+		if s[i] == 'w' {
+			unit *= 7
+		}
+		d += time.Duration(n) * unit
+		s = s[i+1:]
+	}
+	if s == "" {
+		return d, nil
+	}
+	rest, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", orig)
+	}
+	d += rest
+	if d < 0 {
+		return 0, fmt.Errorf("invalid duration %q: negative", orig)
+	}
+	return d, nil
+}
+
+// confirm writes prompt to w and reads a yes/no answer from r.
+// Anything other than "y" or "yes" (case-insensitive) is treated as no.
+func confirm(r io.Reader, w io.Writer, prompt string) (bool, error) {
+	if _, err := fmt.Fprintf(w, "%s [y/N] ", prompt); err != nil {
+		return false, err
+	}
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "y", "yes":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
 // removeAll removes path and any children it contains. It operates similar to
 // os.RemoveAll, but also removes any write-protected files if possible.
 //
@@ -212,7 +361,7 @@ func removeAll(ctx context.Context, path string) error {
 	if err1 == nil || os.IsNotExist(err1) {
 		return nil
 	}
-	if runtime.GOOS == "windows" && os.IsPermission(err1) {
+	if runtime.GOOS == biome.Windows && os.IsPermission(err1) {
 		if fs, err := os.Stat(path); err == nil {
 			if err = os.Chmod(path, 0o200|fs.Mode()); err == nil {
 				err1 = os.Remove(path)
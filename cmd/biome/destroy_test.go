@@ -0,0 +1,160 @@
+// Copyright 2021 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		 https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"zombiezen.com/go/log/testlog"
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+func TestParseAge(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    time.Duration
+		wantErr bool
+	}{
+		{s: "24h", want: 24 * time.Hour},
+		{s: "90m", want: 90 * time.Minute},
+		{s: "30d", want: 30 * 24 * time.Hour},
+		{s: "1w", want: 7 * 24 * time.Hour},
+		{s: "1w2d", want: 9 * 24 * time.Hour},
+		{s: "1d12h", want: 36 * time.Hour},
+		{s: "0d", want: 0},
+		{s: "", wantErr: true},
+		{s: "d", wantErr: true},
+		{s: "1y", wantErr: true},
+		{s: "12h1d", wantErr: true},
+		{s: "-1h", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := parseAge(test.s)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("parseAge(%q) = %v, <nil>; want _, <error>", test.s, got)
+			}
+			continue
+		}
+		if got != test.want || err != nil {
+			t.Errorf("parseAge(%q) = %v, %v; want %v, <nil>", test.s, got, err, test.want)
+		}
+	}
+}
+
+func TestDestroyMany(t *testing.T) {
+	now := time.Now()
+	type testBiome struct {
+		id       string
+		lastUsed time.Time
+	}
+	biomes := []testBiome{
+		{id: "aaaa", lastUsed: now.Add(-40 * 24 * time.Hour)},
+		{id: "bbbb", lastUsed: now.Add(-time.Hour)},
+		{id: "cccc", lastUsed: now.Add(-50 * 24 * time.Hour)},
+	}
+	tests := []struct {
+		name    string
+		cutoff  time.Time
+		broken  string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "All",
+			want: []string{},
+		},
+		{
+			name:   "OlderThan",
+			cutoff: now.Add(-30 * 24 * time.Hour),
+			want:   []string{"bbbb"},
+		},
+		{
+			// A biome that can't be destroyed doesn't stop the others.
+			name:    "PartialFailure",
+			broken:  "aaaa",
+			want:    []string{"aaaa"},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := testlog.WithTB(context.Background(), t)
+			db := openTestDB(t)
+			for _, b := range biomes {
+				var platform interface{}
+				if b.id == test.broken {
+					// Not a platform that findBiome accepts.
+					platform = "beos/vax"
+				}
+				err := sqlitex.Exec(db, `insert into "biomes" ("id", "root_host_dir", "last_used_at", "platform") values (?, ?, ?, ?);`, nil,
+					b.id, t.TempDir(), b.lastUsed.UTC().Format(sqliteTimestampFormatMillis), platform)
+				if err != nil {
+					t.Fatal(err)
+				}
+				supportRoot, err := computeSupportRoot(b.id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := os.MkdirAll(filepath.Join(supportRoot, "home"), 0o777); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			c := &destroyCommand{yes: true}
+			if err := c.runMany(ctx, test.cutoff); test.wantErr && err == nil {
+				t.Error("runMany did not return an error")
+			} else if !test.wantErr && err != nil {
+				t.Error("runMany:", err)
+			}
+
+			got := []string{}
+			err := sqlitex.Exec(db, `select "id" from "biomes" order by "id";`, func(stmt *sqlite.Stmt) error {
+				got = append(got, stmt.ColumnText(0))
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("remaining biomes (-want +got):\n%s", diff)
+			}
+			kept := make(map[string]bool)
+			for _, id := range test.want {
+				kept[id] = true
+			}
+			for _, b := range biomes {
+				supportRoot, err := computeSupportRoot(b.id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				_, err = os.Stat(supportRoot)
+				if kept[b.id] && err != nil {
+					t.Errorf("support root for remaining biome %s: %v", b.id, err)
+				} else if !kept[b.id] && !os.IsNotExist(err) {
+					t.Errorf("support root for destroyed biome %s still exists (err = %v)", b.id, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright 2021 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		 https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"zombiezen.com/go/biome"
+	"zombiezen.com/go/log"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+type diffCommand struct {
+	biomeID string
+	path    string
+}
+
+func newDiffCommand() *cobra.Command {
+	c := new(diffCommand)
+	cmd := &cobra.Command{
+		Use:                   "diff [options] PATH",
+		DisableFlagsInUseLine: true,
+		Short:                 "compare a file in the working directory with its copy in the biome",
+		Args:                  cobra.ExactArgs(1),
+		SilenceErrors:         true,
+		SilenceUsage:          true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c.path = args[0]
+			return c.run(cmd.Context())
+		},
+	}
+	addBiomeFlag(cmd, &c.biomeID, "biome to compare with")
+	return cmd
+}
+
+func (c *diffCommand) run(ctx context.Context) error {
+	var rec *biomeRecord
+	err := func() (err error) {
+		db, err := openDB(ctx)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		endFn, err := sqlitex.ImmediateTransaction(db)
+		if err != nil {
+			return err
+		}
+		defer endFn(&err)
+		rec, err = findBiome(db, c.biomeID)
+		return err
+	}()
+	if err != nil {
+		return err
+	}
+	if rec.mounted {
+		log.Infof(ctx, "Biome %s is mounted at %s; files are always the same", rec.id, rec.rootHostDir)
+		return nil
+	}
+
+	absPath, err := filepath.Abs(c.path)
+	if err != nil {
+		return err
+	}
+	relPath, err := filepath.Rel(rec.rootHostDir, absPath)
+	if err != nil {
+		return err
+	}
+	if !isSubFilepath(relPath) {
+		return fmt.Errorf("%s: not inside %s", c.path, rec.rootHostDir)
+	}
+
+	// Don't use rec.setup: it would copy the host file into the biome.
+	bio := rec.local()
+	hostData, err := os.ReadFile(absPath)
+	if errors.Is(err, os.ErrNotExist) {
+		hostData = nil
+	} else if err != nil {
+		return err
+	}
+	biomeData, err := readBiomeFile(ctx, bio, biome.FromSlash(bio.Describe(), filepath.ToSlash(relPath)))
+	if errors.Is(err, biome.ErrNotFound) {
+		if hostData == nil {
+			return fmt.Errorf("%s: not found on host or in biome %s", c.path, rec.id)
+		}
+		biomeData = nil
+	} else if err != nil {
+		return err
+	}
+	return writeFileDiff(ctx, os.Stdout, filepath.ToSlash(relPath), hostData, biomeData)
+}
+
+// readBiomeFile reads the entire content of a file in the biome.
+func readBiomeFile(ctx context.Context, bio biome.Biome, path string) ([]byte, error) {
+	rc, err := biome.OpenFile(ctx, bio, path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return nil, fmt.Errorf("read %s from biome: %w", path, err)
+	}
+	return data, nil
+}
+
+// writeFileDiff writes a unified diff from the host's copy of a file
+// to the biome's copy. A nil slice indicates that the file does not exist
+// on that side. Binary files are only reported as differing.
+// writeFileDiff writes nothing if both files exist and have the same content.
+func writeFileDiff(ctx context.Context, w io.Writer, name string, hostData, biomeData []byte) error {
+	// Compare existence first: bytes.Equal treats nil and empty as equal.
+	if (hostData == nil) == (biomeData == nil) && bytes.Equal(hostData, biomeData) {
+		return nil
+	}
+	hostLabel, biomeLabel := "host/"+name, "biome/"+name
+	if hostData == nil {
+		hostLabel = os.DevNull
+	}
+	if biomeData == nil {
+		biomeLabel = os.DevNull
+	}
+	if len(hostData) == 0 && len(biomeData) == 0 {
+		// One side is an empty file and the other does not exist.
+		// diff would consider them identical.
+		_, err := fmt.Fprintf(w, "Files %s and %s differ\n", hostLabel, biomeLabel)
+		return err
+	}
+	if isBinary(hostData) || isBinary(biomeData) {
+		_, err := fmt.Fprintf(w, "Binary files %s and %s differ\n", hostLabel, biomeLabel)
+		return err
+	}
+
+	dir, err := os.MkdirTemp("", "zombiezen-biome-diff-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			log.Warnf(ctx, "Clean up diff files: %v", err)
+		}
+	}()
+	hostPath := filepath.Join(dir, "host")
+	if err := os.WriteFile(hostPath, hostData, 0o600); err != nil {
+		return err
+	}
+	biomePath := filepath.Join(dir, "biome")
+	if err := os.WriteFile(biomePath, biomeData, 0o600); err != nil {
+		return err
+	}
+	diffCmd := exec.CommandContext(ctx, "diff", "-u", "--label", hostLabel, "--label", biomeLabel, hostPath, biomePath)
+	diffCmd.Stdout = w
+	diffCmd.Stderr = os.Stderr
+	err = diffCmd.Run()
+	if exitErr := (*exec.ExitError)(nil); errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		// diff exits 1 when the files differ.
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("diff %s: %w", name, err)
+	}
+	return nil
+}
+
+// isBinary reports whether data appears to be binary content
+// rather than text. Like Git, it looks for a NUL byte near the beginning.
+func isBinary(data []byte) bool {
+	const sniffLen = 8000
+	if len(data) > sniffLen {
+		data = data[:sniffLen]
+	}
+	return bytes.IndexByte(data, 0) != -1
+}
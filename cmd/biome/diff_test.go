@@ -0,0 +1,92 @@
+// Copyright 2021 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		 https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestWriteFileDiff(t *testing.T) {
+	if _, err := exec.LookPath("diff"); err != nil {
+		t.Skip("Cannot find diff:", err)
+	}
+	tests := []struct {
+		name      string
+		hostData  []byte
+		biomeData []byte
+		want      string
+	}{
+		{
+			name:      "Same",
+			hostData:  []byte("Hello\n"),
+			biomeData: []byte("Hello\n"),
+			want:      "",
+		},
+		{
+			name:      "Changed",
+			hostData:  []byte("Hello\nWorld\n"),
+			biomeData: []byte("Hello\nBiome\n"),
+			want: "--- host/foo.txt\n" +
+				"+++ biome/foo.txt\n" +
+				"@@ -1,2 +1,2 @@\n" +
+				" Hello\n" +
+				"-World\n" +
+				"+Biome\n",
+		},
+		{
+			name:      "OnlyOnHost",
+			hostData:  []byte("Hello\n"),
+			biomeData: nil,
+			want: "--- host/foo.txt\n" +
+				"+++ /dev/null\n" +
+				"@@ -1 +0,0 @@\n" +
+				"-Hello\n",
+		},
+		{
+			name:      "EmptyOnlyInBiome",
+			hostData:  nil,
+			biomeData: []byte{},
+			want:      "Files /dev/null and biome/foo.txt differ\n",
+		},
+		{
+			name:      "BothEmpty",
+			hostData:  []byte{},
+			biomeData: []byte{},
+			want:      "",
+		},
+		{
+			name:      "Binary",
+			hostData:  []byte("Hello\x00\n"),
+			biomeData: []byte("Hello\n"),
+			want:      "Binary files host/foo.txt and biome/foo.txt differ\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := new(strings.Builder)
+			if err := writeFileDiff(context.Background(), got, "foo.txt", test.hostData, test.biomeData); err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != test.want {
+				t.Errorf("output:\n%s\nwant:\n%s", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,349 @@
+// Copyright 2021 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		 https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"zombiezen.com/go/biome"
+	"zombiezen.com/go/log"
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+// exportMetadataName is the name of the metadata file in an exported archive.
+// The rest of the archive is the content of the biome's support directory.
+const exportMetadataName = "biome.json"
+
+// supportRootPlaceholder stands in for the biome's support directory
+// in exported environment variables so that the archive is relocatable.
+const supportRootPlaceholder = "${BIOME_SUPPORT_ROOT}"
+
+// exportMetadata is the JSON-encoded description of an exported biome.
+type exportMetadata struct {
+	CreatedAt time.Time `json:"created_at"`
+	// RootHostDir is the biome's root directory on the machine it was
+	// exported from. It is informational: import uses its own root directory.
+	RootHostDir string            `json:"root_host_dir"`
+	Mounted     bool              `json:"mounted"`
+	Env         exportEnvironment `json:"env"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Installs    []exportInstall   `json:"installs,omitempty"`
+	// Platform is the biome's "OS/ARCH" if it was created with --platform.
+	Platform string `json:"platform,omitempty"`
+	// Versions maps tool names to the versions recorded by install scripts.
+	Versions map[string]string `json:"versions,omitempty"`
+	// UseGitignore is true if the biome was created with --use-gitignore.
+	UseGitignore bool `json:"use_gitignore,omitempty"`
+}
+
+// exportEnvironment is the JSON form of a biome.Environment.
+// Paths inside the biome's support directory start with supportRootPlaceholder.
+type exportEnvironment struct {
+	Vars map[string]string `json:"vars,omitempty"`
+	// ExpandVars lists the variables whose values refer to other variables.
+	ExpandVars  []string `json:"expand_vars,omitempty"`
+	PrependPath []string `json:"prepend_path,omitempty"`
+	AppendPath  []string `json:"append_path,omitempty"`
+}
+
+type exportInstall struct {
+	Script      string    `json:"script"`
+	Version     string    `json:"version"`
+	InstalledAt time.Time `json:"installed_at"`
+}
+
+type exportCommand struct {
+	biomeID string
+	dst     string
+}
+
+func newExportCommand() *cobra.Command {
+	c := new(exportCommand)
+	cmd := &cobra.Command{
+		Use:                   "export [options] FILE.tar.gz",
+		DisableFlagsInUseLine: true,
+		Short:                 "save a biome to an archive",
+		Long: "Save a biome's files, environment, tags, tool versions, and install history to an archive " +
+			"that can be loaded with biome import, possibly on another machine.",
+		Args:          cobra.ExactArgs(1),
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c.dst = args[0]
+			return c.run(cmd.Context())
+		},
+	}
+	addBiomeFlag(cmd, &c.biomeID, "biome to export")
+	return cmd
+}
+
+func (c *exportCommand) run(ctx context.Context) (err error) {
+	if !strings.HasSuffix(c.dst, ".tar.gz") {
+		return fmt.Errorf("export: %s must end in .tar.gz", c.dst)
+	}
+	db, err := openDB(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	endFn, err := sqlitex.ImmediateTransaction(db)
+	if err != nil {
+		return fmt.Errorf("export: %v", err)
+	}
+	defer endFn(&err)
+	rec, err := findBiome(db, c.biomeID)
+	if err != nil {
+		return fmt.Errorf("export: %v", err)
+	}
+	meta, err := readExportMetadata(db, rec)
+	if err != nil {
+		return fmt.Errorf("export %s: %v", rec.id, err)
+	}
+
+	f, err := os.Create(c.dst)
+	if err != nil {
+		return fmt.Errorf("export %s: %v", rec.id, err)
+	}
+	err = writeBiomeArchive(ctx, f, meta, rec.supportRoot)
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(c.dst)
+		return fmt.Errorf("export %s: %v", rec.id, err)
+	}
+	return nil
+}
+
+// readExportMetadata reads the database records for the biome.
+func readExportMetadata(conn *sqlite.Conn, rec *biomeRecord) (*exportMetadata, error) {
+	meta := &exportMetadata{
+		RootHostDir:  rec.rootHostDir,
+		Mounted:      rec.mounted,
+		UseGitignore: rec.useGitignore,
+	}
+	if rec.platform != nil {
+		meta.Platform = formatPlatform(rec.platform)
+	}
+	env := rebaseEnvironment(rec.env, rec.supportRoot, supportRootPlaceholder)
+	meta.Env = exportEnvironment{
+		Vars:        env.Vars,
+		ExpandVars:  sortedExpandVars(env),
+		PrependPath: env.PrependPath,
+		AppendPath:  env.AppendPath,
+	}
+	err := sqlitex.Exec(conn, `select "created_at" from "biomes" where "id" = ?;`, func(stmt *sqlite.Stmt) error {
+		var err error
+		meta.CreatedAt, err = time.Parse(sqliteTimestampFormatMillis, stmt.ColumnText(0))
+		return err
+	}, rec.id)
+	if err != nil {
+		return nil, err
+	}
+	err = sqlitex.Exec(conn, `select "key", "value" from "biome_tags" where "biome_id" = ? order by "key";`, func(stmt *sqlite.Stmt) error {
+		if meta.Tags == nil {
+			meta.Tags = make(map[string]string)
+		}
+		meta.Tags[stmt.ColumnText(0)] = stmt.ColumnText(1)
+		return nil
+	}, rec.id)
+	if err != nil {
+		return nil, err
+	}
+	const installsQuery = `select "script_path", "version", "installed_at" from "biome_installs" ` +
+		`where "biome_id" = ? order by "installed_at", "script_path", "version";`
+	err = sqlitex.Exec(conn, installsQuery, func(stmt *sqlite.Stmt) error {
+		installedAt, err := time.Parse(sqliteTimestampFormatMillis, stmt.ColumnText(2))
+		if err != nil {
+			return err
+		}
+		meta.Installs = append(meta.Installs, exportInstall{
+			Script:      stmt.ColumnText(0),
+			Version:     stmt.ColumnText(1),
+			InstalledAt: installedAt,
+		})
+		return nil
+	}, rec.id)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := readToolVersions(conn, rec.id)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) > 0 {
+		meta.Versions = versions
+	}
+	return meta, nil
+}
+
+// writeBiomeArchive writes a gzip-compressed tar archive to w
+// containing the metadata followed by the contents of the root directory
+// (except for snapshots).
+// Symbolic links that point inside root are made relative;
+// other symbolic links and special files are skipped.
+func writeBiomeArchive(ctx context.Context, w io.Writer, meta *exportMetadata, root string) error {
+	metaData, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(w)
+	tw := tar.NewWriter(zw)
+	err = tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     exportMetadataName,
+		Mode:     0o644,
+		Size:     int64(len(metaData)),
+		ModTime:  time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := tw.Write(metaData); err != nil {
+		return err
+	}
+
+	err = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == root {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if name == exportMetadataName || name == installLockName {
+			return nil
+		}
+		if name == snapshotsDirName {
+			return fs.SkipDir
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		link := ""
+		switch {
+		case info.Mode().IsRegular() || info.IsDir():
+		case info.Mode()&fs.ModeSymlink != 0:
+			link, err = os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			target := link
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(filepath.Dir(path), target)
+			}
+			if !isSubpath(root, target) {
+				log.Warnf(ctx, "Skipping %s: symlink refers to %s, which is outside the biome", name, link)
+				return nil
+			}
+			if filepath.IsAbs(link) {
+				link, err = filepath.Rel(filepath.Dir(path), link)
+				if err != nil {
+					return err
+				}
+			}
+		default:
+			log.Warnf(ctx, "Skipping %s: unsupported file type", name)
+			return nil
+		}
+		hdr, err := tar.FileInfoHeader(info, filepath.ToSlash(link))
+		if err != nil {
+			return err
+		}
+		hdr.Name = name
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		hdr.Uname, hdr.Gname = "", ""
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, f)
+		f.Close()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// isSubpath reports whether path is root or inside root.
+func isSubpath(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && !isParentRel(rel)
+}
+
+// relocate converts an exported environment to one that refers
+// to the given support root.
+func (env exportEnvironment) relocate(supportRoot string) biome.Environment {
+	e := biome.Environment{
+		Vars:        env.Vars,
+		PrependPath: env.PrependPath,
+		AppendPath:  env.AppendPath,
+	}
+	for _, k := range env.ExpandVars {
+		if e.ExpandVars == nil {
+			e.ExpandVars = make(map[string]bool)
+		}
+		e.ExpandVars[k] = true
+	}
+	return rebaseEnvironment(e, supportRootPlaceholder, supportRoot)
+}
+
+// sortedExpandVars returns the names of the variables in env.ExpandVars
+// in sorted order.
+func sortedExpandVars(env biome.Environment) []string {
+	var names []string
+	for k, expand := range env.ExpandVars {
+		if expand {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,128 @@
+// Copyright 2021 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		 https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"zombiezen.com/go/biome"
+	"zombiezen.com/go/biome/internal/extract"
+	"zombiezen.com/go/log/testlog"
+)
+
+func TestBiomeArchiveRoundTrip(t *testing.T) {
+	ctx := testlog.WithTB(context.Background(), t)
+	src := t.TempDir()
+	writeFiles := map[string]string{
+		"home/.profile":           "export FOO=1\n",
+		"work/main.go":            "package main\n",
+		"home/.cache/tools/a/bin": "#!/bin/sh\n",
+	}
+	for name, content := range writeFiles {
+		path := filepath.Join(src, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// An absolute symlink inside the biome should be made relative.
+	if err := os.Symlink(filepath.Join(src, "work", "main.go"), filepath.Join(src, "home", "main.go")); err != nil {
+		t.Fatal(err)
+	}
+	// A symlink outside the biome should be skipped.
+	if err := os.Symlink(os.TempDir(), filepath.Join(src, "home", "tmp")); err != nil {
+		t.Fatal(err)
+	}
+
+	meta := &exportMetadata{
+		CreatedAt:   time.Date(2021, time.December, 1, 12, 0, 0, 0, time.UTC),
+		RootHostDir: "/src/project",
+		Env: exportEnvironment{
+			Vars:        map[string]string{"GOROOT": supportRootPlaceholder + "/home/.cache/tools/go"},
+			PrependPath: []string{supportRootPlaceholder + "/home/.cache/tools/go/bin"},
+		},
+		Tags: map[string]string{"env": "dev"},
+	}
+	buf := new(bytes.Buffer)
+	if err := writeBiomeArchive(ctx, buf, meta, src); err != nil {
+		t.Fatal("writeBiomeArchive:", err)
+	}
+
+	dst := t.TempDir()
+	if err := extract.TarFile(bytes.NewReader(buf.Bytes()), ".tar.gz", dst); err != nil {
+		t.Fatal("extract:", err)
+	}
+	metaData, err := os.ReadFile(filepath.Join(dst, exportMetadataName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotMeta := new(exportMetadata)
+	if err := json.Unmarshal(metaData, gotMeta); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(meta, gotMeta); diff != "" {
+		t.Errorf("metadata (-want +got):\n%s", diff)
+	}
+	for name, want := range writeFiles {
+		got, err := os.ReadFile(filepath.Join(dst, filepath.FromSlash(name)))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if string(got) != want {
+			t.Errorf("%s content = %q; want %q", name, got, want)
+		}
+	}
+	link, err := os.Readlink(filepath.Join(dst, "home", "main.go"))
+	if err != nil {
+		t.Error(err)
+	} else if want := filepath.Join("..", "work", "main.go"); link != want {
+		t.Errorf("home/main.go -> %q; want %q", link, want)
+	}
+	if _, err := os.Lstat(filepath.Join(dst, "home", "tmp")); err == nil {
+		t.Error("home/tmp symlink outside biome was exported")
+	}
+}
+
+func TestExportEnvironmentRelocate(t *testing.T) {
+	const supportRoot = "/cache/biomes/ab/cdef"
+	env := exportEnvironment{
+		Vars:        map[string]string{"GOROOT": supportRootPlaceholder + "/home/go", "FOO": "bar", "GOBIN": "$GOROOT/bin"},
+		ExpandVars:  []string{"GOBIN"},
+		PrependPath: []string{supportRootPlaceholder + "/home/go/bin"},
+		AppendPath:  []string{"/usr/local/bin"},
+	}
+	got := env.relocate(supportRoot)
+	want := biome.Environment{
+		Vars:        map[string]string{"GOROOT": supportRoot + "/home/go", "FOO": "bar", "GOBIN": "$GOROOT/bin"},
+		ExpandVars:  map[string]bool{"GOBIN": true},
+		PrependPath: []string{supportRoot + "/home/go/bin"},
+		AppendPath:  []string{"/usr/local/bin"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("relocate(%q) (-want +got):\n%s", supportRoot, diff)
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2021 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		 https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/cobra/doc"
+)
+
+func newGenManCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:                   "gen-man OUTDIR",
+		DisableFlagsInUseLine: true,
+		Short:                 "generate man pages for biome and its subcommands",
+		Args:                  cobra.ExactArgs(1),
+		Hidden:                true,
+		SilenceErrors:         true,
+		SilenceUsage:          true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			outDir := args[0]
+			if err := os.MkdirAll(outDir, 0o777); err != nil {
+				return fmt.Errorf("gen-man: %v", err)
+			}
+			root := cmd.Root()
+			root.DisableAutoGenTag = true
+			header := &doc.GenManHeader{
+				Title:   "BIOME",
+				Section: "1",
+				Source:  "biome",
+				Manual:  "biome Manual",
+			}
+			if err := doc.GenManTree(root, header, outDir); err != nil {
+				return fmt.Errorf("gen-man: %v", err)
+			}
+			return nil
+		},
+	}
+}
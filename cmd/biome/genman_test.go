@@ -0,0 +1,47 @@
+// Copyright 2021 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		 https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenMan(t *testing.T) {
+	outDir := filepath.Join(t.TempDir(), "man")
+	root := newRootCommand()
+	root.SetArgs([]string{"gen-man", outDir})
+	if err := root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"biome.1", "biome-run.1", "biome-snapshot-create.1"} {
+		got, err := os.ReadFile(filepath.Join(outDir, name))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if !strings.HasPrefix(string(got), ".nh\n.TH \"BIOME\" \"1\"") {
+			t.Errorf("%s does not start with a man page header:\n%s", name, got)
+		}
+	}
+	// Hidden commands like gen-man itself are not documented.
+	if _, err := os.Stat(filepath.Join(outDir, "biome-gen-man.1")); err == nil {
+		t.Error("biome-gen-man.1 was generated")
+	}
+}
@@ -0,0 +1,271 @@
+// Copyright 2021 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		 https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go4.org/xdgdir"
+	"zombiezen.com/go/biome/internal/gitglob"
+	"zombiezen.com/go/log"
+)
+
+// readGitExcludes returns the patterns that Git applies to rootDir
+// from outside the directory: the user's core.excludesFile,
+// the repository's info/exclude file, and any .gitignore files in
+// rootDir's parent directories inside the repository, in order of increasing
+// precedence. readGitExcludes returns nil if rootDir is not inside
+// a Git repository. Files that can't be read are skipped with a warning.
+func readGitExcludes(ctx context.Context, rootDir string) []gitglob.Pattern {
+	workTree, gitDir := findGitDir(rootDir)
+	if gitDir == "" {
+		return nil
+	}
+	prefix, err := filepath.Rel(workTree, rootDir)
+	if err != nil {
+		log.Warnf(ctx, "Skipping Git excludes: %v", err)
+		return nil
+	}
+	prefix = filepath.ToSlash(prefix)
+	if prefix == "." {
+		prefix = ""
+	}
+
+	var patterns []gitglob.Pattern
+	readFile := func(path, dir string) {
+		data, err := os.ReadFile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return
+		}
+		if err != nil {
+			log.Warnf(ctx, "Skipping Git excludes: %v", err)
+			return
+		}
+		for _, line := range bytes.Split(data, []byte("\n")) {
+			pat := parseGitPatternFor(dir, prefix, string(line))
+			if pat.IsValid() {
+				patterns = append(patterns, pat)
+			}
+		}
+	}
+	if path := gitExcludesFile(gitDir); path != "" {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(workTree, path)
+		}
+		readFile(path, "")
+	}
+	readFile(filepath.Join(gitDir, "info", "exclude"), "")
+	if prefix != "" {
+		parts := strings.Split(prefix, "/")
+		for i := range parts {
+			dir := strings.Join(parts[:i], "/")
+			readFile(filepath.Join(workTree, filepath.FromSlash(dir), gitignoreFileName), dir)
+		}
+	}
+	return patterns
+}
+
+// parseGitPatternFor parses a line from a Git ignore file whose patterns
+// are relative to the slash-separated directory dir of the work tree
+// (or the top of the work tree if dir is empty) and returns an equivalent
+// pattern relative to the work tree directory prefix, which must be inside dir.
+// Patterns that can only match outside prefix and anchored patterns
+// that use wildcards to match prefix's directories are skipped.
+func parseGitPatternFor(dir, prefix, line string) gitglob.Pattern {
+	if dir != "" {
+		prefix = strings.TrimPrefix(prefix, dir+"/")
+	}
+	if prefix == "" {
+		return gitglob.ParseLine(line)
+	}
+	if !gitglob.ParseLine(line).IsValid() {
+		return gitglob.Pattern{}
+	}
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	negate := ""
+	if strings.HasPrefix(line, "!") {
+		negate = "!"
+		line = line[1:]
+	}
+	body := strings.TrimPrefix(line, "/")
+	anchored := strings.HasPrefix(line, "/") ||
+		(!strings.HasPrefix(line, "**/") && strings.Contains(strings.TrimSuffix(line, "/"), "/"))
+	if !anchored {
+		// Matches at any depth, so it applies the same inside prefix.
+		return gitglob.ParseLine(negate + line)
+	}
+	if !strings.HasPrefix(body, prefix+"/") {
+		return gitglob.Pattern{}
+	}
+	return gitglob.ParseLine(negate + "/" + body[len(prefix)+1:])
+}
+
+// findGitDir finds the Git repository containing dir. It returns the top
+// directory of the work tree and the repository's common directory,
+// or empty strings if dir is not inside a Git repository.
+func findGitDir(dir string) (workTree, gitDir string) {
+	for {
+		dotGit := filepath.Join(dir, ".git")
+		if info, err := os.Stat(dotGit); err == nil {
+			gitDir = dotGit
+			if !info.IsDir() {
+				// Work trees and submodules have a file that points to the
+				// repository.
+				gitDir = readGitDirFile(dotGit)
+			}
+			if gitDir != "" {
+				return dir, commonGitDir(gitDir)
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", ""
+		}
+		dir = parent
+	}
+}
+
+// readGitDirFile returns the path in a ".git" file of the form "gitdir: PATH"
+// or the empty string if the file can't be read.
+func readGitDirFile(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	const gitDirPrefix = "gitdir:"
+	line := strings.TrimSpace(string(data))
+	if !strings.HasPrefix(line, gitDirPrefix) {
+		return ""
+	}
+	gitDir := filepath.FromSlash(strings.TrimSpace(line[len(gitDirPrefix):]))
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(filepath.Dir(path), gitDir)
+	}
+	return gitDir
+}
+
+// commonGitDir returns the directory that holds the repository's
+// configuration and info files, which for a linked work tree is not the
+// work tree's own Git directory.
+func commonGitDir(gitDir string) string {
+	data, err := os.ReadFile(filepath.Join(gitDir, "commondir"))
+	if err != nil {
+		return gitDir
+	}
+	common := filepath.FromSlash(strings.TrimSpace(string(data)))
+	if !filepath.IsAbs(common) {
+		common = filepath.Join(gitDir, common)
+	}
+	return common
+}
+
+// gitExcludesFile returns the path of the user's global Git ignore file:
+// the core.excludesFile setting from the user's or the repository's Git
+// configuration, or Git's default location if it's not set.
+func gitExcludesFile(gitDir string) string {
+	var configFiles []string
+	if dir := xdgdir.Config.Path(); dir != "" {
+		configFiles = append(configFiles, filepath.Join(dir, "git", "config"))
+	}
+	home, _ := os.UserHomeDir()
+	if home != "" {
+		configFiles = append(configFiles, filepath.Join(home, ".gitconfig"))
+	}
+	configFiles = append(configFiles, filepath.Join(gitDir, "config"))
+	path := ""
+	for _, configFile := range configFiles {
+		if value, ok := readGitConfigValue(configFile, "core", "excludesfile"); ok {
+			path = value
+		}
+	}
+	if path == "" {
+		if dir := xdgdir.Config.Path(); dir != "" {
+			return filepath.Join(dir, "git", "ignore")
+		}
+		return ""
+	}
+	if strings.HasPrefix(path, "~/") && home != "" {
+		path = filepath.Join(home, path[2:])
+	}
+	return filepath.FromSlash(path)
+}
+
+// readGitConfigValue returns the last value of section.key in the Git
+// configuration file at path. It only understands the basic syntax of
+// Git configuration files: subsections, includes,
+// and escape sequences other than quotes are not supported.
+func readGitConfigValue(path, section, key string) (value string, found bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+	inSection := false
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if strings.HasPrefix(line, "[") {
+			end := strings.IndexByte(line, ']')
+			if end < 0 {
+				inSection = false
+				continue
+			}
+			inSection = strings.EqualFold(strings.TrimSpace(line[1:end]), section)
+			line = strings.TrimSpace(line[end+1:])
+		}
+		if !inSection || line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		eq := strings.IndexByte(line, '=')
+		if eq < 0 || !strings.EqualFold(strings.TrimSpace(line[:eq]), key) {
+			continue
+		}
+		value, found = parseGitConfigValue(strings.TrimSpace(line[eq+1:])), true
+	}
+	return value, found
+}
+
+// parseGitConfigValue removes quotes and trailing comments from a raw
+// Git configuration value.
+func parseGitConfigValue(raw string) string {
+	sb := new(strings.Builder)
+	quoted := false
+	for i := 0; i < len(raw); i++ {
+		c := raw[i]
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == '\\' && i+1 < len(raw):
+			i++
+			sb.WriteByte(raw[i])
+		case !quoted && (c == '#' || c == ';'):
+			return strings.TrimSpace(sb.String())
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return strings.TrimSpace(sb.String())
+}
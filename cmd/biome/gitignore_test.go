@@ -0,0 +1,198 @@
+// Copyright 2021 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		 https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"zombiezen.com/go/biome/internal/gitglob"
+)
+
+func TestParseGitPatternFor(t *testing.T) {
+	tests := []struct {
+		dir          string
+		prefix       string
+		line         string
+		matches      []string
+		doesNotMatch []string
+		invalid      bool
+	}{
+		{
+			prefix:  "",
+			line:    "/build",
+			matches: []string{"build"},
+		},
+		{
+			prefix:       "web",
+			line:         "*.log",
+			matches:      []string{"a.log", "sub/b.log"},
+			doesNotMatch: []string{"a.txt"},
+		},
+		{
+			prefix:       "web",
+			line:         "/web/dist",
+			matches:      []string{"dist"},
+			doesNotMatch: []string{"web/dist", "sub/dist"},
+		},
+		{
+			prefix:  "web",
+			line:    "web/src/gen",
+			matches: []string{"src/gen"},
+		},
+		{
+			prefix:  "web",
+			line:    "/dist",
+			invalid: true,
+		},
+		{
+			prefix:  "web",
+			line:    "*/dist",
+			invalid: true,
+		},
+		{
+			prefix:  "web",
+			line:    "**/dist",
+			matches: []string{"dist", "a/dist"},
+		},
+		{
+			prefix:  "web",
+			line:    "# comment",
+			invalid: true,
+		},
+		{
+			dir:          "a",
+			prefix:       "a/b/c",
+			line:         "/b/c/out",
+			matches:      []string{"out"},
+			doesNotMatch: []string{"b/c/out"},
+		},
+	}
+	for _, test := range tests {
+		pat := parseGitPatternFor(test.dir, test.prefix, test.line)
+		if !pat.IsValid() {
+			if !test.invalid {
+				t.Errorf("parseGitPatternFor(%q, %q, %q) is invalid", test.dir, test.prefix, test.line)
+			}
+			continue
+		}
+		if test.invalid {
+			t.Errorf("parseGitPatternFor(%q, %q, %q) = %q; want invalid", test.dir, test.prefix, test.line, pat)
+			continue
+		}
+		for _, m := range test.matches {
+			if !pat.Match(m, 0) {
+				t.Errorf("parseGitPatternFor(%q, %q, %q).Match(%q, 0) = false; want true", test.dir, test.prefix, test.line, m)
+			}
+		}
+		for _, m := range test.doesNotMatch {
+			if pat.Match(m, 0) {
+				t.Errorf("parseGitPatternFor(%q, %q, %q).Match(%q, 0) = true; want false", test.dir, test.prefix, test.line, m)
+			}
+		}
+	}
+}
+
+func TestReadGitExcludes(t *testing.T) {
+	ctx := context.Background()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	writeFiles := func(files map[string]string) {
+		t.Helper()
+		for path, content := range files {
+			if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(content), 0o666); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	t.Run("NotARepository", func(t *testing.T) {
+		if got := readGitExcludes(ctx, t.TempDir()); len(got) > 0 {
+			t.Errorf("readGitExcludes(...) = %q; want none", got)
+		}
+	})
+
+	t.Run("DefaultExcludesFile", func(t *testing.T) {
+		repo := t.TempDir()
+		writeFiles(map[string]string{
+			filepath.Join(home, ".config", "git", "ignore"): "*.swp\n",
+			filepath.Join(repo, ".git", "info", "exclude"):  "/local\n",
+		})
+		defer os.RemoveAll(filepath.Join(home, ".config"))
+		checkIgnored(t, readGitExcludes(ctx, repo), map[string]bool{
+			"a.swp":     true,
+			"local":     true,
+			"sub/local": false,
+			"a.txt":     false,
+		})
+	})
+
+	t.Run("Subdirectory", func(t *testing.T) {
+		repo := t.TempDir()
+		writeFiles(map[string]string{
+			filepath.Join(home, ".gitconfig"): "[user]\n\tname = Someone\n" +
+				"[Core]\n\texcludesFile = \"~/my ignore\" ; comment\n",
+			filepath.Join(home, "my ignore"):               "*.bak\n",
+			filepath.Join(repo, ".git", "info", "exclude"): "/proj/secret.txt\n/other.txt\n",
+			filepath.Join(repo, ".gitignore"):              "*.log\n!keep.log\n",
+			filepath.Join(repo, "proj", ".gitignore"):      "ignored by bundle\n",
+		})
+		defer os.Remove(filepath.Join(home, ".gitconfig"))
+		checkIgnored(t, readGitExcludes(ctx, filepath.Join(repo, "proj")), map[string]bool{
+			"a.bak":        true,
+			"secret.txt":   true,
+			"other.txt":    false,
+			"a.log":        true,
+			"sub/keep.log": false,
+			"a.txt":        false,
+		})
+	})
+
+	t.Run("WorkTree", func(t *testing.T) {
+		repo := t.TempDir()
+		workTree := t.TempDir()
+		gitDir := filepath.Join(repo, ".git", "worktrees", "wt")
+		writeFiles(map[string]string{
+			filepath.Join(workTree, ".git"):                "gitdir: " + gitDir + "\n",
+			filepath.Join(gitDir, "commondir"):             "../..\n",
+			filepath.Join(repo, ".git", "info", "exclude"): "*.tmp\n",
+			filepath.Join(repo, ".git", "config"):          "[core]\n\texcludesfile = " + filepath.Join(repo, "excludes") + "\n",
+			filepath.Join(repo, "excludes"):                "*.o\n",
+		})
+		checkIgnored(t, readGitExcludes(ctx, workTree), map[string]bool{
+			"a.tmp": true,
+			"a.o":   true,
+			"a.c":   false,
+		})
+	})
+}
+
+func checkIgnored(tb testing.TB, patterns []gitglob.Pattern, want map[string]bool) {
+	tb.Helper()
+	for path, wantIgnored := range want {
+		pat := gitglob.LastMatch(patterns, path, 0)
+		if got := pat != nil && !pat.IsNegated(); got != wantIgnored {
+			tb.Errorf("%s ignored = %t; want %t", path, got, wantIgnored)
+		}
+	}
+}
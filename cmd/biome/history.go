@@ -0,0 +1,210 @@
+// Copyright 2021 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		 https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"zombiezen.com/go/log"
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+type historyCommand struct {
+	biomeID   string
+	clear     bool
+	olderThan string
+}
+
+func newHistoryCommand() *cobra.Command {
+	c := new(historyCommand)
+	cmd := &cobra.Command{
+		Use:                   "history [options]",
+		DisableFlagsInUseLine: true,
+		Short:                 "list commands run in a biome",
+		Args:                  cobra.NoArgs,
+		SilenceErrors:         true,
+		SilenceUsage:          true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.run(cmd.Context())
+		},
+	}
+	addBiomeFlag(cmd, &c.biomeID, "biome whose history to list")
+	cmd.Flags().BoolVar(&c.clear, "clear", false, "delete history entries instead of listing them")
+	cmd.Flags().StringVar(&c.olderThan, "older-than", "", "with --clear, only delete entries older than the given `duration` (e.g. 36h, 30d, 2w)")
+	return cmd
+}
+
+func (c *historyCommand) run(ctx context.Context) (err error) {
+	var age time.Duration
+	if c.olderThan != "" {
+		if !c.clear {
+			return fmt.Errorf("--older-than requires --clear")
+		}
+		var err error
+		age, err = parseAge(c.olderThan)
+		if err != nil {
+			return fmt.Errorf("--older-than: %v", err)
+		}
+	}
+	db, err := openDB(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	rec, err := findBiome(db, c.biomeID)
+	if err != nil {
+		return err
+	}
+	if c.clear {
+		var before time.Time
+		if age > 0 {
+			before = time.Now().Add(-age)
+		}
+		n, err := clearHistory(db, rec.id, before)
+		if err != nil {
+			return err
+		}
+		log.Infof(ctx, "Deleted %d history entries from %s", n, rec.id)
+		return nil
+	}
+	entries, err := readHistory(db, rec.id)
+	if err != nil {
+		return err
+	}
+	for _, ent := range entries {
+		exitCode := "-"
+		if ent.exitCode >= 0 {
+			exitCode = fmt.Sprint(ent.exitCode)
+		}
+		_, err := fmt.Printf("%s\t%s\t%s\t%s\n",
+			ent.startedAt.Local().Format(time.RFC3339), ent.command, exitCode, strings.Join(ent.argv, " "))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// historyEntry is a command recorded in a biome's history.
+type historyEntry struct {
+	startedAt time.Time
+	// command is the biome subcommand: "run", "install", or "uninstall".
+	command string
+	// argv is the program and its arguments for "run"
+	// or the script and version for "install" and "uninstall".
+	argv []string
+	// exitCode is the program's exit code for "run",
+	// or 0 on success and 1 on failure for "install" and "uninstall".
+	// It is -1 if the program did not exit normally,
+	// such as if it could not be started.
+	exitCode int
+}
+
+// runExitCode returns the exit code of a program run in a biome
+// from the error returned by biome.Biome.Run, or -1 if unknown.
+func runExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if exitErr := (*exec.ExitError)(nil); errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// recordHistory adds an entry to a biome's history.
+// Failures are logged instead of returned,
+// since the history is only informational.
+func recordHistory(ctx context.Context, conn *sqlite.Conn, id string, ent *historyEntry) {
+	if err := insertHistory(conn, id, ent); err != nil {
+		log.Warnf(ctx, "%v", err)
+	}
+}
+
+func insertHistory(conn *sqlite.Conn, id string, ent *historyEntry) error {
+	argv, err := json.Marshal(ent.argv)
+	if err != nil {
+		return fmt.Errorf("record history for %s: %v", id, err)
+	}
+	var exitCode interface{}
+	if ent.exitCode >= 0 {
+		exitCode = ent.exitCode
+	}
+	const query = `insert into "biome_history" ("biome_id", "started_at", "command", "argv", "exit_code") ` +
+		`values (?, ?, ?, ?, ?);`
+	err = sqlitex.Exec(conn, query, nil,
+		id, ent.startedAt.UTC().Format(sqliteTimestampFormatMillis), ent.command, string(argv), exitCode)
+	if err != nil {
+		return fmt.Errorf("record history for %s: %v", id, err)
+	}
+	return nil
+}
+
+// readHistory returns a biome's history, oldest first.
+func readHistory(conn *sqlite.Conn, id string) ([]*historyEntry, error) {
+	const query = `select "started_at", "command", "argv", "exit_code" from "biome_history" ` +
+		`where "biome_id" = ? order by "started_at", "id";`
+	var entries []*historyEntry
+	err := sqlitex.Exec(conn, query, func(stmt *sqlite.Stmt) error {
+		ent := &historyEntry{
+			command:  stmt.ColumnText(1),
+			exitCode: -1,
+		}
+		var err error
+		ent.startedAt, err = time.Parse(sqliteTimestampFormatMillis, stmt.ColumnText(0))
+		if err != nil {
+			return fmt.Errorf("started_at: %w", err)
+		}
+		if err := json.Unmarshal([]byte(stmt.ColumnText(2)), &ent.argv); err != nil {
+			return fmt.Errorf("argv: %w", err)
+		}
+		if stmt.ColumnType(3) != sqlite.TypeNull {
+			ent.exitCode = stmt.ColumnInt(3)
+		}
+		entries = append(entries, ent)
+		return nil
+	}, id)
+	if err != nil {
+		return nil, fmt.Errorf("read history for %s: %v", id, err)
+	}
+	return entries, nil
+}
+
+// clearHistory deletes a biome's history entries that started before the
+// given time, or all entries if before is the zero time.
+// It returns the number of entries deleted.
+func clearHistory(conn *sqlite.Conn, id string, before time.Time) (int, error) {
+	var err error
+	if before.IsZero() {
+		err = sqlitex.Exec(conn, `delete from "biome_history" where "biome_id" = ?;`, nil, id)
+	} else {
+		err = sqlitex.Exec(conn, `delete from "biome_history" where "biome_id" = ? and "started_at" < ?;`, nil,
+			id, before.UTC().Format(sqliteTimestampFormatMillis))
+	}
+	if err != nil {
+		return 0, fmt.Errorf("clear history for %s: %v", id, err)
+	}
+	return conn.Changes(), nil
+}
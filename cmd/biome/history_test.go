@@ -0,0 +1,164 @@
+// Copyright 2021 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		 https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+func TestHistory(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	db, err := openDB(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	const id = "abcd"
+	err = sqlitex.Exec(db, `insert into "biomes" ("id", "root_host_dir") values (?, ?);`, nil, id, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base := time.Date(2021, time.November, 1, 12, 0, 0, 0, time.UTC)
+	entries := []*historyEntry{
+		{startedAt: base, command: "install", argv: []string{"go.star", "1.17.3"}, exitCode: 0},
+		{startedAt: base.Add(1 * time.Hour), command: "run", argv: []string{"go", "test", "./..."}, exitCode: 1},
+		{startedAt: base.Add(2 * time.Hour), command: "run", argv: []string{"nonexistent"}, exitCode: -1},
+	}
+	// Insert out of order to check sorting.
+	for _, i := range []int{1, 2, 0} {
+		if err := insertHistory(db, id, entries[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := readHistory(db, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(entries, got, cmp.AllowUnexported(historyEntry{})); diff != "" {
+		t.Errorf("readHistory(...) (-want +got):\n%s", diff)
+	}
+
+	n, err := clearHistory(db, id, base.Add(90*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("clearHistory(..., base+90m) = %d, <nil>; want 2, <nil>", n)
+	}
+	got, err = readHistory(db, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(entries[2:], got, cmp.AllowUnexported(historyEntry{})); diff != "" {
+		t.Errorf("readHistory(...) after clearing old entries (-want +got):\n%s", diff)
+	}
+
+	n, err = clearHistory(db, id, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("clearHistory(..., time.Time{}) = %d, <nil>; want 1, <nil>", n)
+	}
+	got, err = readHistory(db, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) > 0 {
+		t.Errorf("readHistory(...) after clearing = %d entries; want none", len(got))
+	}
+}
+
+func TestHistoryClearOlderThan(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	db, err := openDB(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	const id = "abcd"
+	err = sqlitex.Exec(db, `insert into "biomes" ("id", "root_host_dir") values (?, ?);`, nil, id, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	entries := []*historyEntry{
+		{startedAt: now.Add(-40 * 24 * time.Hour), command: "run", argv: []string{"old"}},
+		{startedAt: now.Add(-10 * 24 * time.Hour), command: "run", argv: []string{"new"}},
+	}
+	for _, ent := range entries {
+		if err := insertHistory(db, id, ent); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, c := range []*historyCommand{
+		{biomeID: id, olderThan: "30d"},
+		{biomeID: id, clear: true, olderThan: "30x"},
+	} {
+		if err := c.run(ctx); err == nil {
+			t.Errorf("history --clear=%t --older-than=%s did not return an error", c.clear, c.olderThan)
+		}
+	}
+	c := &historyCommand{biomeID: id, clear: true, olderThan: "30d"}
+	if err := c.run(ctx); err != nil {
+		t.Fatal("history --clear --older-than=30d:", err)
+	}
+	got, err := readHistory(db, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].argv[0] != "new" {
+		t.Errorf("history after --clear --older-than=30d = %+v; want only the entry from 10 days ago", got)
+	}
+}
+
+func TestRunExitCode(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("Cannot find sh:", err)
+	}
+	exitErr := exec.Command("sh", "-c", "exit 3").Run()
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "Success", err: nil, want: 0},
+		{name: "ExitError", err: exitErr, want: 3},
+		{name: "WrappedExitError", err: fmt.Errorf("local run: %w", exitErr), want: 3},
+		{name: "OtherError", err: errors.New("bork"), want: -1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := runExitCode(test.err); got != test.want {
+				t.Errorf("runExitCode(%v) = %d; want %d", test.err, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2021 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		 https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"zombiezen.com/go/biome/internal/extract"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+type importCommand struct {
+	src     string
+	rootDir string
+}
+
+func newImportCommand() *cobra.Command {
+	c := new(importCommand)
+	cmd := &cobra.Command{
+		Use:                   "import [options] FILE.tar.gz",
+		DisableFlagsInUseLine: true,
+		Short:                 "create a biome from an archive made by biome export",
+		Args:                  cobra.ExactArgs(1),
+		SilenceErrors:         true,
+		SilenceUsage:          true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c.src = args[0]
+			return c.run(cmd.Context())
+		},
+	}
+	cmd.Flags().StringVar(&c.rootDir, "root", ".", "root of the directory to associate with the biome")
+	return cmd
+}
+
+func (c *importCommand) run(ctx context.Context) (err error) {
+	if !strings.HasSuffix(c.src, ".tar.gz") {
+		return fmt.Errorf("import: %s must end in .tar.gz", c.src)
+	}
+	rootDir, err := filepath.Abs(c.rootDir)
+	if err != nil {
+		return fmt.Errorf("import: %v", err)
+	}
+	f, err := os.Open(c.src)
+	if err != nil {
+		return fmt.Errorf("import: %v", err)
+	}
+	defer f.Close()
+	db, err := openDB(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	id, err := genHexDigits(16)
+	if err != nil {
+		return fmt.Errorf("import: %v", err)
+	}
+	supportRoot, err := computeSupportRoot(id)
+	if err != nil {
+		return fmt.Errorf("import: %v", err)
+	}
+	if err := os.MkdirAll(supportRoot, 0o777); err != nil {
+		return fmt.Errorf("import: %v", err)
+	}
+	defer func() {
+		if err != nil {
+			if rmErr := removeAll(ctx, supportRoot); rmErr != nil {
+				err = fmt.Errorf("%w (and failed to clean up: %v)", err, rmErr)
+			}
+		}
+	}()
+	if err := extract.TarFile(f, ".tar.gz", supportRoot); err != nil {
+		return fmt.Errorf("import %s: %v", c.src, err)
+	}
+	metaPath := filepath.Join(supportRoot, exportMetadataName)
+	metaData, err := os.ReadFile(metaPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("import %s: missing %s; not created by biome export?", c.src, exportMetadataName)
+	}
+	if err != nil {
+		return fmt.Errorf("import %s: %v", c.src, err)
+	}
+	meta := new(exportMetadata)
+	if err := json.Unmarshal(metaData, meta); err != nil {
+		return fmt.Errorf("import %s: %s: %v", c.src, exportMetadataName, err)
+	}
+	if err := os.Remove(metaPath); err != nil {
+		return fmt.Errorf("import %s: %v", c.src, err)
+	}
+	var platformArg interface{} // NULL in the database if the biome uses the host's platform
+	if meta.Platform != "" {
+		platform, err := parsePlatform(meta.Platform)
+		if err != nil {
+			return fmt.Errorf("import %s: %v", c.src, err)
+		}
+		if err := checkPlatform(platform); err != nil {
+			return fmt.Errorf("import %s: %v", c.src, err)
+		}
+		platformArg = formatPlatform(platform)
+	}
+
+	endFn, err := sqlitex.ImmediateTransaction(db)
+	if err != nil {
+		return fmt.Errorf("import %s: %v", c.src, err)
+	}
+	defer endFn(&err)
+	createdAt := meta.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+	err = sqlitex.Exec(db, `insert into "biomes" ("id", "created_at", "root_host_dir", "mounted", "platform", "use_gitignore") values (?, ?, ?, ?, ?, ?);`, nil,
+		id, createdAt.UTC().Format(sqliteTimestampFormatMillis), rootDir, meta.Mounted, platformArg, meta.UseGitignore)
+	if err != nil {
+		return fmt.Errorf("import %s: %v", c.src, err)
+	}
+	if err := setTags(db, id, meta.Tags); err != nil {
+		return fmt.Errorf("import %s: %v", c.src, err)
+	}
+	if err := writeBiomeEnvironment(db, id, meta.Env.relocate(supportRoot)); err != nil {
+		return fmt.Errorf("import %s: %v", c.src, err)
+	}
+	for _, inst := range meta.Installs {
+		if err := recordInstall(db, id, inst.Script, inst.Version, inst.InstalledAt); err != nil {
+			return fmt.Errorf("import %s: %v", c.src, err)
+		}
+	}
+	if err := writeToolVersions(db, id, meta.Versions); err != nil {
+		return fmt.Errorf("import %s: %v", c.src, err)
+	}
+	fmt.Println(id)
+	return nil
+}
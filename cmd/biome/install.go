@@ -19,23 +19,39 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"go.starlark.net/starlark"
 	"go4.org/xdgdir"
+	"golang.org/x/term"
 	"zombiezen.com/go/biome"
 	"zombiezen.com/go/biome/downloader"
 	"zombiezen.com/go/biome/internal/extract"
+	"zombiezen.com/go/log"
+	"zombiezen.com/go/sqlite"
 	"zombiezen.com/go/sqlite/sqlitex"
 )
 
 type installCommand struct {
-	biomeID string
-	script  string
-	version string
+	biomeID      string
+	script       string
+	version      string
+	versionCheck bool
+	dryRun       bool
+	force        bool
+	uninstall    bool
+	settings     []string
+
+	// downloads is an optional log of the archives the script downloads.
+	downloads *downloadLog
 }
 
 func newInstallCommand() *cobra.Command {
@@ -53,7 +69,12 @@ func newInstallCommand() *cobra.Command {
 			return c.run(cmd.Context())
 		},
 	}
-	cmd.Flags().StringVarP(&c.biomeID, "biome", "b", "", "biome to run inside")
+	addBiomeFlag(cmd, &c.biomeID, "biome to install into")
+	cmd.Flags().BoolVarP(&c.dryRun, "dry-run", "n", false, "print the commands and downloads the script would perform without running them")
+	cmd.Flags().BoolVarP(&c.force, "force", "f", false, "install even if the biome already has this version installed from the same script")
+	cmd.Flags().BoolVar(&c.versionCheck, "version-check", false, "skip installing if the script's current_version function reports the requested version")
+	cmd.Flags().StringArrayVar(&c.settings, "set", nil, "pass a `KEY=VALUE` keyword argument to the script's install function (can be repeated)")
+	cmd.Flags().BoolVar(&c.uninstall, "uninstall", false, "call the script's uninstall function instead of install")
 	return cmd
 }
 
@@ -63,21 +84,96 @@ func (c *installCommand) run(ctx context.Context) (err error) {
 		return err
 	}
 	defer db.Close()
-	endFn, err := sqlitex.ImmediateTransaction(db)
+	startedAt := time.Now()
+	// The lock is taken before the install transaction
+	// so that the environment is read only once any other install finishes.
+	rec, err := findBiome(db, c.biomeID)
 	if err != nil {
 		return err
 	}
-	defer endFn(&err)
-	rec, err := findBiome(db, c.biomeID)
+	unlock, err := lockInstall(ctx, rec.supportRoot)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	var id string
+	err = func() (err error) {
+		endFn, err := sqlitex.ImmediateTransaction(db)
+		if err != nil {
+			return err
+		}
+		defer endFn(&err)
+		rec, err := findBiome(db, c.biomeID)
+		if err != nil {
+			return err
+		}
+		id = rec.id
+		return c.install(ctx, db, rec)
+	}()
+	if id != "" && !c.dryRun {
+		// Recorded outside the install transaction
+		// so that failed installs are recorded too.
+		ent := &historyEntry{
+			startedAt: startedAt,
+			command:   "install",
+			argv:      []string{c.script, c.version},
+		}
+		if c.uninstall {
+			ent.command = "uninstall"
+		}
+		if err != nil {
+			ent.exitCode = 1
+		}
+		recordHistory(ctx, db, id, ent)
+	}
+	return err
+}
+
+// install runs the install script in the given biome
+// and records the resulting environment.
+func (c *installCommand) install(ctx context.Context, db *sqlite.Conn, rec *biomeRecord) error {
+	settingsKwargs, err := parseInstallSettings(c.settings)
+	if err != nil {
+		return fmt.Errorf("--set: %v", err)
+	}
+	scriptPath, err := filepath.Abs(c.script)
 	if err != nil {
 		return err
 	}
-	bio, err := rec.setupWithoutEnv(ctx, db)
+	if !c.force && !c.uninstall {
+		installed, err := hasInstalled(db, rec.id, scriptPath, c.version)
+		if err != nil {
+			return err
+		}
+		if installed {
+			log.Infof(ctx, "%s %s already installed in %s; use --force to reinstall", c.script, c.version, rec.id)
+			return nil
+		}
+	}
+	var bio biome.Biome
+	bio, err = rec.setupWithoutEnv(ctx, db)
 	if err != nil {
 		return err
 	}
+	// Install scripts don't see the biome's saved environment,
+	// but can still use tools placed in the tools bin directory.
+	bio = biome.EnvBiome{Biome: bio, Env: toolsEnv(bio)}
+	var dryRunOutput io.Writer
+	if c.dryRun {
+		dryRunOutput = os.Stdout
+		bio = dryRunBiome{Biome: bio, out: dryRunOutput}
+	}
+	// Programs found by has_command and the install helpers are remembered
+	// until the script finishes.
+	bio = &biome.CommandCache{Biome: bio}
+	savedVersions, err := readToolVersions(db, rec.id)
+	if err != nil {
+		return err
+	}
+	versions := &toolVersions{saved: savedVersions}
 	thread := &starlark.Thread{}
 	thread.SetLocal(threadContextKey, ctx)
+	thread.SetLocal(toolVersionsKey, versions)
 	script, err := os.Open(c.script)
 	if err != nil {
 		return err
@@ -90,6 +186,19 @@ func (c *installCommand) run(ctx context.Context) (err error) {
 	if err != nil {
 		return err
 	}
+	cachePath := xdgdir.Cache.Path()
+	if cachePath == "" {
+		return fmt.Errorf("%v not set", xdgdir.Cache)
+	}
+	myDownloader := downloader.New(filepath.Join(cachePath, cacheSubdirName, "downloads"))
+	kwargs := []starlark.Tuple{
+		{starlark.String("downloader"), downloaderValue(myDownloader, dryRunOutput, c.downloads)},
+	}
+	kwargs = append(kwargs, settingsKwargs...)
+	if c.uninstall {
+		return c.callUninstall(db, rec, thread, globals, bio, scriptPath, kwargs, versions, dryRunOutput)
+	}
+
 	installFuncValue := globals["install"]
 	if installFuncValue == nil {
 		return fmt.Errorf("no install function found")
@@ -103,19 +212,27 @@ func (c *installCommand) run(ctx context.Context) (err error) {
 		return fmt.Errorf("install function does not permit extra keyword arguments. " +
 			"Please add `**kwargs` to the end of install's parameters for forward compatibility.")
 	}
-	cachePath := xdgdir.Cache.Path()
-	if cachePath == "" {
-		return fmt.Errorf("%v not set", xdgdir.Cache)
+	if c.versionCheck {
+		currentVersion, err := callCurrentVersion(thread, globals, bio, kwargs)
+		if err != nil {
+			return err
+		}
+		if currentVersion == c.version {
+			log.Infof(ctx, "Version %s already installed; skipping", c.version)
+			return nil
+		}
+		if currentVersion != "" {
+			log.Infof(ctx, "Replacing version %s with %s", currentVersion, c.version)
+		}
 	}
-	myDownloader := downloader.New(filepath.Join(cachePath, cacheSubdirName, "downloads"))
-	installReturnValue, err := starlark.Call(
-		thread,
-		installFunc,
-		starlark.Tuple{biomeValue(bio), starlark.String(c.version)},
-		[]starlark.Tuple{
-			{starlark.String("downloader"), downloaderValue(myDownloader)},
-		},
-	)
+	installReturnValue, err := versions.callWritable(func() (starlark.Value, error) {
+		return starlark.Call(
+			thread,
+			installFunc,
+			starlark.Tuple{biomeValue(bio), starlark.String(c.version)},
+			kwargs,
+		)
+	})
 	if err != nil {
 		return err
 	}
@@ -128,12 +245,259 @@ func (c *installCommand) run(ctx context.Context) (err error) {
 	if err != nil {
 		return fmt.Errorf("install return value: %w", err)
 	}
-	if err := writeBiomeEnvironment(db, rec.id, rec.env.Merge(installEnv)); err != nil {
+	newEnv := rec.env.Merge(installEnv)
+	if c.dryRun {
+		fmt.Fprintf(dryRunOutput, "would set environment:\n%v\n", newEnv)
+		if len(versions.pending) > 0 {
+			fmt.Fprintf(dryRunOutput, "would set versions:\n%v", versions)
+		}
+		return nil
+	}
+	return commitInstall(db, func() error {
+		if err := writeBiomeEnvironment(db, rec.id, newEnv); err != nil {
+			return err
+		}
+		if err := writeToolVersions(db, rec.id, versions.pending); err != nil {
+			return err
+		}
+		if err := recordInstall(db, rec.id, scriptPath, c.version, time.Now()); err != nil {
+			return err
+		}
+		verifyEnv, err := expandBiomeEnvironment(bio, newEnv)
+		if err != nil {
+			return err
+		}
+		verifyBiome := biome.EnvBiome{Biome: bio, Env: verifyEnv}
+		return callVerify(thread, globals, verifyBiome, c.version, kwargs)
+	})
+}
+
+// commitInstall calls fn in a savepoint,
+// rolling back any changes fn made to the database if fn returns an error.
+func commitInstall(conn *sqlite.Conn, fn func() error) (err error) {
+	defer sqlitex.Save(conn)(&err)
+	return fn()
+}
+
+// callVerify calls the script's optional verify function
+// with the biome's new environment applied.
+// verify fails if it raises an error or returns False.
+func callVerify(thread *starlark.Thread, globals starlark.StringDict, bio biome.Biome, version string, kwargs []starlark.Tuple) error {
+	fnValue := globals["verify"]
+	if fnValue == nil {
+		log.Debugf(threadContext(thread), "No verify function found; skipping verification")
+		return nil
+	}
+	fn, ok := fnValue.(*starlark.Function)
+	if !ok {
+		return fmt.Errorf("`verify` is declared as %s instead of function", fnValue.Type())
+	}
+	if !fn.HasKwargs() {
+		return fmt.Errorf("verify function does not permit extra keyword arguments. " +
+			"Please add `**kwargs` to the end of verify's parameters for forward compatibility.")
+	}
+	result, err := starlark.Call(thread, fn, starlark.Tuple{biomeValue(bio), starlark.String(version)}, kwargs)
+	if err != nil {
+		return fmt.Errorf("verify: %w", err)
+	}
+	if result == starlark.False {
+		return fmt.Errorf("verify returned False")
+	}
+	return nil
+}
+
+// callUninstall calls the script's uninstall function. The function may return
+// None or the Environment that the script's install function returned,
+// in which case the Environment's variables and PATH entries are removed
+// from the biome's environment. On success, the install record for the
+// script and version is deleted.
+func (c *installCommand) callUninstall(db *sqlite.Conn, rec *biomeRecord, thread *starlark.Thread, globals starlark.StringDict, bio biome.Biome, scriptPath string, kwargs []starlark.Tuple, versions *toolVersions, dryRunOutput io.Writer) error {
+	fnValue := globals["uninstall"]
+	if fnValue == nil {
+		return fmt.Errorf("%s has no uninstall function", c.script)
+	}
+	fn, ok := fnValue.(*starlark.Function)
+	if !ok {
+		return fmt.Errorf("`uninstall` is declared as %s instead of function", fnValue.Type())
+	}
+	if !fn.HasKwargs() {
+		return fmt.Errorf("uninstall function does not permit extra keyword arguments. " +
+			"Please add `**kwargs` to the end of uninstall's parameters for forward compatibility.")
+	}
+	result, err := versions.callWritable(func() (starlark.Value, error) {
+		return starlark.Call(thread, fn, starlark.Tuple{biomeValue(bio), starlark.String(c.version)}, kwargs)
+	})
+	if err != nil {
+		return err
+	}
+	newEnv := rec.env
+	if result != starlark.None {
+		ev, ok := result.(*envValue)
+		if !ok {
+			return fmt.Errorf("`uninstall` returned a %s instead of Environment or None", result.Type())
+		}
+		installedEnv, err := ev.toEnvironment()
+		if err != nil {
+			return fmt.Errorf("uninstall return value: %w", err)
+		}
+		newEnv = removeEnvironment(rec.env, installedEnv)
+	}
+	if c.dryRun {
+		fmt.Fprintf(dryRunOutput, "would set environment:\n%v\n", newEnv)
+		if len(versions.pending) > 0 {
+			fmt.Fprintf(dryRunOutput, "would set versions:\n%v", versions)
+		}
+		return nil
+	}
+	if err := writeBiomeEnvironment(db, rec.id, newEnv); err != nil {
+		return err
+	}
+	if err := writeToolVersions(db, rec.id, versions.pending); err != nil {
 		return err
 	}
+	if err := deleteInstall(db, rec.id, scriptPath, c.version); err != nil {
+		return err
+	}
+	return nil
+}
+
+// removeEnvironment returns a copy of env without the variables in remove
+// and without the PATH entries in remove. It is the inverse of Merge
+// for the variables and entries added by remove.
+func removeEnvironment(env, remove biome.Environment) biome.Environment {
+	result := biome.Environment{Vars: make(map[string]string)}
+	for k, v := range env.Vars {
+		if _, removed := remove.Vars[k]; !removed {
+			result.Vars[k] = v
+			if env.ExpandVars[k] {
+				if result.ExpandVars == nil {
+					result.ExpandVars = make(map[string]bool)
+				}
+				result.ExpandVars[k] = true
+			}
+		}
+	}
+	result.PrependPath = removePaths(env.PrependPath, remove.PrependPath)
+	result.AppendPath = removePaths(env.AppendPath, remove.AppendPath)
+	return result
+}
+
+// removePaths returns the elements of list that are not in remove.
+func removePaths(list, remove []string) []string {
+	var result []string
+	for _, p := range list {
+		found := false
+		for _, r := range remove {
+			if p == r {
+				found = true
+				break
+			}
+		}
+		if !found {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// parseInstallSettings converts KEY=VALUE arguments to keyword arguments
+// for the install function. If a key is given more than once,
+// the last value wins.
+func parseInstallSettings(settings []string) ([]starlark.Tuple, error) {
+	var kwargs []starlark.Tuple
+	index := make(map[string]int)
+	for _, s := range settings {
+		i := strings.IndexByte(s, '=')
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid setting %q: must be in the form KEY=VALUE", s)
+		}
+		k, v := s[:i], s[i+1:]
+		if k == "downloader" {
+			return nil, fmt.Errorf("invalid setting %q: %s is reserved", s, k)
+		}
+		if j, ok := index[k]; ok {
+			kwargs[j][1] = starlark.String(v)
+			continue
+		}
+		index[k] = len(kwargs)
+		kwargs = append(kwargs, starlark.Tuple{starlark.String(k), starlark.String(v)})
+	}
+	return kwargs, nil
+}
+
+// hasInstalled reports whether the given script has been used to install the
+// given version into the biome.
+func hasInstalled(conn *sqlite.Conn, id string, scriptPath string, version string) (bool, error) {
+	const query = `select 1 from "biome_installs" ` +
+		`where "biome_id" = ? and "script_path" = ? and "version" = ? limit 1;`
+	found := false
+	err := sqlitex.Exec(conn, query, func(stmt *sqlite.Stmt) error {
+		found = true
+		return nil
+	}, id, scriptPath, version)
+	if err != nil {
+		return false, fmt.Errorf("check install of %s %s: %w", scriptPath, version, err)
+	}
+	return found, nil
+}
+
+// recordInstall records that the given script was used to install the
+// given version into the biome.
+func recordInstall(conn *sqlite.Conn, id string, scriptPath string, version string, installedAt time.Time) error {
+	const query = `insert into "biome_installs" ("biome_id", "script_path", "version", "installed_at") ` +
+		`values (?, ?, ?, ?) ` +
+		`on conflict ("biome_id", "script_path", "version") do update set "installed_at" = excluded."installed_at";`
+	err := sqlitex.Exec(conn, query, nil, id, scriptPath, version, installedAt.UTC().Format(sqliteTimestampFormatMillis))
+	if err != nil {
+		return fmt.Errorf("record install of %s %s: %w", scriptPath, version, err)
+	}
 	return nil
 }
 
+// deleteInstall removes the record that the given script was used to
+// install the given version into the biome.
+func deleteInstall(conn *sqlite.Conn, id string, scriptPath string, version string) error {
+	const query = `delete from "biome_installs" ` +
+		`where "biome_id" = ? and "script_path" = ? and "version" = ?;`
+	err := sqlitex.Exec(conn, query, nil, id, scriptPath, version)
+	if err != nil {
+		return fmt.Errorf("delete install of %s %s: %w", scriptPath, version, err)
+	}
+	return nil
+}
+
+// callCurrentVersion calls the script's optional current_version function
+// to determine which version is installed in the biome. It returns the empty
+// string if the script does not declare current_version or the function
+// returns None. current_version receives the same keyword arguments as install.
+func callCurrentVersion(thread *starlark.Thread, globals starlark.StringDict, bio biome.Biome, kwargs []starlark.Tuple) (string, error) {
+	fnValue := globals["current_version"]
+	if fnValue == nil {
+		log.Debugf(threadContext(thread), "No current_version function found; installing unconditionally")
+		return "", nil
+	}
+	fn, ok := fnValue.(*starlark.Function)
+	if !ok {
+		return "", fmt.Errorf("`current_version` is declared as %s instead of function", fnValue.Type())
+	}
+	if !fn.HasKwargs() {
+		return "", fmt.Errorf("current_version function does not permit extra keyword arguments. " +
+			"Please add `**kwargs` to the end of current_version's parameters for forward compatibility.")
+	}
+	result, err := starlark.Call(thread, fn, starlark.Tuple{biomeValue(bio)}, kwargs)
+	if err != nil {
+		return "", err
+	}
+	if result == starlark.None {
+		return "", nil
+	}
+	version, ok := starlark.AsString(result)
+	if !ok {
+		return "", fmt.Errorf("`current_version` returned a %s instead of string", result.Type())
+	}
+	return version, nil
+}
+
 const threadContextKey = "zombiezen.com/go/biome.Context"
 
 func threadContext(t *starlark.Thread) context.Context {
@@ -148,6 +512,7 @@ type envValue struct {
 	vars        *starlark.Dict
 	prependPath *starlark.List
 	appendPath  *starlark.List
+	expand      bool
 }
 
 func builtinEnvironmentCtor(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
@@ -156,6 +521,7 @@ func builtinEnvironmentCtor(thread *starlark.Thread, fn *starlark.Builtin, args
 		"vars?", &ev.vars,
 		"prepend_path?", &ev.prependPath,
 		"append_path?", &ev.appendPath,
+		"expand?", &ev.expand,
 	)
 	if err != nil {
 		return nil, err
@@ -173,6 +539,10 @@ func builtinEnvironmentCtor(thread *starlark.Thread, fn *starlark.Builtin, args
 }
 
 func (ev *envValue) String() string {
+	if ev.expand {
+		return fmt.Sprintf("Environment(vars=%v, prepend_path=%v, append_path=%v, expand=True)",
+			ev.vars, ev.prependPath, ev.appendPath)
+	}
 	return fmt.Sprintf("Environment(vars=%v, prepend_path=%v, append_path=%v)",
 		ev.vars, ev.prependPath, ev.appendPath)
 }
@@ -204,6 +574,8 @@ func (ev *envValue) Attr(name string) (starlark.Value, error) {
 		return ev.prependPath, nil
 	case "append_path":
 		return ev.appendPath, nil
+	case "expand":
+		return starlark.Bool(ev.expand), nil
 	default:
 		return nil, nil
 	}
@@ -212,6 +584,7 @@ func (ev *envValue) Attr(name string) (starlark.Value, error) {
 func (ev *envValue) AttrNames() []string {
 	return []string{
 		"append_path",
+		"expand",
 		"prepend_path",
 		"vars",
 	}
@@ -231,6 +604,12 @@ func (ev *envValue) toEnvironment() (biome.Environment, error) {
 				return biome.Environment{}, fmt.Errorf("invalid Environment.vars value %v for key %q", kv[1], k)
 			}
 			e.Vars[k] = v
+			if ev.expand {
+				if e.ExpandVars == nil {
+					e.ExpandVars = make(map[string]bool, n)
+				}
+				e.ExpandVars[k] = true
+			}
 		}
 	}
 	for i, n := 0, ev.appendPath.Len(); i < n; i++ {
@@ -260,11 +639,14 @@ type biomeWrapper struct {
 func biomeValue(bio biome.Biome) *biomeWrapper {
 	bw := &biomeWrapper{biome: bio}
 	bw.attrs = starlark.StringDict{
-		"os":   starlark.String(bio.Describe().OS),
-		"arch": starlark.String(bio.Describe().Arch),
-		"run":  starlark.NewBuiltin("run", bw.runBuiltin),
-		"dirs": newDirsModule(bio.Dirs()),
-		"path": newPathModule(bio),
+		"os":          starlark.String(bio.Describe().NativeOS()),
+		"arch":        starlark.String(bio.Describe().NativeArch()),
+		"run":         starlark.NewBuiltin("run", bw.runBuiltin),
+		"has_command": starlark.NewBuiltin("has_command", bw.hasCommandBuiltin),
+		"get_version": starlark.NewBuiltin("get_version", getVersionBuiltin),
+		"set_version": starlark.NewBuiltin("set_version", setVersionBuiltin),
+		"dirs":        newDirsModule(bio.Dirs()),
+		"path":        newPathModule(bio),
 	}
 	return bw
 }
@@ -290,13 +672,18 @@ func (bw *biomeWrapper) runBuiltin(thread *starlark.Thread, fn *starlark.Builtin
 		Stdout: os.Stderr,
 		Stderr: os.Stderr,
 	}
+	// Default to interactive when install is run from a terminal
+	// so that tools can prompt the user.
+	interactive := term.IsTerminal(int(os.Stdin.Fd()))
 	err := starlark.UnpackArgs(fn.Name(), args, kwargs,
 		"argv", &argv,
 		"dir??", &invocation.Dir,
+		"interactive?", &interactive,
 	)
 	if err != nil {
 		return nil, err
 	}
+	invocation.Interactive = interactive
 	invocation.Argv = make([]string, 0, argv.Len())
 	for i := 0; i < argv.Len(); i++ {
 		arg, ok := starlark.AsString(argv.Index(i))
@@ -311,6 +698,18 @@ func (bw *biomeWrapper) runBuiltin(thread *starlark.Thread, fn *starlark.Builtin
 	return starlark.None, nil
 }
 
+func (bw *biomeWrapper) hasCommandBuiltin(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "name", &name); err != nil {
+		return nil, err
+	}
+	found, err := biome.HasCommand(threadContext(thread), bw.biome, name)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.Bool(found), nil
+}
+
 func newDirsModule(dirs *biome.Dirs) *module {
 	return &module{
 		name: "dirs",
@@ -352,7 +751,11 @@ func newPathModule(bio biome.Biome) *module {
 	}
 }
 
-func downloaderValue(d *downloader.Downloader) *module {
+// downloaderValue returns the Starlark downloader module.
+// If dryRun is not nil, then the module prints the operations it would
+// perform to dryRun instead of downloading anything.
+// If downloads is not nil, then archives are checked against and recorded in it.
+func downloaderValue(d *downloader.Downloader, dryRun io.Writer, downloads *downloadLog) *module {
 	return &module{
 		name: "downloader",
 		attrs: starlark.StringDict{
@@ -362,25 +765,85 @@ func downloaderValue(d *downloader.Downloader) *module {
 					Output:     os.Stderr,
 				}
 				var bw *biomeWrapper
+				var url starlark.Value
+				var headers *starlark.Dict
 				mode := "tarbomb"
 				err := starlark.UnpackArgs(fn.Name(), args, kwargs,
 					"biome", &bw,
 					"dst_dir", &opts.DestinationDir,
-					"url", &opts.URL,
+					"url", &url,
 					"mode?", &mode,
+					"cache_key?", &opts.CacheKey,
+					"merge?", &opts.Merge,
+					"headers?", &headers,
+					"strip_components?", &opts.StripComponents,
 				)
 				if err != nil {
 					return nil, err
 				}
 				opts.Biome = bw.biome
+				if headers != nil {
+					opts.Header, err = headersFromDict(headers)
+					if err != nil {
+						return nil, fmt.Errorf("%s: %v", fn.Name(), err)
+					}
+				}
+				// url may be a single URL or a list of URLs to try in order.
+				switch url := url.(type) {
+				case starlark.String:
+					opts.URL = string(url)
+				case *starlark.List:
+					if url.Len() == 0 {
+						return nil, fmt.Errorf("%s: url list is empty", fn.Name())
+					}
+					for i := 0; i < url.Len(); i++ {
+						u, ok := starlark.AsString(url.Index(i))
+						if !ok {
+							return nil, fmt.Errorf("%s: could not convert url[%d] to string", fn.Name(), i)
+						}
+						if i == 0 {
+							opts.URL = u
+						} else {
+							opts.Mirrors = append(opts.Mirrors, u)
+						}
+					}
+				default:
+					return nil, fmt.Errorf("%s: url must be a string or list of strings, got %s", fn.Name(), url.Type())
+				}
+				if opts.StripComponents < 0 {
+					return nil, fmt.Errorf("%s: strip_components must not be negative", fn.Name())
+				}
 				switch mode {
 				case "tarbomb":
-					opts.ExtractMode = extract.Tarbomb
 				case "strip":
-					opts.ExtractMode = extract.StripTopDirectory
+					if opts.StripComponents > 1 {
+						return nil, fmt.Errorf("%s: mode=%q conflicts with strip_components=%d", fn.Name(), mode, opts.StripComponents)
+					}
+					opts.StripComponents = 1
 				default:
 					return nil, fmt.Errorf("%s: invalid mode %q", fn.Name(), mode)
 				}
+				if dryRun != nil {
+					modeDesc := "mode=" + mode
+					if opts.StripComponents > 1 {
+						modeDesc = fmt.Sprintf("strip_components=%d", opts.StripComponents)
+					}
+					if len(opts.Header) > 0 {
+						fmt.Fprintf(dryRun, "would download %s with headers %s and extract to %s (%s)\n", opts.URL, redactHeader(opts.Header), opts.DestinationDir, modeDesc)
+					} else {
+						fmt.Fprintf(dryRun, "would download %s and extract to %s (%s)\n", opts.URL, opts.DestinationDir, modeDesc)
+					}
+					return starlark.None, nil
+				}
+				if downloads != nil {
+					opts.SHA256, err = downloads.expect(opts.URL)
+					if err != nil {
+						return nil, fmt.Errorf("%s: %v", fn.Name(), err)
+					}
+					opts.OnDownload = func(sum string) {
+						downloads.record(opts.URL, sum)
+					}
+				}
 				if err := extract.Extract(threadContext(thread), opts); err != nil {
 					return nil, err
 				}
@@ -390,6 +853,96 @@ func downloaderValue(d *downloader.Downloader) *module {
 	}
 }
 
+// headersFromDict converts a Starlark dictionary of strings
+// to HTTP header fields.
+func headersFromDict(d *starlark.Dict) (http.Header, error) {
+	h := make(http.Header, d.Len())
+	for _, item := range d.Items() {
+		k, ok := starlark.AsString(item[0])
+		if !ok {
+			return nil, fmt.Errorf("headers: key %v is not a string", item[0])
+		}
+		v, ok := starlark.AsString(item[1])
+		if !ok {
+			// Don't print the value: it may be a credential.
+			return nil, fmt.Errorf("headers: value for %q is a %s, not a string", k, item[1].Type())
+		}
+		h.Add(k, v)
+	}
+	return h, nil
+}
+
+// redactHeader returns a description of the header fields in h
+// that omits their values, which may contain credentials.
+func redactHeader(h http.Header) string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	sb := new(strings.Builder)
+	for i, k := range keys {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(k)
+		sb.WriteString(": <redacted>")
+	}
+	return sb.String()
+}
+
+// dryRunBiome is a biome that prints commands and file modifications instead
+// of performing them. Read-only operations are forwarded to the wrapped biome
+// so that scripts can still inspect the biome's state.
+type dryRunBiome struct {
+	biome.Biome
+	out io.Writer
+}
+
+func (d dryRunBiome) Run(ctx context.Context, invoke *biome.Invocation) error {
+	if invoke.Dir == "" {
+		_, err := fmt.Fprintf(d.out, "would run: %s\n", strings.Join(invoke.Argv, " "))
+		return err
+	}
+	_, err := fmt.Fprintf(d.out, "would run (in %s): %s\n", invoke.Dir, strings.Join(invoke.Argv, " "))
+	return err
+}
+
+func (d dryRunBiome) OpenFile(ctx context.Context, path string) (io.ReadCloser, error) {
+	return biome.OpenFile(ctx, d.Biome, path)
+}
+
+func (d dryRunBiome) WriteFile(ctx context.Context, path string, src io.Reader) error {
+	_, err := fmt.Fprintf(d.out, "would write file: %s\n", path)
+	return err
+}
+
+func (d dryRunBiome) MkdirAll(ctx context.Context, path string) error {
+	_, err := fmt.Fprintf(d.out, "would create directory: %s\n", path)
+	return err
+}
+
+func (d dryRunBiome) MkdirAllPerm(ctx context.Context, path string, perm fs.FileMode) error {
+	_, err := fmt.Fprintf(d.out, "would create directory: %s (mode %#o)\n", path, perm.Perm())
+	return err
+}
+
+func (d dryRunBiome) EvalSymlinks(ctx context.Context, path string) (string, error) {
+	return biome.EvalSymlinks(ctx, d.Biome, path)
+}
+
+func (d dryRunBiome) Stat(ctx context.Context, path string) (fs.FileInfo, error) {
+	return biome.Stat(ctx, d.Biome, path)
+}
+
+func (d dryRunBiome) ReadDir(ctx context.Context, path string) ([]fs.DirEntry, error) {
+	return biome.ReadDir(ctx, d.Biome, path)
+}
+
+func (d dryRunBiome) HasCommand(ctx context.Context, name string) (bool, error) {
+	return biome.HasCommand(ctx, d.Biome, name)
+}
+
 var _ starlark.HasAttrs = (*module)(nil)
 
 type module struct {
@@ -0,0 +1,526 @@
+// Copyright 2021 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		 https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"go.starlark.net/starlark"
+	"zombiezen.com/go/biome"
+	"zombiezen.com/go/biome/downloader"
+	"zombiezen.com/go/log/testlog"
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+func TestParseInstallSettings(t *testing.T) {
+	tests := []struct {
+		settings []string
+		want     [][2]string
+		err      bool
+	}{
+		{settings: nil, want: nil},
+		{
+			settings: []string{"with_debug=true"},
+			want:     [][2]string{{"with_debug", "true"}},
+		},
+		{
+			settings: []string{"a=1", "b=", "c=x=y"},
+			want:     [][2]string{{"a", "1"}, {"b", ""}, {"c", "x=y"}},
+		},
+		{
+			settings: []string{"a=1", "b=2", "a=3"},
+			want:     [][2]string{{"a", "3"}, {"b", "2"}},
+		},
+		{settings: []string{"a"}, err: true},
+		{settings: []string{"=1"}, err: true},
+		{settings: []string{"downloader=x"}, err: true},
+	}
+	for _, test := range tests {
+		kwargs, err := parseInstallSettings(test.settings)
+		if err != nil {
+			if !test.err {
+				t.Errorf("parseInstallSettings(%q): %v", test.settings, err)
+			}
+			continue
+		}
+		if test.err {
+			t.Errorf("parseInstallSettings(%q) = %v, <nil>; want error", test.settings, kwargs)
+			continue
+		}
+		var got [][2]string
+		for _, kv := range kwargs {
+			k, _ := starlark.AsString(kv[0])
+			v, ok := kv[1].(starlark.String)
+			if !ok {
+				t.Errorf("parseInstallSettings(%q) value for %q is %s; want string", test.settings, k, kv[1].Type())
+			}
+			got = append(got, [2]string{k, string(v)})
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("parseInstallSettings(%q) (-want +got):\n%s", test.settings, diff)
+		}
+	}
+}
+
+func TestRemoveEnvironment(t *testing.T) {
+	env := biome.Environment{
+		Vars:        map[string]string{"GOROOT": "/tools/go", "FOO": "bar"},
+		PrependPath: []string{"/tools/go/bin", "/tools/node/bin"},
+		AppendPath:  []string{"/tools/extra"},
+	}
+	remove := biome.Environment{
+		Vars:        map[string]string{"GOROOT": "/tools/go"},
+		PrependPath: []string{"/tools/go/bin"},
+		AppendPath:  []string{"/not/present"},
+	}
+	want := biome.Environment{
+		Vars:        map[string]string{"FOO": "bar"},
+		PrependPath: []string{"/tools/node/bin"},
+		AppendPath:  []string{"/tools/extra"},
+	}
+	got := removeEnvironment(env, remove)
+	if diff := cmp.Diff(want, got, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("removeEnvironment(...) (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(want, removeEnvironment(env.Merge(remove), remove), cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("removeEnvironment(env.Merge(remove), remove) (-want +got):\n%s", diff)
+	}
+}
+
+func TestCallVerify(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		ok     bool
+	}{
+		{name: "NoVerify", script: "", ok: true},
+		{name: "ReturnsNone", script: "def verify(biome, version, **kwargs):\n  pass\n", ok: true},
+		{name: "ReturnsTrue", script: "def verify(biome, version, **kwargs):\n  return True\n", ok: true},
+		{name: "ReturnsFalse", script: "def verify(biome, version, **kwargs):\n  return False\n", ok: false},
+		{name: "Fails", script: "def verify(biome, version, **kwargs):\n  fail('broken')\n", ok: false},
+		{name: "NoKwargs", script: "def verify(biome, version):\n  pass\n", ok: false},
+		{name: "NotFunction", script: "verify = 42\n", ok: false},
+		{
+			name:   "SeesVersionAndSettings",
+			script: "def verify(biome, version, **kwargs):\n  return version == '1.0' and kwargs['flavor'] == 'debug'\n",
+			ok:     true,
+		},
+	}
+	bio := &biome.Fake{
+		Descriptor: biome.Descriptor{OS: biome.Linux, Arch: biome.Intel64},
+		DirsResult: biome.Dirs{Work: "/work", Home: "/home", Tools: "/tools"},
+	}
+	kwargs := []starlark.Tuple{{starlark.String("flavor"), starlark.String("debug")}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			thread := new(starlark.Thread)
+			globals, err := starlark.ExecFile(thread, "test.star", test.script, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = callVerify(thread, globals, bio, "1.0", kwargs)
+			if test.ok && err != nil {
+				t.Errorf("callVerify(...) = %v; want <nil>", err)
+			}
+			if !test.ok && err == nil {
+				t.Error("callVerify(...) = <nil>; want error")
+			}
+		})
+	}
+}
+
+func TestCallCurrentVersion(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   string
+		err    bool
+	}{
+		{name: "NoCurrentVersion", script: "", want: ""},
+		{name: "ReturnsNone", script: "def current_version(biome, **kwargs):\n  return None\n", want: ""},
+		{name: "ReturnsVersion", script: "def current_version(biome, **kwargs):\n  return '1.0'\n", want: "1.0"},
+		{name: "ReturnsNumber", script: "def current_version(biome, **kwargs):\n  return 1\n", err: true},
+		{name: "Fails", script: "def current_version(biome, **kwargs):\n  fail('broken')\n", err: true},
+		{name: "NoKwargs", script: "def current_version(biome):\n  return '1.0'\n", err: true},
+		{name: "NotFunction", script: "current_version = '1.0'\n", err: true},
+		{
+			name:   "SeesSettings",
+			script: "def current_version(biome, flavor = None, **kwargs):\n  return '1.0-' + flavor\n",
+			want:   "1.0-debug",
+		},
+	}
+	bio := &biome.Fake{
+		Descriptor: biome.Descriptor{OS: biome.Linux, Arch: biome.Intel64},
+		DirsResult: biome.Dirs{Work: "/work", Home: "/home", Tools: "/tools"},
+	}
+	kwargs := []starlark.Tuple{{starlark.String("flavor"), starlark.String("debug")}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			thread := new(starlark.Thread)
+			globals, err := starlark.ExecFile(thread, "test.star", test.script, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := callCurrentVersion(thread, globals, bio, kwargs)
+			if test.err {
+				if err == nil {
+					t.Errorf("callCurrentVersion(...) = %q, <nil>; want error", got)
+				}
+				return
+			}
+			if got != test.want || err != nil {
+				t.Errorf("callCurrentVersion(...) = %q, %v; want %q, <nil>", got, err, test.want)
+			}
+		})
+	}
+}
+
+func TestDownloaderExtractHeaders(t *testing.T) {
+	const secret = "s3cr3t-t0ken"
+	bio := &biome.Fake{
+		Descriptor: biome.Descriptor{OS: biome.Linux, Arch: biome.Intel64},
+		DirsResult: biome.Dirs{Work: "/work", Home: "/home", Tools: "/tools"},
+	}
+	tests := []struct {
+		name    string
+		headers string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "Redacted",
+			headers: `{"Authorization": "Bearer ` + secret + `", "x-token": "` + secret + `"}`,
+			want:    "would download https://example.com/foo.tar.gz with headers Authorization: <redacted>, X-Token: <redacted> and extract to /tools/foo (mode=tarbomb)\n",
+		},
+		{
+			name:    "Empty",
+			headers: `{}`,
+			want:    "would download https://example.com/foo.tar.gz and extract to /tools/foo (mode=tarbomb)\n",
+		},
+		{
+			name:    "NonStringKey",
+			headers: `{42: "` + secret + `"}`,
+			wantErr: true,
+		},
+		{
+			name:    "NonStringValue",
+			headers: `{"Authorization": ["` + secret + `"]}`,
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out := new(strings.Builder)
+			predeclared := starlark.StringDict{
+				"biome":      biomeValue(bio),
+				"downloader": downloaderValue(nil, out, nil),
+			}
+			script := `downloader.extract(biome, dst_dir="/tools/foo", url="https://example.com/foo.tar.gz", headers=` + test.headers + ")\n"
+			_, err := starlark.ExecFile(new(starlark.Thread), "test.star", script, predeclared)
+			if err != nil {
+				if strings.Contains(err.Error(), secret) {
+					t.Errorf("error %q contains credentials", err)
+				}
+				if !test.wantErr {
+					t.Fatal(err)
+				}
+				return
+			}
+			if test.wantErr {
+				t.Fatal("downloader.extract did not return an error")
+			}
+			if got := out.String(); got != test.want {
+				t.Errorf("output = %q; want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDownloaderExtractStripComponents(t *testing.T) {
+	bio := &biome.Fake{
+		Descriptor: biome.Descriptor{OS: biome.Linux, Arch: biome.Intel64},
+		DirsResult: biome.Dirs{Work: "/work", Home: "/home", Tools: "/tools"},
+	}
+	tests := []struct {
+		name    string
+		args    string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "Default",
+			args: ``,
+			want: "would download https://example.com/foo.tar.gz and extract to /tools/foo (mode=tarbomb)\n",
+		},
+		{
+			name: "StripMode",
+			args: `, mode="strip"`,
+			want: "would download https://example.com/foo.tar.gz and extract to /tools/foo (mode=strip)\n",
+		},
+		{
+			name: "StripComponents",
+			args: `, strip_components=2`,
+			want: "would download https://example.com/foo.tar.gz and extract to /tools/foo (strip_components=2)\n",
+		},
+		{
+			name: "StripModeWithOne",
+			args: `, mode="strip", strip_components=1`,
+			want: "would download https://example.com/foo.tar.gz and extract to /tools/foo (mode=strip)\n",
+		},
+		{
+			name:    "Conflict",
+			args:    `, mode="strip", strip_components=2`,
+			wantErr: true,
+		},
+		{
+			name:    "Negative",
+			args:    `, strip_components=-1`,
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out := new(strings.Builder)
+			predeclared := starlark.StringDict{
+				"biome":      biomeValue(bio),
+				"downloader": downloaderValue(nil, out, nil),
+			}
+			script := `downloader.extract(biome, dst_dir="/tools/foo", url="https://example.com/foo.tar.gz"` + test.args + ")\n"
+			_, err := starlark.ExecFile(new(starlark.Thread), "test.star", script, predeclared)
+			if err != nil {
+				if !test.wantErr {
+					t.Fatal(err)
+				}
+				return
+			}
+			if test.wantErr {
+				t.Fatal("downloader.extract did not return an error")
+			}
+			if got := out.String(); got != test.want {
+				t.Errorf("output = %q; want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDownloaderExtractEnvBiome(t *testing.T) {
+	const content = "hello\n"
+	archive := new(bytes.Buffer)
+	zw := gzip.NewWriter(archive)
+	tw := tar.NewWriter(zw)
+	err := tw.WriteHeader(&tar.Header{
+		Name:     "foo/bar.txt",
+		Typeflag: tar.TypeReg,
+		Mode:     0o644,
+		Size:     int64(len(content)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(archive.Bytes())
+	}))
+	t.Cleanup(srv.Close)
+
+	// With an empty PATH, the biome can't find tar,
+	// so the archive must be extracted in-process.
+	local := biome.Local{
+		WorkDir:  t.TempDir(),
+		HomeDir:  t.TempDir(),
+		ToolsDir: t.TempDir(),
+	}
+	bio := biome.EnvBiome{
+		Biome: local,
+		Env:   biome.Environment{Vars: map[string]string{"PATH": t.TempDir()}},
+	}
+	d := downloader.New(t.TempDir())
+	d.Client = srv.Client()
+	predeclared := starlark.StringDict{
+		"biome":      biomeValue(bio),
+		"downloader": downloaderValue(d, nil, nil),
+		"url":        starlark.String(srv.URL + "/foo.tar.gz"),
+	}
+	thread := new(starlark.Thread)
+	thread.SetLocal(threadContextKey, testlog.WithTB(context.Background(), t))
+	script := `downloader.extract(biome, dst_dir="foo", url=url, mode="strip")` + "\n"
+	if _, err := starlark.ExecFile(thread, "test.star", script, predeclared); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(filepath.Join(local.WorkDir, "foo", "bar.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != content {
+		t.Errorf("foo/bar.txt content = %q; want %q", got, content)
+	}
+}
+
+func TestInstallVersionCheck(t *testing.T) {
+	const script = `def current_version(biome, flavor = None, **kwargs):
+  return "1.0-" + flavor
+
+def install(biome, version, **kwargs):
+  return Environment(vars = {"INSTALLED": version})
+`
+	tests := []struct {
+		name    string
+		version string
+		want    string
+	}{
+		{name: "SameVersion", version: "1.0-debug", want: ""},
+		{name: "OtherVersion", version: "2.0", want: "2.0"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := testlog.WithTB(context.Background(), t)
+			db := openTestDB(t)
+			id, _ := newTestBiome(t, db)
+			c := &installCommand{
+				biomeID:      id,
+				script:       writeTestScript(t, script),
+				version:      test.version,
+				versionCheck: true,
+				settings:     []string{"flavor=debug"},
+			}
+			if err := c.run(ctx); err != nil {
+				t.Fatal("install:", err)
+			}
+			env, err := readBiomeEnvironment(db, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := env.Vars["INSTALLED"]; got != test.want {
+				t.Errorf("INSTALLED = %q; want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestInstallSkipsInstalledVersion(t *testing.T) {
+	// The script records each time it runs in the biome's directory.
+	const script = `def install(biome, version, **kwargs):
+  biome.run(["sh", "-c", "echo " + version + " >> installs.txt"])
+  return Environment()
+`
+	ctx := testlog.WithTB(context.Background(), t)
+	db := openTestDB(t)
+	id, root := newTestBiome(t, db)
+	scriptPath := writeTestScript(t, script)
+	installs := []*installCommand{
+		{biomeID: id, script: scriptPath, version: "1.0"},
+		{biomeID: id, script: scriptPath, version: "1.0"},
+		{biomeID: id, script: scriptPath, version: "2.0"},
+		{biomeID: id, script: scriptPath, version: "1.0", force: true},
+	}
+	for _, c := range installs {
+		if err := c.run(ctx); err != nil {
+			t.Fatalf("install %s (force=%t): %v", c.version, c.force, err)
+		}
+	}
+	got, err := os.ReadFile(filepath.Join(root, "installs.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = "1.0\n2.0\n1.0\n"
+	if string(got) != want {
+		t.Errorf("installs.txt = %q; want %q", got, want)
+	}
+}
+
+func TestDryRunBiome(t *testing.T) {
+	ctx := testlog.WithTB(context.Background(), t)
+	work := t.TempDir()
+	out := new(strings.Builder)
+	bio := dryRunBiome{
+		Biome: biome.Local{WorkDir: work, HomeDir: t.TempDir()},
+		out:   out,
+	}
+	if err := biome.WriteFile(ctx, bio, "foo.txt", strings.NewReader("hello")); err != nil {
+		t.Error("WriteFile:", err)
+	}
+	if err := biome.MkdirAll(ctx, bio, "a/b"); err != nil {
+		t.Error("MkdirAll:", err)
+	}
+	if err := biome.MkdirAllPerm(ctx, bio, "c", 0o700); err != nil {
+		t.Error("MkdirAllPerm:", err)
+	}
+	if err := bio.Run(ctx, &biome.Invocation{Argv: []string{"touch", "bar.txt"}}); err != nil {
+		t.Error("Run:", err)
+	}
+	if err := bio.Run(ctx, &biome.Invocation{Argv: []string{"touch", "baz.txt"}, Dir: "a"}); err != nil {
+		t.Error("Run:", err)
+	}
+
+	want := "would write file: foo.txt\n" +
+		"would create directory: a/b\n" +
+		"would create directory: c (mode 0700)\n" +
+		"would run: touch bar.txt\n" +
+		"would run (in a): touch baz.txt\n"
+	if diff := cmp.Diff(want, out.String()); diff != "" {
+		t.Errorf("output (-want +got):\n%s", diff)
+	}
+	entries, err := os.ReadDir(work)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ent := range entries {
+		t.Errorf("dry run created %s", ent.Name())
+	}
+}
+
+// newTestBiome adds a biome to db that runs commands directly in a new
+// temporary directory, returning the biome's ID and directory.
+func newTestBiome(tb testing.TB, db *sqlite.Conn) (id, root string) {
+	id = "abcd"
+	root = tb.TempDir()
+	err := sqlitex.Exec(db, `insert into "biomes" ("id", "root_host_dir", "mounted") values (?, ?, 1);`, nil, id, root)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return id, root
+}
+
+// writeTestScript writes an install script to a new temporary directory
+// and returns its path.
+func writeTestScript(tb testing.TB, script string) string {
+	path := filepath.Join(tb.TempDir(), "install.star")
+	if err := os.WriteFile(path, []byte(script), 0o666); err != nil {
+		tb.Fatal(err)
+	}
+	return path
+}
@@ -0,0 +1,75 @@
+// Copyright 2021 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		 https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"zombiezen.com/go/log"
+)
+
+// installLockName is the name of the file inside a biome's support root
+// that biome install locks while it modifies the biome.
+const installLockName = "install.lock"
+
+// installLockPollInterval is how often lockInstall retries
+// while another process holds the lock.
+const installLockPollInterval = 100 * time.Millisecond
+
+// lockInstall acquires an exclusive lock on the install lock file
+// in the given support root, waiting until any other install finishes
+// or ctx is done. The returned function releases the lock.
+func lockInstall(ctx context.Context, supportRoot string) (unlock func(), err error) {
+	path := filepath.Join(supportRoot, installLockName)
+	if err := os.MkdirAll(supportRoot, 0o777); err != nil {
+		return nil, fmt.Errorf("lock %s: %v", path, err)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o666)
+	if err != nil {
+		return nil, fmt.Errorf("lock %s: %v", path, err)
+	}
+	waiting := false
+	for {
+		err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if err != unix.EWOULDBLOCK && err != unix.EINTR {
+			f.Close()
+			return nil, fmt.Errorf("lock %s: %v", path, err)
+		}
+		if !waiting {
+			log.Infof(ctx, "Waiting for another install to finish...")
+			waiting = true
+		}
+		select {
+		case <-time.After(installLockPollInterval):
+		case <-ctx.Done():
+			f.Close()
+			return nil, fmt.Errorf("lock %s: %w", path, ctx.Err())
+		}
+	}
+	return func() {
+		// Closing the file releases the lock.
+		f.Close()
+	}, nil
+}
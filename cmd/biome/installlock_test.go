@@ -0,0 +1,71 @@
+// Copyright 2021 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		 https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLockInstall(t *testing.T) {
+	ctx := context.Background()
+	supportRoot := t.TempDir()
+	unlock1, err := lockInstall(ctx, supportRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Canceled", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(ctx, 3*installLockPollInterval)
+		defer cancel()
+		unlock, err := lockInstall(ctx, supportRoot)
+		if err == nil {
+			unlock()
+			t.Fatal("lockInstall succeeded while another lock was held")
+		}
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("lockInstall(...) = _, %v; want context.DeadlineExceeded", err)
+		}
+	})
+
+	t.Run("WaitsForUnlock", func(t *testing.T) {
+		done := make(chan error, 1)
+		go func() {
+			unlock, err := lockInstall(ctx, supportRoot)
+			if err == nil {
+				unlock()
+			}
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			t.Fatalf("lockInstall returned before the first lock was released (error = %v)", err)
+		case <-time.After(3 * installLockPollInterval):
+		}
+		unlock1()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Error(err)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("lockInstall did not return after the first lock was released")
+		}
+	})
+}
@@ -20,6 +20,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -28,8 +29,10 @@ import (
 )
 
 type listCommand struct {
-	all   bool
-	quiet bool
+	all      bool
+	quiet    bool
+	versions bool
+	filters  []string
 }
 
 func newListCommand() *cobra.Command {
@@ -47,44 +50,88 @@ func newListCommand() *cobra.Command {
 	}
 	cmd.Flags().BoolVarP(&c.all, "all", "a", false, "show biomes in all directories")
 	cmd.Flags().BoolVarP(&c.quiet, "quiet", "q", false, "only show IDs")
+	cmd.Flags().BoolVar(&c.versions, "versions", false, "show the tool versions recorded by install scripts")
+	cmd.Flags().StringArrayVar(&c.filters, "filter", nil, "only show biomes matching a `tag:KEY=VALUE` filter (can be repeated)")
 	return cmd
 }
 
 func (c *listCommand) run(ctx context.Context) (err error) {
+	tags := make(map[string]string)
+	for _, f := range c.filters {
+		k, v, err := parseListFilter(f)
+		if err != nil {
+			return fmt.Errorf("--filter: %v", err)
+		}
+		tags[k] = v
+	}
 	db, err := openDB(ctx)
 	if err != nil {
 		return err
 	}
 	defer db.Close()
 
-	query := `select "id", "created_at", "root_host_dir" from "biomes" `
-	var queryArgs []interface{}
-	if !c.all {
-		query += `where pathparentof("root_host_dir", ?) `
-		currDir, err := os.Getwd()
-		if err != nil {
-			return err
-		}
-		queryArgs = append(queryArgs, currDir)
-	}
-	query += `order by "created_at" desc, "id";`
-	err = sqlitex.Exec(db, query, func(stmt *sqlite.Stmt) error {
+	err = listBiomes(db, c.all, tags, func(stmt *sqlite.Stmt) error {
 		id := stmt.ColumnText(0)
 		createdAt, err := time.Parse(sqliteTimestampFormatMillis, stmt.ColumnText(1))
 		if err != nil {
 			return fmt.Errorf("biome[id=%q].created_at: %w", id, err)
 		}
 		rootHostDir := stmt.ColumnText(2)
+		lastUsed := "-"
+		if stmt.ColumnType(3) != sqlite.TypeNull {
+			lastUsedAt, err := time.Parse(sqliteTimestampFormatMillis, stmt.ColumnText(3))
+			if err != nil {
+				return fmt.Errorf("biome[id=%q].last_used_at: %w", id, err)
+			}
+			lastUsed = lastUsedAt.Local().Format(time.RFC3339)
+		}
 
 		if c.quiet {
 			_, err = fmt.Println(id)
-		} else {
-			_, err = fmt.Printf("%s\t%s\t%s\n", id, createdAt.Local().Format(time.RFC3339), rootHostDir)
+			return err
 		}
+		line := fmt.Sprintf("%s\t%s\t%s\t%s", id, createdAt.Local().Format(time.RFC3339), lastUsed, rootHostDir)
+		if c.versions {
+			versions, err := readToolVersions(db, id)
+			if err != nil {
+				return err
+			}
+			line += "\t" + formatToolVersions(versions)
+		}
+		_, err = fmt.Println(line)
 		return err
-	}, queryArgs...)
+	})
 	if err != nil {
 		return err
 	}
 	return nil
 }
+
+// listBiomes calls fn for each biome, most recently created first.
+// The statement's columns are the biome's "id", "created_at", "root_host_dir",
+// and "last_used_at". If all is false, then only biomes whose root directory
+// contains the current working directory are listed. Only biomes that have
+// all of the given tags are listed.
+func listBiomes(conn *sqlite.Conn, all bool, tags map[string]string, fn func(stmt *sqlite.Stmt) error) error {
+	query := `select "id", "created_at", "root_host_dir", "last_used_at" from "biomes" `
+	var conds []string
+	var queryArgs []interface{}
+	if !all {
+		currDir, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		cond, args := rootContainsCondition(currDir)
+		conds = append(conds, cond)
+		queryArgs = append(queryArgs, args...)
+	}
+	for k, v := range tags {
+		conds = append(conds, `exists (select 1 from "biome_tags" where "biome_id" = "biomes"."id" and "key" = ? and "value" = ?)`)
+		queryArgs = append(queryArgs, k, v)
+	}
+	if len(conds) > 0 {
+		query += `where ` + strings.Join(conds, ` and `) + ` `
+	}
+	query += `order by "created_at" desc, "id";`
+	return sqlitex.Exec(conn, query, fn, queryArgs...)
+}
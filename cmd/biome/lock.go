@@ -0,0 +1,170 @@
+// Copyright 2021 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		 https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const lockFileName = "biome.lock"
+
+// lockFile is the JSON-decoded form of a lock file, which records the
+// archives downloaded while installing a manifest's tools.
+type lockFile struct {
+	Tools []lockedTool `json:"tools"`
+}
+
+type lockedTool struct {
+	// Script is the path to the install script. In the file, relative paths
+	// are relative to the lock file's directory and use forward slashes.
+	Script    string           `json:"script"`
+	Version   string           `json:"version"`
+	Downloads []lockedDownload `json:"downloads"`
+}
+
+type lockedDownload struct {
+	URL string `json:"url"`
+	// SHA256 is the hex-encoded SHA-256 digest of the downloaded archive.
+	SHA256 string `json:"sha256"`
+}
+
+// find returns the entry for the given script and version
+// or nil if the lock file does not have one.
+func (lock *lockFile) find(script, version string) *lockedTool {
+	for i := range lock.Tools {
+		if tool := &lock.Tools[i]; tool.Script == script && tool.Version == version {
+			return tool
+		}
+	}
+	return nil
+}
+
+// readLockFile reads the lock file at path, resolving script paths
+// to absolute paths.
+func readLockFile(path string) (*lockFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read lock file: %w", err)
+	}
+	lock, err := parseLockFile(data, filepath.Dir(path))
+	if err != nil {
+		return nil, fmt.Errorf("read lock file %s: %w", path, err)
+	}
+	return lock, nil
+}
+
+func parseLockFile(data []byte, dir string) (*lockFile, error) {
+	lock := new(lockFile)
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(lock); err != nil {
+		return nil, err
+	}
+	for i := range lock.Tools {
+		tool := &lock.Tools[i]
+		if tool.Script == "" {
+			return nil, fmt.Errorf("tools[%d]: missing script", i)
+		}
+		tool.Script = filepath.FromSlash(tool.Script)
+		if !filepath.IsAbs(tool.Script) {
+			tool.Script = filepath.Join(dir, tool.Script)
+		}
+		for j, dl := range tool.Downloads {
+			if dl.URL == "" || dl.SHA256 == "" {
+				return nil, fmt.Errorf("tools[%d].downloads[%d]: missing url or sha256", i, j)
+			}
+		}
+	}
+	return lock, nil
+}
+
+// writeLockFile writes lock to path, storing script paths relative to the
+// lock file's directory where possible.
+func writeLockFile(path string, lock *lockFile) error {
+	data, err := marshalLockFile(lock, filepath.Dir(path))
+	if err != nil {
+		return fmt.Errorf("write lock file: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0o666); err != nil {
+		return fmt.Errorf("write lock file: %v", err)
+	}
+	return nil
+}
+
+func marshalLockFile(lock *lockFile, dir string) ([]byte, error) {
+	out := &lockFile{Tools: make([]lockedTool, 0, len(lock.Tools))}
+	for _, tool := range lock.Tools {
+		if rel, err := filepath.Rel(dir, tool.Script); err == nil && !isParentRel(rel) {
+			tool.Script = rel
+		}
+		tool.Script = filepath.ToSlash(tool.Script)
+		if tool.Downloads == nil {
+			tool.Downloads = []lockedDownload{}
+		}
+		out.Tools = append(out.Tools, tool)
+	}
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+func isParentRel(rel string) bool {
+	return rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// downloadLog records the archives that an install script downloads.
+// If locked is true, then the log only permits downloads of archives
+// already in the log.
+type downloadLog struct {
+	downloads []lockedDownload
+	locked    bool
+}
+
+// expect returns the SHA-256 digest that the archive at url must have
+// or the empty string if the archive is not pinned.
+func (dl *downloadLog) expect(url string) (string, error) {
+	if !dl.locked {
+		return "", nil
+	}
+	for _, d := range dl.downloads {
+		if d.URL == url {
+			return d.SHA256, nil
+		}
+	}
+	return "", fmt.Errorf("%s is not in %s; run biome up without --locked to update it", url, lockFileName)
+}
+
+// record adds a download to the log.
+func (dl *downloadLog) record(url, sha256 string) {
+	if dl.locked {
+		return
+	}
+	for i := range dl.downloads {
+		if dl.downloads[i].URL == url {
+			dl.downloads[i].SHA256 = sha256
+			return
+		}
+	}
+	dl.downloads = append(dl.downloads, lockedDownload{URL: url, SHA256: sha256})
+}
@@ -0,0 +1,118 @@
+// Copyright 2021 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		 https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLockFileRoundTrip(t *testing.T) {
+	dir := filepath.FromSlash("/src/project")
+	lock := &lockFile{
+		Tools: []lockedTool{
+			{
+				Script:  filepath.Join(dir, "installers", "go.star"),
+				Version: "1.17.3",
+				Downloads: []lockedDownload{
+					{URL: "https://example.com/go.tar.gz", SHA256: "abcd"},
+				},
+			},
+			{
+				Script:  filepath.FromSlash("/opt/node.star"),
+				Version: "16.13.0",
+			},
+		},
+	}
+	data, err := marshalLockFile(lock, dir)
+	if err != nil {
+		t.Fatal("marshalLockFile:", err)
+	}
+	const wantData = `{
+  "tools": [
+    {
+      "script": "installers/go.star",
+      "version": "1.17.3",
+      "downloads": [
+        {
+          "url": "https://example.com/go.tar.gz",
+          "sha256": "abcd"
+        }
+      ]
+    },
+    {
+      "script": "/opt/node.star",
+      "version": "16.13.0",
+      "downloads": []
+    }
+  ]
+}
+`
+	if filepath.Separator == '/' {
+		if diff := cmp.Diff(wantData, string(data)); diff != "" {
+			t.Errorf("marshalLockFile(...) (-want +got):\n%s", diff)
+		}
+	}
+	got, err := parseLockFile(data, dir)
+	if err != nil {
+		t.Fatal("parseLockFile:", err)
+	}
+	want := &lockFile{Tools: append([]lockedTool(nil), lock.Tools...)}
+	want.Tools[1].Downloads = []lockedDownload{}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("parseLockFile(marshalLockFile(lock)) (-want +got):\n%s", diff)
+	}
+}
+
+func TestDownloadLog(t *testing.T) {
+	t.Run("Unlocked", func(t *testing.T) {
+		dl := new(downloadLog)
+		if sum, err := dl.expect("https://example.com/a.zip"); sum != "" || err != nil {
+			t.Errorf("expect(...) = %q, %v; want \"\", <nil>", sum, err)
+		}
+		dl.record("https://example.com/a.zip", "1111")
+		dl.record("https://example.com/b.zip", "2222")
+		dl.record("https://example.com/a.zip", "3333")
+		want := []lockedDownload{
+			{URL: "https://example.com/a.zip", SHA256: "3333"},
+			{URL: "https://example.com/b.zip", SHA256: "2222"},
+		}
+		if diff := cmp.Diff(want, dl.downloads); diff != "" {
+			t.Errorf("downloads (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("Locked", func(t *testing.T) {
+		pinned := []lockedDownload{{URL: "https://example.com/a.zip", SHA256: "1111"}}
+		dl := &downloadLog{
+			downloads: append([]lockedDownload(nil), pinned...),
+			locked:    true,
+		}
+		if sum, err := dl.expect("https://example.com/a.zip"); sum != "1111" || err != nil {
+			t.Errorf("expect(a.zip) = %q, %v; want \"1111\", <nil>", sum, err)
+		}
+		if _, err := dl.expect("https://example.com/b.zip"); err == nil {
+			t.Error("expect(b.zip) did not return an error")
+		}
+		dl.record("https://example.com/a.zip", "2222")
+		if diff := cmp.Diff(pinned, dl.downloads); diff != "" {
+			t.Errorf("record changed locked downloads (-want +got):\n%s", diff)
+		}
+	})
+}
@@ -33,6 +33,7 @@ import (
 	"go4.org/xdgdir"
 	"golang.org/x/sys/unix"
 	"zombiezen.com/go/biome"
+	"zombiezen.com/go/biome/internal/gitglob"
 	"zombiezen.com/go/log"
 	"zombiezen.com/go/sqlite"
 	"zombiezen.com/go/sqlite/sqlitemigration"
@@ -47,33 +48,52 @@ const (
 const sqliteTimestampFormatMillis = "2006-01-02 15:04:05.999"
 
 func main() {
+	root := newRootCommand()
+	ctx, cancel := signal.NotifyContext(context.Background(), unix.SIGTERM, unix.SIGINT)
+	err := root.ExecuteContext(ctx)
+	cancel()
+	if err != nil {
+		ensureLogger(false)
+		log.Errorf(ctx, "%v", err)
+		os.Exit(1)
+	}
+}
+
+// newRootCommand returns the biome command with all of its subcommands.
+func newRootCommand() *cobra.Command {
 	root := &cobra.Command{
 		Use:           "biome",
 		Short:         "Lightweight dev environments",
 		SilenceErrors: true,
 		SilenceUsage:  true,
+		Version:       versionInfo(),
 	}
+	root.SetVersionTemplate("{{.Version}}")
 	debug := root.PersistentFlags().Bool("debug", false, "show debug logs")
 	root.PersistentPreRun = func(cmd *cobra.Command, args []string) {
 		ensureLogger(*debug)
 	}
 	root.AddCommand(
+		newCompletionCommand(),
+		newCpCommand(),
 		newCreateCommand(),
 		newDestroyCommand(),
+		newDiffCommand(),
+		newExportCommand(),
+		newGenManCommand(),
+		newHistoryCommand(),
+		newImportCommand(),
 		newInstallCommand(),
 		newListCommand(),
 		newPullCommand(),
 		newRunCommand(),
+		newSnapshotCommand(),
+		newTagCommand(),
+		newUpCommand(),
+		newVerifyCommand(),
+		newVersionCommand(),
 	)
-
-	ctx, cancel := signal.NotifyContext(context.Background(), unix.SIGTERM, unix.SIGINT)
-	err := root.ExecuteContext(ctx)
-	cancel()
-	if err != nil {
-		ensureLogger(false)
-		log.Errorf(ctx, "%v", err)
-		os.Exit(1)
-	}
+	return root
 }
 
 var logInit sync.Once
@@ -140,33 +160,46 @@ func openDB(ctx context.Context) (*sqlite.Conn, error) {
 		conn.Close()
 		return nil, fmt.Errorf("open database: %v", err)
 	}
-	err = conn.CreateFunction("pathparentof", &sqlite.FunctionImpl{
-		NArgs:         2,
-		Deterministic: true,
-		Scalar: func(ctx sqlite.Context, args []sqlite.Value) (sqlite.Value, error) {
-			if args[0].Type() == sqlite.TypeNull || args[1].Type() == sqlite.TypeNull {
-				return sqlite.Value{}, nil
-			}
-			parent := filepath.Clean(args[0].Text())
-			child := filepath.Clean(args[1].Text())
-			if parent == child || strings.HasPrefix(child, parent+string(filepath.Separator)) {
-				return sqlite.IntegerValue(1), nil
-			} else {
-				return sqlite.IntegerValue(0), nil
-			}
-		},
-	})
+	schema := loadSchema()
+	// Migrate always writes to the database, which fails while another process
+	// holds a write transaction (like a running install),
+	// so only migrate if the schema is out of date.
+	upToDate, err := schemaUpToDate(conn, schema)
 	if err != nil {
 		conn.Close()
 		return nil, fmt.Errorf("open database: %v", err)
 	}
-	if err := sqlitemigration.Migrate(ctx, conn, loadSchema()); err != nil {
-		conn.Close()
-		return nil, fmt.Errorf("open database: %v", err)
+	if !upToDate {
+		if err := sqlitemigration.Migrate(ctx, conn, schema); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("open database: %v", err)
+		}
 	}
 	return conn, nil
 }
 
+// schemaUpToDate reports whether the database has had
+// all of the schema's migrations applied.
+func schemaUpToDate(conn *sqlite.Conn, schema sqlitemigration.Schema) (bool, error) {
+	var appID int32
+	err := sqlitex.ExecTransient(conn, "PRAGMA application_id;", func(stmt *sqlite.Stmt) error {
+		appID = stmt.ColumnInt32(0)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	var version int
+	err = sqlitex.ExecTransient(conn, "PRAGMA user_version;", func(stmt *sqlite.Stmt) error {
+		version = stmt.ColumnInt(0)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return appID == schema.AppID && version == len(schema.Migrations), nil
+}
+
 //go:embed dbschema/*.sql
 var schemaFiles embed.FS
 
@@ -185,11 +218,44 @@ func loadSchema() sqlitemigration.Schema {
 	return schema
 }
 
+// biomeIDEnvVar is the name of the environment variable
+// that provides the default value of the --biome flag.
+const biomeIDEnvVar = "BIOME_ID"
+
+// addBiomeFlag adds the --biome flag to cmd, storing its value in p.
+// If the flag is not given, it defaults to the value of $BIOME_ID.
+// If neither are set, then findBiome uses the biome for the current directory.
+func addBiomeFlag(cmd *cobra.Command, p *string, usage string) {
+	cmd.Flags().StringVarP(p, "biome", "b", os.Getenv(biomeIDEnvVar), usage+" (defaults to $"+biomeIDEnvVar+")")
+	cmd.RegisterFlagCompletionFunc("biome", completeBiomeID)
+}
+
 type biomeRecord struct {
 	id          string
 	rootHostDir string
 	supportRoot string
 	env         biome.Environment
+
+	// mounted is true if the biome uses rootHostDir directly as its
+	// working directory instead of a copy.
+	mounted bool
+
+	// platform is the OS and architecture that the biome was created for
+	// or nil if the biome uses the host's.
+	platform *biome.Descriptor
+
+	// useGitignore is true if pushing the working directory
+	// also skips files matched by .gitignore files.
+	useGitignore bool
+
+	// exclude is a list of patterns to skip when pushing the working directory.
+	// It is set from command-line flags for a single operation
+	// and is not stored in the database.
+	exclude []gitglob.Pattern
+
+	// include is a list of negated patterns for files to push even if they
+	// would otherwise be ignored. Like exclude, it applies to a single operation.
+	include []gitglob.Pattern
 }
 
 // findBiome fetches the biome record for an ID reference or the empty string.
@@ -200,33 +266,21 @@ func findBiome(conn *sqlite.Conn, arg string) (*biomeRecord, error) {
 		if err != nil {
 			return nil, err
 		}
-		const query = `select "id", "root_host_dir" from "biomes" where pathparentof("root_host_dir", ?) limit 2;`
-		n := 0
-		rec = new(biomeRecord)
-		err = sqlitex.Exec(conn, query, func(stmt *sqlite.Stmt) error {
-			n++
-			rec.id = stmt.ColumnText(0)
-			rec.rootHostDir = stmt.ColumnText(1)
-			return nil
-		}, currDir)
+		rec, err = findBiomeInDir(conn, currDir)
 		if err != nil {
 			return nil, err
 		}
-		if n == 0 {
-			return nil, fmt.Errorf("no biomes in %s", currDir)
-		}
-		if n > 1 {
-			return nil, fmt.Errorf("multiple biomes in %s; use --biome=ID to disambiguate", currDir)
-		}
 	} else {
 		// TODO(soon): Allow prefix of ID.
-		const query = `select "id", "root_host_dir" from "biomes" where "id" = ? limit 1;`
+		const query = `select "id", "root_host_dir", "mounted", "platform", "use_gitignore" from "biomes" where "id" = ? limit 1;`
 		err := sqlitex.Exec(conn, query, func(stmt *sqlite.Stmt) error {
 			rec = &biomeRecord{
-				id:          stmt.ColumnText(0),
-				rootHostDir: stmt.ColumnText(1),
+				id:           stmt.ColumnText(0),
+				rootHostDir:  stmt.ColumnText(1),
+				mounted:      stmt.ColumnInt(2) != 0,
+				useGitignore: stmt.ColumnInt(4) != 0,
 			}
-			return nil
+			return scanPlatform(rec, stmt, 3)
 		}, arg)
 		if err != nil {
 			return nil, err
@@ -248,25 +302,131 @@ func findBiome(conn *sqlite.Conn, arg string) (*biomeRecord, error) {
 	return rec, nil
 }
 
+// findBiomeInDir returns the record of the biome whose root directory
+// contains dir, without reading its environment.
+func findBiomeInDir(conn *sqlite.Conn, dir string) (*biomeRecord, error) {
+	cond, args := rootContainsCondition(dir)
+	query := `select "id", "root_host_dir", "mounted", "platform", "use_gitignore" from "biomes" where ` + cond + ` limit 2;`
+	n := 0
+	rec := new(biomeRecord)
+	err := sqlitex.Exec(conn, query, func(stmt *sqlite.Stmt) error {
+		n++
+		rec.id = stmt.ColumnText(0)
+		rec.rootHostDir = stmt.ColumnText(1)
+		rec.mounted = stmt.ColumnInt(2) != 0
+		rec.useGitignore = stmt.ColumnInt(4) != 0
+		return scanPlatform(rec, stmt, 3)
+	}, args...)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, fmt.Errorf("no biomes in %s", dir)
+	}
+	if n > 1 {
+		return nil, fmt.Errorf("multiple biomes in %s; use --biome=ID to disambiguate", dir)
+	}
+	return rec, nil
+}
+
+// rootContainsCondition returns an SQL condition (and its arguments)
+// that matches the biomes whose root directory is dir or one of its parents.
+// Comparing against each parent directory
+// lets SQLite look up the biomes with the "root_host_dir" index
+// instead of checking every biome.
+func rootContainsCondition(dir string) (string, []interface{}) {
+	var args []interface{}
+	dir = filepath.Clean(dir)
+	for {
+		args = append(args, dir)
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	return `"root_host_dir" in (?` + strings.Repeat(", ?", len(args)-1) + `)`, args
+}
+
+// scanPlatform sets rec.platform from the given "platform" column.
+func scanPlatform(rec *biomeRecord, stmt *sqlite.Stmt, col int) error {
+	if stmt.ColumnType(col) == sqlite.TypeNull {
+		return nil
+	}
+	var err error
+	rec.platform, err = parsePlatform(stmt.ColumnText(col))
+	if err != nil {
+		return fmt.Errorf("biome[id=%q].platform: %w", rec.id, err)
+	}
+	return nil
+}
+
 func (rec *biomeRecord) setup(ctx context.Context, conn *sqlite.Conn) (biome.Biome, error) {
 	bio, err := rec.setupWithoutEnv(ctx, conn)
 	if err != nil {
 		return nil, err
 	}
+	// Tools installed directly into the tools directory's bin subdirectory
+	// are available to every command, but have lower precedence than
+	// anything added by an install script.
+	env, err := expandBiomeEnvironment(bio, toolsEnv(bio).Merge(rec.env))
+	if err != nil {
+		return nil, fmt.Errorf("open biome %s: %v", rec.id, err)
+	}
 	return biome.EnvBiome{
 		Biome: bio,
-		Env:   rec.env,
+		Env:   env,
 	}, nil
 }
 
+// expandBiomeEnvironment expands references to variables in the values
+// of a stored environment's variables that were marked for expansion.
+// Besides the environment's own variables, values may refer to $HOME
+// and to the biome's directories as $BIOME_WORK, $BIOME_HOME,
+// and $BIOME_TOOLS. Other values are used verbatim.
+func expandBiomeEnvironment(bio biome.Biome, env biome.Environment) (biome.Environment, error) {
+	dirs := bio.Dirs()
+	return env.Expand(func(name string) (string, bool) {
+		switch name {
+		case "HOME", "BIOME_HOME":
+			return dirs.Home, true
+		case "BIOME_WORK":
+			return dirs.Work, true
+		case "BIOME_TOOLS":
+			return dirs.Tools, true
+		default:
+			return "", false
+		}
+	})
+}
+
+// toolsEnv returns an environment that places the biome's tools bin directory
+// on the PATH.
+func toolsEnv(bio biome.Biome) biome.Environment {
+	return biome.Environment{
+		PrependPath: []string{biome.JoinPath(bio.Describe(), bio.Dirs().Tools, "bin")},
+	}
+}
+
 func (rec *biomeRecord) setupWithoutEnv(ctx context.Context, conn *sqlite.Conn) (biome.Biome, error) {
-	bio := biome.Local{
-		HomeDir: filepath.Join(rec.supportRoot, "home"),
-		WorkDir: filepath.Join(rec.supportRoot, "work"),
+	if err := markBiomeUsed(conn, rec.id, time.Now()); err != nil {
+		return nil, err
 	}
+	if err := checkPlatform(rec.platform); err != nil {
+		return nil, fmt.Errorf("open biome %s: %v", rec.id, err)
+	}
+	bio := rec.local()
 	if err := os.MkdirAll(bio.HomeDir, 0o744); err != nil {
 		return nil, fmt.Errorf("open biome %s: %v", rec.id, err)
 	}
+	if err := os.MkdirAll(filepath.Join(bio.Dirs().Tools, "bin"), 0o744); err != nil {
+		return nil, fmt.Errorf("open biome %s: %v", rec.id, err)
+	}
+	if rec.mounted {
+		// Mounted biomes run directly in the host directory,
+		// so there's nothing to copy.
+		return bio, nil
+	}
 	if err := os.MkdirAll(bio.WorkDir, 0o744); err != nil {
 		return nil, fmt.Errorf("open biome %s: %v", rec.id, err)
 	}
@@ -276,6 +436,31 @@ func (rec *biomeRecord) setupWithoutEnv(ctx context.Context, conn *sqlite.Conn)
 	return bio, nil
 }
 
+// local returns the biome's directories as a local biome
+// without creating them or copying the working directory.
+func (rec *biomeRecord) local() biome.Local {
+	bio := biome.Local{
+		HomeDir:    filepath.Join(rec.supportRoot, "home"),
+		WorkDir:    filepath.Join(rec.supportRoot, "work"),
+		ToolsDir:   filepath.Join(rec.supportRoot, "tools"),
+		Descriptor: rec.platform,
+	}
+	if rec.mounted {
+		bio.WorkDir = rec.rootHostDir
+	}
+	return bio
+}
+
+// markBiomeUsed sets the biome's last-used time.
+func markBiomeUsed(conn *sqlite.Conn, id string, usedAt time.Time) error {
+	err := sqlitex.Exec(conn, `update "biomes" set "last_used_at" = ? where "id" = ?;`, nil,
+		usedAt.UTC().Format(sqliteTimestampFormatMillis), id)
+	if err != nil {
+		return fmt.Errorf("mark biome %s used: %v", id, err)
+	}
+	return nil
+}
+
 // computeSupportRoot returns the cache directory that contains the biome's
 // supporting files.
 func computeSupportRoot(id string) (string, error) {
@@ -301,13 +486,20 @@ func readBiomeEnvironment(conn *sqlite.Conn, id string) (e biome.Environment, er
 	}()
 	defer sqlitex.Save(conn)(&err)
 
-	const varQuery = `select "name", "value" from "env_vars" where "biome_id" = ?;`
+	const varQuery = `select "name", "value", "expand" from "env_vars" where "biome_id" = ?;`
 	e = biome.Environment{}
 	err = sqlitex.ExecTransient(conn, varQuery, func(stmt *sqlite.Stmt) error {
 		if e.Vars == nil {
 			e.Vars = make(map[string]string)
 		}
-		e.Vars[stmt.ColumnText(0)] = stmt.ColumnText(1)
+		name := stmt.ColumnText(0)
+		e.Vars[name] = stmt.ColumnText(1)
+		if stmt.ColumnInt(2) != 0 {
+			if e.ExpandVars == nil {
+				e.ExpandVars = make(map[string]bool)
+			}
+			e.ExpandVars[name] = true
+		}
 		return nil
 	}, id)
 	if err != nil {
@@ -357,6 +549,9 @@ func writeBiomeEnvironment(conn *sqlite.Conn, id string, e biome.Environment) (e
 			err = fmt.Errorf("write biome %q environment: %w", id, err)
 		}
 	}()
+	if err := e.Validate(); err != nil {
+		return err
+	}
 	defer sqlitex.Save(conn)(&err)
 
 	err = sqlitex.ExecTransient(conn, `delete from "env_vars" where "biome_id" = ?;`, nil, id)
@@ -368,11 +563,12 @@ func writeBiomeEnvironment(conn *sqlite.Conn, id string, e biome.Environment) (e
 		return err
 	}
 
-	insertVarStmt := conn.Prep(`insert into "env_vars" ("biome_id", "name", "value") values (?, ?, ?);`)
+	insertVarStmt := conn.Prep(`insert into "env_vars" ("biome_id", "name", "value", "expand") values (?, ?, ?, ?);`)
 	insertVarStmt.BindText(1, id)
 	for k, v := range e.Vars {
 		insertVarStmt.BindText(2, k)
 		insertVarStmt.BindText(3, v)
+		insertVarStmt.BindBool(4, e.ExpandVars[k])
 		if _, err := insertVarStmt.Step(); err != nil {
 			return fmt.Errorf("set %s: %w", k, err)
 		}
@@ -401,7 +597,7 @@ func writeBiomeEnvironment(conn *sqlite.Conn, id string, e biome.Environment) (e
 	if err := insertPathParts(e.PrependPath); err != nil {
 		return err
 	}
-	insertPathPartStmt.BindText(3, "append")
+	insertPathPartStmt.BindText(2, "append")
 	if err := insertPathParts(e.AppendPath); err != nil {
 		return err
 	}
@@ -0,0 +1,282 @@
+// Copyright 2021 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		 https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/spf13/cobra"
+	"zombiezen.com/go/biome"
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+func TestFindBiomeInDir(t *testing.T) {
+	db := openTestDB(t)
+	root := t.TempDir()
+	biomes := map[string]string{
+		"aaaa": filepath.Join(root, "foo"),
+		"bbbb": filepath.Join(root, "foo", "bar"),
+		"cccc": filepath.Join(root, "foobar"),
+		"dddd": filepath.Join(root, "twin"),
+		"eeee": filepath.Join(root, "twin"),
+	}
+	for id, dir := range biomes {
+		err := sqlitex.Exec(db, `insert into "biomes" ("id", "root_host_dir") values (?, ?);`, nil, id, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		dir    string
+		wantID string
+	}{
+		{dir: filepath.Join(root, "foo"), wantID: "aaaa"},
+		{dir: filepath.Join(root, "foo", "baz", "quux"), wantID: "aaaa"},
+		// Nested biomes are ambiguous.
+		{dir: filepath.Join(root, "foo", "bar"), wantID: ""},
+		{dir: filepath.Join(root, "foo", "bar", "baz"), wantID: ""},
+		{dir: filepath.Join(root, "foobar", "baz"), wantID: "cccc"},
+		{dir: filepath.Join(root, "foo") + string(filepath.Separator), wantID: "aaaa"},
+		{dir: root, wantID: ""},
+		{dir: filepath.Join(root, "fo"), wantID: ""},
+		{dir: filepath.Join(root, "twin"), wantID: ""},
+	}
+	for _, test := range tests {
+		rec, err := findBiomeInDir(db, test.dir)
+		if test.wantID == "" {
+			if err == nil {
+				t.Errorf("findBiomeInDir(db, %q) = %q, <nil>; want error", test.dir, rec.id)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("findBiomeInDir(db, %q): %v", test.dir, err)
+			continue
+		}
+		if rec.id != test.wantID {
+			t.Errorf("findBiomeInDir(db, %q) = %q; want %q", test.dir, rec.id, test.wantID)
+		}
+	}
+}
+
+func BenchmarkFindBiomeInDir(b *testing.B) {
+	db := openTestDB(b)
+	root := b.TempDir()
+	const numBiomes = 5000
+	err := func() (err error) {
+		defer sqlitex.Save(db)(&err)
+		for i := 0; i < numBiomes; i++ {
+			dir := filepath.Join(root, fmt.Sprintf("project%04d", i))
+			err := sqlitex.Exec(db, `insert into "biomes" ("id", "root_host_dir") values (?, ?);`, nil,
+				fmt.Sprintf("%032x", i), dir)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}()
+	if err != nil {
+		b.Fatal(err)
+	}
+	dir := filepath.Join(root, fmt.Sprintf("project%04d", numBiomes/2), "src", "pkg")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := findBiomeInDir(db, dir); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestSetupEnvironmentWithDollarSigns(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	const id = "aaaa"
+	err := sqlitex.Exec(db, `insert into "biomes" ("id", "root_host_dir", "mounted") values (?, ?, 1);`, nil, id, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Variables stored before expansion existed don't set the "expand" column.
+	vars := map[string]string{
+		"PS1": `\u$ `,
+		"X":   "a$b",
+		"Y":   "$HOME",
+	}
+	for k, v := range vars {
+		err := sqlitex.Exec(db, `insert into "env_vars" ("biome_id", "name", "value") values (?, ?, ?);`, nil, id, k, v)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = sqlitex.Exec(db, `insert into "env_vars" ("biome_id", "name", "value", "expand") values (?, 'GOPATH', '$HOME/go', 1);`, nil, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec, err := findBiome(db, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bio, err := rec.setup(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := new(strings.Builder)
+	err = bio.Run(ctx, &biome.Invocation{
+		Argv:   []string{"sh", "-c", `printf '%s|%s|%s|%s' "$PS1" "$X" "$Y" "$GOPATH"`},
+		Stdout: stdout,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `\u$ |a$b|$HOME|` + bio.Dirs().Home + "/go"
+	if got := stdout.String(); got != want {
+		t.Errorf("variables = %q; want %q", got, want)
+	}
+}
+
+func TestRunMarksBiomeUsed(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	const id = "aaaa"
+	err := sqlitex.Exec(db, `insert into "biomes" ("id", "root_host_dir", "mounted") values (?, ?, 1);`, nil, id, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	readLastUsed := func() string {
+		var lastUsed string
+		err := sqlitex.Exec(db, `select "last_used_at" from "biomes" where "id" = ?;`, func(stmt *sqlite.Stmt) error {
+			lastUsed = stmt.ColumnText(0)
+			return nil
+		}, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return lastUsed
+	}
+	if got := readLastUsed(); got != "" {
+		t.Fatalf("last_used_at = %q before running; want NULL", got)
+	}
+
+	before := time.Now().UTC().Truncate(time.Millisecond)
+	if err := (&runCommand{biomeID: id, argv: []string{"true"}}).run(ctx); err != nil {
+		t.Fatal("run:", err)
+	}
+	after := time.Now().UTC()
+	got, err := time.Parse(sqliteTimestampFormatMillis, readLastUsed())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Before(before) || got.After(after) {
+		t.Errorf("last_used_at = %v; want between %v and %v", got, before, after)
+	}
+}
+
+func TestBiomeRecordLocal(t *testing.T) {
+	supportRoot := t.TempDir()
+	rootHostDir := t.TempDir()
+	tests := []struct {
+		name    string
+		mounted bool
+		want    biome.Dirs
+	}{
+		{
+			name: "Copied",
+			want: biome.Dirs{
+				Work:  filepath.Join(supportRoot, "work"),
+				Home:  filepath.Join(supportRoot, "home"),
+				Tools: filepath.Join(supportRoot, "tools"),
+			},
+		},
+		{
+			name:    "Mounted",
+			mounted: true,
+			want: biome.Dirs{
+				Work:  rootHostDir,
+				Home:  filepath.Join(supportRoot, "home"),
+				Tools: filepath.Join(supportRoot, "tools"),
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := &biomeRecord{
+				id:          "abcd",
+				rootHostDir: rootHostDir,
+				supportRoot: supportRoot,
+				mounted:     test.mounted,
+			}
+			l := rec.local()
+			if diff := cmp.Diff(&test.want, l.Dirs()); diff != "" {
+				t.Errorf("rec.local().Dirs() (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestBiomeFlagDefault(t *testing.T) {
+	t.Setenv(biomeIDEnvVar, "abcd")
+	commands := map[string]func() *cobra.Command{
+		"destroy": newDestroyCommand,
+		"install": newInstallCommand,
+		"pull":    newPullCommand,
+		"run":     newRunCommand,
+	}
+	for name, newCommand := range commands {
+		t.Run(name, func(t *testing.T) {
+			tests := []struct {
+				args []string
+				want string
+			}{
+				{args: nil, want: "abcd"},
+				{args: []string{"--biome=efgh"}, want: "efgh"},
+			}
+			for _, test := range tests {
+				cmd := newCommand()
+				if err := cmd.ParseFlags(test.args); err != nil {
+					t.Fatal(err)
+				}
+				if got := cmd.Flags().Lookup("biome").Value.String(); got != test.want {
+					t.Errorf("after parsing %q, --biome = %q; want %q", test.args, got, test.want)
+				}
+			}
+		})
+	}
+}
+
+// openTestDB opens a new biome database for the duration of the test.
+func openTestDB(tb testing.TB) *sqlite.Conn {
+	tb.Setenv("XDG_CACHE_HOME", tb.TempDir())
+	tb.Setenv("XDG_CONFIG_HOME", tb.TempDir())
+	db, err := openDB(context.Background())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			tb.Error(err)
+		}
+	})
+	return db
+}
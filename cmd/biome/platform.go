@@ -0,0 +1,67 @@
+// Copyright 2021 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		 https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"zombiezen.com/go/biome"
+)
+
+// parsePlatform parses an "OS/ARCH" string like "linux/amd64".
+// The operating system and architecture may use any name that
+// biome.NormalizeOS and biome.NormalizeArch recognize.
+func parsePlatform(s string) (*biome.Descriptor, error) {
+	i := strings.IndexByte(s, '/')
+	if i < 0 {
+		return nil, fmt.Errorf("platform %q must be in the form OS/ARCH", s)
+	}
+	desc := &biome.Descriptor{
+		OS:   biome.NormalizeOS(s[:i]),
+		Arch: biome.NormalizeArch(s[i+1:]),
+	}
+	switch desc.OS {
+	case biome.Linux, biome.MacOS, biome.Windows:
+	default:
+		return nil, fmt.Errorf("platform %q: unknown operating system %q", s, s[:i])
+	}
+	switch desc.Arch {
+	case biome.Intel64, biome.Intel32, biome.ARM64:
+	default:
+		return nil, fmt.Errorf("platform %q: unknown architecture %q", s, s[i+1:])
+	}
+	return desc, nil
+}
+
+// formatPlatform formats a descriptor as an "OS/ARCH" string.
+func formatPlatform(desc *biome.Descriptor) string {
+	return desc.NativeOS() + "/" + desc.NativeArch()
+}
+
+// checkPlatform returns an error if the host cannot run programs
+// for the given platform. A nil platform is always permitted.
+func checkPlatform(platform *biome.Descriptor) error {
+	if platform == nil {
+		return nil
+	}
+	host := biome.Local{}.Describe()
+	if !host.Compatible(platform) {
+		return fmt.Errorf("%s biomes cannot run on this %s host", formatPlatform(platform), formatPlatform(host))
+	}
+	return nil
+}
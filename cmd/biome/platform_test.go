@@ -0,0 +1,73 @@
+// Copyright 2021 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		 https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"runtime"
+	"testing"
+
+	"zombiezen.com/go/biome"
+)
+
+func TestParsePlatform(t *testing.T) {
+	tests := []struct {
+		s    string
+		want *biome.Descriptor
+	}{
+		{s: "linux/amd64", want: &biome.Descriptor{OS: biome.Linux, Arch: biome.Intel64}},
+		{s: "windows/amd64", want: &biome.Descriptor{OS: biome.Windows, Arch: biome.Intel64}},
+		{s: "darwin/arm64", want: &biome.Descriptor{OS: biome.MacOS, Arch: biome.ARM64}},
+		{s: "Linux/x86_64", want: &biome.Descriptor{OS: biome.Linux, Arch: biome.Intel64}},
+		{s: "linux/i686", want: &biome.Descriptor{OS: biome.Linux, Arch: biome.Intel32}},
+		{s: "", want: nil},
+		{s: "linux", want: nil},
+		{s: "linux/", want: nil},
+		{s: "/amd64", want: nil},
+		{s: "plan9/amd64", want: nil},
+		{s: "linux/riscv64", want: nil},
+		{s: "linux/amd64/v3", want: nil},
+	}
+	for _, test := range tests {
+		got, err := parsePlatform(test.s)
+		if test.want == nil {
+			if err == nil {
+				t.Errorf("parsePlatform(%q) = %+v, <nil>; want error", test.s, got)
+			}
+			continue
+		}
+		if err != nil || !got.Equal(test.want) || got.Arch != test.want.Arch {
+			t.Errorf("parsePlatform(%q) = %+v, %v; want %+v, <nil>", test.s, got, err, test.want)
+		}
+	}
+}
+
+func TestCheckPlatform(t *testing.T) {
+	if err := checkPlatform(nil); err != nil {
+		t.Errorf("checkPlatform(nil) = %v; want <nil>", err)
+	}
+	host := &biome.Descriptor{OS: runtime.GOOS, Arch: runtime.GOARCH}
+	if err := checkPlatform(host); err != nil {
+		t.Errorf("checkPlatform(%+v) = %v; want <nil>", host, err)
+	}
+	other := &biome.Descriptor{OS: biome.Windows, Arch: runtime.GOARCH}
+	if runtime.GOOS == biome.Windows {
+		other.OS = biome.Linux
+	}
+	if err := checkPlatform(other); err == nil {
+		t.Errorf("checkPlatform(%+v) = <nil>; want error", other)
+	}
+}
@@ -50,7 +50,7 @@ func newPullCommand() *cobra.Command {
 			return c.run(cmd.Context())
 		},
 	}
-	cmd.Flags().StringVarP(&c.biomeID, "biome", "b", "", "biome to run inside")
+	addBiomeFlag(cmd, &c.biomeID, "biome to pull from")
 	return cmd
 }
 
@@ -81,6 +81,10 @@ func (c *pullCommand) run(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	if rec.mounted {
+		log.Infof(ctx, "Biome %s is mounted at %s; files are already in place", rec.id, rec.rootHostDir)
+		return nil
+	}
 
 	// Create zip file of requested files and directories.
 	zipName, err := genHexDigits(8)
@@ -20,15 +20,19 @@ import (
 	"context"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/spf13/cobra"
 	"golang.org/x/term"
 	"zombiezen.com/go/biome"
+	"zombiezen.com/go/log"
 	"zombiezen.com/go/sqlite/sqlitex"
 )
 
 type runCommand struct {
 	biomeID string
+	exclude []string
+	include []string
 	argv    []string
 }
 
@@ -46,14 +50,24 @@ func newRunCommand() *cobra.Command {
 			return c.run(cmd.Context())
 		},
 	}
-	cmd.Flags().StringVarP(&c.biomeID, "biome", "b", "", "biome to run inside")
+	addBiomeFlag(cmd, &c.biomeID, "biome to run inside")
+	cmd.Flags().StringArrayVar(&c.exclude, "exclude", nil, "skip files matching a .biomeignore-style `pattern` when copying (can be repeated)")
+	cmd.Flags().StringArrayVar(&c.include, "include", nil, "copy files matching a .biomeignore-style `pattern` even if ignored or excluded (can be repeated)")
 	return cmd
 }
 
 func (c *runCommand) run(ctx context.Context) error {
+	exclude, err := parsePatternFlags("exclude", c.exclude, false)
+	if err != nil {
+		return err
+	}
+	include, err := parsePatternFlags("include", c.include, true)
+	if err != nil {
+		return err
+	}
 	var rec *biomeRecord
 	var bio biome.Biome
-	err := func() (err error) {
+	err = func() (err error) {
 		db, err := openDB(ctx)
 		if err != nil {
 			return err
@@ -68,6 +82,8 @@ func (c *runCommand) run(ctx context.Context) error {
 		if err != nil {
 			return err
 		}
+		rec.exclude = exclude
+		rec.include = include
 		bio, err = rec.setup(ctx, db)
 		if err != nil {
 			return err
@@ -91,7 +107,8 @@ func (c *runCommand) run(ctx context.Context) error {
 	}
 
 	// TODO(soon): Exit with same exit code.
-	return bio.Run(ctx, &biome.Invocation{
+	startedAt := time.Now()
+	runErr := bio.Run(ctx, &biome.Invocation{
 		Argv:        c.argv,
 		Dir:         relDir,
 		Stdin:       os.Stdin,
@@ -99,4 +116,19 @@ func (c *runCommand) run(ctx context.Context) error {
 		Stderr:      os.Stderr,
 		Interactive: term.IsTerminal(int(os.Stdin.Fd())),
 	})
+
+	// Record the command even if it was interrupted.
+	db, err := openDB(context.Background())
+	if err != nil {
+		log.Warnf(ctx, "Record history: %v", err)
+		return runErr
+	}
+	defer db.Close()
+	recordHistory(ctx, db, rec.id, &historyEntry{
+		startedAt: startedAt,
+		command:   "run",
+		argv:      c.argv,
+		exitCode:  runExitCode(runErr),
+	})
+	return runErr
 }
@@ -0,0 +1,581 @@
+// Copyright 2021 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		 https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"zombiezen.com/go/log"
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+// snapshotsDirName is the name of the directory inside a biome's support root
+// that holds its snapshots.
+const snapshotsDirName = "snapshots"
+
+var snapshotNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+func newSnapshotCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "snapshot",
+		Short: "save and restore the state of a biome",
+		Long: "Save and restore the state of a biome.\n\n" +
+			"A snapshot holds the biome's files along with its environment " +
+			"and install history.",
+		SilenceErrors: true,
+		SilenceUsage:  true,
+	}
+	cmd.AddCommand(
+		newSnapshotCreateCommand(),
+		newSnapshotDeleteCommand(),
+		newSnapshotListCommand(),
+		newSnapshotRestoreCommand(),
+	)
+	return cmd
+}
+
+type snapshotCreateCommand struct {
+	biomeID string
+	name    string
+	link    bool
+}
+
+func newSnapshotCreateCommand() *cobra.Command {
+	c := new(snapshotCreateCommand)
+	cmd := &cobra.Command{
+		Use:                   "create [options] [NAME]",
+		DisableFlagsInUseLine: true,
+		Short:                 "save the state of a biome",
+		Long: "Save the state of a biome and print the snapshot's name.\n\n" +
+			"With --link, files are hard-linked into the snapshot where possible " +
+			"instead of copied. This is much cheaper for large biomes, " +
+			"but a program that modifies a file in place instead of replacing it " +
+			"(like a shell redirection) also modifies the snapshot.",
+		Args:          cobra.MaximumNArgs(1),
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 0 {
+				c.name = args[0]
+			}
+			return c.run(cmd.Context())
+		},
+	}
+	addBiomeFlag(cmd, &c.biomeID, "biome to snapshot")
+	cmd.Flags().BoolVar(&c.link, "link", false, "hard-link files instead of copying them (in-place edits to linked files then also modify the snapshot)")
+	return cmd
+}
+
+func (c *snapshotCreateCommand) run(ctx context.Context) (err error) {
+	now := time.Now()
+	name := c.name
+	if name == "" {
+		name = now.UTC().Format("20060102T150405Z")
+	}
+	if !snapshotNamePattern.MatchString(name) {
+		return fmt.Errorf("snapshot create: invalid name %q (must only contain letters, digits, '.', '_', or '-')", name)
+	}
+	db, err := openDB(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// Hold the install lock for the whole create
+	// so that no install modifies the files being copied.
+	rec, err := findBiome(db, c.biomeID)
+	if err != nil {
+		return fmt.Errorf("snapshot create: %v", err)
+	}
+	unlock, err := lockInstall(ctx, rec.supportRoot)
+	if err != nil {
+		return fmt.Errorf("snapshot create %s: %v", rec.id, err)
+	}
+	defer unlock()
+
+	endFn, err := sqlitex.ImmediateTransaction(db)
+	if err != nil {
+		return fmt.Errorf("snapshot create: %v", err)
+	}
+	defer endFn(&err)
+	rec, err = findBiome(db, c.biomeID)
+	if err != nil {
+		return fmt.Errorf("snapshot create: %v", err)
+	}
+	meta, err := readExportMetadata(db, rec)
+	if err != nil {
+		return fmt.Errorf("snapshot create %s: %v", rec.id, err)
+	}
+	envData, err := json.Marshal(meta.Env)
+	if err != nil {
+		return fmt.Errorf("snapshot create %s: %v", rec.id, err)
+	}
+	installsData, err := json.Marshal(meta.Installs)
+	if err != nil {
+		return fmt.Errorf("snapshot create %s: %v", rec.id, err)
+	}
+	versionsData := []byte("{}")
+	if len(meta.Versions) > 0 {
+		versionsData, err = json.Marshal(meta.Versions)
+		if err != nil {
+			return fmt.Errorf("snapshot create %s: %v", rec.id, err)
+		}
+	}
+	err = sqlitex.Exec(db, `insert into "biome_snapshots" ("biome_id", "name", "created_at", "env", "installs", "tool_versions") values (?, ?, ?, ?, ?, ?);`, nil,
+		rec.id, name, now.UTC().Format(sqliteTimestampFormatMillis), string(envData), string(installsData), string(versionsData))
+	if sqlite.ErrCode(err) == sqlite.ResultConstraintPrimaryKey {
+		return fmt.Errorf("snapshot create %s: snapshot %q already exists", rec.id, name)
+	}
+	if err != nil {
+		return fmt.Errorf("snapshot create %s: %v", rec.id, err)
+	}
+
+	snapDir := filepath.Join(rec.supportRoot, snapshotsDirName, name)
+	if err := os.MkdirAll(snapDir, 0o777); err != nil {
+		return fmt.Errorf("snapshot create %s: %v", rec.id, err)
+	}
+	if err := copySupportRoot(snapDir, rec.supportRoot, c.link); err != nil {
+		if rmErr := removeAll(ctx, snapDir); rmErr != nil {
+			err = fmt.Errorf("%v (and failed to clean up: %v)", err, rmErr)
+		}
+		return fmt.Errorf("snapshot create %s: %v", rec.id, err)
+	}
+	fmt.Println(name)
+	return nil
+}
+
+type snapshotRestoreCommand struct {
+	biomeID string
+	name    string
+	link    bool
+}
+
+func newSnapshotRestoreCommand() *cobra.Command {
+	c := new(snapshotRestoreCommand)
+	cmd := &cobra.Command{
+		Use:                   "restore [options] NAME",
+		DisableFlagsInUseLine: true,
+		Short:                 "roll a biome back to a snapshot",
+		Long: "Roll a biome back to a snapshot, replacing its files, environment, " +
+			"and install history. The snapshot is kept, so it can be restored again.",
+		Args:          cobra.ExactArgs(1),
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c.name = args[0]
+			return c.run(cmd.Context())
+		},
+	}
+	addBiomeFlag(cmd, &c.biomeID, "biome to restore")
+	cmd.Flags().BoolVar(&c.link, "link", false, "hard-link files instead of copying them (in-place edits to linked files then also modify the snapshot)")
+	return cmd
+}
+
+func (c *snapshotRestoreCommand) run(ctx context.Context) (err error) {
+	db, err := openDB(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// Hold the install lock for the whole restore
+	// so that no install modifies the files being replaced.
+	rec, err := findBiome(db, c.biomeID)
+	if err != nil {
+		return fmt.Errorf("snapshot restore: %v", err)
+	}
+	unlock, err := lockInstall(ctx, rec.supportRoot)
+	if err != nil {
+		return fmt.Errorf("snapshot restore %s: %v", rec.id, err)
+	}
+	defer unlock()
+
+	endFn, err := sqlitex.ImmediateTransaction(db)
+	if err != nil {
+		return fmt.Errorf("snapshot restore: %v", err)
+	}
+	defer endFn(&err)
+	rec, err = findBiome(db, c.biomeID)
+	if err != nil {
+		return fmt.Errorf("snapshot restore: %v", err)
+	}
+	var env exportEnvironment
+	var installs []exportInstall
+	var versions map[string]string
+	found := false
+	err = sqlitex.Exec(db, `select "env", "installs", "tool_versions" from "biome_snapshots" where "biome_id" = ? and "name" = ?;`, func(stmt *sqlite.Stmt) error {
+		found = true
+		if err := json.Unmarshal([]byte(stmt.ColumnText(0)), &env); err != nil {
+			return fmt.Errorf("env: %v", err)
+		}
+		if err := json.Unmarshal([]byte(stmt.ColumnText(1)), &installs); err != nil {
+			return fmt.Errorf("installs: %v", err)
+		}
+		if err := json.Unmarshal([]byte(stmt.ColumnText(2)), &versions); err != nil {
+			return fmt.Errorf("tool_versions: %v", err)
+		}
+		return nil
+	}, rec.id, c.name)
+	if err != nil {
+		return fmt.Errorf("snapshot restore %s: %v", rec.id, err)
+	}
+	if !found {
+		return fmt.Errorf("snapshot restore %s: no snapshot %q", rec.id, c.name)
+	}
+
+	// Copy the snapshot next to the live files first,
+	// so that a failed copy leaves the biome untouched.
+	staging, err := os.MkdirTemp(rec.supportRoot, restoreTempPrefix)
+	if err != nil {
+		return fmt.Errorf("snapshot restore %s: %v", rec.id, err)
+	}
+	defer func() {
+		if rmErr := removeAll(ctx, staging); rmErr != nil {
+			log.Warnf(ctx, "Cleaning up %s: %v", staging, rmErr)
+		}
+	}()
+	snapDir := filepath.Join(rec.supportRoot, snapshotsDirName, c.name)
+	if err := copySupportRoot(staging, snapDir, c.link); err != nil {
+		return fmt.Errorf("snapshot restore %s: %v", rec.id, err)
+	}
+
+	if err := writeBiomeEnvironment(db, rec.id, env.relocate(rec.supportRoot)); err != nil {
+		return fmt.Errorf("snapshot restore %s: %v", rec.id, err)
+	}
+	err = sqlitex.Exec(db, `delete from "biome_installs" where "biome_id" = ?;`, nil, rec.id)
+	if err != nil {
+		return fmt.Errorf("snapshot restore %s: %v", rec.id, err)
+	}
+	for _, inst := range installs {
+		if err := recordInstall(db, rec.id, inst.Script, inst.Version, inst.InstalledAt); err != nil {
+			return fmt.Errorf("snapshot restore %s: %v", rec.id, err)
+		}
+	}
+	err = sqlitex.Exec(db, `delete from "biome_tool_versions" where "biome_id" = ?;`, nil, rec.id)
+	if err != nil {
+		return fmt.Errorf("snapshot restore %s: %v", rec.id, err)
+	}
+	if err := writeToolVersions(db, rec.id, versions); err != nil {
+		return fmt.Errorf("snapshot restore %s: %v", rec.id, err)
+	}
+	// The working directory no longer matches what was last copied into it,
+	// so forget the recorded files to copy everything on the next run.
+	err = sqlitex.Exec(db, `delete from "local_files" where "biome_id" = ?;`, nil, rec.id)
+	if err != nil {
+		return fmt.Errorf("snapshot restore %s: %v", rec.id, err)
+	}
+
+	if err := swapSupportRoot(ctx, rec.supportRoot, staging); err != nil {
+		return fmt.Errorf("snapshot restore %s: %v", rec.id, err)
+	}
+	return nil
+}
+
+type snapshotListCommand struct {
+	biomeID string
+}
+
+func newSnapshotListCommand() *cobra.Command {
+	c := new(snapshotListCommand)
+	cmd := &cobra.Command{
+		Use:                   "list [options]",
+		DisableFlagsInUseLine: true,
+		Aliases:               []string{"ls"},
+		Short:                 "list a biome's snapshots",
+		Args:                  cobra.NoArgs,
+		SilenceErrors:         true,
+		SilenceUsage:          true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.run(cmd.Context())
+		},
+	}
+	addBiomeFlag(cmd, &c.biomeID, "biome whose snapshots to list")
+	return cmd
+}
+
+func (c *snapshotListCommand) run(ctx context.Context) (err error) {
+	db, err := openDB(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	rec, err := findBiome(db, c.biomeID)
+	if err != nil {
+		return fmt.Errorf("snapshot list: %v", err)
+	}
+	const query = `select "name", "created_at" from "biome_snapshots" where "biome_id" = ? order by "created_at" desc, "name";`
+	err = sqlitex.Exec(db, query, func(stmt *sqlite.Stmt) error {
+		name := stmt.ColumnText(0)
+		createdAt, err := time.Parse(sqliteTimestampFormatMillis, stmt.ColumnText(1))
+		if err != nil {
+			return fmt.Errorf("snapshot[%q].created_at: %w", name, err)
+		}
+		_, err = fmt.Printf("%s\t%s\n", name, createdAt.Local().Format(time.RFC3339))
+		return err
+	}, rec.id)
+	if err != nil {
+		return fmt.Errorf("snapshot list %s: %v", rec.id, err)
+	}
+	return nil
+}
+
+type snapshotDeleteCommand struct {
+	biomeID string
+	names   []string
+}
+
+func newSnapshotDeleteCommand() *cobra.Command {
+	c := new(snapshotDeleteCommand)
+	cmd := &cobra.Command{
+		Use:                   "delete [options] NAME [...]",
+		DisableFlagsInUseLine: true,
+		Aliases:               []string{"rm"},
+		Short:                 "delete snapshots",
+		Args:                  cobra.MinimumNArgs(1),
+		SilenceErrors:         true,
+		SilenceUsage:          true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c.names = args
+			return c.run(cmd.Context())
+		},
+	}
+	addBiomeFlag(cmd, &c.biomeID, "biome whose snapshots to delete")
+	return cmd
+}
+
+func (c *snapshotDeleteCommand) run(ctx context.Context) (err error) {
+	db, err := openDB(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	endFn, err := sqlitex.ImmediateTransaction(db)
+	if err != nil {
+		return fmt.Errorf("snapshot delete: %v", err)
+	}
+	defer endFn(&err)
+	rec, err := findBiome(db, c.biomeID)
+	if err != nil {
+		return fmt.Errorf("snapshot delete: %v", err)
+	}
+	for _, name := range c.names {
+		err := sqlitex.Exec(db, `delete from "biome_snapshots" where "biome_id" = ? and "name" = ?;`, nil, rec.id, name)
+		if err != nil {
+			return fmt.Errorf("snapshot delete %s: %v", rec.id, err)
+		}
+		if db.Changes() == 0 {
+			return fmt.Errorf("snapshot delete %s: no snapshot %q", rec.id, name)
+		}
+		if err := removeAll(ctx, filepath.Join(rec.supportRoot, snapshotsDirName, name)); err != nil {
+			return fmt.Errorf("snapshot delete %s: %v", rec.id, err)
+		}
+	}
+	return nil
+}
+
+// copySupportRoot copies the contents of the support root src into dst,
+// skipping the snapshots directory and install lock. If link is true, then
+// regular files are hard-linked instead of copied where possible.
+func copySupportRoot(dst, src string, link bool) error {
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, ent := range entries {
+		if !isSupportRootContent(ent.Name()) {
+			continue
+		}
+		err := copyTree(filepath.Join(dst, ent.Name()), filepath.Join(src, ent.Name()), link)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreTempPrefix is the prefix of the temporary directories
+// inside a support root that snapshot restore swaps files through.
+const restoreTempPrefix = ".restore-"
+
+// isSupportRootContent reports whether the entry in a support root
+// with the given name is part of the biome's files,
+// as opposed to its snapshots, install lock, or restore temporaries.
+func isSupportRootContent(name string) bool {
+	return name != snapshotsDirName && name != installLockName &&
+		!strings.HasPrefix(name, restoreTempPrefix)
+}
+
+// swapSupportRoot replaces the files in supportRoot with the files in
+// staging by renaming, leaving staging empty. If a rename fails,
+// swapSupportRoot moves the original files back before returning the error.
+func swapSupportRoot(ctx context.Context, supportRoot, staging string) (err error) {
+	old, err := os.MkdirTemp(supportRoot, restoreTempPrefix)
+	if err != nil {
+		return err
+	}
+	// keepOld is set if rolling back fails, since old then holds
+	// the only remaining copy of some of the original files.
+	keepOld := false
+	defer func() {
+		if keepOld {
+			return
+		}
+		if rmErr := removeAll(ctx, old); rmErr != nil {
+			log.Warnf(ctx, "Cleaning up %s: %v", old, rmErr)
+		}
+	}()
+	// moveEntries renames every file in src into dst,
+	// returning the names it moved.
+	moveEntries := func(dst, src string) ([]string, error) {
+		entries, err := os.ReadDir(src)
+		if err != nil {
+			return nil, err
+		}
+		var moved []string
+		for _, ent := range entries {
+			if !isSupportRootContent(ent.Name()) {
+				continue
+			}
+			if err := os.Rename(filepath.Join(src, ent.Name()), filepath.Join(dst, ent.Name())); err != nil {
+				return moved, err
+			}
+			moved = append(moved, ent.Name())
+		}
+		return moved, nil
+	}
+	// rollback renames the named files in src back into dst,
+	// reporting whether every rename succeeded.
+	rollback := func(dst, src string, names []string) bool {
+		ok := true
+		for _, name := range names {
+			if err := os.Rename(filepath.Join(src, name), filepath.Join(dst, name)); err != nil {
+				log.Errorf(ctx, "Restoring %s: %v", filepath.Join(dst, name), err)
+				ok = false
+			}
+		}
+		return ok
+	}
+
+	movedOld, err := moveEntries(old, supportRoot)
+	if err != nil {
+		if !rollback(supportRoot, old, movedOld) {
+			keepOld = true
+			return fmt.Errorf("%w (original files left in %s)", err, old)
+		}
+		return err
+	}
+	movedNew, err := moveEntries(supportRoot, staging)
+	if err != nil {
+		rollback(staging, supportRoot, movedNew)
+		if !rollback(supportRoot, old, movedOld) {
+			keepOld = true
+			return fmt.Errorf("%w (original files left in %s)", err, old)
+		}
+		return err
+	}
+	return nil
+}
+
+// copyTree recursively copies the file or directory src to dst, which must
+// not exist. Directories are created, symbolic links are recreated with the
+// same target, and regular files are hard-linked if link is true or copied
+// otherwise (or if hard-linking fails). Other kinds of files are skipped.
+func copyTree(dst, src string, link bool) error {
+	type dirMode struct {
+		path string
+		mode fs.FileMode
+	}
+	var dirModes []dirMode
+	err := filepath.WalkDir(src, func(path string, ent fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := ent.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case info.IsDir():
+			// Make the directory writable while copying,
+			// then apply its mode after its contents are copied.
+			dirModes = append(dirModes, dirMode{target, info.Mode().Perm()})
+			return os.Mkdir(target, 0o700)
+		case info.Mode()&fs.ModeSymlink != 0:
+			linkTarget, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(linkTarget, target)
+		case info.Mode().IsRegular():
+			if link && os.Link(path, target) == nil {
+				return nil
+			}
+			return copyFile(target, path, info)
+		default:
+			return nil
+		}
+	})
+	if err != nil {
+		return err
+	}
+	for i := len(dirModes) - 1; i >= 0; i-- {
+		if err := os.Chmod(dirModes[i].path, dirModes[i].mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFile copies the regular file src to dst,
+// preserving its mode and modification time.
+func copyFile(dst, src string, info fs.FileInfo) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	closeErr := w.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	return os.Chtimes(dst, info.ModTime(), info.ModTime())
+}
@@ -0,0 +1,158 @@
+// Copyright 2021 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		 https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"zombiezen.com/go/log/testlog"
+)
+
+func TestCopyTree(t *testing.T) {
+	for _, link := range []bool{false, true} {
+		name := "Copy"
+		if link {
+			name = "Link"
+		}
+		t.Run(name, func(t *testing.T) {
+			src := filepath.Join(t.TempDir(), "src")
+			if err := os.MkdirAll(filepath.Join(src, "ro"), 0o777); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(src, "foo.txt"), []byte("foo"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(src, "ro", "bar.txt"), []byte("bar"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Symlink("foo.txt", filepath.Join(src, "link")); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chmod(filepath.Join(src, "ro"), 0o555); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { os.Chmod(filepath.Join(src, "ro"), 0o777) })
+
+			dst := filepath.Join(t.TempDir(), "dst")
+			if err := copyTree(dst, src, link); err != nil {
+				t.Fatal("copyTree:", err)
+			}
+			t.Cleanup(func() { os.Chmod(filepath.Join(dst, "ro"), 0o777) })
+
+			for _, name := range []string{"foo.txt", filepath.Join("ro", "bar.txt")} {
+				srcInfo, err := os.Stat(filepath.Join(src, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				dstInfo, err := os.Stat(filepath.Join(dst, name))
+				if err != nil {
+					t.Error(err)
+					continue
+				}
+				if got, want := dstInfo.Mode(), srcInfo.Mode(); got != want {
+					t.Errorf("%s mode = %v; want %v", name, got, want)
+				}
+				if got := os.SameFile(srcInfo, dstInfo); got != link {
+					t.Errorf("os.SameFile(src/%s, dst/%s) = %t; want %t", name, name, got, link)
+				}
+			}
+			if got, err := os.ReadFile(filepath.Join(dst, "ro", "bar.txt")); err != nil {
+				t.Error(err)
+			} else if string(got) != "bar" {
+				t.Errorf("ro/bar.txt content = %q; want %q", got, "bar")
+			}
+			if target, err := os.Readlink(filepath.Join(dst, "link")); err != nil {
+				t.Error(err)
+			} else if target != "foo.txt" {
+				t.Errorf("link -> %q; want %q", target, "foo.txt")
+			}
+			if info, err := os.Stat(filepath.Join(dst, "ro")); err != nil {
+				t.Error(err)
+			} else if got, want := info.Mode().Perm(), os.FileMode(0o555); got != want {
+				t.Errorf("ro mode = %v; want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestSwapSupportRoot(t *testing.T) {
+	ctx := testlog.WithTB(context.Background(), t)
+	supportRoot := t.TempDir()
+	staging, err := os.MkdirTemp(supportRoot, restoreTempPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFiles := map[string]string{
+		filepath.Join(supportRoot, "home", "old.txt"):          "old",
+		filepath.Join(supportRoot, "stale.txt"):                "stale",
+		filepath.Join(supportRoot, snapshotsDirName, "a", "x"): "snapshot",
+		filepath.Join(supportRoot, installLockName):            "",
+		filepath.Join(staging, "home", "new.txt"):              "new",
+		filepath.Join(staging, "work", "main.go"):              "package main\n",
+	}
+	for path, content := range writeFiles {
+		if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := swapSupportRoot(ctx, supportRoot, staging); err != nil {
+		t.Fatal("swapSupportRoot:", err)
+	}
+	want := map[string]string{
+		filepath.Join("home", "new.txt"):          "new",
+		filepath.Join("work", "main.go"):          "package main\n",
+		filepath.Join(snapshotsDirName, "a", "x"): "snapshot",
+		installLockName:                           "",
+	}
+	for name, content := range want {
+		got, err := os.ReadFile(filepath.Join(supportRoot, name))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if string(got) != content {
+			t.Errorf("%s content = %q; want %q", name, got, content)
+		}
+	}
+	for _, name := range []string{filepath.Join("home", "old.txt"), "stale.txt"} {
+		if _, err := os.Lstat(filepath.Join(supportRoot, name)); err == nil {
+			t.Errorf("%s still exists after swap", name)
+		}
+	}
+	entries, err := os.ReadDir(supportRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ent := range entries {
+		if strings.HasPrefix(ent.Name(), restoreTempPrefix) && ent.Name() != filepath.Base(staging) {
+			t.Errorf("temporary %s left in support root", ent.Name())
+		}
+	}
+	if entries, err := os.ReadDir(staging); err != nil {
+		t.Error(err)
+	} else if len(entries) > 0 {
+		t.Errorf("%d files left in staging directory", len(entries))
+	}
+}
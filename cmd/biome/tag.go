@@ -0,0 +1,185 @@
+// Copyright 2021 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		 https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+func newTagCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:           "tag",
+		Short:         "manage a biome's tags",
+		SilenceErrors: true,
+		SilenceUsage:  true,
+	}
+	cmd.AddCommand(
+		newTagSetCommand(),
+		newTagUnsetCommand(),
+	)
+	return cmd
+}
+
+type tagSetCommand struct {
+	biomeID string
+	tags    []string
+}
+
+func newTagSetCommand() *cobra.Command {
+	c := new(tagSetCommand)
+	cmd := &cobra.Command{
+		Use:                   "set [options] KEY=VALUE [...]",
+		DisableFlagsInUseLine: true,
+		Short:                 "add or change tags on a biome",
+		Args:                  cobra.MinimumNArgs(1),
+		SilenceErrors:         true,
+		SilenceUsage:          true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c.tags = args
+			return c.run(cmd.Context())
+		},
+	}
+	addBiomeFlag(cmd, &c.biomeID, "biome to tag")
+	return cmd
+}
+
+func (c *tagSetCommand) run(ctx context.Context) (err error) {
+	tags, err := parseTags(c.tags)
+	if err != nil {
+		return fmt.Errorf("tag set: %v", err)
+	}
+	db, err := openDB(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	endFn, err := sqlitex.ImmediateTransaction(db)
+	if err != nil {
+		return fmt.Errorf("tag set: %v", err)
+	}
+	defer endFn(&err)
+	rec, err := findBiome(db, c.biomeID)
+	if err != nil {
+		return fmt.Errorf("tag set: %v", err)
+	}
+	if err := setTags(db, rec.id, tags); err != nil {
+		return fmt.Errorf("tag set: %v", err)
+	}
+	return nil
+}
+
+type tagUnsetCommand struct {
+	biomeID string
+	keys    []string
+}
+
+func newTagUnsetCommand() *cobra.Command {
+	c := new(tagUnsetCommand)
+	cmd := &cobra.Command{
+		Use:                   "unset [options] KEY [...]",
+		DisableFlagsInUseLine: true,
+		Short:                 "remove tags from a biome",
+		Args:                  cobra.MinimumNArgs(1),
+		SilenceErrors:         true,
+		SilenceUsage:          true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c.keys = args
+			return c.run(cmd.Context())
+		},
+	}
+	addBiomeFlag(cmd, &c.biomeID, "biome to untag")
+	return cmd
+}
+
+func (c *tagUnsetCommand) run(ctx context.Context) (err error) {
+	db, err := openDB(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	endFn, err := sqlitex.ImmediateTransaction(db)
+	if err != nil {
+		return fmt.Errorf("tag unset: %v", err)
+	}
+	defer endFn(&err)
+	rec, err := findBiome(db, c.biomeID)
+	if err != nil {
+		return fmt.Errorf("tag unset: %v", err)
+	}
+	for _, key := range c.keys {
+		err := sqlitex.Exec(db, `delete from "biome_tags" where "biome_id" = ? and "key" = ?;`, nil, rec.id, key)
+		if err != nil {
+			return fmt.Errorf("tag unset: %v", err)
+		}
+	}
+	return nil
+}
+
+// setTags stores the given tags for a biome,
+// replacing the values of any tags with the same keys.
+func setTags(conn *sqlite.Conn, biomeID string, tags map[string]string) error {
+	for k, v := range tags {
+		err := sqlitex.Exec(conn,
+			`insert or replace into "biome_tags" ("biome_id", "key", "value") values (?, ?, ?);`,
+			nil, biomeID, k, v)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseTags parses a list of "KEY=VALUE" arguments.
+// Later arguments take precedence over earlier ones with the same key.
+func parseTags(args []string) (map[string]string, error) {
+	tags := make(map[string]string, len(args))
+	for _, arg := range args {
+		k, v, err := parseTag(arg)
+		if err != nil {
+			return nil, err
+		}
+		tags[k] = v
+	}
+	return tags, nil
+}
+
+// parseTag parses a "KEY=VALUE" argument.
+func parseTag(s string) (key, value string, err error) {
+	i := strings.IndexByte(s, '=')
+	if i <= 0 {
+		return "", "", fmt.Errorf("invalid tag %q: must be in the form KEY=VALUE", s)
+	}
+	return s[:i], s[i+1:], nil
+}
+
+// parseListFilter parses a filter argument for the list command.
+// The only supported filter is "tag:KEY=VALUE".
+func parseListFilter(s string) (key, value string, err error) {
+	tag := strings.TrimPrefix(s, "tag:")
+	if tag == s {
+		return "", "", fmt.Errorf("invalid filter %q: must be in the form tag:KEY=VALUE", s)
+	}
+	return parseTag(tag)
+}
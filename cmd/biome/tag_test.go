@@ -0,0 +1,73 @@
+// Copyright 2021 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		 https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import "testing"
+
+func TestParseTag(t *testing.T) {
+	tests := []struct {
+		s         string
+		wantKey   string
+		wantValue string
+		wantErr   bool
+	}{
+		{s: "env=production", wantKey: "env", wantValue: "production"},
+		{s: "empty=", wantKey: "empty", wantValue: ""},
+		{s: "eq=a=b", wantKey: "eq", wantValue: "a=b"},
+		{s: "novalue", wantErr: true},
+		{s: "=value", wantErr: true},
+		{s: "", wantErr: true},
+	}
+	for _, test := range tests {
+		key, value, err := parseTag(test.s)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("parseTag(%q) = %q, %q, <nil>; want _, _, <error>", test.s, key, value)
+			}
+			continue
+		}
+		if key != test.wantKey || value != test.wantValue || err != nil {
+			t.Errorf("parseTag(%q) = %q, %q, %v; want %q, %q, <nil>", test.s, key, value, err, test.wantKey, test.wantValue)
+		}
+	}
+}
+
+func TestParseListFilter(t *testing.T) {
+	tests := []struct {
+		s         string
+		wantKey   string
+		wantValue string
+		wantErr   bool
+	}{
+		{s: "tag:project=myapp", wantKey: "project", wantValue: "myapp"},
+		{s: "project=myapp", wantErr: true},
+		{s: "tag:project", wantErr: true},
+		{s: "label:project=myapp", wantErr: true},
+	}
+	for _, test := range tests {
+		key, value, err := parseListFilter(test.s)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("parseListFilter(%q) = %q, %q, <nil>; want _, _, <error>", test.s, key, value)
+			}
+			continue
+		}
+		if key != test.wantKey || value != test.wantValue || err != nil {
+			t.Errorf("parseListFilter(%q) = %q, %q, %v; want %q, %q, <nil>", test.s, key, value, err, test.wantKey, test.wantValue)
+		}
+	}
+}
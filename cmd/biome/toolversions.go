@@ -0,0 +1,177 @@
+// Copyright 2021 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		 https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.starlark.net/starlark"
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+const toolVersionsKey = "zombiezen.com/go/biome.ToolVersions"
+
+// toolVersions holds the tool versions recorded in a biome
+// for the biome.get_version and biome.set_version builtins.
+type toolVersions struct {
+	saved map[string]string
+	// pending maps tool names to versions passed to set_version
+	// that have not been written to the database yet.
+	// An empty version removes the tool's entry.
+	pending map[string]string
+	// writable is true while set_version may be called.
+	writable bool
+}
+
+func threadToolVersions(t *starlark.Thread) *toolVersions {
+	tv, _ := t.Local(toolVersionsKey).(*toolVersions)
+	return tv
+}
+
+// get returns the version recorded for the named tool.
+func (tv *toolVersions) get(name string) (string, bool) {
+	if v, ok := tv.pending[name]; ok {
+		return v, v != ""
+	}
+	v, ok := tv.saved[name]
+	return v, ok
+}
+
+// callWritable calls fn with set_version permitted.
+func (tv *toolVersions) callWritable(fn func() (starlark.Value, error)) (starlark.Value, error) {
+	tv.writable = true
+	defer func() { tv.writable = false }()
+	return fn()
+}
+
+// String formats the pending changes for dry-run output.
+func (tv *toolVersions) String() string {
+	sb := new(strings.Builder)
+	for _, name := range sortedStringMapKeys(tv.pending) {
+		if v := tv.pending[name]; v != "" {
+			fmt.Fprintf(sb, "%s=%s\n", name, v)
+		} else {
+			fmt.Fprintf(sb, "%s removed\n", name)
+		}
+	}
+	return sb.String()
+}
+
+func getVersionBuiltin(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "name", &name); err != nil {
+		return nil, err
+	}
+	tv := threadToolVersions(thread)
+	if tv == nil {
+		return starlark.None, nil
+	}
+	v, ok := tv.get(name)
+	if !ok {
+		return starlark.None, nil
+	}
+	return starlark.String(v), nil
+}
+
+func setVersionBuiltin(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	var version starlark.Value
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "name", &name, "version", &version); err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return nil, fmt.Errorf("%s: empty tool name", fn.Name())
+	}
+	var v string
+	switch version := version.(type) {
+	case starlark.String:
+		v = string(version)
+		if v == "" {
+			return nil, fmt.Errorf("%s: empty version for %s (use None to remove)", fn.Name(), name)
+		}
+	case starlark.NoneType:
+	default:
+		return nil, fmt.Errorf("%s: version for %s is a %s instead of string or None", fn.Name(), name, version.Type())
+	}
+	tv := threadToolVersions(thread)
+	if tv == nil || !tv.writable {
+		return nil, fmt.Errorf("%s: can only be called from install or uninstall", fn.Name())
+	}
+	if tv.pending == nil {
+		tv.pending = make(map[string]string)
+	}
+	tv.pending[name] = v
+	return starlark.None, nil
+}
+
+// readToolVersions returns the tool versions recorded in the biome.
+func readToolVersions(conn *sqlite.Conn, id string) (map[string]string, error) {
+	versions := make(map[string]string)
+	const query = `select "name", "version" from "biome_tool_versions" where "biome_id" = ?;`
+	err := sqlitex.Exec(conn, query, func(stmt *sqlite.Stmt) error {
+		versions[stmt.ColumnText(0)] = stmt.ColumnText(1)
+		return nil
+	}, id)
+	if err != nil {
+		return nil, fmt.Errorf("read tool versions for %s: %w", id, err)
+	}
+	return versions, nil
+}
+
+// writeToolVersions saves the given tool versions in the biome.
+// An empty version removes the tool's entry.
+func writeToolVersions(conn *sqlite.Conn, id string, versions map[string]string) (err error) {
+	defer sqlitex.Save(conn)(&err)
+	for _, name := range sortedStringMapKeys(versions) {
+		v := versions[name]
+		if v == "" {
+			err = sqlitex.Exec(conn, `delete from "biome_tool_versions" where "biome_id" = ? and "name" = ?;`, nil, id, name)
+		} else {
+			err = sqlitex.Exec(conn, `insert into "biome_tool_versions" ("biome_id", "name", "version") values (?, ?, ?) `+
+				`on conflict ("biome_id", "name") do update set "version" = excluded."version";`, nil, id, name, v)
+		}
+		if err != nil {
+			return fmt.Errorf("write tool versions for %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// formatToolVersions formats tool versions as a comma-separated list
+// of NAME=VERSION pairs sorted by name, or "-" if there are none.
+func formatToolVersions(versions map[string]string) string {
+	if len(versions) == 0 {
+		return "-"
+	}
+	parts := make([]string, 0, len(versions))
+	for _, name := range sortedStringMapKeys(versions) {
+		parts = append(parts, name+"="+versions[name])
+	}
+	return strings.Join(parts, ",")
+}
+
+func sortedStringMapKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,105 @@
+// Copyright 2021 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		 https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.starlark.net/starlark"
+	"zombiezen.com/go/biome"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+func TestVersionBuiltins(t *testing.T) {
+	bio := &biome.Fake{
+		Descriptor: biome.Descriptor{OS: biome.Linux, Arch: biome.Intel64},
+		DirsResult: biome.Dirs{Work: "/work", Home: "/home", Tools: "/tools"},
+	}
+	const script = "def install(biome, version, **kwargs):\n" +
+		"  old = biome.get_version('go')\n" +
+		"  biome.set_version('go', version)\n" +
+		"  biome.set_version('node', None)\n" +
+		"  return (old, biome.get_version('go'), biome.get_version('node'), biome.get_version('rust'))\n" +
+		"def verify(biome, version, **kwargs):\n" +
+		"  biome.set_version('go', 'oops')\n"
+	thread := new(starlark.Thread)
+	versions := &toolVersions{saved: map[string]string{"go": "1.16", "node": "16.0.0"}}
+	thread.SetLocal(toolVersionsKey, versions)
+	globals, err := starlark.ExecFile(thread, "test.star", script, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := versions.callWritable(func() (starlark.Value, error) {
+		return starlark.Call(thread, globals["install"], starlark.Tuple{biomeValue(bio), starlark.String("1.17.3")}, nil)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := starlark.Tuple{starlark.String("1.16"), starlark.String("1.17.3"), starlark.None, starlark.None}
+	if got, ok := result.(starlark.Tuple); !ok || len(got) != len(want) || got.String() != want.String() {
+		t.Errorf("install returned %v; want %v", result, want)
+	}
+	wantPending := map[string]string{"go": "1.17.3", "node": ""}
+	if diff := cmp.Diff(wantPending, versions.pending); diff != "" {
+		t.Errorf("pending versions (-want +got):\n%s", diff)
+	}
+
+	if _, err := starlark.Call(thread, globals["verify"], starlark.Tuple{biomeValue(bio), starlark.String("1.17.3")}, nil); err == nil {
+		t.Error("set_version outside install succeeded")
+	}
+	if diff := cmp.Diff(wantPending, versions.pending); diff != "" {
+		t.Errorf("pending versions after verify (-want +got):\n%s", diff)
+	}
+}
+
+func TestToolVersionsDB(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	db, err := openDB(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	const id = "abcd"
+	err = sqlitex.Exec(db, `insert into "biomes" ("id", "root_host_dir") values (?, ?);`, nil, id, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeToolVersions(db, id, map[string]string{"go": "1.16", "node": "16.0.0"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeToolVersions(db, id, map[string]string{"go": "1.17.3", "node": ""}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readToolVersions(db, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"go": "1.17.3"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("readToolVersions(...) (-want +got):\n%s", diff)
+	}
+	if got, want := formatToolVersions(got), "go=1.17.3"; got != want {
+		t.Errorf("formatToolVersions(...) = %q; want %q", got, want)
+	}
+	if got, want := formatToolVersions(nil), "-"; got != want {
+		t.Errorf("formatToolVersions(nil) = %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,284 @@
+// Copyright 2021 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		 https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"zombiezen.com/go/biome/internal/gitglob"
+	"zombiezen.com/go/log"
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+const defaultManifestFileName = "biome.json"
+
+// manifest is the JSON-decoded form of a biome manifest file.
+type manifest struct {
+	// Root is the biome's root directory.
+	// Relative paths are resolved relative to the manifest's directory.
+	Root string `json:"root"`
+	// Exclude and Include are lists of patterns in the style of the create
+	// command's --exclude and --include flags.
+	Exclude []string `json:"exclude"`
+	Include []string `json:"include"`
+	// UseGitignore is like the create command's --use-gitignore flag.
+	UseGitignore bool `json:"use_gitignore"`
+	// Tools is the list of install scripts to run, in order.
+	Tools []manifestTool `json:"tools"`
+}
+
+type manifestTool struct {
+	// Script is the path to an install script.
+	// Relative paths are resolved relative to the manifest's directory.
+	Script  string `json:"script"`
+	Version string `json:"version"`
+}
+
+// readManifest reads the manifest file at path, resolving any relative paths
+// inside it to absolute paths.
+func readManifest(path string) (*manifest, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("read manifest: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read manifest: %v", err)
+	}
+	m, err := parseManifest(data, filepath.Dir(path))
+	if err != nil {
+		return nil, fmt.Errorf("read manifest %s: %v", path, err)
+	}
+	return m, nil
+}
+
+// parseManifest parses a JSON manifest. Relative paths are resolved
+// relative to dir.
+func parseManifest(data []byte, dir string) (*manifest, error) {
+	m := new(manifest)
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(m); err != nil {
+		return nil, err
+	}
+	if m.Root == "" {
+		m.Root = "."
+	}
+	if !filepath.IsAbs(m.Root) {
+		m.Root = filepath.Join(dir, m.Root)
+	}
+	for i := range m.Tools {
+		tool := &m.Tools[i]
+		if tool.Script == "" {
+			return nil, fmt.Errorf("tools[%d]: missing script", i)
+		}
+		if tool.Version == "" {
+			return nil, fmt.Errorf("tools[%d] (%s): missing version", i, tool.Script)
+		}
+		if !filepath.IsAbs(tool.Script) {
+			tool.Script = filepath.Join(dir, tool.Script)
+		}
+	}
+	return m, nil
+}
+
+type upCommand struct {
+	manifestPath string
+	locked       bool
+}
+
+func newUpCommand() *cobra.Command {
+	c := new(upCommand)
+	cmd := &cobra.Command{
+		Use:                   "up [options]",
+		DisableFlagsInUseLine: true,
+		Short:                 "create a biome and install tools from a manifest",
+		Long: "Create a biome and install tools as described by a manifest file.\n\n" +
+			"If a biome already exists for the manifest's root directory, " +
+			"up installs any tools that haven't been installed into it yet.\n\n" +
+			"The URL and SHA-256 digest of each archive downloaded by the install scripts " +
+			"are recorded in " + lockFileName + " next to the manifest. " +
+			"With --locked, up only permits downloads recorded in " + lockFileName + " " +
+			"and fails if an archive's digest has changed.",
+		Args:          cobra.NoArgs,
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.run(cmd.Context())
+		},
+	}
+	cmd.Flags().StringVarP(&c.manifestPath, "manifest", "m", defaultManifestFileName, "manifest `file` to read")
+	cmd.Flags().BoolVar(&c.locked, "locked", false, "only download archives recorded in the lock file instead of updating it")
+	return cmd
+}
+
+func (c *upCommand) run(ctx context.Context) (err error) {
+	m, err := readManifest(c.manifestPath)
+	if err != nil {
+		return err
+	}
+	manifestPath, err := filepath.Abs(c.manifestPath)
+	if err != nil {
+		return err
+	}
+	lockPath := filepath.Join(filepath.Dir(manifestPath), lockFileName)
+	lock, err := readLockFile(lockPath)
+	if errors.Is(err, fs.ErrNotExist) && !c.locked {
+		lock = new(lockFile)
+	} else if err != nil {
+		return err
+	}
+	exclude, err := parsePatternFlags("exclude", m.Exclude, false)
+	if err != nil {
+		return fmt.Errorf("%s: %v", c.manifestPath, err)
+	}
+	include, err := parsePatternFlags("include", m.Include, true)
+	if err != nil {
+		return fmt.Errorf("%s: %v", c.manifestPath, err)
+	}
+	db, err := openDB(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	id, err := findBiomeForRoot(db, m.Root)
+	if err != nil {
+		return err
+	}
+	if id == "" {
+		create := &createCommand{
+			rootDir: m.Root,
+			exclude: m.Exclude,
+			include: m.Include,
+
+			useGitignore: m.UseGitignore,
+		}
+		id, err = create.create(ctx, db)
+		if err != nil {
+			return err
+		}
+		log.Infof(ctx, "Created biome %s", id)
+	}
+	newLock := new(lockFile)
+	for _, tool := range m.Tools {
+		prev := lock.find(tool.Script, tool.Version)
+		if c.locked && prev == nil {
+			return fmt.Errorf("%s %s is not in %s; run biome up without --locked to update it", tool.Script, tool.Version, lockPath)
+		}
+		downloads := &downloadLog{locked: c.locked}
+		if c.locked {
+			downloads.downloads = prev.Downloads
+		}
+		installed, err := upInstall(ctx, db, id, exclude, include, tool, downloads)
+		if err != nil {
+			return err
+		}
+		entry := lockedTool{
+			Script:    tool.Script,
+			Version:   tool.Version,
+			Downloads: downloads.downloads,
+		}
+		if !installed && prev != nil {
+			// Nothing was downloaded, so keep what was recorded before.
+			entry.Downloads = prev.Downloads
+		}
+		newLock.Tools = append(newLock.Tools, entry)
+	}
+	if !c.locked {
+		if err := writeLockFile(lockPath, newLock); err != nil {
+			return err
+		}
+	}
+	fmt.Println(id)
+	return nil
+}
+
+// upInstall installs a manifest's tool into the biome with the given ID
+// if it hasn't already been installed and reports whether it ran the install.
+// Each install is run in its own transaction so that an install failure
+// doesn't undo earlier installs.
+func upInstall(ctx context.Context, db *sqlite.Conn, id string, exclude, include []gitglob.Pattern, tool manifestTool, downloads *downloadLog) (_ bool, err error) {
+	supportRoot, err := computeSupportRoot(id)
+	if err != nil {
+		return false, err
+	}
+	unlock, err := lockInstall(ctx, supportRoot)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+	endFn, err := sqlitex.ImmediateTransaction(db)
+	if err != nil {
+		return false, err
+	}
+	defer endFn(&err)
+	rec, err := findBiome(db, id)
+	if err != nil {
+		return false, err
+	}
+	rec.exclude = exclude
+	rec.include = include
+	installed, err := hasInstalled(db, id, tool.Script, tool.Version)
+	if err != nil {
+		return false, err
+	}
+	if installed {
+		log.Debugf(ctx, "%s %s already installed", tool.Script, tool.Version)
+		return false, nil
+	}
+	log.Infof(ctx, "Installing %s %s", tool.Script, tool.Version)
+	install := &installCommand{
+		script:    tool.Script,
+		version:   tool.Version,
+		downloads: downloads,
+	}
+	if err := install.install(ctx, db, rec); err != nil {
+		return false, fmt.Errorf("install %s %s: %w", tool.Script, tool.Version, err)
+	}
+	return true, nil
+}
+
+// findBiomeForRoot returns the ID of the biome whose root directory is
+// exactly rootDir or the empty string if there is no such biome.
+func findBiomeForRoot(conn *sqlite.Conn, rootDir string) (string, error) {
+	const query = `select "id" from "biomes" where "root_host_dir" = ? order by "created_at" desc, "id" limit 2;`
+	var ids []string
+	err := sqlitex.Exec(conn, query, func(stmt *sqlite.Stmt) error {
+		ids = append(ids, stmt.ColumnText(0))
+		return nil
+	}, rootDir)
+	if err != nil {
+		return "", fmt.Errorf("find biome for %s: %v", rootDir, err)
+	}
+	if len(ids) > 1 {
+		return "", fmt.Errorf("find biome for %s: multiple biomes; destroy all but one to use up", rootDir)
+	}
+	if len(ids) == 0 {
+		return "", nil
+	}
+	return ids[0], nil
+}
@@ -0,0 +1,98 @@
+// Copyright 2021 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		 https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseManifest(t *testing.T) {
+	dir := filepath.FromSlash("/src/project")
+	tests := []struct {
+		name    string
+		data    string
+		want    *manifest
+		wantErr bool
+	}{
+		{
+			name: "Empty",
+			data: `{}`,
+			want: &manifest{Root: dir},
+		},
+		{
+			name: "Full",
+			data: `{
+				"root": "app",
+				"exclude": ["*.log"],
+				"include": ["keep.log"],
+				"tools": [
+					{"script": "installers/go.star", "version": "1.17.3"},
+					{"script": "/opt/node.star", "version": "16.13.0"}
+				]
+			}`,
+			want: &manifest{
+				Root:    filepath.Join(dir, "app"),
+				Exclude: []string{"*.log"},
+				Include: []string{"keep.log"},
+				Tools: []manifestTool{
+					{Script: filepath.Join(dir, "installers", "go.star"), Version: "1.17.3"},
+					{Script: filepath.FromSlash("/opt/node.star"), Version: "16.13.0"},
+				},
+			},
+		},
+		{
+			name:    "UnknownField",
+			data:    `{"rooot": "app"}`,
+			wantErr: true,
+		},
+		{
+			name:    "MissingVersion",
+			data:    `{"tools": [{"script": "go.star"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "MissingScript",
+			data:    `{"tools": [{"version": "1.17.3"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "Malformed",
+			data:    `{`,
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseManifest([]byte(test.data), dir)
+			if err != nil {
+				if !test.wantErr {
+					t.Fatal("parseManifest:", err)
+				}
+				return
+			}
+			if test.wantErr {
+				t.Fatalf("parseManifest(...) = %+v, <nil>; want error", got)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("parseManifest(...) (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
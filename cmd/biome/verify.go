@@ -0,0 +1,213 @@
+// Copyright 2021 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		 https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	slashpath "path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"zombiezen.com/go/biome"
+	"zombiezen.com/go/log"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+type verifyCommand struct {
+	biomeID string
+}
+
+func newVerifyCommand() *cobra.Command {
+	c := new(verifyCommand)
+	cmd := &cobra.Command{
+		Use:                   "verify [options]",
+		DisableFlagsInUseLine: true,
+		Short:                 "list files changed in the biome since the last push",
+		Args:                  cobra.NoArgs,
+		SilenceErrors:         true,
+		SilenceUsage:          true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.run(cmd.Context())
+		},
+	}
+	addBiomeFlag(cmd, &c.biomeID, "biome to verify")
+	return cmd
+}
+
+func (c *verifyCommand) run(ctx context.Context) error {
+	var rec *biomeRecord
+	var stamps map[string]string
+	err := func() (err error) {
+		db, err := openDB(ctx)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		endFn, err := sqlitex.ImmediateTransaction(db)
+		if err != nil {
+			return err
+		}
+		defer endFn(&err)
+		rec, err = findBiome(db, c.biomeID)
+		if err != nil {
+			return err
+		}
+		stamps, err = readStamps(db, rec.id)
+		return err
+	}()
+	if err != nil {
+		return err
+	}
+	if rec.mounted {
+		log.Infof(ctx, "Biome %s is mounted at %s; files are always the same", rec.id, rec.rootHostDir)
+		return nil
+	}
+
+	// Don't use rec.setup: pushing would overwrite the changes.
+	changes, err := verifyStamps(ctx, rec.local(), stamps)
+	if err != nil {
+		return fmt.Errorf("verify %s: %v", rec.id, err)
+	}
+	if len(changes) == 0 {
+		log.Infof(ctx, "No files changed in biome %s since the last push", rec.id)
+		return nil
+	}
+	for _, change := range changes {
+		if _, err := fmt.Printf("%c %s\n", change.status, change.path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Statuses of a stampChange.
+const (
+	stampModified = 'M'
+	stampDeleted  = 'D'
+)
+
+// stampChange is a file in a biome's working directory
+// that no longer matches its stamp.
+type stampChange struct {
+	status byte
+	path   string
+}
+
+// verifyStamps compares the files in bio's working directory against
+// the stamps recorded by the last push and returns the paths that were
+// modified or deleted, sorted by path. Files that were created in the biome
+// since the push are not reported.
+//
+// The files are listed with one biome.ReadDir per directory
+// instead of stat-ing each file.
+func verifyStamps(ctx context.Context, bio biome.Biome, stamps map[string]string) ([]stampChange, error) {
+	byDir := make(map[string][]string)
+	for path := range stamps {
+		dir := slashpath.Dir(path)
+		byDir[dir] = append(byDir[dir], path)
+	}
+	dirs := make([]string, 0, len(byDir))
+	for dir := range byDir {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	var changes []stampChange
+	desc := bio.Describe()
+	workDir := bio.Dirs().Work
+	for _, dir := range dirs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		dirPath := workDir
+		if dir != "." {
+			dirPath = biome.JoinPath(desc, workDir, biome.FromSlash(desc, dir))
+		}
+		entries, err := biome.ReadDir(ctx, bio, dirPath)
+		if err != nil && !errors.Is(err, biome.ErrNotFound) {
+			return nil, err
+		}
+		entryMap := make(map[string]fs.DirEntry, len(entries))
+		for _, ent := range entries {
+			entryMap[ent.Name()] = ent
+		}
+		for _, path := range byDir[dir] {
+			ent := entryMap[slashpath.Base(path)]
+			if ent == nil {
+				changes = append(changes, stampChange{status: stampDeleted, path: path})
+				continue
+			}
+			info, err := ent.Info()
+			if errors.Is(err, biome.ErrNotFound) {
+				changes = append(changes, stampChange{status: stampDeleted, path: path})
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+			if !stampMatches(stamps[path], info) {
+				changes = append(changes, stampChange{status: stampModified, path: path})
+			}
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].path < changes[j].path
+	})
+	return changes, nil
+}
+
+// stampMatches reports whether info describes a file pushed with the given
+// stamp. Stamps record the host's copy of the file, so only the attributes
+// that pushing preserves are compared: the file type, and for regular files,
+// the size, permissions, and modification time (to the second).
+// Regular files that were pushed as symlinks to another hard link
+// have symlink stamps, so a regular file stamp never matches a symlink.
+func stampMatches(stamp string, info fs.FileInfo) bool {
+	if stamp == dirStamp {
+		return info.IsDir()
+	}
+	mode := stampMode(stamp)
+	switch mode.Type() {
+	case fs.ModeSymlink:
+		return info.Mode().Type() == fs.ModeSymlink
+	case 0:
+		if !info.Mode().IsRegular() || info.Mode().Perm() != mode.Perm() {
+			return false
+		}
+		parts := strings.Split(stamp, "-")
+		if len(parts) < 2 {
+			return false
+		}
+		size, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || size != info.Size() {
+			return false
+		}
+		sec := parts[0]
+		if i := strings.IndexByte(sec, '.'); i >= 0 {
+			sec = sec[:i]
+		}
+		mtime, err := strconv.ParseInt(sec, 10, 64)
+		return err == nil && mtime == info.ModTime().Unix()
+	default:
+		return false
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2021 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		 https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"zombiezen.com/go/biome"
+)
+
+func TestVerifyStamps(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	ctx := context.Background()
+	db, err := openDB(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rootHostDir := t.TempDir()
+	hostFiles := map[string]string{
+		"unchanged.txt":       "same\n",
+		"modified.txt":        "before\n",
+		"chmod.sh":            "#!/bin/sh\n",
+		"deleted.txt":         "bye\n",
+		"gone/inner.txt":      "bye\n",
+		"sub/unchanged.txt":   "same\n",
+		"sub/replaced_by_dir": "file\n",
+		"replaced_by_link":    "file\n",
+		"hardlink1.txt":       "linked\n",
+	}
+	for name, content := range hostFiles {
+		path := filepath.Join(rootHostDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("unchanged.txt", filepath.Join(rootHostDir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	// Hard links are pushed as symlinks and must still verify.
+	if err := os.Link(filepath.Join(rootHostDir, "hardlink1.txt"), filepath.Join(rootHostDir, "hardlink2.txt")); err != nil {
+		t.Fatal(err)
+	}
+	bio := biome.Local{
+		WorkDir: t.TempDir(),
+		HomeDir: t.TempDir(),
+	}
+	rec := &biomeRecord{
+		id:          "testbiome",
+		rootHostDir: rootHostDir,
+	}
+	if err := pushWorkDir(ctx, db, rec, bio); err != nil {
+		t.Fatal(err)
+	}
+	stamps, err := readStamps(db, rec.id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := verifyStamps(ctx, bio, stamps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) > 0 {
+		t.Errorf("verifyStamps(...) right after push = %v; want none", got)
+	}
+
+	// Change the biome's copy.
+	workPath := func(name string) string {
+		return filepath.Join(bio.WorkDir, filepath.FromSlash(name))
+	}
+	if err := os.WriteFile(workPath("modified.txt"), []byte("after, and longer\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(workPath("chmod.sh"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(workPath("deleted.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(workPath("gone")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(workPath("sub/replaced_by_dir")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(workPath("sub/replaced_by_dir"), 0o777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(workPath("replaced_by_link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("unchanged.txt", workPath("replaced_by_link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(workPath("created.txt"), []byte("new\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err = verifyStamps(ctx, bio, stamps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []stampChange{
+		{status: stampModified, path: "chmod.sh"},
+		{status: stampDeleted, path: "deleted.txt"},
+		{status: stampDeleted, path: "gone"},
+		{status: stampDeleted, path: "gone/inner.txt"},
+		{status: stampModified, path: "modified.txt"},
+		{status: stampModified, path: "replaced_by_link"},
+		{status: stampModified, path: "sub/replaced_by_dir"},
+	}
+	if diff := cmp.Diff(want, got, cmp.AllowUnexported(stampChange{})); diff != "" {
+		t.Errorf("verifyStamps(...) (-want +got):\n%s", diff)
+	}
+}
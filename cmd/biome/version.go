@@ -0,0 +1,64 @@
+// Copyright 2021 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		 https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func newVersionCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:                   "version",
+		DisableFlagsInUseLine: true,
+		Short:                 "print biome's version information",
+		Args:                  cobra.NoArgs,
+		SilenceErrors:         true,
+		SilenceUsage:          true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			_, err := fmt.Fprint(cmd.OutOrStdout(), versionInfo())
+			return err
+		},
+	}
+}
+
+// versionInfo returns a human-readable description of the running binary's
+// version, including the Go version it was built with and the VCS revision
+// if one was embedded at build time.
+func versionInfo() string {
+	sb := new(strings.Builder)
+	version := "unknown"
+	info, ok := debug.ReadBuildInfo()
+	if ok && info.Main.Version != "" {
+		version = info.Main.Version
+	}
+	fmt.Fprintf(sb, "biome %s\n", version)
+	fmt.Fprintf(sb, "go: %s\n", runtime.Version())
+	if ok {
+		if rev, modified := vcsRevision(info); rev != "" {
+			if modified {
+				rev += " (modified)"
+			}
+			fmt.Fprintf(sb, "revision: %s\n", rev)
+		}
+	}
+	return sb.String()
+}
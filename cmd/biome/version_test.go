@@ -0,0 +1,40 @@
+// Copyright 2021 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		 https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestVersion(t *testing.T) {
+	for _, args := range [][]string{{"version"}, {"--version"}} {
+		root := newRootCommand()
+		out := new(strings.Builder)
+		root.SetOut(out)
+		root.SetArgs(args)
+		if err := root.Execute(); err != nil {
+			t.Errorf("biome %s: %v", strings.Join(args, " "), err)
+			continue
+		}
+		got := out.String()
+		if !strings.HasPrefix(got, "biome ") || !strings.Contains(got, "\ngo: "+runtime.Version()+"\n") {
+			t.Errorf("biome %s output:\n%s\nwant biome version and Go version", strings.Join(args, " "), got)
+		}
+	}
+}
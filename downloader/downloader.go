@@ -20,67 +20,364 @@ package downloader
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strings"
+	"sync"
 
 	"zombiezen.com/go/log"
 )
 
-// Downloader manages a download cache.
+// Downloader manages a download cache. It is safe to call Download from
+// multiple goroutines concurrently.
 type Downloader struct {
 	// Client is the HTTP client to use to fetch URLs.
 	// This can only be changed before the first call to Download.
 	Client *http.Client
 
-	dir string
+	// MaxConcurrentDownloads is the maximum number of HTTP transfers that
+	// the Downloader will perform at once. If it is zero or negative, then
+	// there is no limit. This can only be changed before the first call
+	// to Download.
+	MaxConcurrentDownloads int
+
+	dir    string
+	layout Layout
+
+	initOnce sync.Once
+	sem      chan struct{}
+
+	mu       sync.Mutex
+	inflight map[string]*downloadCall
+}
+
+// downloadCall is an in-progress or completed Download.
+type downloadCall struct {
+	done chan struct{}
+	err  error
 }
 
 // New returns a Downloader that maintains a cache in the
 // given directory. The Downloader will create the directory if it
 // does not exist.
 func New(dir string) *Downloader {
+	return NewWithOptions(dir, nil)
+}
+
+// Options holds optional parameters for NewWithOptions.
+type Options struct {
+	// Layout determines how files in the cache directory are named.
+	// The zero value is FlatLayout.
+	Layout Layout
+}
+
+// Layout is a naming strategy for files in a download cache.
+// A cache directory should only be used with one Layout.
+type Layout int
+
+const (
+	// FlatLayout stores each download directly in the cache directory,
+	// named after the URL (or cache key) with any characters other than
+	// ASCII letters, digits, and dots removed.
+	FlatLayout Layout = iota
+
+	// HashedLayout stores each download in a subdirectory named after the
+	// hex-encoded SHA-256 hash of the URL (or cache key). The file is named
+	// after the last element of the URL's path, with any characters that
+	// are not safe in filenames on all common operating systems replaced.
+	// This makes the cache easier to inspect while still avoiding collisions.
+	HashedLayout
+)
+
+// NewWithOptions returns a Downloader that maintains a cache in the
+// given directory. The Downloader will create the directory if it
+// does not exist. A nil opts is the same as passing a pointer to
+// the zero value.
+func NewWithOptions(dir string, opts *Options) *Downloader {
+	if opts == nil {
+		opts = new(Options)
+	}
 	return &Downloader{
 		Client: http.DefaultClient,
 		dir:    dir,
+		layout: opts.Layout,
 	}
 }
 
-func (d *Downloader) download(ctx context.Context, dst io.Writer, url string) (err error) {
+func (d *Downloader) init() {
+	d.initOnce.Do(func() {
+		if d.MaxConcurrentDownloads > 0 {
+			d.sem = make(chan struct{}, d.MaxConcurrentDownloads)
+		}
+	})
+}
+
+// acquire blocks until a download slot is available or ctx is done.
+func (d *Downloader) acquire(ctx context.Context) error {
+	if d.sem == nil {
+		return nil
+	}
+	select {
+	case d.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *Downloader) release() {
+	if d.sem != nil {
+		<-d.sem
+	}
+}
+
+// download fetches url into f. If v contains any validators, then the request
+// is conditional: if the server reports that the resource has not been
+// modified, then download leaves f untouched and returns notModified = true.
+// Otherwise, download returns the validators for the new content.
+func (d *Downloader) download(ctx context.Context, f *os.File, url string, header http.Header, v cacheValidators) (_ cacheValidators, notModified bool, err error) {
 	// Make HTTP request.
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	req, err := newRequest(ctx, http.MethodGet, url, header)
 	if err != nil {
-		return fmt.Errorf("download %s: %w", url, err)
+		return cacheValidators{}, false, fmt.Errorf("download %s: %w", url, err)
+	}
+	if v.ETag != "" {
+		req.Header.Set("If-None-Match", v.ETag)
+	}
+	if v.LastModified != "" {
+		req.Header.Set("If-Modified-Since", v.LastModified)
 	}
 	log.Infof(ctx, "Downloading %s", url)
 	resp, err := d.Client.Do(req)
 	if err != nil {
-		return fmt.Errorf("download %s: %w", url, err)
+		return cacheValidators{}, false, fmt.Errorf("download %s: %w", url, err)
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified && !v.isEmpty() {
+		return v, true, nil
+	}
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("download %s: %w", url, httpError{
+		return cacheValidators{}, false, fmt.Errorf("download %s: %w", url, httpError{
 			status:     resp.Status,
 			statusCode: resp.StatusCode,
 		})
 	}
 
 	// Copy to file.
-	if _, err := io.Copy(dst, resp.Body); err != nil {
-		return fmt.Errorf("download %s: %w", url, err)
+	if err := f.Truncate(0); err != nil {
+		return cacheValidators{}, false, fmt.Errorf("download %s: %w", url, err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return cacheValidators{}, false, fmt.Errorf("download %s: %w", url, err)
+	}
+	n, err := io.Copy(f, resp.Body)
+	if err != nil {
+		return cacheValidators{}, false, fmt.Errorf("download %s: %w", url, err)
+	}
+	// The HTTP client usually detects truncated bodies itself,
+	// but caching a truncated file would cause confusing errors later.
+	if resp.ContentLength >= 0 && n != resp.ContentLength {
+		return cacheValidators{}, false, fmt.Errorf("download %s: received %d bytes, but Content-Length is %d: %w",
+			url, n, resp.ContentLength, io.ErrUnexpectedEOF)
+	}
+	newValidators := cacheValidators{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+	return newValidators, false, nil
+}
+
+// newRequest returns a new HTTP request with the given extra header fields.
+func newRequest(ctx context.Context, method, url string, header http.Header) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = append([]string(nil), v...)
+	}
+	return req, nil
+}
+
+// A DownloadOption is an optional parameter to Download.
+type DownloadOption func(*downloadOptions)
+
+type downloadOptions struct {
+	cacheKey   string
+	revalidate bool
+	header     http.Header
+}
+
+// WithCacheKey returns a DownloadOption that stores the download in the cache
+// under the given key instead of the URL. This is useful for URLs that contain
+// volatile parts, like signatures or cache-busting query parameters. Because
+// such URLs may not be usable to check whether the cache is fresh, a file
+// cached under an explicit key is reused without contacting the server.
+func WithCacheKey(key string) DownloadOption {
+	return func(opts *downloadOptions) {
+		opts.cacheKey = key
+	}
+}
+
+// WithHeader returns a DownloadOption that adds the fields in h to the
+// HTTP requests that Download makes, such as an Authorization header for
+// private release artifacts. Header fields are not part of the cache key
+// and are never logged, so they may contain credentials. The HTTP client
+// does not forward sensitive fields like Authorization when a redirect
+// leads to a different domain.
+func WithHeader(h http.Header) DownloadOption {
+	return func(opts *downloadOptions) {
+		if opts.header == nil {
+			opts.header = make(http.Header)
+		}
+		for k, v := range h {
+			k = http.CanonicalHeaderKey(k)
+			opts.header[k] = append(opts.header[k], v...)
+		}
+	}
+}
+
+// WithRevalidate returns a DownloadOption that forces Download to check with
+// the server that a cached file is still current, even if the file would
+// otherwise be reused without a request (as with WithCacheKey). If the server
+// did not send an ETag or Last-Modified header for the cached file, then the
+// file is downloaded again.
+func WithRevalidate() DownloadOption {
+	return func(opts *downloadOptions) {
+		opts.revalidate = true
 	}
-	return nil
 }
 
 // Download downloads a URL to the local filesystem and returns a handle to
 // the file. If the URL could not be found on the server, then IsNotFound(err)
-// will return true.
-func (d *Downloader) Download(ctx context.Context, url string) (_ *os.File, err error) {
-	cacheFilename := filepath.Join(d.dir, cacheFilenameForURL(url))
+// will return true. Redirects are followed, but the file is cached under
+// the originally requested URL.
+//
+// If the server sent an ETag or Last-Modified header with the cached file,
+// then Download makes a conditional request and reuses the cached file
+// if the server reports that it has not been modified. Otherwise, Download
+// reuses the cached file if its size matches the resource's size.
+//
+// Concurrent calls to Download for the same URL share a single transfer.
+// If the transfer stops because the Context of the call that started it
+// is done, the other calls start a new transfer with their own Contexts.
+func (d *Downloader) Download(ctx context.Context, url string, opts ...DownloadOption) (*os.File, error) {
+	d.init()
+	var options downloadOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	cacheFilename := d.cacheFilename(url, &options)
+	d.mu.Lock()
+	for {
+		call := d.inflight[cacheFilename]
+		if call == nil {
+			break
+		}
+		d.mu.Unlock()
+		log.Debugf(ctx, "Waiting on concurrent download of %s", url)
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("download %s: %w", url, ctx.Err())
+		}
+		if errors.Is(call.err, context.Canceled) || errors.Is(call.err, context.DeadlineExceeded) {
+			// The transfer was stopped by the other caller's Context,
+			// not by a problem with the URL, so try again with ours.
+			d.mu.Lock()
+			continue
+		}
+		if call.err != nil {
+			return nil, call.err
+		}
+		f, err := os.Open(cacheFilename)
+		if err != nil {
+			return nil, fmt.Errorf("download %s: %w", url, err)
+		}
+		return f, nil
+	}
+	call := &downloadCall{done: make(chan struct{})}
+	if d.inflight == nil {
+		d.inflight = make(map[string]*downloadCall)
+	}
+	d.inflight[cacheFilename] = call
+	d.mu.Unlock()
+
+	f, err := d.fetch(ctx, cacheFilename, url, &options)
+	call.err = err
+	d.mu.Lock()
+	delete(d.inflight, cacheFilename)
+	d.mu.Unlock()
+	close(call.done)
+	return f, err
+}
+
+// DownloadFirst calls Download for each URL in order and returns the file for
+// the first URL that succeeds. The file is cached under the URL that succeeded
+// (or the cache key, if WithCacheKey is given). This is useful for trying a
+// primary host and then falling back to mirrors. If every URL fails, then the
+// returned error describes all the failures and IsNotFound(err) reports
+// whether all of them were not found.
+func (d *Downloader) DownloadFirst(ctx context.Context, urls []string, opts ...DownloadOption) (*os.File, error) {
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("download: no URLs given")
+	}
+	var errs downloadErrors
+	for _, url := range urls {
+		f, err := d.Download(ctx, url, opts...)
+		if err == nil {
+			return f, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		log.Debugf(ctx, "%v", err)
+		errs = append(errs, err)
+	}
+	if len(errs) == 1 {
+		return nil, errs[0]
+	}
+	return nil, errs
+}
+
+// Path returns the path of the file that Download would use to cache the
+// given URL and reports whether the file is present in the cache.
+// The file may be stale or in the middle of being written: callers that need
+// up-to-date content should call Download, whose returned file's Name method
+// reports the same path.
+func (d *Downloader) Path(url string, opts ...DownloadOption) (path string, cached bool) {
+	var options downloadOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	path = d.cacheFilename(url, &options)
+	info, err := os.Stat(path)
+	return path, err == nil && info.Mode().IsRegular() && info.Size() > 0
+}
+
+func (d *Downloader) cacheFilename(url string, opts *downloadOptions) string {
+	cacheKey := url
+	if opts.cacheKey != "" {
+		cacheKey = opts.cacheKey
+	}
+	if d.layout == HashedLayout {
+		return filepath.Join(d.dir, hashedCachePath(cacheKey))
+	}
+	return filepath.Join(d.dir, cacheFilenameForURL(cacheKey))
+}
+
+// fetch populates cacheFilename with the content of url, reusing the
+// existing file if it is still valid.
+func (d *Downloader) fetch(ctx context.Context, cacheFilename string, url string, opts *downloadOptions) (_ *os.File, err error) {
 	if err := os.MkdirAll(filepath.Dir(cacheFilename), 0777); err != nil {
 		return nil, fmt.Errorf("download %s: %w", url, err)
 	}
@@ -98,37 +395,64 @@ func (d *Downloader) Download(ctx context.Context, url string) (_ *os.File, err
 			if err := os.Remove(cacheFilename); err != nil {
 				log.Warnf(ctx, "Failed to clean up failed download: %v", err)
 			}
+			if err := os.Remove(validatorsFilename(cacheFilename)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				log.Warnf(ctx, "Failed to clean up failed download: %v", err)
+			}
 		}
 	}()
 
-	cacheErr := d.validateDownloadCache(ctx, f, url)
-	if cacheErr == nil {
-		log.Infof(ctx, "Reusing cached version of %s", url)
-		return f, nil
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("download %s: %w", url, err)
 	}
-	if IsNotFound(cacheErr) {
-		return nil, fmt.Errorf("download %s: %w", url, cacheErr)
+	cached := info.Size() > 0
+	if cached && opts.cacheKey != "" && !opts.revalidate {
+		log.Infof(ctx, "Reusing cached version of %s (key %q)", url, opts.cacheKey)
+		return f, nil
 	}
-	log.Debugf(ctx, "Cache error: %v", cacheErr)
-	log.Infof(ctx, "Not using cache for %s", url)
-	if err := f.Truncate(0); err != nil {
+	if err := d.acquire(ctx); err != nil {
 		return nil, fmt.Errorf("download %s: %w", url, err)
 	}
-	if err := d.download(ctx, f, url); err != nil {
+	defer d.release()
+	var validators cacheValidators
+	if cached {
+		validators = readCacheValidators(ctx, cacheFilename)
+		if validators.isEmpty() && !opts.revalidate {
+			cacheErr := d.validateDownloadCache(ctx, f, url, opts.header)
+			if cacheErr == nil {
+				log.Infof(ctx, "Reusing cached version of %s", url)
+				return f, nil
+			}
+			if IsNotFound(cacheErr) {
+				return nil, fmt.Errorf("download %s: %w", url, cacheErr)
+			}
+			log.Debugf(ctx, "Cache error: %v", cacheErr)
+			log.Infof(ctx, "Not using cache for %s", url)
+		}
+	}
+	newValidators, notModified, err := d.download(ctx, f, url, opts.header, validators)
+	if err != nil {
 		return nil, fmt.Errorf("download %s: %w", url, err)
 	}
+	if notModified {
+		log.Infof(ctx, "Reusing cached version of %s (not modified)", url)
+		return f, nil
+	}
+	if err := writeCacheValidators(cacheFilename, newValidators); err != nil {
+		log.Warnf(ctx, "Failed to save cache validators for %s: %v", url, err)
+	}
 	if _, err := f.Seek(0, io.SeekStart); err != nil {
 		return nil, fmt.Errorf("download %s: %w", url, err)
 	}
 	return f, nil
 }
 
-func (d *Downloader) validateDownloadCache(ctx context.Context, statter interface{ Stat() (os.FileInfo, error) }, url string) (err error) {
+func (d *Downloader) validateDownloadCache(ctx context.Context, statter interface{ Stat() (os.FileInfo, error) }, url string, header http.Header) (err error) {
 	info, err := statter.Stat()
 	if err != nil {
 		return fmt.Errorf("validate %s download cache: %w", url, err)
 	}
-	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	req, err := newRequest(ctx, http.MethodHead, url, header)
 	if err != nil {
 		return fmt.Errorf("validate %s download cache: %w", url, err)
 	}
@@ -149,6 +473,60 @@ func (d *Downloader) validateDownloadCache(ctx context.Context, statter interfac
 	return nil
 }
 
+// cacheValidators holds the HTTP validators for a cached file.
+type cacheValidators struct {
+	ETag         string `json:",omitempty"`
+	LastModified string `json:",omitempty"`
+}
+
+func (v cacheValidators) isEmpty() bool {
+	return v.ETag == "" && v.LastModified == ""
+}
+
+// validatorsFilename returns the path of the file that stores the validators
+// for the given cache file. cacheFilenameForURL never produces a hyphen
+// and hashedCachePath places each file in its own directory,
+// so this cannot collide with another cache file.
+func validatorsFilename(cacheFilename string) string {
+	return cacheFilename + "-validators.json"
+}
+
+// readCacheValidators reads the validators for the given cache file.
+// Any errors are logged and cause an empty set of validators to be returned.
+func readCacheValidators(ctx context.Context, cacheFilename string) cacheValidators {
+	data, err := os.ReadFile(validatorsFilename(cacheFilename))
+	if errors.Is(err, os.ErrNotExist) {
+		return cacheValidators{}
+	}
+	if err != nil {
+		log.Debugf(ctx, "Reading cache validators: %v", err)
+		return cacheValidators{}
+	}
+	var v cacheValidators
+	if err := json.Unmarshal(data, &v); err != nil {
+		log.Debugf(ctx, "Reading cache validators: %v", err)
+		return cacheValidators{}
+	}
+	return v
+}
+
+// writeCacheValidators saves the validators for the given cache file,
+// or removes any previously saved validators if v is empty.
+func writeCacheValidators(cacheFilename string, v cacheValidators) error {
+	path := validatorsFilename(cacheFilename)
+	if v.isEmpty() {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0666)
+}
+
 var cacheFilenameUnsafeChars = regexp.MustCompile(`[^a-zA-Z0-9.]+`)
 
 func cacheFilenameForURL(url string) string {
@@ -156,9 +534,76 @@ func cacheFilenameForURL(url string) string {
 	return cacheFilenameUnsafeChars.ReplaceAllString(url, "")
 }
 
+// hashedCachePath returns the slash-separated path of the cache file
+// for the given key relative to the cache directory in HashedLayout.
+func hashedCachePath(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:]) + "/" + safeBasename(key)
+}
+
+// maxBasenameLen is the maximum length of a filename returned by safeBasename.
+// Most filesystems permit 255 bytes, but a shorter name leaves room for
+// suffixes like the one added by validatorsFilename.
+const maxBasenameLen = 100
+
+var unsafeBasenameChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// safeBasename returns a filename derived from the last element of the key's
+// path that is valid on Unix-like systems and Windows.
+func safeBasename(key string) string {
+	p := key
+	if u, err := url.Parse(key); err == nil && u.Host != "" {
+		// Ignore the query and fragment. If the path is empty,
+		// the host is the last element.
+		p = u.Host + u.Path
+	}
+	p = strings.TrimRight(p, "/")
+	name := p[strings.LastIndex(p, "/")+1:]
+	name = unsafeBasenameChars.ReplaceAllString(name, "_")
+	if len(name) > maxBasenameLen {
+		name = name[len(name)-maxBasenameLen:]
+	}
+	// Windows does not permit names ending in a dot
+	// and "." and ".." are special everywhere.
+	name = strings.TrimRight(name, ".")
+	if name == "" {
+		return "download"
+	}
+	if isWindowsReservedName(name) {
+		name = "_" + name
+	}
+	return name
+}
+
+// isWindowsReservedName reports whether name refers to a device on Windows,
+// regardless of extension.
+func isWindowsReservedName(name string) bool {
+	base := name
+	if i := strings.IndexByte(base, '.'); i >= 0 {
+		base = base[:i]
+	}
+	switch strings.ToUpper(base) {
+	case "CON", "PRN", "AUX", "NUL",
+		"COM1", "COM2", "COM3", "COM4", "COM5", "COM6", "COM7", "COM8", "COM9",
+		"LPT1", "LPT2", "LPT3", "LPT4", "LPT5", "LPT6", "LPT7", "LPT8", "LPT9":
+		return true
+	default:
+		return false
+	}
+}
+
 // IsNotFound reports whether e indicates an HTTP 404 Not Found or
 // 410 Gone response.
 func IsNotFound(e error) bool {
+	var errs downloadErrors
+	if errors.As(e, &errs) {
+		for _, err := range errs {
+			if !IsNotFound(err) {
+				return false
+			}
+		}
+		return len(errs) > 0
+	}
 	var httpErr httpError
 	if !errors.As(e, &httpErr) {
 		return false
@@ -167,6 +612,22 @@ func IsNotFound(e error) bool {
 		httpErr.statusCode == http.StatusGone
 }
 
+// downloadErrors is the error returned by DownloadFirst
+// when all of its URLs fail.
+type downloadErrors []error
+
+func (e downloadErrors) Error() string {
+	sb := new(strings.Builder)
+	sb.WriteString("all downloads failed: ")
+	for i, err := range e {
+		if i > 0 {
+			sb.WriteString("; ")
+		}
+		sb.WriteString(err.Error())
+	}
+	return sb.String()
+}
+
 type httpError struct {
 	status     string
 	statusCode int
@@ -19,6 +19,7 @@ package downloader
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -26,7 +27,11 @@ import (
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/yourbase/commons/http/headers"
 	"zombiezen.com/go/log/testlog"
@@ -109,6 +114,570 @@ func TestDownload(t *testing.T) {
 	}
 }
 
+func TestDownloadTruncated(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.Header().Set(headers.ContentLength, "100")
+			return
+		}
+		// Promise more content than is sent, then drop the connection.
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 100\r\n\r\n")
+		buf.WriteString("Hello, World!\n")
+		buf.Flush()
+	}))
+	t.Cleanup(srv.Close)
+	dir := t.TempDir()
+	d := New(dir)
+	d.Client = srv.Client()
+	ctx := testlog.WithTB(context.Background(), t)
+
+	f, err := d.Download(ctx, srv.URL+"/file.txt")
+	if err == nil {
+		f.Close()
+		t.Fatal("Download did not return an error")
+	}
+	t.Log("Download error:", err)
+	if path, cached := d.Path(srv.URL + "/file.txt"); cached {
+		t.Errorf("truncated download cached at %s", path)
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, info := range files {
+		t.Errorf("cache contains %s after failed download", info.Name())
+	}
+}
+
+func TestDownloadWithHeader(t *testing.T) {
+	const secret = "s3cr3t-t0ken"
+	const content = "Hello, World!\n"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(headers.Authorization) != "Bearer "+secret {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set(headers.ContentLength, fmt.Sprint(len(content)))
+		w.Header().Set(headers.ETag, `"abc"`)
+		io.WriteString(w, content)
+	}))
+	t.Cleanup(srv.Close)
+	dir := t.TempDir()
+	d := New(dir)
+	d.Client = srv.Client()
+	logs := new(logRecorder)
+	ctx := testlog.WithTB(context.Background(), logs)
+	url := srv.URL + "/file.txt"
+
+	if f, err := d.Download(ctx, url); err == nil {
+		f.Close()
+		t.Error("Download without header did not return an error")
+	} else if strings.Contains(err.Error(), secret) {
+		t.Errorf("error %q contains credentials", err)
+	}
+
+	opt := WithHeader(http.Header{headers.Authorization: {"Bearer " + secret}})
+	for i := 0; i < 2; i++ {
+		f, err := d.Download(ctx, url, opt)
+		if err != nil {
+			t.Fatalf("Download #%d: %v", i+1, err)
+		}
+		got, err := ioutil.ReadAll(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("Download #%d: %v", i+1, err)
+		}
+		if string(got) != content {
+			t.Errorf("Download #%d content = %q; want %q", i+1, got, content)
+		}
+	}
+
+	pathWithHeader, _ := d.Path(url, opt)
+	if path, _ := d.Path(url); path != pathWithHeader {
+		t.Errorf("Path(url, WithHeader(...)) = %q; want %q", pathWithHeader, path)
+	}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.Contains(path, secret) {
+			t.Errorf("cache path %s contains credentials", path)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if strings.Contains(string(data), secret) {
+			t.Errorf("cache file %s contains credentials", path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	logs.mu.Lock()
+	defer logs.mu.Unlock()
+	if len(logs.msgs) == 0 {
+		t.Error("nothing logged")
+	}
+	for _, msg := range logs.msgs {
+		if strings.Contains(msg, secret) {
+			t.Errorf("log message %q contains credentials", msg)
+		}
+	}
+}
+
+// logRecorder is a testlog.TB that records messages.
+type logRecorder struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (r *logRecorder) Log(args ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.msgs = append(r.msgs, fmt.Sprint(args...))
+}
+
+func TestDownloadShortBody(t *testing.T) {
+	// Simulate a transport that reports a clean EOF before Content-Length
+	// bytes have been read.
+	dir := t.TempDir()
+	d := New(dir)
+	d.Client = &http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				Status:        "200 OK",
+				StatusCode:    http.StatusOK,
+				Proto:         "HTTP/1.1",
+				ProtoMajor:    1,
+				ProtoMinor:    1,
+				Header:        http.Header{headers.ContentLength: {"100"}},
+				ContentLength: 100,
+				Body:          ioutil.NopCloser(strings.NewReader("Hello, World!\n")),
+				Request:       req,
+			}, nil
+		}),
+	}
+	ctx := testlog.WithTB(context.Background(), t)
+
+	const url = "http://example.com/file.txt"
+	f, err := d.Download(ctx, url)
+	if err == nil {
+		f.Close()
+		t.Fatal("Download did not return an error")
+	}
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Download error = %v; want %v", err, io.ErrUnexpectedEOF)
+	}
+	if path, cached := d.Path(url); cached {
+		t.Errorf("truncated download cached at %s", path)
+	}
+}
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestDownloadConcurrent(t *testing.T) {
+	const content = "Hello, World!\n"
+	var getCount int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(headers.ContentLength, fmt.Sprint(len(content)))
+		if r.Method == http.MethodGet {
+			atomic.AddInt32(&getCount, 1)
+			time.Sleep(50 * time.Millisecond)
+		}
+		io.WriteString(w, content)
+	}))
+	t.Cleanup(srv.Close)
+	d := New(t.TempDir())
+	d.Client = srv.Client()
+	d.MaxConcurrentDownloads = 2
+
+	const n = 20
+	ctx := testlog.WithTB(context.Background(), t)
+	var wg sync.WaitGroup
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			f, err := d.Download(ctx, srv.URL)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			defer f.Close()
+			data, err := ioutil.ReadAll(f)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			if string(data) != content {
+				errs[i] = fmt.Errorf("content = %q; want %q", data, content)
+			}
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("Download #%d: %v", i, err)
+		}
+	}
+	if got := atomic.LoadInt32(&getCount); got != 1 {
+		t.Errorf("server received %d GET requests; want 1", got)
+	}
+}
+
+func TestDownloadConcurrentCancel(t *testing.T) {
+	const content = "Hello, World!\n"
+	var getCount int32
+	firstStarted := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set(headers.ContentLength, fmt.Sprint(len(content)))
+			return
+		}
+		if atomic.AddInt32(&getCount, 1) == 1 {
+			// Hold the first transfer open until its client gives up.
+			close(firstStarted)
+			<-r.Context().Done()
+			return
+		}
+		w.Header().Set(headers.ContentLength, fmt.Sprint(len(content)))
+		io.WriteString(w, content)
+	}))
+	t.Cleanup(srv.Close)
+	d := New(t.TempDir())
+	d.Client = srv.Client()
+
+	ctx := testlog.WithTB(context.Background(), t)
+	leaderCtx, cancelLeader := context.WithCancel(ctx)
+	leaderErr := make(chan error, 1)
+	go func() {
+		f, err := d.Download(leaderCtx, srv.URL)
+		if err == nil {
+			f.Close()
+		}
+		leaderErr <- err
+	}()
+	<-firstStarted
+	type result struct {
+		data []byte
+		err  error
+	}
+	waiterResult := make(chan result, 1)
+	go func() {
+		f, err := d.Download(ctx, srv.URL)
+		if err != nil {
+			waiterResult <- result{err: err}
+			return
+		}
+		defer f.Close()
+		data, err := ioutil.ReadAll(f)
+		waiterResult <- result{data, err}
+	}()
+	// Give the second call time to start waiting on the first.
+	time.Sleep(50 * time.Millisecond)
+	cancelLeader()
+
+	if err := <-leaderErr; err == nil {
+		t.Error("Download with cancelled Context succeeded")
+	}
+	// The second call must not fail because the first call was cancelled.
+	if got := <-waiterResult; got.err != nil {
+		t.Error("Download:", got.err)
+	} else if string(got.data) != content {
+		t.Errorf("content = %q; want %q", got.data, content)
+	}
+}
+
+func TestDownloadCacheKey(t *testing.T) {
+	const content = "Hello, World!\n"
+	var requestCount int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/file", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requestCount, 1)
+		w.Header().Set(headers.ContentLength, fmt.Sprint(len(content)))
+		io.WriteString(w, content)
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/file?"+r.URL.RawQuery, http.StatusFound)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	dir := t.TempDir()
+	d := New(dir)
+	d.Client = srv.Client()
+	ctx := testlog.WithTB(context.Background(), t)
+
+	for i, url := range []string{srv.URL + "/redirect?sig=1", srv.URL + "/redirect?sig=2"} {
+		f, err := d.Download(ctx, url, WithCacheKey("myfile"))
+		if err != nil {
+			t.Fatalf("Download #%d: %v", i+1, err)
+		}
+		data, err := ioutil.ReadAll(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != content {
+			t.Errorf("Download #%d content = %q; want %q", i+1, data, content)
+		}
+	}
+	if got := atomic.LoadInt32(&requestCount); got > 2 {
+		t.Errorf("server received %d requests; want <=2 (HEAD and GET for first download only)", got)
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("cache has %d files; want 1", len(files))
+	}
+}
+
+func TestDownloadRevalidate(t *testing.T) {
+	var (
+		mu          sync.Mutex
+		content     = "version 1\n"
+		etag        = `"v1"`
+		fullCount   int
+		notModCount int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			notModCount++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		fullCount++
+		w.Header().Set(headers.ContentLength, fmt.Sprint(len(content)))
+		io.WriteString(w, content)
+	}))
+	t.Cleanup(srv.Close)
+	d := New(t.TempDir())
+	d.Client = srv.Client()
+	ctx := testlog.WithTB(context.Background(), t)
+	download := func(want string, opts ...DownloadOption) {
+		t.Helper()
+		f, err := d.Download(ctx, srv.URL, opts...)
+		if err != nil {
+			t.Fatal("Download:", err)
+		}
+		data, err := ioutil.ReadAll(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Errorf("content = %q; want %q", data, want)
+		}
+	}
+
+	download("version 1\n")
+	download("version 1\n")
+	mu.Lock()
+	if fullCount != 1 || notModCount != 1 {
+		t.Errorf("after unchanged resource: full responses = %d, not modified responses = %d; want 1, 1", fullCount, notModCount)
+	}
+	content = "version 2!\n"
+	etag = `"v2"`
+	mu.Unlock()
+
+	download("version 2!\n")
+	mu.Lock()
+	if fullCount != 2 {
+		t.Errorf("after changed resource: full responses = %d; want 2", fullCount)
+	}
+	content = "version 3!\n"
+	etag = `"v3"`
+	mu.Unlock()
+
+	download("version 3!\n", WithCacheKey("foo"))
+	mu.Lock()
+	content = "version 4!\n"
+	etag = `"v4"`
+	mu.Unlock()
+	download("version 3!\n", WithCacheKey("foo"))
+	download("version 4!\n", WithCacheKey("foo"), WithRevalidate())
+}
+
+func TestDownloadFirst(t *testing.T) {
+	const content = "Hello, World!\n"
+	mux := http.NewServeMux()
+	mux.HandleFunc("/file", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(headers.ContentLength, fmt.Sprint(len(content)))
+		io.WriteString(w, content)
+	})
+	mux.HandleFunc("/broken", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bork", http.StatusInternalServerError)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	ctx := testlog.WithTB(context.Background(), t)
+
+	t.Run("Fallback", func(t *testing.T) {
+		d := New(t.TempDir())
+		d.Client = srv.Client()
+		f, err := d.DownloadFirst(ctx, []string{srv.URL + "/missing", srv.URL + "/broken", srv.URL + "/file"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		data, err := ioutil.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != content {
+			t.Errorf("content = %q; want %q", data, content)
+		}
+		if want, _ := d.Path(srv.URL + "/file"); f.Name() != want {
+			t.Errorf("f.Name() = %q; want %q", f.Name(), want)
+		}
+	})
+
+	t.Run("AllNotFound", func(t *testing.T) {
+		d := New(t.TempDir())
+		d.Client = srv.Client()
+		_, err := d.DownloadFirst(ctx, []string{srv.URL + "/missing1", srv.URL + "/missing2"})
+		if err == nil {
+			t.Fatal("DownloadFirst did not return an error")
+		}
+		t.Logf("DownloadFirst: %v", err)
+		if !IsNotFound(err) {
+			t.Errorf("IsNotFound(err) = false; want true")
+		}
+	})
+
+	t.Run("AllFailed", func(t *testing.T) {
+		d := New(t.TempDir())
+		d.Client = srv.Client()
+		_, err := d.DownloadFirst(ctx, []string{srv.URL + "/missing", srv.URL + "/broken"})
+		if err == nil {
+			t.Fatal("DownloadFirst did not return an error")
+		}
+		t.Logf("DownloadFirst: %v", err)
+		if IsNotFound(err) {
+			t.Errorf("IsNotFound(err) = true; want false")
+		}
+	})
+}
+
+func TestPath(t *testing.T) {
+	const content = "Hello, World!\n"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(headers.ContentLength, fmt.Sprint(len(content)))
+		io.WriteString(w, content)
+	}))
+	t.Cleanup(srv.Close)
+	d := New(t.TempDir())
+	d.Client = srv.Client()
+
+	if path, cached := d.Path(srv.URL); cached {
+		t.Errorf("before download, d.Path(%q) = %q, true; want _, false", srv.URL, path)
+	}
+	f, err := d.Download(testlog.WithTB(context.Background(), t), srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	path, cached := d.Path(srv.URL)
+	if !cached || path != f.Name() {
+		t.Errorf("after download, d.Path(%q) = %q, %t; want %q, true", srv.URL, path, cached, f.Name())
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != content {
+		t.Errorf("content = %q; want %q", data, content)
+	}
+}
+
+func TestHashedLayout(t *testing.T) {
+	const content = "Hello, World!\n"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(headers.ContentLength, fmt.Sprint(len(content)))
+		io.WriteString(w, content)
+	}))
+	t.Cleanup(srv.Close)
+	dir := t.TempDir()
+	d := NewWithOptions(dir, &Options{Layout: HashedLayout})
+	d.Client = srv.Client()
+	ctx := testlog.WithTB(context.Background(), t)
+
+	urls := []string{
+		srv.URL + "/a/go1.17.3.linux-amd64.tar.gz",
+		srv.URL + "/b/go1.17.3.linux-amd64.tar.gz",
+	}
+	for _, u := range urls {
+		f, err := d.Download(ctx, u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		if got, want := filepath.Base(f.Name()), "go1.17.3.linux-amd64.tar.gz"; got != want {
+			t.Errorf("Download(ctx, %q) cached at %s; want name %s", u, f.Name(), want)
+		}
+		if got, want := filepath.Dir(filepath.Dir(f.Name())), dir; got != want {
+			t.Errorf("Download(ctx, %q) cached at %s; want in a subdirectory of %s", u, f.Name(), want)
+		}
+		if path, cached := d.Path(u); !cached || path != f.Name() {
+			t.Errorf("d.Path(%q) = %q, %t; want %q, true", u, path, cached, f.Name())
+		}
+	}
+	subdirs, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subdirs) != len(urls) {
+		t.Errorf("cache has %d entries; want %d", len(subdirs), len(urls))
+	}
+}
+
+func TestSafeBasename(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{key: "https://example.com/dl/go1.17.3.linux-amd64.tar.gz", want: "go1.17.3.linux-amd64.tar.gz"},
+		{key: "https://example.com/dl/foo.zip?sig=abc#frag", want: "foo.zip"},
+		{key: "https://example.com/dl/", want: "dl"},
+		{key: "https://example.com", want: "example.com"},
+		{key: "https://example.com/", want: "example.com"},
+		{key: "https://example.com/a%20b%3Ac.txt", want: "a_b_c.txt"},
+		{key: "https://example.com/..", want: "download"},
+		{key: "https://example.com/file.", want: "file"},
+		{key: "https://example.com/con.txt", want: "_con.txt"},
+		{key: "https://example.com/LPT1", want: "_LPT1"},
+		{key: "myfile", want: "myfile"},
+		{key: "my key:v1", want: "my_key_v1"},
+		{key: "", want: "download"},
+		{key: "https://example.com/" + strings.Repeat("x", 200) + ".tar.gz", want: strings.Repeat("x", 93) + ".tar.gz"},
+	}
+	for _, test := range tests {
+		if got := safeBasename(test.key); got != test.want {
+			t.Errorf("safeBasename(%q) = %q; want %q", test.key, got, test.want)
+		}
+	}
+}
+
 func TestValidateDownloadCache(t *testing.T) {
 	tests := []struct {
 		name         string
@@ -190,7 +759,7 @@ func TestValidateDownloadCache(t *testing.T) {
 
 			d := New(dir)
 			d.Client = srv.Client()
-			err = d.validateDownloadCache(context.Background(), f, srv.URL)
+			err = d.validateDownloadCache(context.Background(), f, srv.URL, nil)
 			if err != nil {
 				t.Logf("validateDownloadCache: %v", err)
 				if !test.wantError {
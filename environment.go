@@ -18,9 +18,14 @@ package biome
 
 import (
 	"context"
+	"fmt"
 	"io"
+	"io/fs"
+	slashpath "path"
 	"sort"
 	"strings"
+
+	"zombiezen.com/go/biome/internal/windowspath"
 )
 
 // Environment holds environment variables. The zero value is an empty
@@ -28,6 +33,10 @@ import (
 type Environment struct {
 	// Vars is a mapping of variables.
 	Vars map[string]string
+	// ExpandVars is the set of variables in Vars whose values
+	// may refer to other variables. See Expand for details.
+	// The values of other variables are always used verbatim.
+	ExpandVars map[string]bool
 	// PrependPath is a list of paths to prepend to PATH.
 	PrependPath []string
 	// AppendPath is a list of paths to append to PATH.
@@ -39,22 +48,224 @@ func (env Environment) IsEmpty() bool {
 	return len(env.Vars) == 0 && len(env.PrependPath) == 0 && len(env.AppendPath) == 0
 }
 
+// Validate returns an error if env cannot be represented correctly
+// in a process's environment. Every variable name must be a valid shell
+// variable name (a letter or underscore followed by letters, digits, or
+// underscores) and every PrependPath and AppendPath entry must be an absolute
+// path. Since an Environment is not tied to a particular operating system,
+// both POSIX and Windows absolute paths are accepted.
+func (env Environment) Validate() error {
+	keys := make([]string, 0, len(env.Vars))
+	for k := range env.Vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if !isShellVarName(k) {
+			return fmt.Errorf("invalid environment variable name %q", k)
+		}
+	}
+	for k, expand := range env.ExpandVars {
+		if _, ok := env.Vars[k]; expand && !ok {
+			return fmt.Errorf("expand %s: variable not set", k)
+		}
+	}
+	for _, p := range env.PrependPath {
+		if !isAnyAbsPath(p) {
+			return fmt.Errorf("prepend to PATH: %q is not an absolute path", p)
+		}
+	}
+	for _, p := range env.AppendPath {
+		if !isAnyAbsPath(p) {
+			return fmt.Errorf("append to PATH: %q is not an absolute path", p)
+		}
+	}
+	return nil
+}
+
+// isShellVarName reports whether s is a valid POSIX shell variable name.
+func isShellVarName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		isLetter := 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '_'
+		isDigit := '0' <= c && c <= '9'
+		if !isLetter && (i == 0 || !isDigit) {
+			return false
+		}
+	}
+	return true
+}
+
+func isAnyAbsPath(path string) bool {
+	return slashpath.IsAbs(path) || windowspath.IsAbs(path)
+}
+
 // Merge returns a new environment that merges env2 into env.
+// env2 takes precedence: its variables replace any of the same name in env,
+// its PrependPath entries come before env's PrependPath entries, and its
+// AppendPath entries come after env's AppendPath entries. Thus, if env2
+// holds the most recently installed tools, they are found first on PATH.
+// The result does not share any memory with env or env2.
 func (env Environment) Merge(env2 Environment) Environment {
 	env3 := Environment{
 		Vars:        make(map[string]string),
-		PrependPath: append(env2.PrependPath[:len(env2.PrependPath):len(env2.PrependPath)], env.PrependPath...),
-		AppendPath:  append(env.AppendPath[:len(env.AppendPath):len(env.AppendPath)], env2.AppendPath...),
+		PrependPath: make([]string, 0, len(env2.PrependPath)+len(env.PrependPath)),
+		AppendPath:  make([]string, 0, len(env.AppendPath)+len(env2.AppendPath)),
 	}
+	env3.PrependPath = append(env3.PrependPath, env2.PrependPath...)
+	env3.PrependPath = append(env3.PrependPath, env.PrependPath...)
+	env3.AppendPath = append(env3.AppendPath, env.AppendPath...)
+	env3.AppendPath = append(env3.AppendPath, env2.AppendPath...)
 	for k, v := range env.Vars {
 		env3.Vars[k] = v
 	}
 	for k, v := range env2.Vars {
 		env3.Vars[k] = v
 	}
+	for k := range env3.Vars {
+		_, overridden := env2.Vars[k]
+		if overridden && env2.ExpandVars[k] || !overridden && env.ExpandVars[k] {
+			if env3.ExpandVars == nil {
+				env3.ExpandVars = make(map[string]bool)
+			}
+			env3.ExpandVars[k] = true
+		}
+	}
 	return env3
 }
 
+// Expand returns a copy of env with references to variables in the values of
+// the variables in env.ExpandVars replaced by the referenced variables' values.
+// Other values are copied verbatim, so a "$" in them keeps its literal meaning.
+// A reference is either $NAME or ${NAME}, where NAME is a valid shell
+// variable name. "$$" stands for a literal "$", and any other "$" that does
+// not start a reference is left as-is. PrependPath and AppendPath are not
+// expanded. The returned environment's ExpandVars is nil.
+//
+// References are resolved against env.Vars first, whose values are
+// themselves expanded if they are in env.ExpandVars, and then with lookup,
+// which may be nil. Values returned by lookup are used verbatim.
+// Expand returns an error if a reference cannot be resolved or if variables
+// refer to each other in a cycle.
+func (env Environment) Expand(lookup func(name string) (string, bool)) (Environment, error) {
+	e := &expander{
+		vars:       env.Vars,
+		expandVars: env.ExpandVars,
+		lookup:     lookup,
+		expanded:   make(map[string]string, len(env.Vars)),
+		active:     make(map[string]bool),
+	}
+	keys := make([]string, 0, len(env.Vars))
+	for k := range env.Vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	env2 := Environment{
+		PrependPath: append([]string(nil), env.PrependPath...),
+		AppendPath:  append([]string(nil), env.AppendPath...),
+	}
+	if env.Vars != nil {
+		env2.Vars = make(map[string]string, len(env.Vars))
+	}
+	for _, k := range keys {
+		v, err := e.get(k)
+		if err != nil {
+			return Environment{}, err
+		}
+		env2.Vars[k] = v
+	}
+	return env2, nil
+}
+
+// expander holds the state of an Environment.Expand call.
+type expander struct {
+	vars       map[string]string
+	expandVars map[string]bool
+	lookup     func(name string) (string, bool)
+	expanded   map[string]string
+	// active is the set of variables currently being expanded,
+	// used to detect cycles.
+	active map[string]bool
+}
+
+func (e *expander) get(name string) (string, error) {
+	if v, ok := e.expanded[name]; ok {
+		return v, nil
+	}
+	raw, ok := e.vars[name]
+	if !ok {
+		if e.lookup != nil {
+			if v, ok := e.lookup(name); ok {
+				return v, nil
+			}
+		}
+		return "", fmt.Errorf("$%s is not defined", name)
+	}
+	if !e.expandVars[name] {
+		return raw, nil
+	}
+	if e.active[name] {
+		return "", fmt.Errorf("$%s refers to itself", name)
+	}
+	e.active[name] = true
+	v, err := e.expand(raw)
+	delete(e.active, name)
+	if err != nil {
+		return "", fmt.Errorf("expand $%s: %w", name, err)
+	}
+	e.expanded[name] = v
+	return v, nil
+}
+
+func (e *expander) expand(s string) (string, error) {
+	if !strings.Contains(s, "$") {
+		return s, nil
+	}
+	sb := new(strings.Builder)
+	for {
+		i := strings.IndexByte(s, '$')
+		if i == -1 {
+			sb.WriteString(s)
+			return sb.String(), nil
+		}
+		sb.WriteString(s[:i])
+		s = s[i+1:]
+		var name string
+		switch {
+		case strings.HasPrefix(s, "$"):
+			sb.WriteString("$")
+			s = s[1:]
+			continue
+		case strings.HasPrefix(s, "{"):
+			end := strings.IndexByte(s, '}')
+			if end == -1 || !isShellVarName(s[1:end]) {
+				return "", fmt.Errorf("bad substitution in %q", "$"+s)
+			}
+			name = s[1:end]
+			s = s[end+1:]
+		default:
+			n := 0
+			for n < len(s) && isShellVarName(s[:n+1]) {
+				n++
+			}
+			if n == 0 {
+				sb.WriteString("$")
+				continue
+			}
+			name = s[:n]
+			s = s[n:]
+		}
+		v, err := e.get(name)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(v)
+	}
+}
+
 const pathVar = "PATH"
 
 // appendTo appends a sorted list of variables in the form "key=value" to the
@@ -134,6 +345,11 @@ type EnvBiome struct {
 	Env Environment
 }
 
+// Unwrap returns eb.Biome.
+func (eb EnvBiome) Unwrap() Biome {
+	return eb.Biome
+}
+
 // Run runs a command with eb.Env as a base environment with invoke.Env
 // merged in.
 func (eb EnvBiome) Run(ctx context.Context, invoke *Invocation) error {
@@ -161,11 +377,35 @@ func (eb EnvBiome) MkdirAll(ctx context.Context, path string) error {
 	return forwardMkdirAll(ctx, eb.Biome, path)
 }
 
+// MkdirAllPerm calls eb.Context.MkdirAllPerm or returns ErrUnsupported if not present.
+func (eb EnvBiome) MkdirAllPerm(ctx context.Context, path string, perm fs.FileMode) error {
+	return forwardMkdirAllPerm(ctx, eb.Biome, path, perm)
+}
+
+// HasCommand calls eb.Biome.HasCommand if present and eb.Env does not
+// change PATH. Otherwise, it looks up the command with eb.Env applied.
+func (eb EnvBiome) HasCommand(ctx context.Context, name string) (bool, error) {
+	if _, setsPath := eb.Env.Vars["PATH"]; setsPath || len(eb.Env.PrependPath) > 0 || len(eb.Env.AppendPath) > 0 {
+		return lookUpCommand(ctx, eb, name)
+	}
+	return HasCommand(ctx, eb.Biome, name)
+}
+
 // EvalSymlinks calls eb.Context.EvalSymlinks or returns ErrUnsupported if not present.
 func (eb EnvBiome) EvalSymlinks(ctx context.Context, path string) (string, error) {
 	return forwardEvalSymlinks(ctx, eb.Biome, path)
 }
 
+// Stat calls eb.Context.Stat or returns ErrUnsupported if not present.
+func (eb EnvBiome) Stat(ctx context.Context, path string) (fs.FileInfo, error) {
+	return forwardStat(ctx, eb.Biome, path)
+}
+
+// ReadDir calls eb.Context.ReadDir or returns ErrUnsupported if not present.
+func (eb EnvBiome) ReadDir(ctx context.Context, path string) ([]fs.DirEntry, error) {
+	return forwardReadDir(ctx, eb.Biome, path)
+}
+
 // Close calls eb.Biome.Close if such a method exists or returns nil if not present.
 func (eb EnvBiome) Close() error {
 	if c, ok := eb.Biome.(io.Closer); ok {
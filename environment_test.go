@@ -28,7 +28,11 @@ var _ interface {
 	fileOpener
 	fileWriter
 	dirMaker
+	dirPermMaker
+	commandFinder
 	symlinkEvaler
+	statter
+	dirReader
 } = EnvBiome{}
 
 func TestEnvironmentMerge(t *testing.T) {
@@ -63,6 +67,35 @@ func TestEnvironmentMerge(t *testing.T) {
 				"BAZ": "QUUX",
 			}},
 		},
+		{
+			env1: Environment{
+				Vars:       map[string]string{"A": "$HOME/a", "B": "$HOME/b", "C": "$c"},
+				ExpandVars: map[string]bool{"A": true, "B": true},
+			},
+			env2: Environment{
+				Vars:       map[string]string{"B": "$b", "C": "$HOME/c"},
+				ExpandVars: map[string]bool{"C": true},
+			},
+			want: Environment{
+				Vars:       map[string]string{"A": "$HOME/a", "B": "$b", "C": "$HOME/c"},
+				ExpandVars: map[string]bool{"A": true, "C": true},
+			},
+		},
+		{
+			env1: Environment{PrependPath: []string{"/old/bin", "/old/sbin"}},
+			env2: Environment{PrependPath: []string{"/new/bin", "/new/sbin"}},
+			want: Environment{PrependPath: []string{"/new/bin", "/new/sbin", "/old/bin", "/old/sbin"}},
+		},
+		{
+			env1: Environment{AppendPath: []string{"/old/bin", "/old/sbin"}},
+			env2: Environment{AppendPath: []string{"/new/bin", "/new/sbin"}},
+			want: Environment{AppendPath: []string{"/old/bin", "/old/sbin", "/new/bin", "/new/sbin"}},
+		},
+		{
+			env1: Environment{},
+			env2: Environment{PrependPath: []string{"/new/bin"}, AppendPath: []string{"/new/sbin"}},
+			want: Environment{PrependPath: []string{"/new/bin"}, AppendPath: []string{"/new/sbin"}},
+		},
 	}
 	for _, test := range tests {
 		got := test.env1.Merge(test.env2)
@@ -70,6 +103,17 @@ func TestEnvironmentMerge(t *testing.T) {
 			t.Errorf("Merging:\n\n%v\n\nand:\n\n%v\n\n-want +got:\n%s", test.env1, test.env2, diff)
 		}
 	}
+
+	t.Run("NoAliasing", func(t *testing.T) {
+		env1 := Environment{AppendPath: make([]string, 1, 10)}
+		env2 := Environment{PrependPath: make([]string, 1, 10)}
+		got := env1.Merge(env2)
+		got.PrependPath[0] = "changed"
+		got.AppendPath[0] = "changed"
+		if env1.AppendPath[0] != "" || env2.PrependPath[0] != "" {
+			t.Error("Merge result shares memory with its arguments")
+		}
+	})
 }
 
 func TestEnvironmentAppend(t *testing.T) {
@@ -185,3 +229,172 @@ func TestEnvironmentAppend(t *testing.T) {
 		})
 	}
 }
+
+func TestEnvironmentValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		env  Environment
+		ok   bool
+	}{
+		{name: "Empty", ok: true},
+		{
+			name: "Valid",
+			env: Environment{
+				Vars:        map[string]string{"FOO": "bar", "_x1": "", "GOPATH": "=="},
+				PrependPath: []string{"/opt/go/bin"},
+				AppendPath:  []string{`C:\Go\bin`},
+			},
+			ok: true,
+		},
+		{name: "EmptyKey", env: Environment{Vars: map[string]string{"": "x"}}},
+		{name: "EqualsInKey", env: Environment{Vars: map[string]string{"A=B": "x"}}},
+		{name: "LeadingDigit", env: Environment{Vars: map[string]string{"1A": "x"}}},
+		{name: "Dash", env: Environment{Vars: map[string]string{"MY-VAR": "x"}}},
+		{name: "RelativePrependPath", env: Environment{PrependPath: []string{"bin"}}},
+		{name: "EmptyAppendPath", env: Environment{AppendPath: []string{""}}},
+		{name: "DriveRelativeAppendPath", env: Environment{AppendPath: []string{`C:bin`}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.env.Validate()
+			if test.ok && err != nil {
+				t.Errorf("Validate() = %v; want <nil>", err)
+			}
+			if !test.ok && err == nil {
+				t.Error("Validate() = <nil>; want error")
+			}
+		})
+	}
+}
+
+func TestEnvironmentExpand(t *testing.T) {
+	lookup := func(name string) (string, bool) {
+		if name == "HOME" {
+			return "/home/biome", true
+		}
+		return "", false
+	}
+	tests := []struct {
+		name string
+		vars map[string]string
+		// expand is the list of variables to expand.
+		// If it is nil, all variables are expanded.
+		expand []string
+		want   map[string]string
+		noErr  bool
+	}{
+		{name: "Empty", noErr: true},
+		{
+			name:   "NotExpanded",
+			vars:   map[string]string{"PS1": `\u$ `, "X": "a$b", "Y": "$HOME", "Z": "$$"},
+			expand: []string{},
+			want:   map[string]string{"PS1": `\u$ `, "X": "a$b", "Y": "$HOME", "Z": "$$"},
+			noErr:  true,
+		},
+		{
+			name:   "ReferToNotExpanded",
+			vars:   map[string]string{"PRICE": "$5", "MSG": "costs $PRICE"},
+			expand: []string{"MSG"},
+			want:   map[string]string{"PRICE": "$5", "MSG": "costs $5"},
+			noErr:  true,
+		},
+		{
+			name:  "Literal",
+			vars:  map[string]string{"FOO": "bar"},
+			want:  map[string]string{"FOO": "bar"},
+			noErr: true,
+		},
+		{
+			name:  "Lookup",
+			vars:  map[string]string{"GOPATH": "$HOME/go", "CARGO_HOME": "${HOME}/.cargo"},
+			want:  map[string]string{"GOPATH": "/home/biome/go", "CARGO_HOME": "/home/biome/.cargo"},
+			noErr: true,
+		},
+		{
+			name:  "Chained",
+			vars:  map[string]string{"GOBIN": "$GOPATH/bin", "GOPATH": "$HOME/go"},
+			want:  map[string]string{"GOBIN": "/home/biome/go/bin", "GOPATH": "/home/biome/go"},
+			noErr: true,
+		},
+		{
+			name:  "VarsTakePrecedence",
+			vars:  map[string]string{"HOME": "/elsewhere", "GOPATH": "$HOME/go"},
+			want:  map[string]string{"HOME": "/elsewhere", "GOPATH": "/elsewhere/go"},
+			noErr: true,
+		},
+		{
+			name:  "Escapes",
+			vars:  map[string]string{"PRICE": "$$5", "PS1": "$ ", "TRAILING": "a$", "DIGIT": "$1"},
+			want:  map[string]string{"PRICE": "$5", "PS1": "$ ", "TRAILING": "a$", "DIGIT": "$1"},
+			noErr: true,
+		},
+		{
+			name:  "NameEndsAtNonNameChar",
+			vars:  map[string]string{"FOO": "x", "BAR": "$FOO-$FOO.y"},
+			want:  map[string]string{"FOO": "x", "BAR": "x-x.y"},
+			noErr: true,
+		},
+		{name: "Undefined", vars: map[string]string{"FOO": "$NOPE"}},
+		{name: "SelfReference", vars: map[string]string{"FOO": "$FOO:x"}},
+		{name: "Cycle", vars: map[string]string{"A": "$B", "B": "${A}"}},
+		{name: "UnterminatedBrace", vars: map[string]string{"A": "${HOME"}},
+		{name: "BadBraceName", vars: map[string]string{"A": "${1x}"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			env := Environment{
+				Vars:        test.vars,
+				ExpandVars:  make(map[string]bool),
+				PrependPath: []string{"/opt/$HOME/bin"},
+			}
+			if test.expand == nil {
+				for k := range test.vars {
+					env.ExpandVars[k] = true
+				}
+			} else {
+				for _, k := range test.expand {
+					env.ExpandVars[k] = true
+				}
+			}
+			got, err := env.Expand(lookup)
+			if err != nil {
+				t.Log("Expand:", err)
+				if test.noErr {
+					t.Fail()
+				}
+				return
+			}
+			if !test.noErr {
+				t.Fatalf("Expand(...) = %v, <nil>; want error", got)
+			}
+			want := Environment{
+				Vars:        test.want,
+				PrependPath: []string{"/opt/$HOME/bin"},
+			}
+			if diff := cmp.Diff(want, got, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("Expand(...) (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("NilLookup", func(t *testing.T) {
+		env := Environment{
+			Vars:       map[string]string{"A": "x", "B": "$A/y"},
+			ExpandVars: map[string]bool{"B": true},
+		}
+		got, err := env.Expand(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := map[string]string{"A": "x", "B": "x/y"}; !cmp.Equal(want, got.Vars) {
+			t.Errorf("Expand(nil).Vars = %v; want %v", got.Vars, want)
+		}
+		undefinedHome := Environment{
+			Vars:       map[string]string{"A": "$HOME"},
+			ExpandVars: map[string]bool{"A": true},
+		}
+		if _, err := undefinedHome.Expand(nil); err == nil {
+			t.Error("Expand(nil) with undefined $HOME did not return an error")
+		}
+	})
+}
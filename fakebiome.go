@@ -17,8 +17,15 @@
 package biome
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
+	"io/fs"
+	slashpath "path"
+	"strings"
+	"sync"
+	"testing/fstest"
 )
 
 // Fake is a biome that operates in-memory. It uses POSIX-style paths, but
@@ -30,8 +37,36 @@ type Fake struct {
 	// DirsResult is what will be returned by Dirs.
 	DirsResult Dirs
 
+	// RunResult maps commands to the error Run returns for them.
+	// Keys are the invocation's arguments joined by spaces,
+	// like "rm -f foo.txt". A nil error means the command succeeds.
+	// Commands not in RunResult are passed to RunFunc.
+	RunResult map[string]error
+
 	// RunFunc is called to handle the Run method.
 	RunFunc func(context.Context, *Invocation) error
+
+	// CapturedInvocations is the list of invocations passed to Run,
+	// in the order Run was called.
+	CapturedInvocations []*Invocation
+
+	// OpenFileFunc is called to handle the OpenFile method.
+	// If OpenFileFunc is nil, then OpenFile uses FileSystem.
+	OpenFileFunc func(ctx context.Context, path string) (io.ReadCloser, error)
+
+	// WriteFileFunc is called to handle the WriteFile method.
+	// If WriteFileFunc is nil, then WriteFile uses FileSystem.
+	WriteFileFunc func(ctx context.Context, path string, src io.Reader) error
+
+	// FileSystem is the biome's filesystem. If FileSystem is not nil, then
+	// OpenFile, WriteFile, MkdirAll, EvalSymlinks, Stat, and ReadDir operate on
+	// it instead of returning ErrUnsupported. Keys are absolute biome paths converted to
+	// slashes with the leading slash removed. For example, the path
+	// "/home/foo.txt" is stored under the key "home/foo.txt".
+	FileSystem fstest.MapFS
+
+	// mu protects FileSystem and CapturedInvocations.
+	mu sync.Mutex
 }
 
 // Describe returns f.Descriptor.
@@ -44,14 +79,167 @@ func (f *Fake) Dirs() *Dirs {
 	return &f.DirsResult
 }
 
-// Run calls f.RunFunc. It returns an error if f.RunFunc is nil.
+// Run appends invoke to f.CapturedInvocations, then returns the command's
+// result from f.RunResult if present and calls f.RunFunc otherwise.
+// It returns an error if the command is not in f.RunResult and f.RunFunc is nil.
 func (f *Fake) Run(ctx context.Context, invoke *Invocation) error {
+	f.mu.Lock()
+	f.CapturedInvocations = append(f.CapturedInvocations, invoke)
+	f.mu.Unlock()
+	if err, ok := f.RunResult[strings.Join(invoke.Argv, " ")]; ok {
+		return err
+	}
 	if f.RunFunc == nil {
 		return fmt.Errorf("fake run: RunFunc not set")
 	}
 	return f.RunFunc(ctx, invoke)
 }
 
+// OpenFile calls f.OpenFileFunc if it is set
+// and opens a file in f.FileSystem otherwise.
+// It returns ErrUnsupported if both are nil.
+func (f *Fake) OpenFile(ctx context.Context, path string) (io.ReadCloser, error) {
+	if f.OpenFileFunc != nil {
+		return f.OpenFileFunc(ctx, path)
+	}
+	if f.FileSystem == nil {
+		return nil, fmt.Errorf("open file %s: %w", path, ErrUnsupported)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, err := fs.ReadFile(f.FileSystem, f.fsKey(path))
+	if err != nil {
+		return nil, fmt.Errorf("open file %s: %w", path, err)
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// WriteFile calls f.WriteFileFunc if it is set
+// and writes a file with the mode 0666 to f.FileSystem otherwise.
+// It returns ErrUnsupported if both are nil.
+func (f *Fake) WriteFile(ctx context.Context, path string, src io.Reader) error {
+	if f.WriteFileFunc != nil {
+		return f.WriteFileFunc(ctx, path, src)
+	}
+	if f.FileSystem == nil {
+		return fmt.Errorf("write file %s: %w", path, ErrUnsupported)
+	}
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return fmt.Errorf("write file %s: %w", path, err)
+	}
+	key := f.fsKey(path)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if key == "." {
+		return fmt.Errorf("write file %s: is a directory", path)
+	}
+	if info, err := fs.Stat(f.FileSystem, slashpath.Dir(key)); err != nil {
+		return fmt.Errorf("write file %s: %w", path, err)
+	} else if !info.IsDir() {
+		return fmt.Errorf("write file %s: parent is not a directory", path)
+	}
+	if info, err := fs.Stat(f.FileSystem, key); err == nil && info.IsDir() {
+		return fmt.Errorf("write file %s: is a directory", path)
+	}
+	f.FileSystem[key] = &fstest.MapFile{
+		Data: data,
+		Mode: 0o666,
+	}
+	return nil
+}
+
+// MkdirAll creates a directory and any necessary parents in f.FileSystem.
+// It returns ErrUnsupported if f.FileSystem is nil.
+func (f *Fake) MkdirAll(ctx context.Context, path string) error {
+	return f.MkdirAllPerm(ctx, path, 0o777)
+}
+
+// MkdirAllPerm creates a directory and any necessary parents in f.FileSystem
+// with the given permission bits.
+// It returns ErrUnsupported if f.FileSystem is nil.
+func (f *Fake) MkdirAllPerm(ctx context.Context, path string, perm fs.FileMode) error {
+	if f.FileSystem == nil {
+		return fmt.Errorf("mkdir -p %s: %w", path, ErrUnsupported)
+	}
+	key := f.fsKey(path)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var toCreate []string
+	for dir := key; dir != "."; dir = slashpath.Dir(dir) {
+		info, err := fs.Stat(f.FileSystem, dir)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("mkdir -p %s: %s is not a directory", path, dir)
+			}
+			continue
+		}
+		toCreate = append(toCreate, dir)
+	}
+	for _, dir := range toCreate {
+		f.FileSystem[dir] = &fstest.MapFile{Mode: fs.ModeDir | perm.Perm()}
+	}
+	return nil
+}
+
+// EvalSymlinks returns the absolute path of the named file in f.FileSystem
+// if it exists. f.FileSystem cannot contain symbolic links.
+// It returns ErrUnsupported if f.FileSystem is nil.
+func (f *Fake) EvalSymlinks(ctx context.Context, path string) (string, error) {
+	if f.FileSystem == nil {
+		return "", fmt.Errorf("eval symlinks %s: %w", path, ErrUnsupported)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err := fs.Stat(f.FileSystem, f.fsKey(path)); err != nil {
+		return "", fmt.Errorf("eval symlinks %s: %w", path, err)
+	}
+	return AbsPath(f, path), nil
+}
+
+// Stat returns information about the named file in f.FileSystem.
+// It returns ErrUnsupported if f.FileSystem is nil.
+func (f *Fake) Stat(ctx context.Context, path string) (fs.FileInfo, error) {
+	if f.FileSystem == nil {
+		return nil, fmt.Errorf("stat %s: %w", path, ErrUnsupported)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	info, err := fs.Stat(f.FileSystem, f.fsKey(path))
+	if err != nil {
+		return nil, fmt.Errorf("stat %s: %w", path, err)
+	}
+	return info, nil
+}
+
+// ReadDir reads the named directory in f.FileSystem.
+// It returns ErrUnsupported if f.FileSystem is nil.
+func (f *Fake) ReadDir(ctx context.Context, path string) ([]fs.DirEntry, error) {
+	if f.FileSystem == nil {
+		return nil, fmt.Errorf("read dir %s: %w", path, ErrUnsupported)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	entries, err := fs.ReadDir(f.FileSystem, f.fsKey(path))
+	if err != nil {
+		return nil, fmt.Errorf("read dir %s: %w", path, err)
+	}
+	return entries, nil
+}
+
+// fsKey converts a biome path into a key in f.FileSystem.
+func (f *Fake) fsKey(path string) string {
+	abs := AbsPath(f, path)
+	if f.Descriptor.NativeOS() == Windows {
+		abs = strings.ReplaceAll(abs, `\`, "/")
+	}
+	key := strings.TrimLeft(abs, "/")
+	if key == "" {
+		return "."
+	}
+	return key
+}
+
 // Close does nothing and returns nil.
 func (f *Fake) Close() error {
 	return nil
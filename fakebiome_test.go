@@ -0,0 +1,208 @@
+// Copyright 2021 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package biome
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFakeFileSystem(t *testing.T) {
+	ctx := context.Background()
+	bio := &Fake{
+		Descriptor: Descriptor{OS: Linux, Arch: Intel64},
+		DirsResult: Dirs{
+			Work: "/work",
+			Home: "/home",
+		},
+		FileSystem: fstest.MapFS{
+			"home":              {Mode: fs.ModeDir | 0o755},
+			"work/existing.txt": {Data: []byte("Hello, World!\n"), Mode: 0o644},
+		},
+	}
+
+	rc, err := OpenFile(ctx, bio, "existing.txt")
+	if err != nil {
+		t.Fatal("OpenFile:", err)
+	}
+	got, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Hello, World!\n"; string(got) != want {
+		t.Errorf("existing.txt content = %q; want %q", got, want)
+	}
+
+	if _, err := OpenFile(ctx, bio, "missing.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("OpenFile(ctx, bio, \"missing.txt\") error = %v; want %v", err, fs.ErrNotExist)
+	}
+
+	if err := MkdirAll(ctx, bio, "foo/bar"); err != nil {
+		t.Error("MkdirAll:", err)
+	}
+	if info, err := fs.Stat(bio.FileSystem, "work/foo/bar"); err != nil {
+		t.Error(err)
+	} else if !info.IsDir() {
+		t.Error("work/foo/bar is not a directory")
+	}
+	if err := MkdirAll(ctx, bio, "existing.txt/baz"); err == nil {
+		t.Error("MkdirAll through a file did not return an error")
+	}
+	if err := MkdirAllPerm(ctx, bio, "/home/.ssh", 0o700); err != nil {
+		t.Error("MkdirAllPerm:", err)
+	}
+	if info, err := fs.Stat(bio.FileSystem, "home/.ssh"); err != nil {
+		t.Error(err)
+	} else if got, want := info.Mode(), fs.ModeDir|0o700; got != want {
+		t.Errorf("home/.ssh mode = %v; want %v", got, want)
+	}
+
+	const want = "xyzzy\n"
+	if err := WriteFile(ctx, bio, "/home/new.txt", strings.NewReader(want)); err != nil {
+		t.Error("WriteFile:", err)
+	}
+	if got, err := fs.ReadFile(bio.FileSystem, "home/new.txt"); err != nil {
+		t.Error(err)
+	} else if string(got) != want {
+		t.Errorf("home/new.txt content = %q; want %q", got, want)
+	}
+	if err := WriteFile(ctx, bio, "nodir/new.txt", strings.NewReader(want)); err == nil {
+		t.Error("WriteFile into missing directory did not return an error")
+	}
+
+	if got, err := EvalSymlinks(ctx, bio, "foo/bar"); err != nil {
+		t.Error("EvalSymlinks:", err)
+	} else if want := "/work/foo/bar"; got != want {
+		t.Errorf("EvalSymlinks(ctx, bio, \"foo/bar\") = %q; want %q", got, want)
+	}
+}
+
+func TestFakeRunResult(t *testing.T) {
+	ctx := context.Background()
+	errUnzip := errors.New("unzip failed")
+	var funcCalls []string
+	bio := &Fake{
+		RunResult: map[string]error{
+			"rm -f foo.txt":       nil,
+			"unzip -o -q foo.zip": errUnzip,
+		},
+		RunFunc: func(ctx context.Context, invoke *Invocation) error {
+			funcCalls = append(funcCalls, strings.Join(invoke.Argv, " "))
+			return nil
+		},
+	}
+
+	tests := []struct {
+		argv []string
+		want error
+	}{
+		{argv: []string{"rm", "-f", "foo.txt"}, want: nil},
+		{argv: []string{"unzip", "-o", "-q", "foo.zip"}, want: errUnzip},
+		{argv: []string{"echo", "hi"}, want: nil},
+	}
+	for _, test := range tests {
+		if err := bio.Run(ctx, &Invocation{Argv: test.argv}); err != test.want {
+			t.Errorf("Run(ctx, %q) = %v; want %v", test.argv, err, test.want)
+		}
+	}
+	if len(funcCalls) != 1 || funcCalls[0] != "echo hi" {
+		t.Errorf("RunFunc called for %q; want [\"echo hi\"]", funcCalls)
+	}
+
+	bio.RunFunc = nil
+	if err := bio.Run(ctx, &Invocation{Argv: []string{"echo", "hi"}}); err == nil {
+		t.Error("Run(ctx, [\"echo\" \"hi\"]) with nil RunFunc did not return an error")
+	}
+}
+
+func TestFakeCapturedInvocations(t *testing.T) {
+	ctx := context.Background()
+	bio := &Fake{
+		RunResult: map[string]error{
+			"rm -f foo.zip": nil,
+		},
+	}
+	first := &Invocation{Argv: []string{"unzip", "-o", "-q", "foo.zip"}}
+	second := &Invocation{Argv: []string{"rm", "-f", "foo.zip"}}
+	if err := bio.Run(ctx, first); err == nil {
+		t.Error("Run(ctx, first) with nil RunFunc did not return an error")
+	}
+	if err := bio.Run(ctx, second); err != nil {
+		t.Error("Run(ctx, second):", err)
+	}
+	if len(bio.CapturedInvocations) != 2 ||
+		bio.CapturedInvocations[0] != first ||
+		bio.CapturedInvocations[1] != second {
+		t.Errorf("CapturedInvocations = %v; want [%v %v]", bio.CapturedInvocations, first, second)
+	}
+}
+
+func TestFakeFileFuncs(t *testing.T) {
+	ctx := context.Background()
+	written := make(map[string]string)
+	errDenied := errors.New("denied")
+	bio := &Fake{
+		OpenFileFunc: func(ctx context.Context, path string) (io.ReadCloser, error) {
+			if path != "/work/foo.txt" {
+				return nil, errDenied
+			}
+			return io.NopCloser(strings.NewReader("Hello, World!\n")), nil
+		},
+		WriteFileFunc: func(ctx context.Context, path string, src io.Reader) error {
+			data, err := io.ReadAll(src)
+			if err != nil {
+				return err
+			}
+			written[path] = string(data)
+			return nil
+		},
+		// Should be ignored in favor of the functions.
+		FileSystem: fstest.MapFS{},
+	}
+
+	rc, err := OpenFile(ctx, bio, "/work/foo.txt")
+	if err != nil {
+		t.Fatal("OpenFile:", err)
+	}
+	got, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Error("Read:", err)
+	}
+	if want := "Hello, World!\n"; string(got) != want {
+		t.Errorf("OpenFile content = %q; want %q", got, want)
+	}
+	if _, err := OpenFile(ctx, bio, "/work/bar.txt"); !errors.Is(err, errDenied) {
+		t.Errorf("OpenFile(ctx, bio, \"/work/bar.txt\") error = %v; want %v", err, errDenied)
+	}
+
+	if err := WriteFile(ctx, bio, "/work/out.txt", strings.NewReader("output\n")); err != nil {
+		t.Error("WriteFile:", err)
+	}
+	if got, want := written["/work/out.txt"], "output\n"; got != want {
+		t.Errorf("WriteFileFunc received %q; want %q", got, want)
+	}
+	if len(bio.FileSystem) > 0 {
+		t.Errorf("FileSystem = %v; want empty", bio.FileSystem)
+	}
+}
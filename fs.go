@@ -19,10 +19,12 @@ package biome
 import (
 	"bytes"
 	"context"
-	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"sort"
 	"strings"
+	"time"
 )
 
 // This file holds functions that can be derived from any implementation of the
@@ -40,15 +42,20 @@ type fileOpener interface {
 // that will be used. If it does not or the method returns ErrUnsupported,
 // OpenFile will Run an appropriate fallback in the biome.
 func OpenFile(ctx context.Context, bio Biome, path string) (io.ReadCloser, error) {
-	if rc, err := forwardOpenFile(ctx, bio, path); !errors.Is(err, ErrUnsupported) {
+	if rc, err := forwardOpenFile(ctx, bio, path); !IsUnsupported(err) {
 		return rc, err
 	}
 	ctx, cancel := context.WithCancel(ctx)
 	stderr := new(strings.Builder)
+	argv := []string{"cat", "--", path}
+	if bio.Describe().NativeOS() == Windows {
+		argv = powerShellArgv(`$f = [IO.File]::OpenRead(` + powerShellQuote(path) + `); ` +
+			`$out = [Console]::OpenStandardOutput(); $f.CopyTo($out); $out.Flush(); $f.Close()`)
+	}
 	pr, pw := io.Pipe()
 	go func() {
 		err := bio.Run(ctx, &Invocation{
-			Argv:   []string{"cat", "--", path},
+			Argv:   argv,
 			Stdout: pw,
 			Stderr: stderr,
 		})
@@ -66,10 +73,7 @@ func OpenFile(ctx context.Context, bio Biome, path string) (io.ReadCloser, error
 	}
 	if n == 0 {
 		cancel()
-		if stderr.Len() == 0 {
-			return nil, fmt.Errorf("open file %s: %w", path, err)
-		}
-		return nil, fmt.Errorf("open file %s: %s", path, strings.TrimSuffix(stderr.String(), "\n"))
+		return nil, fallbackError("open file", path, err, stderr.String())
 	}
 	return &catStream{
 		cancel: cancel,
@@ -120,20 +124,22 @@ type fileWriter interface {
 // that will be used. If it does not or the method returns ErrUnsupported,
 // WriteFile will Run an appropriate fallback in the biome.
 func WriteFile(ctx context.Context, bio Biome, path string, src io.Reader) error {
-	if err := forwardWriteFile(ctx, bio, path, src); !errors.Is(err, ErrUnsupported) {
+	if err := forwardWriteFile(ctx, bio, path, src); !IsUnsupported(err) {
 		return err
 	}
 	stderr := new(strings.Builder)
+	argv := []string{"tee", path}
+	if bio.Describe().NativeOS() == Windows {
+		argv = powerShellArgv(`$f = [IO.File]::Create(` + powerShellQuote(path) + `); ` +
+			`[Console]::OpenStandardInput().CopyTo($f); $f.Close()`)
+	}
 	err := bio.Run(ctx, &Invocation{
-		Argv:   []string{"tee", path},
+		Argv:   argv,
 		Stdin:  src,
 		Stderr: stderr,
 	})
 	if err != nil {
-		if stderr.Len() == 0 {
-			return fmt.Errorf("write file %s: %w", path, err)
-		}
-		return fmt.Errorf("write file %s: %s", path, strings.TrimSuffix(stderr.String(), "\n"))
+		return fallbackError("write file", path, err, stderr.String())
 	}
 	return nil
 }
@@ -157,19 +163,20 @@ type dirMaker interface {
 // that will be used. If it does not or the method returns ErrUnsupported,
 // MkdirAll will Run an appropriate fallback in the biome.
 func MkdirAll(ctx context.Context, bio Biome, path string) error {
-	if err := forwardMkdirAll(ctx, bio, path); !errors.Is(err, ErrUnsupported) {
+	if err := forwardMkdirAll(ctx, bio, path); !IsUnsupported(err) {
 		return err
 	}
 	stderr := new(strings.Builder)
+	argv := []string{"mkdir", "-p", path}
+	if bio.Describe().NativeOS() == Windows {
+		argv = powerShellArgv(`[IO.Directory]::CreateDirectory(` + powerShellQuote(path) + `) | Out-Null`)
+	}
 	err := bio.Run(ctx, &Invocation{
-		Argv:   []string{"mkdir", "-p", path},
+		Argv:   argv,
 		Stderr: stderr,
 	})
 	if err != nil {
-		if stderr.Len() == 0 {
-			return fmt.Errorf("mkdir -p %s: %w", path, err)
-		}
-		return fmt.Errorf("mkdir -p %s: %s", path, strings.TrimSuffix(stderr.String(), "\n"))
+		return fallbackError("mkdir -p", path, err, stderr.String())
 	}
 	return nil
 }
@@ -182,6 +189,46 @@ func forwardMkdirAll(ctx context.Context, bio Biome, path string) error {
 	return maker.MkdirAll(ctx, path)
 }
 
+type dirPermMaker interface {
+	MkdirAllPerm(ctx context.Context, path string, perm fs.FileMode) error
+}
+
+// MkdirAllPerm is like MkdirAll, but creates the directory with the given
+// permission bits, like 0o700 for a directory holding private keys.
+// If the directory already exists, its permissions are left unchanged.
+//
+// If the biome has a method
+// `MkdirAllPerm(ctx context.Context, path string, perm fs.FileMode) error`,
+// that will be used. If it does not or the method returns ErrUnsupported,
+// MkdirAllPerm will Run `mkdir -p -m OCTAL path` in the biome. The fallback
+// creates any missing parent directories with the default permissions.
+// On Windows biomes, the fallback ignores perm.
+func MkdirAllPerm(ctx context.Context, bio Biome, path string, perm fs.FileMode) error {
+	if err := forwardMkdirAllPerm(ctx, bio, path, perm); !IsUnsupported(err) {
+		return err
+	}
+	if bio.Describe().NativeOS() == Windows {
+		return MkdirAll(ctx, bio, path)
+	}
+	stderr := new(strings.Builder)
+	err := bio.Run(ctx, &Invocation{
+		Argv:   []string{"mkdir", "-p", "-m", fmt.Sprintf("%o", perm.Perm()), path},
+		Stderr: stderr,
+	})
+	if err != nil {
+		return fallbackError("mkdir -p", path, err, stderr.String())
+	}
+	return nil
+}
+
+func forwardMkdirAllPerm(ctx context.Context, bio Biome, path string, perm fs.FileMode) error {
+	maker, ok := bio.(dirPermMaker)
+	if !ok {
+		return fmt.Errorf("mkdir -p %s: %w", path, ErrUnsupported)
+	}
+	return maker.MkdirAllPerm(ctx, path, perm)
+}
+
 type symlinkEvaler interface {
 	EvalSymlinks(ctx context.Context, path string) (string, error)
 }
@@ -195,18 +242,29 @@ type symlinkEvaler interface {
 // that will be used. If it does not or the method returns ErrUnsupported,
 // EvalSymlinks will Run an appropriate fallback in the biome.
 func EvalSymlinks(ctx context.Context, bio Biome, path string) (string, error) {
-	if resolved, err := forwardEvalSymlinks(ctx, bio, path); !errors.Is(err, ErrUnsupported) {
+	if resolved, err := forwardEvalSymlinks(ctx, bio, path); !IsUnsupported(err) {
 		return resolved, err
 	}
 	stdout := new(strings.Builder)
 	stderr := new(strings.Builder)
-	argv := []string{
-		"python",
-		"-c", `import os, sys; os.stat(sys.argv[1]); sys.stdout.write(os.path.realpath(sys.argv[1]))`,
-		path,
-	}
-	if bio.Describe().OS == Linux {
-		argv = []string{"readlink", "--canonicalize-existing", "--no-newline", path}
+	var argv []string
+	switch bio.Describe().NativeOS() {
+	case Linux:
+		// --verbose makes readlink report why it failed,
+		// so that fallbackError can detect missing files.
+		argv = []string{"readlink", "--canonicalize-existing", "--no-newline", "--verbose", path}
+	case Windows:
+		argv = powerShellArgv(`[Console]::Out.Write((Resolve-Path -LiteralPath ` + powerShellQuote(path) + `).Path)`)
+	default:
+		python, err := pythonProgram(ctx, bio)
+		if err != nil {
+			return "", fmt.Errorf("eval symlinks for %s: %w", path, err)
+		}
+		argv = []string{
+			python,
+			"-c", `import os, sys; os.stat(sys.argv[1]); sys.stdout.write(os.path.realpath(sys.argv[1]))`,
+			path,
+		}
 	}
 	err := bio.Run(ctx, &Invocation{
 		Argv:   argv,
@@ -214,10 +272,7 @@ func EvalSymlinks(ctx context.Context, bio Biome, path string) (string, error) {
 		Stderr: stderr,
 	})
 	if err != nil {
-		if stderr.Len() == 0 {
-			return "", fmt.Errorf("eval symlinks for %s: %w", path, err)
-		}
-		return "", fmt.Errorf("eval symlinks for %s: %s", path, strings.TrimSuffix(stderr.String(), "\n"))
+		return "", fallbackError("eval symlinks for", path, err, stderr.String())
 	}
 	return CleanPath(bio.Describe(), stdout.String()), nil
 }
@@ -229,3 +284,290 @@ func forwardEvalSymlinks(ctx context.Context, bio Biome, path string) (string, e
 	}
 	return evaler.EvalSymlinks(ctx, path)
 }
+
+type statter interface {
+	Stat(ctx context.Context, path string) (fs.FileInfo, error)
+}
+
+// Stat returns information about the named file, following symbolic links.
+// Paths are resolved relative to the biome's working directory.
+//
+// If the biome has a method
+// `Stat(ctx context.Context, path string) (fs.FileInfo, error)`,
+// that will be used. If it does not or the method returns ErrUnsupported,
+// Stat will Run an appropriate fallback in the biome. The fallback only
+// reports the name, size, mode, and modification time (to the second)
+// and its Sys method returns nil.
+func Stat(ctx context.Context, bio Biome, path string) (fs.FileInfo, error) {
+	if info, err := forwardStat(ctx, bio, path); !IsUnsupported(err) {
+		return info, err
+	}
+	var argv []string
+	if bio.Describe().NativeOS() == Linux {
+		argv = []string{"stat", "--dereference", "--format=%s %f %Y", "--", path}
+	} else {
+		python, err := pythonProgram(ctx, bio)
+		if err != nil {
+			return nil, fmt.Errorf("stat %s: %w", path, err)
+		}
+		argv = []string{
+			python,
+			"-c", `import sys, os; st = os.stat(sys.argv[1]); sys.stdout.write('%d %x %d' % (st.st_size, st.st_mode, st.st_mtime))`,
+			path,
+		}
+	}
+	stdout := new(strings.Builder)
+	stderr := new(strings.Builder)
+	err := bio.Run(ctx, &Invocation{
+		Argv:   argv,
+		Stdout: stdout,
+		Stderr: stderr,
+	})
+	if err != nil {
+		return nil, fallbackError("stat", path, err, stderr.String())
+	}
+	info := &fileInfo{name: basePath(bio.Describe(), path)}
+	var unixMode uint32
+	var mtime int64
+	_, err = fmt.Sscanf(stdout.String(), "%d %x %d", &info.size, &unixMode, &mtime)
+	if err != nil {
+		return nil, fmt.Errorf("stat %s: parse output %q: %v", path, stdout.String(), err)
+	}
+	info.mode = fileModeFromUnix(unixMode)
+	info.modTime = time.Unix(mtime, 0)
+	return info, nil
+}
+
+func forwardStat(ctx context.Context, bio Biome, path string) (fs.FileInfo, error) {
+	s, ok := bio.(statter)
+	if !ok {
+		return nil, fmt.Errorf("stat %s: %w", path, ErrUnsupported)
+	}
+	return s.Stat(ctx, path)
+}
+
+type dirReader interface {
+	ReadDir(ctx context.Context, path string) ([]fs.DirEntry, error)
+}
+
+// ReadDir reads the named directory, returning all its directory entries
+// sorted by filename. Paths are resolved relative to the biome's working
+// directory.
+//
+// If the biome has a method
+// `ReadDir(ctx context.Context, path string) ([]fs.DirEntry, error)`,
+// that will be used. If it does not or the method returns ErrUnsupported,
+// ReadDir will Run an appropriate fallback in the biome. Entries returned by
+// the fallback only distinguish between directories and other files, and
+// their Info methods call Stat.
+func ReadDir(ctx context.Context, bio Biome, path string) ([]fs.DirEntry, error) {
+	if entries, err := forwardReadDir(ctx, bio, path); !IsUnsupported(err) {
+		return entries, err
+	}
+	var argv []string
+	if bio.Describe().NativeOS() == Linux {
+		// The trailing slash makes ls fail if path is not a directory.
+		argv = []string{"ls", "-1Ap", "--", strings.TrimSuffix(path, "/") + "/"}
+	} else {
+		python, err := pythonProgram(ctx, bio)
+		if err != nil {
+			return nil, fmt.Errorf("read dir %s: %w", path, err)
+		}
+		argv = []string{
+			python,
+			"-c", `import sys, os; d = sys.argv[1]; sys.stdout.write(''.join(n + ('/' if os.path.isdir(os.path.join(d, n)) else '') + '\n' for n in os.listdir(d)))`,
+			path,
+		}
+	}
+	stdout := new(strings.Builder)
+	stderr := new(strings.Builder)
+	err := bio.Run(ctx, &Invocation{
+		Argv:   argv,
+		Stdout: stdout,
+		Stderr: stderr,
+	})
+	if err != nil {
+		return nil, fallbackError("read dir", path, err, stderr.String())
+	}
+	var entries []fs.DirEntry
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		if line == "" {
+			continue
+		}
+		ent := &dirEntry{
+			ctx:  ctx,
+			bio:  bio,
+			dir:  path,
+			name: strings.TrimSuffix(line, "/"),
+		}
+		if ent.name != line {
+			ent.typ = fs.ModeDir
+		}
+		entries = append(entries, ent)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+func forwardReadDir(ctx context.Context, bio Biome, path string) ([]fs.DirEntry, error) {
+	r, ok := bio.(dirReader)
+	if !ok {
+		return nil, fmt.Errorf("read dir %s: %w", path, ErrUnsupported)
+	}
+	return r.ReadDir(ctx, path)
+}
+
+// powerShellArgv returns the arguments to run a PowerShell script
+// in a Windows biome. The script stops at the first error.
+func powerShellArgv(script string) []string {
+	return []string{
+		"powershell.exe",
+		"-NoProfile",
+		"-NonInteractive",
+		"-Command", "$ErrorActionPreference = 'Stop'; " + script,
+	}
+}
+
+// powerShellQuote returns s as a PowerShell string literal
+// that is not subject to variable expansion.
+func powerShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// pythonProgram returns the name of the Python interpreter to use in the biome.
+func pythonProgram(ctx context.Context, bio Biome) (string, error) {
+	found, err := HasCommand(ctx, bio, "python")
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		// Newer systems may only ship Python 3 under its versioned name.
+		return "python3", nil
+	}
+	return "python", nil
+}
+
+// fallbackErrorMessages maps the strerror(3) messages that commands print
+// (and their Windows equivalents) to the corresponding sentinel errors.
+var fallbackErrorMessages = []struct {
+	msg string
+	err error
+}{
+	{"No such file or directory", ErrNotFound},
+	{"Permission denied", ErrPermission},
+	{"Operation not permitted", ErrPermission},
+	{"File exists", ErrExists},
+
+	// Windows (PowerShell and .NET) messages.
+	{"because it does not exist", ErrNotFound},
+	{"Could not find file", ErrNotFound},
+	{"Could not find a part of the path", ErrNotFound},
+	{"Access to the path", ErrPermission},
+}
+
+// fallbackError returns an error for a failed fallback command.
+// If the command's error output contains a message for a known condition
+// (like a file not existing), then the returned error wraps
+// the corresponding sentinel error (like ErrNotFound).
+func fallbackError(op string, path string, err error, stderr string) error {
+	stderr = strings.TrimSuffix(stderr, "\n")
+	for _, known := range fallbackErrorMessages {
+		if strings.Contains(stderr, known.msg) {
+			return &fs.PathError{Op: op, Path: path, Err: known.err}
+		}
+	}
+	if stderr == "" {
+		return fmt.Errorf("%s %s: %w", op, path, err)
+	}
+	return fmt.Errorf("%s %s: %s", op, path, stderr)
+}
+
+// basePath returns the last element of a biome path.
+func basePath(desc *Descriptor, path string) string {
+	sep := "/"
+	if desc.NativeOS() == Windows {
+		sep = `\`
+	}
+	path = strings.TrimRight(path, sep)
+	if path == "" {
+		return sep
+	}
+	return path[strings.LastIndex(path, sep)+1:]
+}
+
+// Unix file type and permission bits, as found in st_mode.
+const (
+	unixTypeMask  = 0o170000
+	unixSocket    = 0o140000
+	unixSymlink   = 0o120000
+	unixRegular   = 0o100000
+	unixBlockDev  = 0o060000
+	unixDirectory = 0o040000
+	unixCharDev   = 0o020000
+	unixFIFO      = 0o010000
+	unixSetuid    = 0o4000
+	unixSetgid    = 0o2000
+	unixSticky    = 0o1000
+)
+
+func fileModeFromUnix(m uint32) fs.FileMode {
+	mode := fs.FileMode(m & 0o777)
+	switch m & unixTypeMask {
+	case unixSocket:
+		mode |= fs.ModeSocket
+	case unixSymlink:
+		mode |= fs.ModeSymlink
+	case unixBlockDev:
+		mode |= fs.ModeDevice
+	case unixDirectory:
+		mode |= fs.ModeDir
+	case unixCharDev:
+		mode |= fs.ModeDevice | fs.ModeCharDevice
+	case unixFIFO:
+		mode |= fs.ModeNamedPipe
+	}
+	if m&unixSetuid != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if m&unixSetgid != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if m&unixSticky != 0 {
+		mode |= fs.ModeSticky
+	}
+	return mode
+}
+
+// fileInfo is the fs.FileInfo returned by the Stat fallback.
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (info *fileInfo) Name() string       { return info.name }
+func (info *fileInfo) Size() int64        { return info.size }
+func (info *fileInfo) Mode() fs.FileMode  { return info.mode }
+func (info *fileInfo) ModTime() time.Time { return info.modTime }
+func (info *fileInfo) IsDir() bool        { return info.mode.IsDir() }
+func (info *fileInfo) Sys() interface{}   { return nil }
+
+// dirEntry is the fs.DirEntry returned by the ReadDir fallback.
+type dirEntry struct {
+	ctx  context.Context
+	bio  Biome
+	dir  string
+	name string
+	typ  fs.FileMode
+}
+
+func (ent *dirEntry) Name() string      { return ent.name }
+func (ent *dirEntry) IsDir() bool       { return ent.typ.IsDir() }
+func (ent *dirEntry) Type() fs.FileMode { return ent.typ }
+
+func (ent *dirEntry) Info() (fs.FileInfo, error) {
+	return Stat(ent.ctx, ent.bio, JoinPath(ent.bio.Describe(), ent.dir, ent.name))
+}
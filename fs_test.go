@@ -18,14 +18,19 @@ package biome
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/google/go-cmp/cmp"
 	"zombiezen.com/go/log/testlog"
 )
 
@@ -91,6 +96,15 @@ func TestOpenFile(t *testing.T) {
 			if string(got) != want {
 				t.Errorf("%s content = %q; want %q", fname, got, want)
 			}
+
+			const missingFile = "bork.txt"
+			rc, err = OpenFile(ctx, bio, missingFile)
+			if err == nil {
+				rc.Close()
+			}
+			if !errors.Is(err, ErrNotFound) {
+				t.Errorf("OpenFile(ctx, bio, %q) = _, %v; want ErrNotFound", missingFile, err)
+			}
 		})
 	}
 }
@@ -154,6 +168,183 @@ func TestWriteFile(t *testing.T) {
 	}
 }
 
+func TestFallbackError(t *testing.T) {
+	tests := []struct {
+		stderr string
+		want   error
+	}{
+		{stderr: "cat: foo.txt: No such file or directory\n", want: ErrNotFound},
+		{stderr: "tee: foo.txt: Permission denied\n", want: ErrPermission},
+		{stderr: "mkdir: cannot create directory 'foo': Permission denied\n", want: ErrPermission},
+		{stderr: "mkdir: cannot create directory 'foo': Operation not permitted\n", want: ErrPermission},
+		{stderr: "mkdir: cannot create directory 'foo': File exists\n", want: ErrExists},
+		{stderr: "Resolve-Path : Cannot find path 'C:\\foo' because it does not exist.\r\n", want: ErrNotFound},
+		{stderr: "Exception calling \"OpenRead\" with \"1\" argument(s): \"Could not find file 'C:\\foo'.\"\r\n", want: ErrNotFound},
+		{stderr: "Exception calling \"Create\" with \"1\" argument(s): \"Access to the path 'C:\\foo' is denied.\"\r\n", want: ErrPermission},
+		{stderr: "tee: foo: Is a directory\n", want: nil},
+		{stderr: "", want: nil},
+	}
+	runErr := errors.New("exit status 1")
+	for _, test := range tests {
+		err := fallbackError("op", "foo", runErr, test.stderr)
+		for _, sentinel := range []error{ErrNotFound, ErrPermission, ErrExists} {
+			if got, want := errors.Is(err, sentinel), sentinel == test.want; got != want {
+				t.Errorf("errors.Is(fallbackError(..., %q), %v) = %t; want %t", test.stderr, sentinel, got, want)
+			}
+		}
+	}
+}
+
+func TestIsUnsupported(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{err: nil, want: false},
+		{err: errors.New("bork"), want: false},
+		{err: ErrUnsupported, want: true},
+		{err: fmt.Errorf("open file foo: %w", ErrUnsupported), want: true},
+		{err: fmt.Errorf("outer: %w", fmt.Errorf("inner: %w", ErrUnsupported)), want: true},
+		{err: &fs.PathError{Op: "open", Path: "foo", Err: ErrUnsupported}, want: true},
+		{err: fmt.Errorf("open file foo: %v", ErrUnsupported), want: false},
+	}
+	for _, test := range tests {
+		if got := IsUnsupported(test.err); got != test.want {
+			t.Errorf("IsUnsupported(%v) = %t; want %t", test.err, got, test.want)
+		}
+	}
+}
+
+func TestForwardError(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "foo.txt"), []byte("Hello"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	local := Local{
+		WorkDir: dir,
+		HomeDir: t.TempDir(),
+	}
+
+	t.Run("PathErrorUnsupported", func(t *testing.T) {
+		// A wrapper other than fmt.Errorf should still trigger the fallback.
+		bio := pathErrorUnsupported{local}
+		got, err := readString(ctx, bio, "foo.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "Hello"; got != want {
+			t.Errorf("OpenFile(ctx, bio, \"foo.txt\") content = %q; want %q", got, want)
+		}
+	})
+
+	t.Run("OtherError", func(t *testing.T) {
+		// Errors that don't wrap ErrUnsupported are returned as-is.
+		bork := errors.New("bork")
+		bio := failingOpener{Biome: local, err: bork}
+		_, err := OpenFile(ctx, bio, "foo.txt")
+		if !errors.Is(err, bork) {
+			t.Errorf("OpenFile(ctx, bio, \"foo.txt\") = _, %v; want %v", err, bork)
+		}
+	})
+}
+
+func readString(ctx context.Context, bio Biome, path string) (string, error) {
+	f, err := OpenFile(ctx, bio, path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	return string(data), err
+}
+
+func TestWindowsFallbacks(t *testing.T) {
+	// Biomes may report their operating system with another name,
+	// like the output of `uname -s` in MSYS2.
+	for _, osName := range []string{Windows, "MINGW64_NT-10.0"} {
+		t.Run(osName, func(t *testing.T) {
+			ctx := context.Background()
+			const path = `C:\work\it's.txt`
+			const quotedPath = `'C:\work\it''s.txt'`
+			var scripts []string
+			bio := &Fake{
+				Descriptor: Descriptor{OS: osName, Arch: Intel64},
+				DirsResult: Dirs{Work: `C:\work`, Home: `C:\home`, Tools: `C:\tools`},
+				RunFunc: func(ctx context.Context, invoke *Invocation) error {
+					if len(invoke.Argv) == 0 || invoke.Argv[0] != "powershell.exe" {
+						return fmt.Errorf("ran %q; want powershell.exe", invoke.Argv)
+					}
+					script := invoke.Argv[len(invoke.Argv)-1]
+					scripts = append(scripts, script)
+					if !strings.Contains(script, quotedPath) {
+						return fmt.Errorf("script %q does not contain %s", script, quotedPath)
+					}
+					stdout, _ := invoke.Output()
+					switch {
+					case strings.Contains(script, "OpenRead"):
+						io.WriteString(stdout, "Hello, World!\n")
+					case strings.Contains(script, "Resolve-Path"):
+						io.WriteString(stdout, path)
+					case strings.Contains(script, "Create("):
+						io.Copy(io.Discard, invoke.Stdin)
+					}
+					return nil
+				},
+			}
+
+			f, err := OpenFile(ctx, bio, path)
+			if err != nil {
+				t.Fatal("OpenFile:", err)
+			}
+			got, err := ioutil.ReadAll(f)
+			f.Close()
+			if err != nil {
+				t.Error("OpenFile:", err)
+			}
+			if want := "Hello, World!\n"; string(got) != want {
+				t.Errorf("OpenFile(...) content = %q; want %q", got, want)
+			}
+			if err := WriteFile(ctx, bio, path, strings.NewReader("Hello")); err != nil {
+				t.Error("WriteFile:", err)
+			}
+			if err := MkdirAll(ctx, bio, path); err != nil {
+				t.Error("MkdirAll:", err)
+			}
+			if resolved, err := EvalSymlinks(ctx, bio, path); err != nil {
+				t.Error("EvalSymlinks:", err)
+			} else if resolved != path {
+				t.Errorf("EvalSymlinks(...) = %q; want %q", resolved, path)
+			}
+			if len(scripts) != 4 {
+				t.Errorf("ran %d PowerShell scripts; want 4", len(scripts))
+			}
+			for _, script := range scripts {
+				if !strings.HasPrefix(script, "$ErrorActionPreference = 'Stop'; ") {
+					t.Errorf("script %q does not stop on errors", script)
+				}
+			}
+		})
+	}
+}
+
+func TestPowerShellQuote(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{s: "", want: "''"},
+		{s: `C:\foo`, want: `'C:\foo'`},
+		{s: "$HOME", want: "'$HOME'"},
+		{s: "it's", want: "'it''s'"},
+	}
+	for _, test := range tests {
+		if got := powerShellQuote(test.s); got != test.want {
+			t.Errorf("powerShellQuote(%q) = %s; want %s", test.s, got, test.want)
+		}
+	}
+}
+
 func TestMkdirAll(t *testing.T) {
 	junkHome := t.TempDir()
 	tests := []struct {
@@ -212,6 +403,70 @@ func TestMkdirAll(t *testing.T) {
 	}
 }
 
+func TestMkdirAllPerm(t *testing.T) {
+	if runtime.GOOS == Windows {
+		t.Skip("Permissions not supported on Windows")
+	}
+	junkHome := t.TempDir()
+	tests := []struct {
+		name     string
+		newBiome func(dir string) Biome
+	}{
+		{
+			name: "Local",
+			newBiome: func(dir string) Biome {
+				return Local{
+					WorkDir: dir,
+					HomeDir: junkHome,
+				}
+			},
+		},
+		{
+			name: "Fallback",
+			newBiome: func(dir string) Biome {
+				return forceFallback{Local{
+					WorkDir: dir,
+					HomeDir: junkHome,
+				}}
+			},
+		},
+		{
+			name: "Unsupported",
+			newBiome: func(dir string) Biome {
+				return unsupported{Local{
+					WorkDir: dir,
+					HomeDir: junkHome,
+				}}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := testlog.WithTB(context.Background(), t)
+			dir := t.TempDir()
+			bio := test.newBiome(dir)
+
+			createdDir := filepath.Join("foo", ".ssh")
+			err := MkdirAllPerm(ctx, bio, createdDir, 0o700)
+			if err != nil {
+				t.Error("MkdirAllPerm:", err)
+			}
+
+			got, err := os.Stat(filepath.Join(dir, createdDir))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.IsDir() {
+				t.Errorf("%s is not a directory", createdDir)
+			}
+			if perm := got.Mode().Perm(); perm != 0o700 {
+				t.Errorf("%s mode = %v; want %v", createdDir, perm, fs.FileMode(0o700))
+			}
+		})
+	}
+}
+
 func TestEvalSymlinks(t *testing.T) {
 	// Set up directory.
 	dir, err := filepath.EvalSymlinks(t.TempDir())
@@ -273,8 +528,8 @@ func TestEvalSymlinks(t *testing.T) {
 			t.Run("DoesNotExist", func(t *testing.T) {
 				ctx := testlog.WithTB(context.Background(), t)
 				got, err := EvalSymlinks(ctx, test.bio, missingFile)
-				if err == nil {
-					t.Errorf("EvalSymlinks(ctx, bio, %q) = %q, <nil>; want _, <error>", missingFile, got)
+				if !errors.Is(err, ErrNotFound) {
+					t.Errorf("EvalSymlinks(ctx, bio, %q) = %q, %v; want _, ErrNotFound", missingFile, got, err)
 				}
 			})
 			t.Run("Symlink", func(t *testing.T) {
@@ -297,6 +552,171 @@ func TestEvalSymlinks(t *testing.T) {
 	}
 }
 
+func TestStat(t *testing.T) {
+	dir := t.TempDir()
+	home := t.TempDir()
+	const fname = "foo.txt"
+	const content = "Hello, World!\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, fname), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Date(2021, time.March, 1, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(dir, fname), modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		bio  Biome
+	}{
+		{
+			name: "Local",
+			bio: Local{
+				WorkDir: dir,
+				HomeDir: home,
+			},
+		},
+		{
+			name: "Fallback",
+			bio: forceFallback{Local{
+				WorkDir: dir,
+				HomeDir: home,
+			}},
+		},
+		{
+			name: "Unsupported",
+			bio: unsupported{Local{
+				WorkDir: dir,
+				HomeDir: home,
+			}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Run("File", func(t *testing.T) {
+				ctx := testlog.WithTB(context.Background(), t)
+				info, err := Stat(ctx, test.bio, fname)
+				if err != nil {
+					t.Fatal("Stat:", err)
+				}
+				if got := info.Name(); got != fname {
+					t.Errorf("info.Name() = %q; want %q", got, fname)
+				}
+				if got := info.Size(); got != int64(len(content)) {
+					t.Errorf("info.Size() = %d; want %d", got, len(content))
+				}
+				if got := info.Mode(); !got.IsRegular() {
+					t.Errorf("info.Mode() = %v; want regular file", got)
+				}
+				if got := info.ModTime(); !got.Equal(modTime) {
+					t.Errorf("info.ModTime() = %v; want %v", got, modTime)
+				}
+			})
+			t.Run("Dir", func(t *testing.T) {
+				ctx := testlog.WithTB(context.Background(), t)
+				info, err := Stat(ctx, test.bio, "sub")
+				if err != nil {
+					t.Fatal("Stat:", err)
+				}
+				if !info.IsDir() {
+					t.Errorf("info.Mode() = %v; want directory", info.Mode())
+				}
+			})
+			t.Run("DoesNotExist", func(t *testing.T) {
+				ctx := testlog.WithTB(context.Background(), t)
+				_, err := Stat(ctx, test.bio, "bork.txt")
+				if !errors.Is(err, fs.ErrNotExist) {
+					t.Errorf("Stat(ctx, bio, \"bork.txt\") = _, %v; want fs.ErrNotExist", err)
+				}
+			})
+		})
+	}
+}
+
+func TestReadDir(t *testing.T) {
+	dir := t.TempDir()
+	home := t.TempDir()
+	for _, name := range []string{"b.txt", ".hidden", "a.txt"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		bio  Biome
+	}{
+		{
+			name: "Local",
+			bio: Local{
+				WorkDir: dir,
+				HomeDir: home,
+			},
+		},
+		{
+			name: "Fallback",
+			bio: forceFallback{Local{
+				WorkDir: dir,
+				HomeDir: home,
+			}},
+		},
+		{
+			name: "Unsupported",
+			bio: unsupported{Local{
+				WorkDir: dir,
+				HomeDir: home,
+			}},
+		},
+	}
+	type entry struct {
+		Name  string
+		IsDir bool
+	}
+	want := []entry{
+		{Name: ".hidden"},
+		{Name: "a.txt"},
+		{Name: "b.txt"},
+		{Name: "sub", IsDir: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Run("Dir", func(t *testing.T) {
+				ctx := testlog.WithTB(context.Background(), t)
+				entries, err := ReadDir(ctx, test.bio, ".")
+				if err != nil {
+					t.Fatal("ReadDir:", err)
+				}
+				var got []entry
+				for _, ent := range entries {
+					got = append(got, entry{Name: ent.Name(), IsDir: ent.IsDir()})
+				}
+				if diff := cmp.Diff(want, got); diff != "" {
+					t.Errorf("ReadDir(ctx, bio, \".\") (-want +got):\n%s", diff)
+				}
+			})
+			t.Run("NotDir", func(t *testing.T) {
+				ctx := testlog.WithTB(context.Background(), t)
+				if _, err := ReadDir(ctx, test.bio, "a.txt"); err == nil {
+					t.Error("ReadDir(ctx, bio, \"a.txt\") did not return an error")
+				}
+			})
+			t.Run("DoesNotExist", func(t *testing.T) {
+				ctx := testlog.WithTB(context.Background(), t)
+				_, err := ReadDir(ctx, test.bio, "bork")
+				if !errors.Is(err, fs.ErrNotExist) {
+					t.Errorf("ReadDir(ctx, bio, \"bork\") = _, %v; want fs.ErrNotExist", err)
+				}
+			})
+		})
+	}
+}
+
 // forceFallback delegates the minimal biome method set to another biome.
 // This forces functions that test for extra methods on a biome to fall back
 // to the default implementation.
@@ -322,13 +742,48 @@ func (unsupported) MkdirAll(ctx context.Context, path string) error {
 	return fmt.Errorf("mkdir -p %s: %w", path, ErrUnsupported)
 }
 
+func (unsupported) MkdirAllPerm(ctx context.Context, path string, perm fs.FileMode) error {
+	return fmt.Errorf("mkdir -p %s: %w", path, ErrUnsupported)
+}
+
 func (unsupported) EvalSymlinks(ctx context.Context, path string) (string, error) {
 	return "", fmt.Errorf("eval symlinks %s: %w", path, ErrUnsupported)
 }
 
+func (unsupported) Stat(ctx context.Context, path string) (fs.FileInfo, error) {
+	return nil, fmt.Errorf("stat %s: %w", path, ErrUnsupported)
+}
+
+func (unsupported) ReadDir(ctx context.Context, path string) ([]fs.DirEntry, error) {
+	return nil, fmt.Errorf("read dir %s: %w", path, ErrUnsupported)
+}
+
 var _ interface {
 	fileOpener
 	fileWriter
 	dirMaker
+	dirPermMaker
 	symlinkEvaler
+	statter
+	dirReader
 } = unsupported{}
+
+// pathErrorUnsupported is like unsupported, but wraps ErrUnsupported in an
+// *fs.PathError instead of using fmt.Errorf.
+type pathErrorUnsupported struct {
+	Biome
+}
+
+func (pathErrorUnsupported) OpenFile(ctx context.Context, path string) (io.ReadCloser, error) {
+	return nil, &fs.PathError{Op: "open", Path: path, Err: ErrUnsupported}
+}
+
+// failingOpener returns err from OpenFile.
+type failingOpener struct {
+	Biome
+	err error
+}
+
+func (f failingOpener) OpenFile(ctx context.Context, path string) (io.ReadCloser, error) {
+	return nil, f.err
+}
@@ -5,8 +5,10 @@ go 1.17
 require (
 	github.com/google/go-cmp v0.5.6
 	github.com/spf13/cobra v1.2.1
+	github.com/ulikunitz/xz v0.5.10
 	github.com/yourbase/commons v0.9.1
 	go.starlark.net v0.0.0-20211013185944-b0039bd2cfe3
+	go.uber.org/goleak v1.1.12
 	go4.org v0.0.0-20201209231011-d4a079459e60
 	golang.org/x/sys v0.0.0-20211102192858-4dd72447c267
 	golang.org/x/term v0.0.0-20210927222741-03fcf44c2211
@@ -15,11 +17,15 @@ require (
 )
 
 require (
+	github.com/cpuguy83/go-md2man/v2 v2.0.0 // indirect
 	github.com/inconshreveable/mousetrap v1.0.0 // indirect
 	github.com/mattn/go-isatty v0.0.12 // indirect
 	github.com/remyoudompheng/bigfft v0.0.0-20200410134404-eec4a21b6bb0 // indirect
+	github.com/russross/blackfriday/v2 v2.0.1 // indirect
+	github.com/shurcooL/sanitized_anchor_name v1.0.0 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
 	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
+	gopkg.in/yaml.v2 v2.4.0 // indirect
 	modernc.org/libc v1.11.3 // indirect
 	modernc.org/mathutil v1.4.1 // indirect
 	modernc.org/memory v1.0.5 // indirect
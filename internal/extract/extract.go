@@ -18,10 +18,18 @@
 package extract
 
 import (
+	"archive/tar"
 	"archive/zip"
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -31,7 +39,7 @@ import (
 	"zombiezen.com/go/log"
 )
 
-// Extract modes.
+// Extract modes. Use Options.StripComponents instead.
 const (
 	// Archive does not contain a top-level directory.
 	Tarbomb = false
@@ -40,13 +48,77 @@ const (
 )
 
 type Options struct {
-	URL            string
+	URL string
+	// Mirrors is an optional list of URLs to try in order if downloading URL
+	// fails. The archive format is determined from URL, so mirrors must serve
+	// the same kind of archive.
+	Mirrors        []string
 	DestinationDir string
+	// CacheKey is an optional key to cache the download under instead of URL.
+	CacheKey string
+	// Header holds optional HTTP header fields to send with the download
+	// request, like credentials. They are not logged or used in the cache key.
+	Header http.Header
+	// SHA256 is an optional hex-encoded SHA-256 digest of the archive.
+	// If set, Extract returns an error without extracting anything
+	// if the downloaded archive does not match.
+	SHA256 string
+	// OnDownload is an optional function that is called with the
+	// hex-encoded SHA-256 digest of the archive after it is downloaded.
+	OnDownload func(sha256 string)
 
-	Biome       biome.Biome
-	Downloader  *downloader.Downloader
-	Output      io.Writer
+	Biome      biome.Biome
+	Downloader *downloader.Downloader
+	// Output receives the output of any programs run in the biome.
+	// If Output is nil, then the output is discarded.
+	Output io.Writer
+	// LogPrefix is an optional string written at the start of each line
+	// written to Output.
+	LogPrefix string
+	// StripComponents is the number of leading directories to remove
+	// from the names of the archive's files, like tar's --strip-components.
+	// Entries that are not inside that many directories are skipped if they
+	// are directories and are otherwise an error. If StripComponents is 1,
+	// all files must be inside a single top-level directory.
+	// Values greater than 1 are only supported for tar archives.
+	StripComponents int
+	// ExtractMode is either Tarbomb or StripTopDirectory.
+	// It is ignored if StripComponents is not zero.
+	//
+	// Deprecated: Use StripComponents. StripTopDirectory is the same as
+	// setting StripComponents to 1.
 	ExtractMode bool
+
+	// Merge indicates that DestinationDir may already contain files,
+	// such as from a previous call to Extract. The archive's files are added
+	// to the directory, replacing any files with the same names. If Merge is
+	// set and DestinationDir already exists, then a failed extraction does not
+	// remove DestinationDir, so it may be left with some of the archive's
+	// files. When stripping directories, the archive's top-level directory is
+	// merged into DestinationDir, so it may share a name with an existing
+	// file or directory in DestinationDir.
+	Merge bool
+}
+
+// Archive file extensions.
+const (
+	zipExt    = ".zip"
+	tarXZExt  = ".tar.xz"
+	tarGZExt  = ".tar.gz"
+	tarBZ2Ext = ".tar.bz2"
+)
+
+// hashFile returns the hex-encoded SHA-256 digest of f's content
+// and then seeks back to the beginning of the file.
+func hashFile(f io.ReadSeeker) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
 // Extract downloads the given URL and extracts it to the given directory in the biome.
@@ -56,13 +128,8 @@ func Extract(ctx context.Context, opts *Options) (err error) {
 			err = fmt.Errorf("extract %s in %s: %w", opts.URL, opts.DestinationDir, err)
 		}
 	}()
+	opts = withOutput(opts)
 
-	const (
-		zipExt    = ".zip"
-		tarXZExt  = ".tar.xz"
-		tarGZExt  = ".tar.gz"
-		tarBZ2Ext = ".tar.bz2"
-	)
 	const cleanupTimeout = 10 * time.Second
 	exts := []string{
 		zipExt,
@@ -80,45 +147,103 @@ func Extract(ctx context.Context, opts *Options) (err error) {
 	if ext == "" {
 		return fmt.Errorf("unknown extension")
 	}
+	strip := opts.stripCount()
+	if strip < 0 {
+		return fmt.Errorf("negative number of directories to strip (%d)", strip)
+	}
+	if strip > 1 && ext == zipExt {
+		return fmt.Errorf("stripping more than one directory is only supported for tar archives")
+	}
 
-	f, err := opts.Downloader.Download(ctx, opts.URL)
+	// Tar archives can be extracted in-process for local biomes,
+	// so they don't need tar or a decompressor installed.
+	local, isLocal := biome.AsLocal(opts.Biome)
+	inProcess := isLocal && ext != zipExt
+	if !inProcess {
+		if ext == zipExt {
+			err = requireTool(ctx, opts.Biome, "unzip", "install it or use a tar archive")
+		} else {
+			err = requireTool(ctx, opts.Biome, "tar", "install it or use a zip archive")
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	var downloadOpts []downloader.DownloadOption
+	if opts.CacheKey != "" {
+		downloadOpts = append(downloadOpts, downloader.WithCacheKey(opts.CacheKey))
+	}
+	if len(opts.Header) > 0 {
+		downloadOpts = append(downloadOpts, downloader.WithHeader(opts.Header))
+	}
+	urls := append([]string{opts.URL}, opts.Mirrors...)
+	f, err := opts.Downloader.DownloadFirst(ctx, urls, downloadOpts...)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
-
-	defer func() {
-		// Attempt to clean up if unarchive fails.
+	if opts.SHA256 != "" || opts.OnDownload != nil {
+		sum, err := hashFile(f)
 		if err != nil {
-			ctx, cancel := xcontext.KeepAlive(ctx, cleanupTimeout)
-			defer cancel()
-			rmErr := opts.Biome.Run(ctx, &biome.Invocation{
-				Argv:   []string{"rm", "-rf", opts.DestinationDir},
-				Stdout: opts.Output,
-				Stderr: opts.Output,
-			})
-			if rmErr != nil {
-				log.Warnf(ctx, "Failed to clean up %s: %v", opts.DestinationDir, rmErr)
-			}
+			return err
 		}
-	}()
-	err = biome.MkdirAll(ctx, opts.Biome, opts.DestinationDir)
-	if err != nil {
-		return err
+		if opts.SHA256 != "" && !strings.EqualFold(sum, opts.SHA256) {
+			return fmt.Errorf("archive SHA-256 is %s; want %s", sum, opts.SHA256)
+		}
+		if opts.OnDownload != nil {
+			opts.OnDownload(sum)
+		}
+	}
+
+	// removeDst is true if the destination directory should be removed
+	// if unarchive fails. A merge into an existing directory must not
+	// remove files that were already present.
+	removeDst := true
+	if opts.Merge {
+		_, statErr := biome.Stat(ctx, opts.Biome, opts.DestinationDir)
+		removeDst = errors.Is(statErr, fs.ErrNotExist)
 	}
-	dstFile := opts.DestinationDir + ext
+	// dstFile is the path of the archive in the biome, if it has been copied.
+	var dstFile string
+	// stagingDir is the path of a directory used to strip the archive's
+	// top-level directory before merging, if one has been created.
+	var stagingDir string
 	defer func() {
+		// Always remove the archive and staging directory. If unarchive fails,
+		// also remove the partially extracted destination directory.
+		rmArgv := []string{"rm", "-rf"}
+		if err != nil && removeDst {
+			rmArgv = append(rmArgv, opts.DestinationDir)
+		}
+		if dstFile != "" {
+			rmArgv = append(rmArgv, dstFile)
+		}
+		if stagingDir != "" {
+			rmArgv = append(rmArgv, stagingDir)
+		}
+		if len(rmArgv) == 2 {
+			return
+		}
 		ctx, cancel := xcontext.KeepAlive(ctx, cleanupTimeout)
 		defer cancel()
 		rmErr := opts.Biome.Run(ctx, &biome.Invocation{
-			Argv:   []string{"rm", "-f", dstFile},
+			Argv:   rmArgv,
 			Stdout: opts.Output,
 			Stderr: opts.Output,
 		})
 		if rmErr != nil {
-			log.Warnf(ctx, "Failed to clean up %s: %v", dstFile, rmErr)
+			log.Warnf(ctx, "Failed to clean up %s: %v", strings.Join(rmArgv[2:], ", "), rmErr)
 		}
 	}()
+	err = biome.MkdirAll(ctx, opts.Biome, opts.DestinationDir)
+	if err != nil {
+		return err
+	}
+	if inProcess {
+		return extractTar(f, ext, biome.AbsPath(local, opts.DestinationDir), strip)
+	}
+	dstFile = opts.DestinationDir + ext
 	err = biome.WriteFile(ctx, opts.Biome, dstFile, f)
 	if err != nil {
 		return err
@@ -130,67 +255,229 @@ func Extract(ctx context.Context, opts *Options) (err error) {
 		Stderr: opts.Output,
 	}
 	absDstFile := biome.AbsPath(opts.Biome, dstFile)
+	var tarCompressFlag string
 	switch ext {
 	case zipExt:
 		invoke.Argv = []string{"unzip", "-q", absDstFile}
-	case tarXZExt:
-		invoke.Argv = []string{
-			"tar",
-			"-x", // extract
-			"-J", // xz
-			"-f", absDstFile,
-		}
-		if opts.ExtractMode == StripTopDirectory {
-			invoke.Argv = append(invoke.Argv, "--strip-components", "1")
+		if opts.Merge {
+			invoke.Argv = []string{"unzip", "-o", "-q", absDstFile}
 		}
+	case tarXZExt:
+		tarCompressFlag = "-J" // xz
 	case tarGZExt:
+		tarCompressFlag = "-z" // gzip
+	case tarBZ2Ext:
+		tarCompressFlag = "-j" // bzip2
+	default:
+		panic("unreachable")
+	}
+	manualStrip := strip == 1
+	if tarCompressFlag != "" {
 		invoke.Argv = []string{
 			"tar",
 			"-x", // extract
-			"-z", // gzip
+			tarCompressFlag,
 			"-f", absDstFile,
 		}
-		if opts.ExtractMode == StripTopDirectory {
-			invoke.Argv = append(invoke.Argv, "--strip-components", "1")
+		if strip > 0 {
+			stripArgs := tarStripComponentsArgs(detectTar(ctx, opts.Biome), strip)
+			if len(stripArgs) == 0 && strip > 1 {
+				return fmt.Errorf("tar in biome does not support --strip-components")
+			}
+			invoke.Argv = append(invoke.Argv, stripArgs...)
+			manualStrip = len(stripArgs) == 0
 		}
-	case tarBZ2Ext:
-		invoke.Argv = []string{
-			"tar",
-			"-x", // extract
-			"-j", // bzip2
-			"-f", absDstFile,
+	}
+	var root string
+	var names []string
+	if manualStrip {
+		// Determine the archive's top-level directory before extracting
+		// so we don't create any files if the archive is malformed.
+		if ext == zipExt {
+			// There's no convenient way of stripping the top-level directory from an
+			// unzip invocation, but we can move the files ourselves.
+			size, err := f.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return fmt.Errorf("determine archive size: %w", err)
+			}
+			zr, err := zip.NewReader(f, size)
+			if err != nil {
+				return err
+			}
+			root, names, err = topLevelZipFilenames(zr.File)
+			if err != nil {
+				return err
+			}
+		} else {
+			listing := new(strings.Builder)
+			err := opts.Biome.Run(ctx, &biome.Invocation{
+				Argv:   []string{"tar", "-t", tarCompressFlag, "-f", absDstFile},
+				Stdout: listing,
+				Stderr: opts.Output,
+			})
+			if err != nil {
+				return fmt.Errorf("list archive: %w", err)
+			}
+			root, names, err = topLevelFilenames(parseTarListing(listing.String()))
+			if err != nil {
+				return err
+			}
 		}
-		if opts.ExtractMode == StripTopDirectory {
-			invoke.Argv = append(invoke.Argv, "--strip-components", "1")
+	}
+	if manualStrip && opts.Merge {
+		// Moving the archive's files into the destination can't replace
+		// existing directories, so extract to a staging directory and copy.
+		stagingDir = opts.DestinationDir + ".extract"
+		if err := biome.MkdirAll(ctx, opts.Biome, stagingDir); err != nil {
+			return err
 		}
-	default:
-		panic("unreachable")
+		invoke.Dir = biome.AbsPath(opts.Biome, stagingDir)
 	}
 	if err := opts.Biome.Run(ctx, invoke); err != nil {
 		return err
 	}
-	if ext == zipExt && opts.ExtractMode == StripTopDirectory {
-		// There's no convenient way of stripping the top-level directory from an
-		// unzip invocation, but we can move the files ourselves.
-		size, err := f.Seek(0, io.SeekCurrent)
-		if err != nil {
-			return fmt.Errorf("determine archive size: %w", err)
+	if manualStrip && root != "" {
+		if stagingDir != "" {
+			err = mergeRoot(ctx, opts, stagingDir, root)
+		} else {
+			err = stripRoot(ctx, opts, root, names)
 		}
-		zr, err := zip.NewReader(f, size)
 		if err != nil {
 			return err
 		}
-		root, names, err := topLevelZipFilenames(zr.File)
+	}
+	return nil
+}
+
+// stripCount returns the number of leading directories to remove
+// from the archive's file names.
+func (opts *Options) stripCount() int {
+	if opts.StripComponents != 0 {
+		return opts.StripComponents
+	}
+	if opts.ExtractMode == StripTopDirectory {
+		return 1
+	}
+	return 0
+}
+
+// withOutput returns a copy of opts whose Output is non-nil
+// and writes LogPrefix at the start of each line.
+func withOutput(opts *Options) *Options {
+	opts2 := new(Options)
+	*opts2 = *opts
+	if opts2.Output == nil {
+		opts2.Output = io.Discard
+	}
+	if opts2.LogPrefix != "" {
+		opts2.Output = &prefixWriter{w: opts2.Output, prefix: opts2.LogPrefix}
+	}
+	return opts2
+}
+
+// prefixWriter is an io.Writer that writes a prefix at the start of each line.
+type prefixWriter struct {
+	w      io.Writer
+	prefix string
+	// midLine is true if the last byte written was not a newline.
+	midLine bool
+}
+
+func (pw *prefixWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		if !pw.midLine {
+			if _, err := io.WriteString(pw.w, pw.prefix); err != nil {
+				return n, err
+			}
+			pw.midLine = true
+		}
+		line := p
+		if i := bytes.IndexByte(p, '\n'); i != -1 {
+			line = p[:i+1]
+		}
+		nn, err := pw.w.Write(line)
+		n += nn
 		if err != nil {
-			return err
+			return n, err
+		}
+		pw.midLine = line[len(line)-1] != '\n'
+		p = p[len(line):]
+	}
+	return n, nil
+}
+
+// requireTool returns an error if the given program cannot be found in the
+// biome. hint is included in the error message to suggest a remedy.
+func requireTool(ctx context.Context, bio biome.Biome, tool string, hint string) error {
+	found, err := biome.HasCommand(ctx, bio, tool)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("%s not found in biome; %s", tool, hint)
+	}
+	return nil
+}
+
+// Variants of tar.
+const (
+	unknownTar = iota
+	gnuTar
+	bsdTar
+)
+
+// detectTar determines which implementation of tar the biome has.
+func detectTar(ctx context.Context, bio biome.Biome) int {
+	out, err := biome.CombinedOutput(ctx, bio, "tar", "--version")
+	if err != nil {
+		log.Debugf(ctx, "tar --version: %v", err)
+	}
+	switch {
+	case bytes.Contains(out, []byte("GNU tar")):
+		return gnuTar
+	case bytes.Contains(out, []byte("bsdtar")):
+		return bsdTar
+	default:
+		return unknownTar
+	}
+}
+
+// tarStripComponentsArgs returns the arguments to pass to the given variant
+// of tar to strip n leading directories from the archive's files,
+// or nil if the variant does not support doing so.
+func tarStripComponentsArgs(variant int, n int) []string {
+	switch variant {
+	case gnuTar:
+		return []string{"--strip-components=" + strconv.Itoa(n)}
+	case bsdTar:
+		return []string{"--strip-components", strconv.Itoa(n)}
+	default:
+		return nil
+	}
+}
+
+// parseTarListing parses the output of `tar -t`.
+func parseTarListing(listing string) []string {
+	var names []string
+	for _, line := range strings.Split(listing, "\n") {
+		name := strings.TrimPrefix(line, "./")
+		if name != "" {
+			names = append(names, name)
 		}
+	}
+	return names
+}
 
+// stripRoot moves the given names from the root directory
+// into the destination directory.
+func stripRoot(ctx context.Context, opts *Options, root string, names []string) error {
+	if len(names) > 0 {
 		mvArgv := []string{"mv"}
 		for _, name := range names {
 			mvArgv = append(mvArgv, biome.JoinPath(opts.Biome.Describe(), root, name))
 		}
 		mvArgv = append(mvArgv, ".")
-		err = opts.Biome.Run(ctx, &biome.Invocation{
+		err := opts.Biome.Run(ctx, &biome.Invocation{
 			Argv:   mvArgv,
 			Dir:    biome.AbsPath(opts.Biome, opts.DestinationDir),
 			Stdout: opts.Output,
@@ -199,41 +486,80 @@ func Extract(ctx context.Context, opts *Options) (err error) {
 		if err != nil {
 			return err
 		}
-		err = opts.Biome.Run(ctx, &biome.Invocation{
-			Argv:   []string{"rmdir", root},
-			Dir:    biome.AbsPath(opts.Biome, opts.DestinationDir),
-			Stdout: opts.Output,
-			Stderr: opts.Output,
-		})
-		if err != nil {
-			return err
-		}
 	}
-	return nil
+	return opts.Biome.Run(ctx, &biome.Invocation{
+		Argv:   []string{"rmdir", root},
+		Dir:    biome.AbsPath(opts.Biome, opts.DestinationDir),
+		Stdout: opts.Output,
+		Stderr: opts.Output,
+	})
+}
+
+// mergeRoot copies the contents of the root directory in stagingDir
+// into the destination directory, merging with any existing files.
+func mergeRoot(ctx context.Context, opts *Options, stagingDir string, root string) error {
+	// A trailing "/." copies the directory's contents rather than the directory.
+	// JoinPath would clean it away.
+	return opts.Biome.Run(ctx, &biome.Invocation{
+		Argv: []string{
+			"cp", "-R",
+			root + "/.",
+			biome.AbsPath(opts.Biome, opts.DestinationDir),
+		},
+		Dir:    biome.AbsPath(opts.Biome, stagingDir),
+		Stdout: opts.Output,
+		Stderr: opts.Output,
+	})
 }
 
 // topLevelZipFilenames returns the names of the direct children of the root zip
 // file directory.
 func topLevelZipFilenames(files []*zip.File) (root string, names []string, _ error) {
+	fileNames := make([]string, 0, len(files))
+	for _, f := range files {
+		fileNames = append(fileNames, f.Name)
+	}
+	return topLevelFilenames(fileNames)
+}
+
+// topLevelTarNames returns the names of the direct children of the root
+// directory of a tar archive. It reads tr until the end of the archive.
+func topLevelTarNames(tr *tar.Reader) (root string, names []string, _ error) {
+	var fileNames []string
+	err := readTar(tr, func(hdr *tar.Header, r io.Reader) error {
+		if hdr.Typeflag != tar.TypeXGlobalHeader && hdr.Name != "" {
+			fileNames = append(fileNames, hdr.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("find archive root directory: %w", err)
+	}
+	return topLevelFilenames(fileNames)
+}
+
+// topLevelFilenames returns the names of the direct children of the root
+// directory of an archive, given the slash-separated names of its files.
+func topLevelFilenames(files []string) (root string, names []string, _ error) {
 	if len(files) == 0 {
 		return "", nil, nil
 	}
-	i := strings.IndexByte(files[0].Name, '/')
+	i := strings.IndexByte(files[0], '/')
 	if i == -1 {
-		return "", nil, fmt.Errorf("find zip root directory: %q not in a directory", files[0].Name)
+		return "", nil, fmt.Errorf("find archive root directory: %q not in a directory", files[0])
 	}
-	root = files[0].Name[:i]
-	prefix := files[0].Name[:i+1]
+	root = files[0][:i]
+	prefix := files[0][:i+1]
 	for _, f := range files {
-		if !strings.HasPrefix(f.Name, prefix) {
-			return "", nil, fmt.Errorf("find zip root directory: %q not in directory %q", f.Name, root)
+		if !strings.HasPrefix(f, prefix) {
+			return "", nil, fmt.Errorf("find archive root directory: %q not in directory %q", f, root)
 		}
-		name := f.Name[i+1:]
+		name := f[i+1:]
 		if nameEnd := strings.IndexByte(name, '/'); nameEnd != -1 {
 			name = name[:nameEnd]
 		}
 		if name == root {
-			return "", nil, fmt.Errorf("strip zip root directory: %q contains a file %q", name, name)
+			return "", nil, fmt.Errorf("strip archive root directory: %q contains a file %q", name, name)
 		}
 		if name != "" && !stringInSlice(names, name) {
 			names = append(names, name)
@@ -23,17 +23,23 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"io"
+	"io/fs"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/ulikunitz/xz"
 	"github.com/yourbase/commons/http/headers"
 	"zombiezen.com/go/biome"
 	"zombiezen.com/go/biome/downloader"
@@ -49,6 +55,10 @@ func TestExtract(t *testing.T) {
 		ext         string
 		contentType string
 		mode        bool
+		strip       int
+		// If tarVersion is not empty, then it is used as the output of
+		// `tar --version` in the biome.
+		tarVersion string
 	}{
 		{
 			name:        "Zip",
@@ -64,6 +74,37 @@ func TestExtract(t *testing.T) {
 			archive:     makeGzipTar("root/foo/bar.txt"),
 			contentType: "application/gzip",
 		},
+		{
+			name:        "GzipTar/BSD",
+			mode:        StripTopDirectory,
+			ext:         ".tar.gz",
+			archive:     makeGzipTar("root/foo/bar.txt"),
+			contentType: "application/gzip",
+			tarVersion:  "bsdtar 3.5.1 - libarchive 3.5.1 zlib/1.2.11 liblzma/5.0.5 bz2lib/1.0.8\n",
+		},
+		{
+			name:        "GzipTar/ManualStrip",
+			mode:        StripTopDirectory,
+			ext:         ".tar.gz",
+			archive:     makeGzipTar("root/foo/bar.txt"),
+			contentType: "application/gzip",
+			tarVersion:  "tar: unrecognized option '--version'\nBusyBox v1.33.1 () multi-call binary.\n",
+		},
+		{
+			name:        "GzipTar/StripComponents2",
+			strip:       2,
+			ext:         ".tar.gz",
+			archive:     makeGzipTar("root/sub/foo/bar.txt"),
+			contentType: "application/gzip",
+		},
+		{
+			name:        "GzipTar/StripComponents2/BSD",
+			strip:       2,
+			ext:         ".tar.gz",
+			archive:     makeGzipTar("root/sub/foo/bar.txt"),
+			contentType: "application/gzip",
+			tarVersion:  "bsdtar 3.5.1 - libarchive 3.5.1 zlib/1.2.11 liblzma/5.0.5 bz2lib/1.0.8\n",
+		},
 		{
 			name:        "ZipBomb",
 			archive:     makeZip("foo/bar.txt"),
@@ -101,14 +142,18 @@ func TestExtract(t *testing.T) {
 			}
 			output := new(strings.Builder)
 			opts := &Options{
-				URL:            srv.URL + wantPath,
-				DestinationDir: biome.JoinPath(bio.Describe(), bio.HomeDir, "extractpoint"),
-				Biome:          bio,
-				Output:         output,
-				Downloader:     downloader.New(t.TempDir()),
-				ExtractMode:    test.mode,
+				URL:             srv.URL + wantPath,
+				DestinationDir:  biome.JoinPath(bio.Describe(), bio.HomeDir, "extractpoint"),
+				Biome:           bio,
+				Output:          output,
+				Downloader:      downloader.New(t.TempDir()),
+				ExtractMode:     test.mode,
+				StripComponents: test.strip,
 			}
 			opts.Downloader.Client = srv.Client()
+			if test.tarVersion != "" {
+				opts.Biome = fakeTarVersion{Biome: bio, output: test.tarVersion}
+			}
 
 			if err := Extract(ctx, opts); err != nil {
 				t.Error("extract:", err)
@@ -122,10 +167,590 @@ func TestExtract(t *testing.T) {
 			if string(got) != extractContent {
 				t.Errorf("%s content = %q; want %q", outPath, got, extractContent)
 			}
+			if _, err := os.Lstat(opts.DestinationDir + test.ext); !os.IsNotExist(err) {
+				t.Errorf("archive %s lingers in biome after extract (err = %v)", opts.DestinationDir+test.ext, err)
+			}
+		})
+	}
+}
+
+func TestExtractCleanup(t *testing.T) {
+	tests := []struct {
+		name      string
+		archive   []byte
+		wantError bool
+	}{
+		{name: "Success", archive: makeZip("foo/bar.txt")},
+		{name: "Failure", archive: []byte("not a zip file"), wantError: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set(headers.ContentLength, strconv.Itoa(len(test.archive)))
+				w.Write(test.archive)
+			}))
+			t.Cleanup(srv.Close)
+
+			ctx := testlog.WithTB(context.Background(), t)
+			bio := biome.Local{
+				WorkDir: t.TempDir(),
+				HomeDir: t.TempDir(),
+			}
+			rc := &rmCounter{Biome: bio}
+			opts := &Options{
+				URL:            srv.URL + "/archive.zip",
+				DestinationDir: biome.JoinPath(bio.Describe(), bio.HomeDir, "extractpoint"),
+				Biome:          rc,
+				Output:         io.Discard,
+				Downloader:     downloader.New(t.TempDir()),
+				ExtractMode:    Tarbomb,
+			}
+			opts.Downloader.Client = srv.Client()
+			err := Extract(ctx, opts)
+			if err != nil && !test.wantError {
+				t.Error("extract:", err)
+			} else if err == nil && test.wantError {
+				t.Error("extract did not return an error")
+			}
+
+			if rc.n != 1 {
+				t.Errorf("rm invoked %d times; want 1", rc.n)
+			}
+			if _, err := os.Lstat(opts.DestinationDir + ".zip"); !os.IsNotExist(err) {
+				t.Errorf("archive lingers in biome after extract (err = %v)", err)
+			}
+			_, err = os.Lstat(opts.DestinationDir)
+			if test.wantError && !os.IsNotExist(err) {
+				t.Errorf("destination directory not removed after failed extract (err = %v)", err)
+			}
+			if !test.wantError && err != nil {
+				t.Error(err)
+			}
 		})
 	}
 }
 
+func TestExtractMerge(t *testing.T) {
+	tarFiles := func(names ...string) []byte {
+		var entries []*tar.Header
+		for _, name := range names {
+			entries = append(entries, &tar.Header{
+				Name:     name,
+				Typeflag: tar.TypeReg,
+				Mode:     0o644,
+				Size:     int64(len(extractContent)),
+			})
+		}
+		return makeTar(entries, tarGZExt)
+	}
+	tests := []struct {
+		name     string
+		ext      string
+		mode     bool
+		archives [][]byte
+		want     []string
+	}{
+		{
+			name: "GzipTar",
+			ext:  tarGZExt,
+			mode: StripTopDirectory,
+			archives: [][]byte{
+				tarFiles("root1/a.txt", "root1/sub/b.txt"),
+				tarFiles("root2/sub/c.txt", "root2/root1"),
+			},
+			want: []string{"a.txt", "sub/b.txt", "sub/c.txt", "root1"},
+		},
+		{
+			name: "Zip",
+			ext:  zipExt,
+			mode: StripTopDirectory,
+			archives: [][]byte{
+				makeZip("root1/a.txt", "root1/sub/b.txt"),
+				makeZip("root2/sub/c.txt", "root2/a.txt"),
+			},
+			want: []string{"a.txt", "sub/b.txt", "sub/c.txt"},
+		},
+		{
+			name: "ZipBomb",
+			ext:  zipExt,
+			mode: Tarbomb,
+			archives: [][]byte{
+				makeZip("a.txt", "sub/b.txt"),
+				makeZip("sub/c.txt", "a.txt"),
+			},
+			want: []string{"a.txt", "sub/b.txt", "sub/c.txt"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				i, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/"), test.ext))
+				if err != nil || i < 0 || i >= len(test.archives) {
+					http.NotFound(w, r)
+					return
+				}
+				w.Header().Set(headers.ContentLength, strconv.Itoa(len(test.archives[i])))
+				w.Write(test.archives[i])
+			}))
+			t.Cleanup(srv.Close)
+
+			ctx := testlog.WithTB(context.Background(), t)
+			bio := biome.Local{
+				WorkDir: t.TempDir(),
+				HomeDir: t.TempDir(),
+			}
+			dst := biome.JoinPath(bio.Describe(), bio.HomeDir, "extractpoint")
+			d := downloader.New(t.TempDir())
+			d.Client = srv.Client()
+			for i := range test.archives {
+				err := Extract(ctx, &Options{
+					URL:            fmt.Sprintf("%s/%d%s", srv.URL, i, test.ext),
+					DestinationDir: dst,
+					Biome:          bio,
+					Output:         io.Discard,
+					Downloader:     d,
+					ExtractMode:    test.mode,
+					Merge:          true,
+				})
+				if err != nil {
+					t.Fatalf("extract archive %d: %v", i, err)
+				}
+			}
+
+			var got []string
+			err := filepath.WalkDir(bio.HomeDir, func(path string, ent fs.DirEntry, err error) error {
+				if err != nil || ent.IsDir() {
+					return err
+				}
+				rel, err := filepath.Rel(dst, path)
+				if err != nil {
+					return err
+				}
+				got = append(got, filepath.ToSlash(rel))
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			diff := cmp.Diff(test.want, got, cmpopts.SortSlices(func(s1, s2 string) bool { return s1 < s2 }))
+			if diff != "" {
+				t.Errorf("files (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestExtractMergeFailureKeepsDestination(t *testing.T) {
+	archive := []byte("not a zip file")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(headers.ContentLength, strconv.Itoa(len(archive)))
+		w.Write(archive)
+	}))
+	t.Cleanup(srv.Close)
+
+	ctx := testlog.WithTB(context.Background(), t)
+	bio := biome.Local{
+		WorkDir: t.TempDir(),
+		HomeDir: t.TempDir(),
+	}
+	dst := biome.JoinPath(bio.Describe(), bio.HomeDir, "extractpoint")
+	if err := os.Mkdir(dst, 0o777); err != nil {
+		t.Fatal(err)
+	}
+	keepPath := filepath.Join(dst, "keep.txt")
+	if err := os.WriteFile(keepPath, []byte(extractContent), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	opts := &Options{
+		URL:            srv.URL + "/archive.zip",
+		DestinationDir: dst,
+		Biome:          bio,
+		Output:         io.Discard,
+		Downloader:     downloader.New(t.TempDir()),
+		ExtractMode:    Tarbomb,
+		Merge:          true,
+	}
+	opts.Downloader.Client = srv.Client()
+	if err := Extract(ctx, opts); err == nil {
+		t.Error("extract did not return an error")
+	}
+	if _, err := os.Stat(keepPath); err != nil {
+		t.Errorf("pre-existing file removed after failed merge: %v", err)
+	}
+}
+
+func TestExtractSHA256(t *testing.T) {
+	archive := makeZip("foo.txt")
+	sum := sha256.Sum256(archive)
+	wantSum := hex.EncodeToString(sum[:])
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(headers.ContentLength, strconv.Itoa(len(archive)))
+		w.Write(archive)
+	}))
+	t.Cleanup(srv.Close)
+
+	tests := []struct {
+		name    string
+		sha256  string
+		wantErr bool
+	}{
+		{name: "Unchecked", sha256: ""},
+		{name: "Match", sha256: wantSum},
+		{name: "MatchUppercase", sha256: strings.ToUpper(wantSum)},
+		{name: "Mismatch", sha256: strings.Repeat("0", len(wantSum)), wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := testlog.WithTB(context.Background(), t)
+			bio := biome.Local{
+				WorkDir: t.TempDir(),
+				HomeDir: t.TempDir(),
+			}
+			dst := biome.JoinPath(bio.Describe(), bio.HomeDir, "extractpoint")
+			var gotSum string
+			opts := &Options{
+				URL:            srv.URL + "/archive.zip",
+				SHA256:         test.sha256,
+				OnDownload:     func(sum string) { gotSum = sum },
+				DestinationDir: dst,
+				Biome:          bio,
+				Downloader:     downloader.New(t.TempDir()),
+				ExtractMode:    Tarbomb,
+			}
+			opts.Downloader.Client = srv.Client()
+			err := Extract(ctx, opts)
+			if test.wantErr {
+				if err == nil {
+					t.Error("Extract did not return an error")
+				}
+				if _, err := os.Stat(filepath.Join(dst, "foo.txt")); err == nil {
+					t.Error("archive extracted despite mismatched digest")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("Extract:", err)
+			}
+			if gotSum != wantSum {
+				t.Errorf("OnDownload called with %q; want %q", gotSum, wantSum)
+			}
+			if _, err := os.Stat(filepath.Join(dst, "foo.txt")); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestPrefixWriter(t *testing.T) {
+	tests := []struct {
+		writes []string
+		want   string
+	}{
+		{writes: nil, want: ""},
+		{writes: []string{"foo\n"}, want: "[x] foo\n"},
+		{writes: []string{"foo\nbar\n"}, want: "[x] foo\n[x] bar\n"},
+		{writes: []string{"fo", "o\nba", "r"}, want: "[x] foo\n[x] bar"},
+		{writes: []string{"\n\n"}, want: "[x] \n[x] \n"},
+	}
+	for _, test := range tests {
+		buf := new(strings.Builder)
+		pw := &prefixWriter{w: buf, prefix: "[x] "}
+		for _, w := range test.writes {
+			n, err := io.WriteString(pw, w)
+			if n != len(w) || err != nil {
+				t.Errorf("WriteString(pw, %q) = %d, %v; want %d, <nil>", w, n, err, len(w))
+			}
+		}
+		if got := buf.String(); got != test.want {
+			t.Errorf("after writing %q, output = %q; want %q", test.writes, got, test.want)
+		}
+	}
+}
+
+// rmCounter is a biome that counts the number of times it runs rm.
+type rmCounter struct {
+	biome.Biome
+	n int
+}
+
+func (rc *rmCounter) Run(ctx context.Context, invoke *biome.Invocation) error {
+	if len(invoke.Argv) > 0 && invoke.Argv[0] == "rm" {
+		rc.n++
+	}
+	return rc.Biome.Run(ctx, invoke)
+}
+
+func TestExtractMissingTool(t *testing.T) {
+	archive := makeZip("foo/bar.txt")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(headers.ContentLength, strconv.Itoa(len(archive)))
+		w.Write(archive)
+	}))
+	t.Cleanup(srv.Close)
+
+	ctx := testlog.WithTB(context.Background(), t)
+	bio := &biome.Fake{
+		Descriptor: biome.Descriptor{OS: biome.Linux, Arch: biome.Intel64},
+		DirsResult: biome.Dirs{
+			Work: "/work",
+			Home: "/home",
+		},
+		RunFunc: func(ctx context.Context, invoke *biome.Invocation) error {
+			return fmt.Errorf("%s: not found", invoke.Argv[0])
+		},
+	}
+	opts := &Options{
+		URL:            srv.URL + "/archive.zip",
+		DestinationDir: "/home/extractpoint",
+		Biome:          bio,
+		Output:         io.Discard,
+		Downloader:     downloader.New(t.TempDir()),
+	}
+	opts.Downloader.Client = srv.Client()
+	err := Extract(ctx, opts)
+	if err == nil {
+		t.Fatal("Extract did not return an error")
+	}
+	if got, want := err.Error(), "unzip not found in biome"; !strings.Contains(got, want) {
+		t.Errorf("Extract(...) = %v; want error containing %q", got, want)
+	}
+}
+
+// fakeTarVersion is a biome that reports a different variant of tar
+// from `tar --version`.
+type fakeTarVersion struct {
+	biome.Biome
+	output string
+}
+
+func (ft fakeTarVersion) Run(ctx context.Context, invoke *biome.Invocation) error {
+	if len(invoke.Argv) == 2 && invoke.Argv[0] == "tar" && invoke.Argv[1] == "--version" {
+		stdout, _ := invoke.Output()
+		if stdout != nil {
+			io.WriteString(stdout, ft.output)
+		}
+		return nil
+	}
+	return ft.Biome.Run(ctx, invoke)
+}
+
+func TestExtractTar(t *testing.T) {
+	entries := []*tar.Header{
+		{Name: "./root/", Typeflag: tar.TypeDir, Mode: 0o755},
+		{Name: "./root/bin/", Typeflag: tar.TypeDir, Mode: 0o555},
+		{Name: "./root/bin/tool", Typeflag: tar.TypeReg, Mode: 0o755, Size: int64(len(extractContent))},
+		{Name: "./root/link", Typeflag: tar.TypeSymlink, Linkname: "bin/tool"},
+		{Name: "./root/hardlink", Typeflag: tar.TypeLink, Linkname: "./root/bin/tool"},
+	}
+	for _, ext := range []string{tarGZExt, tarXZExt} {
+		t.Run(ext, func(t *testing.T) {
+			archive := makeTar(entries, ext)
+			dst := t.TempDir()
+			if err := extractTar(bytes.NewReader(archive), ext, dst, 1); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { os.Chmod(filepath.Join(dst, "bin"), 0o755) })
+			for _, name := range []string{"bin/tool", "link", "hardlink"} {
+				got, err := os.ReadFile(filepath.Join(dst, filepath.FromSlash(name)))
+				if err != nil {
+					t.Error(err)
+					continue
+				}
+				if string(got) != extractContent {
+					t.Errorf("%s content = %q; want %q", name, got, extractContent)
+				}
+			}
+			if info, err := os.Stat(filepath.Join(dst, "bin", "tool")); err != nil {
+				t.Error(err)
+			} else if got, want := info.Mode().Perm(), fs.FileMode(0o755); got != want {
+				t.Errorf("bin/tool mode = %v; want %v", got, want)
+			}
+			if info, err := os.Stat(filepath.Join(dst, "bin")); err != nil {
+				t.Error(err)
+			} else if got, want := info.Mode().Perm(), fs.FileMode(0o555); got != want {
+				t.Errorf("bin mode = %v; want %v", got, want)
+			}
+			if info, err := os.Lstat(filepath.Join(dst, "link")); err != nil {
+				t.Error(err)
+			} else if info.Mode().Type() != fs.ModeSymlink {
+				t.Errorf("link mode = %v; want symlink", info.Mode())
+			}
+		})
+	}
+
+	t.Run("StripComponents2", func(t *testing.T) {
+		archive := makeTar([]*tar.Header{
+			{Name: "a/", Typeflag: tar.TypeDir, Mode: 0o755},
+			{Name: "a/b/", Typeflag: tar.TypeDir, Mode: 0o755},
+			{Name: "a/b/tool", Typeflag: tar.TypeReg, Mode: 0o755, Size: int64(len(extractContent))},
+			{Name: "a/b/hardlink", Typeflag: tar.TypeLink, Linkname: "a/b/tool"},
+		}, tarGZExt)
+		dst := t.TempDir()
+		if err := extractTar(bytes.NewReader(archive), tarGZExt, dst, 2); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"tool", "hardlink"} {
+			got, err := os.ReadFile(filepath.Join(dst, name))
+			if err != nil {
+				t.Error(err)
+				continue
+			}
+			if string(got) != extractContent {
+				t.Errorf("%s content = %q; want %q", name, got, extractContent)
+			}
+		}
+	})
+
+	t.Run("StripComponentsShallowFile", func(t *testing.T) {
+		archive := makeTar([]*tar.Header{
+			{Name: "a/", Typeflag: tar.TypeDir, Mode: 0o755},
+			{Name: "a/tool", Typeflag: tar.TypeReg, Mode: 0o755, Size: int64(len(extractContent))},
+		}, tarGZExt)
+		if err := extractTar(bytes.NewReader(archive), tarGZExt, t.TempDir(), 2); err == nil {
+			t.Error("extractTar did not return an error")
+		}
+	})
+
+	t.Run("SymlinkOutside", func(t *testing.T) {
+		archive := makeTar([]*tar.Header{
+			{Name: "evil", Typeflag: tar.TypeSymlink, Linkname: "../outside"},
+		}, tarGZExt)
+		err := extractTar(bytes.NewReader(archive), tarGZExt, t.TempDir(), 0)
+		if err == nil {
+			t.Error("extractTar did not return an error")
+		}
+	})
+
+	t.Run("ChainedSymlinks", func(t *testing.T) {
+		// Each symlink refers inside the archive on its own,
+		// but d/l/l2 resolves to the parent of dst.
+		archive := makeTar([]*tar.Header{
+			{Name: "d/", Typeflag: tar.TypeDir, Mode: 0o755},
+			{Name: "d/l", Typeflag: tar.TypeSymlink, Linkname: ".."},
+			{Name: "d/l/l2", Typeflag: tar.TypeSymlink, Linkname: ".."},
+			{Name: "d/l/l2/evil", Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(extractContent))},
+		}, tarGZExt)
+		parent := t.TempDir()
+		dst := filepath.Join(parent, "dst")
+		if err := os.Mkdir(dst, 0o777); err != nil {
+			t.Fatal(err)
+		}
+		if err := extractTar(bytes.NewReader(archive), tarGZExt, dst, 0); err == nil {
+			t.Error("extractTar did not return an error")
+		}
+		entries, err := os.ReadDir(parent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, ent := range entries {
+			if ent.Name() != "dst" {
+				t.Errorf("extractTar created %s outside destination", ent.Name())
+			}
+		}
+	})
+
+	t.Run("HardLinkThroughSymlink", func(t *testing.T) {
+		archive := makeTar([]*tar.Header{
+			{Name: "d/", Typeflag: tar.TypeDir, Mode: 0o755},
+			{Name: "d/l", Typeflag: tar.TypeSymlink, Linkname: ".."},
+			{Name: "d/l2", Typeflag: tar.TypeSymlink, Linkname: "l/.."},
+			{Name: "h", Typeflag: tar.TypeLink, Linkname: "d/l2/secret.txt"},
+		}, tarGZExt)
+		parent := t.TempDir()
+		if err := os.WriteFile(filepath.Join(parent, "secret.txt"), []byte("secret"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		dst := filepath.Join(parent, "dst")
+		if err := os.Mkdir(dst, 0o777); err != nil {
+			t.Fatal(err)
+		}
+		if err := extractTar(bytes.NewReader(archive), tarGZExt, dst, 0); err == nil {
+			t.Error("extractTar did not return an error")
+		}
+		if _, err := os.Lstat(filepath.Join(dst, "h")); err == nil {
+			t.Error("extractTar linked to a file outside destination")
+		}
+	})
+}
+
+func TestExtractStripComponentsUnsupported(t *testing.T) {
+	tests := []struct {
+		name       string
+		archive    []byte
+		ext        string
+		tarVersion string
+	}{
+		{
+			name:    "Zip",
+			archive: makeZip("root/sub/foo/bar.txt"),
+			ext:     ".zip",
+		},
+		{
+			name:       "ManualStrip",
+			archive:    makeGzipTar("root/sub/foo/bar.txt"),
+			ext:        ".tar.gz",
+			tarVersion: "tar: unrecognized option '--version'\nBusyBox v1.33.1 () multi-call binary.\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set(headers.ContentLength, strconv.Itoa(len(test.archive)))
+				w.Write(test.archive)
+			}))
+			t.Cleanup(srv.Close)
+			ctx := testlog.WithTB(context.Background(), t)
+			bio := biome.Local{
+				WorkDir: t.TempDir(),
+				HomeDir: t.TempDir(),
+			}
+			opts := &Options{
+				URL:             srv.URL + "/archive" + test.ext,
+				DestinationDir:  biome.JoinPath(bio.Describe(), bio.HomeDir, "extractpoint"),
+				Biome:           fakeTarVersion{Biome: bio, output: test.tarVersion},
+				Downloader:      downloader.New(t.TempDir()),
+				StripComponents: 2,
+			}
+			opts.Downloader.Client = srv.Client()
+			if err := Extract(ctx, opts); err == nil {
+				t.Error("Extract did not return an error")
+			}
+			if _, err := os.Lstat(opts.DestinationDir); !os.IsNotExist(err) {
+				t.Errorf("%s exists after failed extract (err = %v)", opts.DestinationDir, err)
+			}
+		})
+	}
+}
+
+func TestTrimLeadingDirs(t *testing.T) {
+	tests := []struct {
+		name   string
+		n      int
+		want   string
+		wantOK bool
+	}{
+		{name: "foo/bar.txt", n: 0, want: "foo/bar.txt", wantOK: true},
+		{name: "foo/bar.txt", n: 1, want: "bar.txt", wantOK: true},
+		{name: "a/b/c/d", n: 2, want: "c/d", wantOK: true},
+		{name: "a/b", n: 2, wantOK: false},
+		{name: "a", n: 1, wantOK: false},
+	}
+	for _, test := range tests {
+		got, ok := trimLeadingDirs(test.name, test.n)
+		if got != test.want || ok != test.wantOK {
+			t.Errorf("trimLeadingDirs(%q, %d) = %q, %t; want %q, %t", test.name, test.n, got, ok, test.want, test.wantOK)
+		}
+	}
+}
+
+func TestParseTarListing(t *testing.T) {
+	got := parseTarListing("root/\n./root/foo/\nroot/foo/bar.txt\n")
+	want := []string{"root/", "root/foo/", "root/foo/bar.txt"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("parseTarListing(...) (-want +got):\n%s", diff)
+	}
+}
+
 func TestTopLevelZipFilenames(t *testing.T) {
 	tests := []struct {
 		name  string
@@ -254,15 +879,17 @@ func TestTopLevelZipFilenames(t *testing.T) {
 	}
 }
 
-func makeZip(fname string) []byte {
+func makeZip(fnames ...string) []byte {
 	buf := new(bytes.Buffer)
 	zw := zip.NewWriter(buf)
-	f, err := zw.Create(fname)
-	if err != nil {
-		panic(err)
-	}
-	if _, err := io.WriteString(f, extractContent); err != nil {
-		panic(err)
+	for _, fname := range fnames {
+		f, err := zw.Create(fname)
+		if err != nil {
+			panic(err)
+		}
+		if _, err := io.WriteString(f, extractContent); err != nil {
+			panic(err)
+		}
 	}
 	if err := zw.Close(); err != nil {
 		panic(err)
@@ -295,6 +922,167 @@ func makeGzipTar(fname string) []byte {
 	return buf.Bytes()
 }
 
+func TestTopLevelTarNames(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+
+		root      string
+		want      []string
+		wantError bool
+	}{
+		{
+			name:  "Empty",
+			files: nil,
+			want:  nil,
+		},
+		{
+			name:  "RootDirOnly",
+			files: []string{"foo/"},
+			root:  "foo",
+			want:  nil,
+		},
+		{
+			name:  "SingleFile",
+			files: []string{"foo/", "foo/bar.txt"},
+			want:  []string{"bar.txt"},
+			root:  "foo",
+		},
+		{
+			name:  "DotSlashPrefix",
+			files: []string{"./foo/", "./foo/bar.txt"},
+			want:  []string{"bar.txt"},
+			root:  "foo",
+		},
+		{
+			name:  "FileWithoutRootEntry",
+			files: []string{"foo/bar.txt"},
+			want:  []string{"bar.txt"},
+			root:  "foo",
+		},
+		{
+			name:  "Subdirectory",
+			files: []string{"foo/", "foo/bar/", "foo/bar/baz.txt"},
+			want:  []string{"bar"},
+			root:  "foo",
+		},
+		{
+			name: "ComplexTree",
+			files: []string{
+				"foo/",
+				"foo/bar/",
+				"foo/bar/baz.txt",
+				"foo/quux/",
+				"foo/quux/spam.txt",
+				"foo/quux/eggs.txt",
+				"foo/myfile.dat",
+			},
+			want: []string{"bar", "quux", "myfile.dat"},
+			root: "foo",
+		},
+		{
+			name:      "RootFile",
+			files:     []string{"foo.txt"},
+			wantError: true,
+		},
+		{
+			name:      "ChildMatchesRoot",
+			files:     []string{"foo/foo"},
+			wantError: true,
+		},
+		{
+			name:  "LowerDownMatchesRoot",
+			files: []string{"foo/bar/foo"},
+			want:  []string{"bar"},
+			root:  "foo",
+		},
+		{
+			name:      "DifferingRoots",
+			files:     []string{"foo/bar", "baz/quux"},
+			wantError: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var entries []*tar.Header
+			for _, name := range test.files {
+				hdr := &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(extractContent))}
+				if strings.HasSuffix(name, "/") {
+					hdr.Typeflag = tar.TypeDir
+					hdr.Mode = 0o755
+					hdr.Size = 0
+				}
+				entries = append(entries, hdr)
+			}
+			tr, err := decompressTar(bytes.NewReader(makeTar(entries, tarGZExt)), tarGZExt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			root, got, err := topLevelTarNames(tr)
+			if err != nil {
+				if test.wantError {
+					t.Logf("Got expected error: %v", err)
+				} else {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil && test.wantError {
+				t.Error("topLevelTarNames did not return an error")
+				return
+			}
+			if root != test.root {
+				t.Errorf("root = %q; want %q", root, test.root)
+			}
+			diff := cmp.Diff(
+				test.want, got,
+				cmpopts.EquateEmpty(),
+				cmpopts.SortSlices(func(s1, s2 string) bool { return s1 < s2 }),
+			)
+			if diff != "" {
+				t.Errorf("filenames (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// makeTar returns a tar archive compressed according to ext
+// with the given entries. Regular files contain extractContent.
+func makeTar(entries []*tar.Header, ext string) []byte {
+	buf := new(bytes.Buffer)
+	var zw io.WriteCloser
+	switch ext {
+	case tarGZExt:
+		zw = gzip.NewWriter(buf)
+	case tarXZExt:
+		var err error
+		zw, err = xz.NewWriter(buf)
+		if err != nil {
+			panic(err)
+		}
+	default:
+		panic("unsupported extension " + ext)
+	}
+	tw := tar.NewWriter(zw)
+	for _, hdr := range entries {
+		if err := tw.WriteHeader(hdr); err != nil {
+			panic(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			if _, err := io.WriteString(tw, extractContent); err != nil {
+				panic(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		panic(err)
+	}
+	if err := zw.Close(); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
 func TestMain(m *testing.M) {
 	testlog.Main(nil)
 	os.Exit(m.Run())
@@ -0,0 +1,255 @@
+// Copyright 2021 Ross Light
+// Copyright 2020 YourBase Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		 https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package extract
+
+import (
+	"archive/tar"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ulikunitz/xz"
+)
+
+// TarFile extracts a compressed tar archive into the local directory dst.
+// ext is the archive's file extension (like ".tar.gz"),
+// which determines the compression. Symbolic links in the archive may only
+// refer to files inside dst, and TarFile returns an error rather than
+// create a file through a symbolic link that an earlier entry created.
+func TarFile(f io.ReadSeeker, ext string, dst string) error {
+	return extractTar(f, ext, dst, 0)
+}
+
+// extractTar extracts a compressed tar archive in the OS filesystem
+// directory dst, removing strip leading directories from each file name.
+// ext is the archive's file extension, which determines the compression.
+// If strip is 1, the archive is read twice, so f must be seekable.
+func extractTar(f io.ReadSeeker, ext string, dst string, strip int) error {
+	if strip == 1 {
+		// Check that the archive has a single top-level directory
+		// before extracting so we don't create any files if the archive
+		// is malformed.
+		tr, err := decompressTar(f, ext)
+		if err != nil {
+			return err
+		}
+		if _, _, err := topLevelTarNames(tr); err != nil {
+			return err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+
+	type dirMode struct {
+		path string
+		mode fs.FileMode
+	}
+	var dirModes []dirMode
+	tr, err := decompressTar(f, ext)
+	if err != nil {
+		return err
+	}
+	err = readTar(tr, func(hdr *tar.Header, r io.Reader) error {
+		name, ok := trimLeadingDirs(strings.TrimSuffix(hdr.Name, "/"), strip)
+		if !ok {
+			if hdr.Typeflag == tar.TypeDir || hdr.Typeflag == tar.TypeXGlobalHeader {
+				return nil
+			}
+			return fmt.Errorf("%s: not inside %d directories", hdr.Name, strip)
+		}
+		if name == "" || name == "." {
+			return nil
+		}
+		if !fs.ValidPath(name) {
+			return fmt.Errorf("invalid path %q", hdr.Name)
+		}
+		// An earlier entry may have placed a symlink where this entry's
+		// parent directory goes. Following it could write outside dst.
+		if err := checkNoSymlinks(dst, filepath.Dir(filepath.FromSlash(name))); err != nil {
+			return fmt.Errorf("%s: %w", hdr.Name, err)
+		}
+		path := filepath.Join(dst, filepath.FromSlash(name))
+		mode := hdr.FileInfo().Mode()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := checkNoSymlinks(dst, filepath.FromSlash(name)); err != nil {
+				return fmt.Errorf("%s: %w", hdr.Name, err)
+			}
+			// Apply the directory's permissions after extracting its contents
+			// in case the directory is not writable.
+			dirModes = append(dirModes, dirMode{path, mode.Perm()})
+			return os.MkdirAll(path, 0o777)
+		case tar.TypeReg, tar.TypeRegA:
+			if err := removeForReplace(path); err != nil {
+				return err
+			}
+			w, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm())
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(w, r)
+			closeErr := w.Close()
+			if err != nil {
+				return fmt.Errorf("%s: %w", hdr.Name, err)
+			}
+			if closeErr != nil {
+				return closeErr
+			}
+			return os.Chtimes(path, hdr.ModTime, hdr.ModTime)
+		case tar.TypeSymlink:
+			target := filepath.FromSlash(hdr.Linkname)
+			if filepath.IsAbs(target) || !isSubFilepath(filepath.Join(filepath.Dir(filepath.FromSlash(name)), target)) {
+				return fmt.Errorf("%s: symlink refers to %s which is outside the archive", hdr.Name, hdr.Linkname)
+			}
+			if err := removeForReplace(path); err != nil {
+				return err
+			}
+			return os.Symlink(target, path)
+		case tar.TypeLink:
+			target, ok := trimLeadingDirs(strings.TrimPrefix(hdr.Linkname, "./"), strip)
+			if !ok || !fs.ValidPath(target) {
+				return fmt.Errorf("%s: invalid hard link target %q", hdr.Name, hdr.Linkname)
+			}
+			if err := checkNoSymlinks(dst, filepath.Dir(filepath.FromSlash(target))); err != nil {
+				return fmt.Errorf("%s: hard link target %s: %w", hdr.Name, hdr.Linkname, err)
+			}
+			if err := removeForReplace(path); err != nil {
+				return err
+			}
+			return os.Link(filepath.Join(dst, filepath.FromSlash(target)), path)
+		case tar.TypeXGlobalHeader:
+			return nil
+		default:
+			return fmt.Errorf("%s: unsupported file type %q", hdr.Name, hdr.Typeflag)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	// Apply in reverse so that parents are changed after their children.
+	for i := len(dirModes) - 1; i >= 0; i-- {
+		if err := os.Chmod(dirModes[i].path, dirModes[i].mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// trimLeadingDirs removes the first n slash-separated elements from name.
+// It returns false if name does not have more than n elements.
+func trimLeadingDirs(name string, n int) (string, bool) {
+	for i := 0; i < n; i++ {
+		j := strings.IndexByte(name, '/')
+		if j == -1 {
+			return "", false
+		}
+		name = name[j+1:]
+	}
+	return name, true
+}
+
+// decompressTar returns a reader for the tar archive in r,
+// which is compressed according to the file extension ext.
+func decompressTar(r io.Reader, ext string) (*tar.Reader, error) {
+	switch ext {
+	case tarGZExt:
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return tar.NewReader(zr), nil
+	case tarBZ2Ext:
+		return tar.NewReader(bzip2.NewReader(r)), nil
+	case tarXZExt:
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return tar.NewReader(xr), nil
+	default:
+		return nil, fmt.Errorf("unsupported archive type %s", ext)
+	}
+}
+
+// readTar calls fn for each entry in tr until the end of the archive.
+// Entry names have any leading "./" removed.
+func readTar(tr *tar.Reader, fn func(hdr *tar.Header, r io.Reader) error) error {
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		hdr.Name = strings.TrimPrefix(hdr.Name, "./")
+		if err := fn(hdr, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// removeForReplace removes the file at path (but not a directory)
+// and creates its parent directories so that a new file can be created
+// in its place without writing through an existing symlink.
+func removeForReplace(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
+		return err
+	}
+	if info, err := os.Lstat(path); err == nil && !info.IsDir() {
+		return os.Remove(path)
+	}
+	return nil
+}
+
+// checkNoSymlinks returns an error if any existing element of the relative
+// path name inside dir is a symbolic link. Elements that do not exist yet
+// are fine, since extraction creates them as directories.
+func checkNoSymlinks(dir string, name string) error {
+	if name == "." {
+		return nil
+	}
+	elems := strings.Split(name, string(filepath.Separator))
+	for i := range elems {
+		rel := filepath.Join(elems[:i+1]...)
+		info, err := os.Lstat(filepath.Join(dir, rel))
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode().Type() == fs.ModeSymlink {
+			return fmt.Errorf("%s is a symlink", filepath.ToSlash(rel))
+		}
+	}
+	return nil
+}
+
+// isSubFilepath reports whether path does not refer to a parent directory.
+func isSubFilepath(path string) bool {
+	path = filepath.Clean(path)
+	return path != ".." && !strings.HasPrefix(path, ".."+string(filepath.Separator)) && !filepath.IsAbs(path)
+}
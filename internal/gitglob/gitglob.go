@@ -23,6 +23,7 @@ package gitglob
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"io/fs"
 	"os"
 	"regexp"
@@ -31,6 +32,17 @@ import (
 	"unicode/utf8"
 )
 
+// ParseFile parses a file that contains gitignore-style patterns, one per line.
+// Blank lines, comments, and malformed patterns are skipped. Any error returned
+// includes the file's path.
+func ParseFile(path string) ([]Pattern, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("parse patterns: %w", err)
+	}
+	return appendPatterns(nil, data), nil
+}
+
 // ParseFiles parses files that contains gitignore-style patterns, one per line.
 // Patterns in later files in the argument list have less precedence (i.e.
 // appear earlier in the returned list) than files that appear earlier in the
@@ -46,18 +58,24 @@ func ParseFiles(files ...string) ([]Pattern, error) {
 			continue
 		}
 		if err != nil {
-			return nil, err
-		}
-		for _, line := range bytes.Split(data, []byte("\n")) {
-			pat := ParseLine(string(line))
-			if pat.IsValid() {
-				patterns = append(patterns, pat)
-			}
+			return nil, fmt.Errorf("parse patterns: %w", err)
 		}
+		patterns = appendPatterns(patterns, data)
 	}
 	return patterns, nil
 }
 
+// appendPatterns appends the valid patterns in data to dst.
+func appendPatterns(dst []Pattern, data []byte) []Pattern {
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		pat := ParseLine(string(line))
+		if pat.IsValid() {
+			dst = append(dst, pat)
+		}
+	}
+	return dst
+}
+
 // LastMatch returns the last pattern in the list that matches the given path,
 // or nil if the path has no matching pattern.
 func LastMatch(patterns []Pattern, path string, mode fs.FileMode) *Pattern {
@@ -70,6 +88,29 @@ func LastMatch(patterns []Pattern, path string, mode fs.FileMode) *Pattern {
 	return nil
 }
 
+// Simplify returns a new list of patterns with the same behavior as patterns
+// under LastMatch, but without any invalid patterns or patterns that are
+// shadowed by a later pattern that matches the same paths. For example,
+// a "*.log" pattern immediately followed by "!*.log" is removed, since
+// the negation always takes precedence. The negation is kept, because it may
+// still re-include paths matched by earlier patterns.
+func Simplify(patterns []Pattern) []Pattern {
+	var simplified []Pattern
+outer:
+	for i, pat := range patterns {
+		if !pat.IsValid() {
+			continue
+		}
+		for _, later := range patterns[i+1:] {
+			if pat.matchesSamePaths(later) {
+				continue outer
+			}
+		}
+		simplified = append(simplified, pat)
+	}
+	return simplified
+}
+
 // Pattern is the representation of a compiled glob pattern. A Pattern is safe
 // for concurrent use by multiple goroutines. The zero value is a Pattern that
 // does not match any paths.
@@ -78,6 +119,11 @@ type Pattern struct {
 	line          string
 	negate        bool
 	directoryOnly bool
+
+	// If rooted is true, then prefix is the literal text
+	// that every matching path starts with.
+	rooted bool
+	prefix string
 }
 
 // ParseLine compiles a single pattern.
@@ -135,6 +181,13 @@ func ParseLine(line string) Pattern {
 	if isPrefix {
 		tokens = tokens[:len(tokens)-1]
 	}
+	prefix := new(strings.Builder)
+	for _, tok := range tokens {
+		if tok.typ != literal {
+			break
+		}
+		prefix.WriteString(tok.s)
+	}
 	re := new(strings.Builder)
 	if rooted {
 		re.WriteString("^")
@@ -168,9 +221,59 @@ func ParseLine(line string) Pattern {
 		line:          orig,
 		negate:        negate,
 		directoryOnly: directoryOnly,
+		rooted:        rooted,
+		prefix:        prefix.String(),
 	}
 }
 
+// ParseLineInDir compiles a single pattern read from an ignore file
+// in the slash-separated directory dir, like a .gitignore file
+// in a subdirectory. The pattern is relative to dir
+// and only matches paths inside it.
+// If dir is "." or empty, ParseLineInDir is equivalent to ParseLine.
+func ParseLineInDir(dir, line string) Pattern {
+	if dir == "" || dir == "." {
+		return ParseLine(line)
+	}
+	if !utf8.ValidString(line) || strings.HasPrefix(line, "#") {
+		return Pattern{}
+	}
+	line = trimRight(line)
+	negate := ""
+	if strings.HasPrefix(line, "!") {
+		negate = "!"
+		line = line[1:]
+	}
+	if strings.TrimPrefix(line, "/") == "" {
+		return Pattern{}
+	}
+	switch {
+	case strings.HasPrefix(line, "/"):
+		line = line[1:]
+	case strings.HasPrefix(line, "**/") || strings.Contains(strings.TrimSuffix(line, "/"), "/"):
+		// Already relative to dir.
+	default:
+		// Matches at any depth below dir.
+		line = "**/" + line
+	}
+	return ParseLine(negate + escapeLiteral(dir) + "/" + line)
+}
+
+// escapeLiteral returns a pattern that matches s literally.
+func escapeLiteral(s string) string {
+	sb := new(strings.Builder)
+	for i, c := range s {
+		switch {
+		case c == '\\' || c == '*' || c == '?' || c == '[':
+			sb.WriteByte('\\')
+		case i == 0 && (c == '#' || c == '!'):
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(c)
+	}
+	return sb.String()
+}
+
 // convertCharacterClass converts a glob character class
 // into an RE2 character class and appends to re.
 //
@@ -257,6 +360,21 @@ func (pat Pattern) Match(path string, mode fs.FileMode) bool {
 		pat.re.MatchString(path)
 }
 
+// MayMatchInside reports whether the pattern could match a path inside the
+// given slash-separated directory. It may report true for patterns that can't
+// match anything inside the directory, but never reports false for a pattern
+// that can.
+func (pat Pattern) MayMatchInside(dir string) bool {
+	if !pat.IsValid() {
+		return false
+	}
+	if !pat.rooted {
+		return true
+	}
+	dir += "/"
+	return strings.HasPrefix(pat.prefix, dir) || strings.HasPrefix(dir, pat.prefix)
+}
+
 // IsNegated reports whether the pattern starts with an exclamation point ('!').
 // In gitignore for example, such a pattern indicates any matching file excluded
 // by a previous pattern will become included again.
@@ -264,6 +382,20 @@ func (pat Pattern) IsNegated() bool {
 	return pat.negate
 }
 
+// IsNegationOf reports whether a and b match the same paths
+// but exactly one of them is negated.
+func IsNegationOf(a, b Pattern) bool {
+	return a.negate != b.negate && a.matchesSamePaths(b)
+}
+
+// matchesSamePaths reports whether pat and other are valid patterns
+// that match the same paths, ignoring negation.
+func (pat Pattern) matchesSamePaths(other Pattern) bool {
+	return pat.IsValid() && other.IsValid() &&
+		pat.directoryOnly == other.directoryOnly &&
+		pat.re.String() == other.re.String()
+}
+
 // String returns the string passed to ParseLine with any trailing space removed.
 func (pat Pattern) String() string {
 	return pat.line
@@ -421,3 +553,63 @@ func trimRight(s string) string {
 	}
 	return ""
 }
+
+// A Matcher matches paths against patterns that are scoped to directories,
+// like the .gitignore files in a Git working tree. Patterns pushed for a
+// directory only apply to paths inside that directory and are matched
+// relative to it. The zero value is an empty Matcher that does not match any
+// paths.
+type Matcher struct {
+	scopes []matcherScope
+}
+
+type matcherScope struct {
+	dir      string
+	patterns []Pattern
+}
+
+// Push adds patterns that apply to paths inside the given slash-separated
+// directory. The directory "." or "" refers to the root. Patterns from later
+// calls to Push take precedence over earlier ones, so callers walking a tree
+// should push a directory's patterns after its parent's.
+func (m *Matcher) Push(dir string, patterns []Pattern) {
+	if dir == "." {
+		dir = ""
+	}
+	m.scopes = append(m.scopes, matcherScope{
+		dir:      strings.TrimSuffix(dir, "/"),
+		patterns: patterns,
+	})
+}
+
+// Pop removes the patterns added by the most recent call to Push.
+// Pop panics if the Matcher is empty.
+func (m *Matcher) Pop() {
+	if len(m.scopes) == 0 {
+		panic("gitglob: Pop called on empty Matcher")
+	}
+	m.scopes[len(m.scopes)-1] = matcherScope{}
+	m.scopes = m.scopes[:len(m.scopes)-1]
+}
+
+// Match reports whether the given slash-separated path is matched by the
+// active patterns. The last matching pattern in the most recently pushed
+// scope that contains path determines the result: a negated pattern
+// reports false. Match does not consider whether any of the path's parent
+// directories are matched.
+func (m *Matcher) Match(path string, mode fs.FileMode) bool {
+	for i := len(m.scopes) - 1; i >= 0; i-- {
+		scope := &m.scopes[i]
+		rel := path
+		if scope.dir != "" {
+			if !strings.HasPrefix(path, scope.dir+"/") {
+				continue
+			}
+			rel = path[len(scope.dir)+1:]
+		}
+		if pat := LastMatch(scope.patterns, rel, mode); pat != nil {
+			return !pat.IsNegated()
+		}
+	}
+	return false
+}
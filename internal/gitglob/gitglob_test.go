@@ -16,7 +16,16 @@
 
 package gitglob
 
-import "testing"
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
 
 var parseLineTests = []struct {
 	line          string
@@ -122,3 +131,206 @@ func TestParseLine(t *testing.T) {
 		}
 	}
 }
+
+func TestParseLineInDir(t *testing.T) {
+	tests := []struct {
+		dir           string
+		line          string
+		want          string
+		negate        bool
+		directoryOnly bool
+	}{
+		{dir: ".", line: `foo.txt`, want: `(^|.*/)foo\.txt$`},
+		{dir: "sub", line: ``, want: ``},
+		{dir: "sub", line: `# comment`, want: ``},
+		{dir: "sub", line: `/`, want: ``},
+		{dir: "sub", line: `!`, want: ``},
+		{dir: "sub", line: `foo.txt`, want: `^sub/(|.+/)foo\.txt$`},
+		{dir: "sub", line: `!foo.txt`, want: `^sub/(|.+/)foo\.txt$`, negate: true},
+		{dir: "sub", line: `node_modules/`, want: `^sub/(|.+/)node_modules$`, directoryOnly: true},
+		{dir: "sub", line: `/foo`, want: `^sub/foo$`},
+		{dir: "sub", line: `foo/bar`, want: `^sub/foo/bar$`},
+		{dir: "sub", line: `**/foo`, want: `^sub/(|.+/)foo$`},
+		{dir: "sub", line: `foo/**`, want: `^sub/foo/`},
+		{dir: "a/b", line: `*.log`, want: `^a/b/(|.+/)[^/]*\.log$`},
+		{dir: "a*[b]", line: `/foo`, want: `^a\*\[b\]/foo$`},
+		{dir: "#a", line: `/foo`, want: `^#a/foo$`},
+		{dir: "!a", line: `!/foo`, want: `^!a/foo$`, negate: true},
+	}
+	for _, test := range tests {
+		gotPattern := ParseLineInDir(test.dir, test.line)
+		got := ""
+		if gotPattern.re != nil {
+			got = gotPattern.re.String()
+		}
+		if got != test.want || gotPattern.negate != test.negate || gotPattern.directoryOnly != test.directoryOnly {
+			t.Errorf(
+				"ParseLineInDir(%q, %q) = {re:%q negate:%t directoryOnly:%t}; "+
+					"want {re:%q negate:%t directoryOnly:%t}",
+				test.dir, test.line, got, gotPattern.negate, gotPattern.directoryOnly,
+				test.want, test.negate, test.directoryOnly,
+			)
+		}
+	}
+}
+
+func TestMayMatchInside(t *testing.T) {
+	tests := []struct {
+		line string
+		dir  string
+		want bool
+	}{
+		{line: "important.txt", dir: "build", want: true},
+		{line: "*.txt", dir: "build", want: true},
+		{line: "build/keep/important.txt", dir: "build", want: true},
+		{line: "build/keep/important.txt", dir: "build/keep", want: true},
+		{line: "/build/*.txt", dir: "build", want: true},
+		{line: "bu*/keep.txt", dir: "build", want: true},
+		{line: "**/keep.txt", dir: "build", want: true},
+		{line: "build/**", dir: "build", want: true},
+		{line: "src/keep.txt", dir: "build", want: false},
+		{line: "build/keep/important.txt", dir: "build/other", want: false},
+		{line: "/buildx/keep.txt", dir: "build", want: false},
+		{line: "", dir: "build", want: false},
+	}
+	for _, test := range tests {
+		if got := ParseLine(test.line).MayMatchInside(test.dir); got != test.want {
+			t.Errorf("ParseLine(%q).MayMatchInside(%q) = %t; want %t", test.line, test.dir, got, test.want)
+		}
+	}
+}
+
+func TestIsNegationOf(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{a: "*.log", b: "!*.log", want: true},
+		{a: "!*.log", b: "*.log", want: true},
+		{a: "/foo", b: "!foo", want: false},
+		{a: "foo/bar", b: "!/foo/bar", want: true},
+		{a: "foo/", b: "!foo", want: false},
+		{a: "*.log", b: "*.log", want: false},
+		{a: "*.log", b: "!important.log", want: false},
+		{a: "", b: "!", want: false},
+	}
+	for _, test := range tests {
+		if got := IsNegationOf(ParseLine(test.a), ParseLine(test.b)); got != test.want {
+			t.Errorf("IsNegationOf(ParseLine(%q), ParseLine(%q)) = %t; want %t", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestSimplify(t *testing.T) {
+	tests := []struct {
+		lines []string
+		want  []string
+	}{
+		{lines: nil, want: nil},
+		{
+			lines: []string{"*.log", "!important.log"},
+			want:  []string{"*.log", "!important.log"},
+		},
+		{
+			lines: []string{"*", "foo", "!foo"},
+			want:  []string{"*", "!foo"},
+		},
+		{
+			lines: []string{"!foo", "foo", "# comment"},
+			want:  []string{"foo"},
+		},
+		{
+			lines: []string{"*.o", "build/", "*.o"},
+			want:  []string{"build/", "*.o"},
+		},
+		{
+			lines: []string{"foo/", "!foo"},
+			want:  []string{"foo/", "!foo"},
+		},
+	}
+	for _, test := range tests {
+		var patterns []Pattern
+		for _, line := range test.lines {
+			patterns = append(patterns, ParseLine(line))
+		}
+		var got []string
+		for _, pat := range Simplify(patterns) {
+			got = append(got, pat.String())
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("Simplify(%q) (-want +got):\n%s", test.lines, diff)
+		}
+	}
+}
+
+func TestParseFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, ".biomeignore")
+	const content = "# Comment\n\n*.o\n!keep.o\nbuild/\n"
+	if err := os.WriteFile(path, []byte(content), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	pats, err := ParseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, pat := range pats {
+		got = append(got, pat.String())
+	}
+	want := []string{"*.o", "!keep.o", "build/"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ParseFile(%q) (-want +got):\n%s", path, diff)
+	}
+
+	missing := filepath.Join(dir, "missing")
+	_, err = ParseFile(missing)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("ParseFile(%q) = _, %v; want error wrapping os.ErrNotExist", missing, err)
+	}
+	if err != nil && !strings.Contains(err.Error(), missing) {
+		t.Errorf("ParseFile(%q) error %q does not mention path", missing, err)
+	}
+}
+
+func TestMatcher(t *testing.T) {
+	m := new(Matcher)
+	m.Push(".", []Pattern{ParseLine("*.o"), ParseLine("/build/")})
+	m.Push("src", []Pattern{ParseLine("!keep.o"), ParseLine("/gen")})
+	m.Push("src/vendor", []Pattern{ParseLine("*.o")})
+
+	tests := []struct {
+		path string
+		mode fs.FileMode
+		want bool
+	}{
+		{path: "foo.o", want: true},
+		{path: "foo.c", want: false},
+		{path: "build", mode: fs.ModeDir, want: true},
+		{path: "build", want: false},
+		{path: "src/build", mode: fs.ModeDir, want: false},
+		{path: "src/foo.o", want: true},
+		{path: "src/keep.o", want: false},
+		{path: "src/sub/keep.o", want: false},
+		{path: "keep.o", want: true},
+		{path: "src/gen", want: true},
+		{path: "gen", want: false},
+		{path: "src/sub/gen", want: false},
+		{path: "srcx/keep.o", want: true},
+		{path: "src/vendor/keep.o", want: true},
+	}
+	for _, test := range tests {
+		if got := m.Match(test.path, test.mode); got != test.want {
+			t.Errorf("m.Match(%q, %v) = %t; want %t", test.path, test.mode, got, test.want)
+		}
+	}
+
+	m.Pop()
+	if m.Match("src/vendor/keep.o", 0) {
+		t.Error("after Pop, m.Match(\"src/vendor/keep.o\", 0) = true; want false")
+	}
+	m.Pop()
+	if !m.Match("src/keep.o", 0) {
+		t.Error("after Pop, m.Match(\"src/keep.o\", 0) = false; want true")
+	}
+}
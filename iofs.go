@@ -0,0 +1,165 @@
+// Copyright 2021 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		 https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package biome
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+)
+
+// FS returns a read-only view of the biome's filesystem as an fs.FS.
+// Names are slash-separated paths relative to the biome's working directory,
+// as required by fs.ValidPath. All operations use ctx and delegate to
+// OpenFile, Stat, and ReadDir.
+//
+// The returned filesystem is not a snapshot: each call observes the biome's
+// state at the time of the call. Depending on the biome, each call may incur
+// network or subprocess overhead, so callers should avoid redundant calls.
+func FS(ctx context.Context, bio Biome) fs.FS {
+	return biomeFS{ctx: ctx, bio: bio}
+}
+
+type biomeFS struct {
+	ctx context.Context
+	bio Biome
+}
+
+// path converts a slash-separated name into a biome path.
+func (fsys biomeFS) path(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return fsys.bio.Dirs().Work, nil
+	}
+	elem := append([]string{fsys.bio.Dirs().Work}, strings.Split(name, "/")...)
+	return JoinPath(fsys.bio.Describe(), elem...), nil
+}
+
+// Open opens the named file or directory.
+func (fsys biomeFS) Open(name string) (fs.File, error) {
+	path, err := fsys.path("open", name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := Stat(fsys.ctx, fsys.bio, path)
+	if err != nil {
+		return nil, fsPathError("open", name, err)
+	}
+	if info.IsDir() {
+		return &fsDir{fsys: fsys, name: name, info: info}, nil
+	}
+	rc, err := OpenFile(fsys.ctx, fsys.bio, path)
+	if err != nil {
+		return nil, fsPathError("open", name, err)
+	}
+	return &fsFile{ReadCloser: rc, info: info}, nil
+}
+
+// Stat returns information about the named file.
+func (fsys biomeFS) Stat(name string) (fs.FileInfo, error) {
+	path, err := fsys.path("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := Stat(fsys.ctx, fsys.bio, path)
+	if err != nil {
+		return nil, fsPathError("stat", name, err)
+	}
+	return info, nil
+}
+
+// ReadDir reads the named directory.
+func (fsys biomeFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	path, err := fsys.path("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := ReadDir(fsys.ctx, fsys.bio, path)
+	if err != nil {
+		return nil, fsPathError("readdir", name, err)
+	}
+	return entries, nil
+}
+
+// fsPathError wraps an error from a biome operation in an *fs.PathError
+// naming the fs.FS path, preserving any fs.ErrNotExist in the chain.
+func fsPathError(op, name string, err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+type fsFile struct {
+	io.ReadCloser
+	info fs.FileInfo
+}
+
+func (f *fsFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+type fsDir struct {
+	fsys    biomeFS
+	name    string
+	info    fs.FileInfo
+	entries []fs.DirEntry
+	read    bool
+}
+
+func (d *fsDir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *fsDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+func (d *fsDir) Close() error {
+	return nil
+}
+
+// ReadDir reads the directory's entries on the first call
+// and then returns them n at a time.
+func (d *fsDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.read {
+		entries, err := d.fsys.ReadDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.entries = entries
+		d.read = true
+	}
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2021 Ross Light
+// Copyright 2020 YourBase Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package biome
+
+import (
+	"context"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"zombiezen.com/go/log/testlog"
+)
+
+func TestFS(t *testing.T) {
+	dir := t.TempDir()
+	home := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "sub", "deeper"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"foo.txt":                "Hello, World!\n",
+		"sub/bar.txt":            "bar\n",
+		"sub/deeper/baz.txt":     "",
+		"sub/deeper/.hidden.txt": "secret\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, filepath.FromSlash(name)), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fake := &Fake{
+		Descriptor: Descriptor{OS: Linux, Arch: Intel64},
+		DirsResult: Dirs{Work: "/work", Home: "/home"},
+		FileSystem: fstest.MapFS{
+			"home":                        {Mode: fs.ModeDir | 0o755},
+			"work/foo.txt":                {Data: []byte(files["foo.txt"])},
+			"work/sub/bar.txt":            {Data: []byte(files["sub/bar.txt"])},
+			"work/sub/deeper/baz.txt":     {Data: []byte(files["sub/deeper/baz.txt"])},
+			"work/sub/deeper/.hidden.txt": {Data: []byte(files["sub/deeper/.hidden.txt"])},
+		},
+	}
+
+	tests := []struct {
+		name string
+		bio  Biome
+	}{
+		{
+			name: "Local",
+			bio: Local{
+				WorkDir: dir,
+				HomeDir: home,
+			},
+		},
+		{
+			name: "Fallback",
+			bio: forceFallback{Local{
+				WorkDir: dir,
+				HomeDir: home,
+			}},
+		},
+		{
+			name: "Fake",
+			bio:  fake,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := testlog.WithTB(context.Background(), t)
+			fsys := FS(ctx, test.bio)
+			if err := fstest.TestFS(fsys, "foo.txt", "sub/bar.txt", "sub/deeper/baz.txt", "sub/deeper/.hidden.txt"); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -27,7 +27,7 @@ import (
 // argument list is empty or all its elements are empty, JoinPath
 // returns an empty string.
 func JoinPath(desc *Descriptor, elem ...string) string {
-	if desc.OS == Windows {
+	if desc.NativeOS() == Windows {
 		return windowspath.Join(elem...)
 	}
 	return slashpath.Join(elem...)
@@ -35,7 +35,7 @@ func JoinPath(desc *Descriptor, elem ...string) string {
 
 // IsAbsPath reports whether the path is absolute.
 func IsAbsPath(desc *Descriptor, path string) bool {
-	if desc.OS == Windows {
+	if desc.NativeOS() == Windows {
 		return windowspath.IsAbs(path)
 	}
 	return slashpath.IsAbs(path)
@@ -63,13 +63,29 @@ func AbsPath(bio Biome, path string) string {
 	return JoinPath(desc, bio.Dirs().Work, path)
 }
 
+// AbsPathJoin joins any number of path elements into a single path
+// with JoinPath and then returns its absolute representation with AbsPath.
+func AbsPathJoin(bio Biome, elem ...string) string {
+	return AbsPath(bio, JoinPath(bio.Describe(), elem...))
+}
+
 // FromSlash returns the result of replacing each slash ('/') character in path
 // with a separator character. Multiple slashes are replaced by multiple separators.
 func FromSlash(desc *Descriptor, path string) string {
-	switch desc.OS {
+	switch desc.NativeOS() {
 	case Windows:
 		return windowspath.FromSlash(path)
 	default:
 		return path
 	}
 }
+
+// PathSep returns the separator used between elements of a path list
+// (like the PATH environment variable) in the biome: ";" on Windows and ":"
+// everywhere else.
+func (desc *Descriptor) PathSep() string {
+	if desc.NativeOS() == Windows {
+		return ";"
+	}
+	return ":"
+}
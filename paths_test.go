@@ -39,6 +39,7 @@ func TestJoinPath(t *testing.T) {
 		{elem: []string{"", "a"}, os: Windows, want: "a"},
 
 		{elem: []string{"a", "b/c"}, os: Windows, want: `a\b\c`},
+		{elem: []string{"a", "b"}, os: "Windows_NT", want: `a\b`},
 	}
 	for _, test := range tests {
 		got := JoinPath(&Descriptor{OS: test.os}, test.elem...)
@@ -48,6 +49,31 @@ func TestJoinPath(t *testing.T) {
 	}
 }
 
+func TestAbsPathJoin(t *testing.T) {
+	tests := []struct {
+		elem []string
+		os   string
+		work string
+		want string
+	}{
+		{elem: []string{"a", "b"}, os: Linux, work: "/work", want: "/work/a/b"},
+		{elem: []string{"/home", "a", "../b"}, os: Linux, work: "/work", want: "/home/b"},
+		{elem: []string{}, os: Linux, work: "/work", want: "/work"},
+		{elem: []string{"a", "b"}, os: Windows, work: `C:\work`, want: `C:\work\a\b`},
+		{elem: []string{`C:\home`, "a"}, os: Windows, work: `C:\work`, want: `C:\home\a`},
+	}
+	for _, test := range tests {
+		bio := &Fake{
+			Descriptor: Descriptor{OS: test.os},
+			DirsResult: Dirs{Work: test.work},
+		}
+		got := AbsPathJoin(bio, test.elem...)
+		if got != test.want {
+			t.Errorf("AbsPathJoin({OS: %q, Work: %q}, %q...) = %q; want %q", test.os, test.work, test.elem, got, test.want)
+		}
+	}
+}
+
 func TestCleanPath(t *testing.T) {
 	tests := []struct {
 		path string
@@ -85,3 +111,40 @@ func TestCleanPath(t *testing.T) {
 		}
 	}
 }
+
+func TestPathSep(t *testing.T) {
+	tests := []struct {
+		os   string
+		want string
+	}{
+		{os: Linux, want: ":"},
+		{os: MacOS, want: ":"},
+		{os: Windows, want: ";"},
+	}
+	for _, test := range tests {
+		got := (&Descriptor{OS: test.os}).PathSep()
+		if got != test.want {
+			t.Errorf("(&Descriptor{OS: %q}).PathSep() = %q; want %q", test.os, got, test.want)
+		}
+	}
+}
+
+func TestFromSlash(t *testing.T) {
+	tests := []struct {
+		os   string
+		path string
+		want string
+	}{
+		{os: Linux, path: "foo/bar", want: "foo/bar"},
+		{os: MacOS, path: "foo/bar", want: "foo/bar"},
+		{os: Windows, path: "foo/bar", want: `foo\bar`},
+		{os: "Windows_NT", path: "foo/bar", want: `foo\bar`},
+		{os: "MINGW64_NT-10.0", path: "foo//bar", want: `foo\\bar`},
+	}
+	for _, test := range tests {
+		got := FromSlash(&Descriptor{OS: test.os}, test.path)
+		if got != test.want {
+			t.Errorf("FromSlash({OS: %q}, %q) = %q; want %q", test.os, test.path, got, test.want)
+		}
+	}
+}
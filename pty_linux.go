@@ -0,0 +1,69 @@
+// Copyright 2020 YourBase Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package biome
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// openPTY allocates a pseudo-terminal. The caller is responsible for closing
+// both the controller and the terminal.
+func openPTY() (controller, tty *os.File, err error) {
+	fd, err := unix.Open("/dev/ptmx", unix.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open pseudo-terminal: %w", err)
+	}
+	controller = os.NewFile(uintptr(fd), "/dev/ptmx")
+	if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		controller.Close()
+		return nil, nil, fmt.Errorf("open pseudo-terminal: unlock: %w", err)
+	}
+	n, err := unix.IoctlGetInt(fd, unix.TIOCGPTN)
+	if err != nil {
+		controller.Close()
+		return nil, nil, fmt.Errorf("open pseudo-terminal: %w", err)
+	}
+	ttyName := fmt.Sprintf("/dev/pts/%d", n)
+	tty, err = os.OpenFile(ttyName, os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		controller.Close()
+		return nil, nil, fmt.Errorf("open pseudo-terminal: %w", err)
+	}
+	return controller, tty, nil
+}
+
+// setControllingTerminal configures c to run in a new session
+// with its standard output as its controlling terminal.
+func setControllingTerminal(c *exec.Cmd) {
+	c.SysProcAttr = &syscall.SysProcAttr{
+		Setsid:  true,
+		Setctty: true,
+		Ctty:    1,
+	}
+}
+
+// isPTYClosed reports whether err is the error that reading from
+// a pseudo-terminal's controller returns after the terminal is closed.
+func isPTYClosed(err error) bool {
+	return errors.Is(err, unix.EIO)
+}
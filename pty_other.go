@@ -0,0 +1,37 @@
+// Copyright 2020 YourBase Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux
+// +build !linux
+
+package biome
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+func openPTY() (controller, tty *os.File, err error) {
+	return nil, nil, fmt.Errorf("open pseudo-terminal: %w on %s", ErrUnsupported, runtime.GOOS)
+}
+
+func setControllingTerminal(c *exec.Cmd) {}
+
+func isPTYClosed(err error) bool {
+	return false
+}
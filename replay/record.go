@@ -114,18 +114,22 @@ func captureIO(original *biome.Invocation) (*biome.Invocation, ioSinks) {
 		sinks.stdinHash = sha256.New()
 		wrapped.Stdin = io.TeeReader(original.Stdin, sinks.stdinHash)
 	}
-	if isCombinedOutput(original.Stdout, original.Stderr) {
+	stdout, stderr := original.Output()
+	wrapped.CombinedOutput = nil
+	if isCombinedOutput(stdout, stderr) {
 		sinks.combinedOutput = new(bytes.Buffer)
-		wrapped.Stdout = io.MultiWriter(original.Stdout, sinks.combinedOutput)
+		wrapped.Stdout = io.MultiWriter(stdout, sinks.combinedOutput)
 		wrapped.Stderr = wrapped.Stdout
 	} else {
-		if original.Stdout != nil {
+		wrapped.Stdout = stdout
+		wrapped.Stderr = stderr
+		if stdout != nil {
 			sinks.stdout = new(bytes.Buffer)
-			wrapped.Stdout = io.MultiWriter(original.Stdout, sinks.stdout)
+			wrapped.Stdout = io.MultiWriter(stdout, sinks.stdout)
 		}
-		if original.Stderr != nil {
+		if stderr != nil {
 			sinks.stderr = new(bytes.Buffer)
-			wrapped.Stderr = io.MultiWriter(original.Stderr, sinks.stderr)
+			wrapped.Stderr = io.MultiWriter(stderr, sinks.stderr)
 		}
 	}
 	return wrapped, sinks
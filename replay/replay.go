@@ -214,34 +214,35 @@ func (r *Replay) Run(ctx context.Context, invoke *biome.Invocation) error {
 		return fmt.Errorf("run replay: `%s`: environment:\n%v\n; expected:\n%s",
 			invokeLine, invoke.Env, want)
 	}
+	stdout, stderr := invoke.Output()
 	if recorded.Output == nil {
-		if invoke.Stdout != nil || invoke.Stderr != nil {
+		if stdout != nil || stderr != nil {
 			return fmt.Errorf("run replay: `%s`: output requested but recording does not include output", invokeLine)
 		}
 	} else {
-		if isCombinedOutput(invoke.Stdout, invoke.Stderr) {
+		if isCombinedOutput(stdout, stderr) {
 			if recorded.Output.combined == nil {
 				return fmt.Errorf("run replay: `%s`: combined output requested but recording has separated output", invokeLine)
 			}
-			if _, err := invoke.Stdout.Write(recorded.Output.combined); err != nil {
+			if _, err := stdout.Write(recorded.Output.combined); err != nil {
 				return fmt.Errorf("run replay: `%s`: write combined output: %w", invokeLine, err)
 			}
 		} else if recorded.Output.combined != nil {
 			return fmt.Errorf("run replay: `%s`: separated output requested but recording has combined output", invokeLine)
 		} else {
-			if invoke.Stdout != nil {
+			if stdout != nil {
 				if recorded.Output.stdout == nil {
 					return fmt.Errorf("run replay: `%s`: stdout requested but recording does not have stdout", invokeLine)
 				}
-				if _, err := invoke.Stdout.Write(recorded.Output.stdout); err != nil {
+				if _, err := stdout.Write(recorded.Output.stdout); err != nil {
 					return fmt.Errorf("run replay: `%s`: write stdout: %w", invokeLine, err)
 				}
 			}
-			if invoke.Stderr != nil {
+			if stderr != nil {
 				if recorded.Output.stderr == nil {
 					return fmt.Errorf("run replay: `%s`: stderr requested but recording does not have stderr", invokeLine)
 				}
-				if _, err := invoke.Stderr.Write(recorded.Output.stderr); err != nil {
+				if _, err := stderr.Write(recorded.Output.stderr); err != nil {
 					return fmt.Errorf("run replay: `%s`: write stderr: %w", invokeLine, err)
 				}
 			}
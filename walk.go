@@ -0,0 +1,150 @@
+// Copyright 2021 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		 https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package biome
+
+import (
+	"context"
+	"io/fs"
+	"strings"
+
+	"zombiezen.com/go/biome/internal/gitglob"
+)
+
+// WalkDir walks the file tree rooted at root in the biome, calling fn for
+// each file or directory in the tree, including root, in lexical order.
+// It behaves like io/fs.WalkDir: paths passed to fn start with root
+// (joined with JoinPath), and fn may return fs.SkipDir to skip a directory
+// or the rest of a directory's entries.
+//
+// Files and directories matched by the .gitignore-style ignore patterns
+// are skipped. Patterns are matched against slash-separated paths relative
+// to root. An ignored directory is only descended into if a negated pattern
+// may re-include something inside it, in which case fn is called for
+// re-included paths but not for the ignored directory itself. Root is never
+// ignored.
+//
+// WalkDir lists each directory with ReadDir and does not call the entries'
+// Info methods itself. For biomes without a ReadDir method (like biomes on
+// remote machines), each directory costs one Run of a listing program, and
+// calling Info on an entry costs one Run of Stat, so fn should avoid calling
+// Info when the entry's type is enough. Callers walking large trees in such
+// biomes should pass ignore patterns that skip directories they don't need.
+func WalkDir(ctx context.Context, bio Biome, root string, fn fs.WalkDirFunc, ignore []gitglob.Pattern) error {
+	info, err := Stat(ctx, bio, root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		w := &walker{
+			bio:    bio,
+			desc:   bio.Describe(),
+			fn:     fn,
+			ignore: ignore,
+		}
+		err = w.walk(ctx, root, "", fs.FileInfoToDirEntry(info))
+	}
+	if err == fs.SkipDir {
+		return nil
+	}
+	return err
+}
+
+type walker struct {
+	bio    Biome
+	desc   *Descriptor
+	fn     fs.WalkDirFunc
+	ignore []gitglob.Pattern
+
+	// ignoredDirs is the list of ignored directories (relative to the root)
+	// that are still walked because a negated pattern may re-include
+	// something inside them.
+	ignoredDirs []string
+}
+
+// walk visits the entry d at path, which is rel relative to the root,
+// and then its children if it is a directory.
+func (w *walker) walk(ctx context.Context, path, rel string, d fs.DirEntry) error {
+	visit := true
+	if rel != "" && w.isIgnored(rel, d.Type()) {
+		if !d.IsDir() || !w.mayReinclude(rel) {
+			return nil
+		}
+		w.ignoredDirs = append(w.ignoredDirs, rel)
+		visit = false
+	}
+	if visit {
+		if err := w.fn(path, d, nil); err != nil || !d.IsDir() {
+			if err == fs.SkipDir && d.IsDir() {
+				err = nil
+			}
+			return err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	entries, err := ReadDir(ctx, w.bio, path)
+	if err != nil {
+		if !visit {
+			// Nothing to report for a directory the caller never saw.
+			return nil
+		}
+		if err := w.fn(path, d, err); err != nil {
+			if err == fs.SkipDir {
+				err = nil
+			}
+			return err
+		}
+	}
+	for _, ent := range entries {
+		childRel := ent.Name()
+		if rel != "" {
+			childRel = rel + "/" + childRel
+		}
+		if err := w.walk(ctx, JoinPath(w.desc, path, ent.Name()), childRel, ent); err != nil {
+			if err == fs.SkipDir {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// isIgnored reports whether the path relative to the root is ignored,
+// either by a pattern or by being inside an ignored directory.
+func (w *walker) isIgnored(rel string, mode fs.FileMode) bool {
+	if pat := gitglob.LastMatch(w.ignore, rel, mode); pat != nil {
+		return !pat.IsNegated()
+	}
+	for _, dir := range w.ignoredDirs {
+		if strings.HasPrefix(rel, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// mayReinclude reports whether a negated pattern
+// may match a path inside the given directory.
+func (w *walker) mayReinclude(dir string) bool {
+	for _, pat := range w.ignore {
+		if pat.IsNegated() && pat.MayMatchInside(dir) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,238 @@
+// Copyright 2021 Ross Light
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//		 https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package biome
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/google/go-cmp/cmp"
+	"zombiezen.com/go/biome/internal/gitglob"
+)
+
+func TestWalkDir(t *testing.T) {
+	newBiome := func() *Fake {
+		return &Fake{
+			Descriptor: Descriptor{OS: Linux, Arch: Intel64},
+			DirsResult: Dirs{Work: "/work", Home: "/home", Tools: "/tools"},
+			FileSystem: fstest.MapFS{
+				"work/a.txt":               {Data: []byte("a")},
+				"work/b/c.txt":             {Data: []byte("c")},
+				"work/b/d.log":             {Data: []byte("d")},
+				"work/build/out.bin":       {Data: []byte("out")},
+				"work/build/keep/k.txt":    {Data: []byte("k")},
+				"work/build/skip/s.txt":    {Data: []byte("s")},
+				"work/node_modules/x/x.js": {Data: []byte("x")},
+				"work/z.txt":               {Data: []byte("z")},
+			},
+		}
+	}
+	parsePatterns := func(lines ...string) []gitglob.Pattern {
+		var patterns []gitglob.Pattern
+		for _, line := range lines {
+			patterns = append(patterns, gitglob.ParseLine(line))
+		}
+		return patterns
+	}
+
+	tests := []struct {
+		name   string
+		root   string
+		ignore []gitglob.Pattern
+		skip   string
+		want   []string
+	}{
+		{
+			name: "All",
+			root: "/work",
+			want: []string{
+				"/work",
+				"/work/a.txt",
+				"/work/b",
+				"/work/b/c.txt",
+				"/work/b/d.log",
+				"/work/build",
+				"/work/build/keep",
+				"/work/build/keep/k.txt",
+				"/work/build/out.bin",
+				"/work/build/skip",
+				"/work/build/skip/s.txt",
+				"/work/node_modules",
+				"/work/node_modules/x",
+				"/work/node_modules/x/x.js",
+				"/work/z.txt",
+			},
+		},
+		{
+			name:   "Ignore",
+			root:   "/work",
+			ignore: parsePatterns("*.log", "node_modules/", "build/"),
+			want: []string{
+				"/work",
+				"/work/a.txt",
+				"/work/b",
+				"/work/b/c.txt",
+				"/work/z.txt",
+			},
+		},
+		{
+			name:   "Reinclude",
+			root:   "/work",
+			ignore: parsePatterns("build/", "!build/keep/k.txt"),
+			want: []string{
+				"/work",
+				"/work/a.txt",
+				"/work/b",
+				"/work/b/c.txt",
+				"/work/b/d.log",
+				"/work/build/keep/k.txt",
+				"/work/node_modules",
+				"/work/node_modules/x",
+				"/work/node_modules/x/x.js",
+				"/work/z.txt",
+			},
+		},
+		{
+			name: "SkipDir",
+			root: "/work",
+			skip: "/work/build",
+			want: []string{
+				"/work",
+				"/work/a.txt",
+				"/work/b",
+				"/work/b/c.txt",
+				"/work/b/d.log",
+				"/work/build",
+				"/work/node_modules",
+				"/work/node_modules/x",
+				"/work/node_modules/x/x.js",
+				"/work/z.txt",
+			},
+		},
+		{
+			name: "SkipDirFromFile",
+			root: "/work",
+			skip: "/work/b/c.txt",
+			want: []string{
+				"/work",
+				"/work/a.txt",
+				"/work/b",
+				"/work/b/c.txt",
+				"/work/build",
+				"/work/build/keep",
+				"/work/build/keep/k.txt",
+				"/work/build/out.bin",
+				"/work/build/skip",
+				"/work/build/skip/s.txt",
+				"/work/node_modules",
+				"/work/node_modules/x",
+				"/work/node_modules/x/x.js",
+				"/work/z.txt",
+			},
+		},
+		{
+			name:   "Subdirectory",
+			root:   "b",
+			ignore: parsePatterns("*.log"),
+			want: []string{
+				"b",
+				"b/c.txt",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			var got []string
+			err := WalkDir(ctx, newBiome(), test.root, func(path string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				got = append(got, path)
+				if path == test.skip {
+					return fs.SkipDir
+				}
+				return nil
+			}, test.ignore)
+			if err != nil {
+				t.Error("WalkDir:", err)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("visited paths (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("MissingRoot", func(t *testing.T) {
+		ctx := context.Background()
+		called := false
+		err := WalkDir(ctx, newBiome(), "/nope", func(path string, d fs.DirEntry, err error) error {
+			called = true
+			if err == nil {
+				t.Errorf("fn(%q, ...) called with nil error", path)
+			}
+			return err
+		}, nil)
+		if !called {
+			t.Error("fn not called")
+		}
+		if err == nil {
+			t.Error("WalkDir did not return an error")
+		}
+	})
+}
+
+func TestWalkDirLocal(t *testing.T) {
+	ctx := context.Background()
+	workDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(workDir, "sub", "ignored"), 0o777); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"foo.txt", filepath.Join("sub", "bar.txt"), filepath.Join("sub", "ignored", "baz.txt")} {
+		if err := os.WriteFile(filepath.Join(workDir, name), []byte("hello"), 0o666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l := Local{
+		WorkDir: workDir,
+		HomeDir: t.TempDir(),
+	}
+	var got []string
+	err := WalkDir(ctx, l, workDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		got = append(got, path)
+		return nil
+	}, []gitglob.Pattern{gitglob.ParseLine("ignored/")})
+	if err != nil {
+		t.Error("WalkDir:", err)
+	}
+	want := []string{
+		workDir,
+		filepath.Join(workDir, "foo.txt"),
+		filepath.Join(workDir, "sub"),
+		filepath.Join(workDir, "sub", "bar.txt"),
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("visited paths (-want +got):\n%s", diff)
+	}
+}